@@ -1,14 +1,24 @@
 package main
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"gymflow/internal/config"
 	"gymflow/internal/database"
 	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/classpack"
+	"gymflow/internal/domain/invoice"
+	"gymflow/internal/domain/ledger"
+	"gymflow/internal/domain/membership"
 	"gymflow/internal/domain/payment"
+	"gymflow/internal/domain/promo"
 	"gymflow/internal/domain/user"
+	"gymflow/internal/middleware"
+	"gymflow/internal/money"
 	"gymflow/internal/router"
+	"gymflow/internal/scheduler"
 )
 
 func main() {
@@ -23,13 +33,84 @@ func main() {
 	if err := db.AutoMigrate(
 		&user.User{},
 		&booking.GymClass{},
+		&booking.ClassSeries{},
+		&booking.Room{},
 		&booking.Booking{},
+		&booking.CancellationPolicy{},
+		&booking.Penalty{},
+		&booking.CreditLot{},
+		&booking.CreditEntry{},
 		&payment.Payment{},
+		&payment.Refund{},
+		&payment.TillSession{},
+		&payment.PaymentMethod{},
+		&middleware.IdempotencyRecord{},
+		&invoice.Invoice{},
+		&invoice.Line{},
+		&invoice.Sequence{},
+		&membership.Plan{},
+		&membership.Subscription{},
+		&classpack.Pack{},
+		&classpack.Purchase{},
+		&promo.Code{},
+		&promo.Redemption{},
+		&ledger.Transaction{},
+		&ledger.Entry{},
 	); err != nil {
 		log.Fatalf("auto migrate failed: %v", err)
 	}
 
-	r := router.SetupRouter(cfg, db)
+	// суммы раньше хранились во float-колонках в основных единицах
+	for _, c := range []struct{ table, column, prefix string }{
+		{"gym_classes", "price", "price_"},
+		{"class_series", "price", "price_"},
+		{"cancellation_policies", "late_cancel_fee", "late_cancel_fee_"},
+		{"cancellation_policies", "no_show_fee", "no_show_fee_"},
+		{"penalties", "amount", "amount_"},
+		{"payments", "amount", "amount_"},
+		{"payments", "refunded_amount", "refunded_"},
+		{"refunds", "amount", "amount_"},
+	} {
+		if err := money.MigrateFloatColumn(db, c.table, c.column, c.prefix, cfg.Currency); err != nil {
+			log.Fatalf("money migration failed: %v", err)
+		}
+	}
+
+	// The jobs and the HTTP handlers share one provider and the services that
+	// talk to it: the fake provider keeps its intents in memory, so a charge
+	// made by a job must be refundable through the API, and reconciliation
+	// must see what both moved.
+	paymentProvider, err := payment.NewProvider(cfg.PaymentProvider, cfg.PaymentWebhookSecret, cfg.PaymentCallbackURL, cfg.FakePaymentDelay)
+	if err != nil {
+		log.Fatalf("payment provider: %v", err)
+	}
+	invoiceService := invoice.NewService(invoice.NewRepository(db), booking.NewRepository(db), router.InvoiceSettings(cfg))
+	ledgerService := ledger.NewService(ledger.NewRepository(db), paymentProvider, cfg.TaxRateBasisPoints)
+	promoService := promo.NewService(promo.NewRepository(db))
+	paymentService := payment.NewService(payment.NewRepository(db), paymentProvider, booking.NewRepository(db), invoiceService, ledgerService, promoService, cfg.RefundApprovalThreshold, cfg.TaxRates)
+
+	// фоновые задачи
+	ctx := context.Background()
+	go scheduler.Every(ctx, "invoices-backfill", 10*time.Minute, invoiceService.IssueMissing)
+	go scheduler.Every(ctx, "ledger-backfill", 10*time.Minute, ledgerService.RecordMissing)
+	go scheduler.Every(ctx, "settlement-reconciliation", 24*time.Hour, ledgerService.CheckSettlements)
+	// The no-show job charges fees, so bookings get the payment service.
+	bookingJobs := booking.NewService(booking.NewRepository(db), paymentService)
+	go scheduler.Every(ctx, "class-series-horizon", time.Hour, bookingJobs.ExtendSeriesHorizon)
+	membershipJobs := membership.NewService(membership.NewRepository(db), paymentService, router.MembershipSettings(cfg))
+	go scheduler.Every(ctx, "membership-renewals", 15*time.Minute, membershipJobs.ProcessRenewals)
+	classPackJobs := classpack.NewService(classpack.NewRepository(db), paymentService, bookingJobs)
+	go scheduler.Every(ctx, "class-pack-purchases", time.Minute, classPackJobs.SettlePending)
+	go scheduler.Every(ctx, "credits-expiry", time.Hour, bookingJobs.ExpireCredits)
+	go scheduler.Every(ctx, "penalty-fees", 5*time.Minute, bookingJobs.SettlePenaltyFees)
+	go scheduler.Every(ctx, "idempotency-keys-purge", time.Hour,
+		middleware.PurgeIdempotencyKeys(middleware.NewIdempotencyStore(db), cfg.IdempotencyKeyTTL))
+	go scheduler.Every(ctx, "booking-no-shows", 5*time.Minute, func() error {
+		_, err := bookingJobs.MarkNoShows()
+		return err
+	})
+
+	r := router.SetupRouter(cfg, db, ledgerService, paymentService)
 
 	log.Printf("GymFlow running on :%s", cfg.AppPort)
 	if err := r.Run(":" + cfg.AppPort); err != nil {
@@ -2,10 +2,16 @@ package config
 
 import (
 	"log"
+	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
 )
 
 type Config struct {
@@ -23,6 +29,47 @@ type Config struct {
 
 	JWTSecret   string
 	JWTTTLHours int
+
+	PaymentProvider      string
+	PaymentWebhookSecret string
+	// PaymentCallbackURL is where the fake provider posts its webhooks.
+	PaymentCallbackURL string
+	FakePaymentDelay   time.Duration
+	// Currency is the ISO 4217 code of class prices and of legacy amounts
+	// migrated from float columns.
+	Currency string
+	// RefundApprovalThreshold is the largest refund non-admin staff may issue
+	// without admin approval.
+	RefundApprovalThreshold money.Money
+	// IdempotencyKeyTTL is how long a stored response is replayed for retries
+	// with the same Idempotency-Key.
+	IdempotencyKeyTTL time.Duration
+
+	// Club details printed on invoices. ClubCode prefixes invoice numbers.
+	ClubCode    string
+	ClubName    string
+	ClubAddress string
+	ClubTaxID   string
+	ClubEmail   string
+	// FiscalYearStartMonth (1-12) is when invoice numbering restarts.
+	FiscalYearStartMonth int
+	// TaxRateBasisPoints is the default tax rate; TAX_RATE is a percentage.
+	// Invoices of payments without a recorded tax split use it.
+	TaxRateBasisPoints int
+	// TaxRates are the rates per product type (TAX_RATE_CLASS,
+	// TAX_RATE_MEMBERSHIP, TAX_RATE_PRODUCT, defaulting to TAX_RATE) and
+	// TAX_MODE: "inclusive" when prices contain the tax, "exclusive" when it
+	// is added on top.
+	TaxRates tax.Rates
+
+	// MembershipGracePeriod is how long a member keeps their tier after a
+	// failed renewal; renewals are retried every MembershipRetryInterval.
+	MembershipGracePeriod   time.Duration
+	MembershipRetryInterval time.Duration
+	// MembershipLapseAction is "downgrade" (to MembershipLapseTier) or
+	// "deactivate" for members whose subscription ends.
+	MembershipLapseAction string
+	MembershipLapseTier   string
 }
 
 func LoadConfig() *Config {
@@ -38,7 +85,20 @@ func LoadConfig() *Config {
 		RedisAddr:    getEnv("REDIS_ADDR", "localhost:6379"),
 		RedisPassword: os.Getenv("REDIS_PASSWORD"),
 		JWTSecret:    getEnv("JWT_SECRET", "changeme"),
+
+		PaymentProvider:      getEnv("PAYMENT_PROVIDER", "fake"),
+		PaymentWebhookSecret: getEnv("PAYMENT_WEBHOOK_SECRET", "changeme-webhook"),
+
+		ClubCode:    getEnv("CLUB_CODE", "GF"),
+		ClubName:    getEnv("CLUB_NAME", "GymFlow"),
+		ClubAddress: os.Getenv("CLUB_ADDRESS"),
+		ClubTaxID:   os.Getenv("CLUB_TAX_ID"),
+		ClubEmail:   os.Getenv("CLUB_EMAIL"),
+
+		MembershipLapseAction: getEnv("MEMBERSHIP_LAPSE_ACTION", "downgrade"),
+		MembershipLapseTier:   getEnv("MEMBERSHIP_LAPSE_TIER", "basic"),
 	}
+	cfg.PaymentCallbackURL = getEnv("PAYMENT_CALLBACK_URL", "http://localhost:"+cfg.AppPort+"/api/v1/payments/webhook")
 
 	redisDB, err := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	if err != nil {
@@ -52,9 +112,82 @@ func LoadConfig() *Config {
 	}
 	cfg.JWTTTLHours = ttl
 
+	delay, err := time.ParseDuration(getEnv("FAKE_PAYMENT_DELAY", "5s"))
+	if err != nil {
+		log.Fatalf("invalid FAKE_PAYMENT_DELAY: %v", err)
+	}
+	cfg.FakePaymentDelay = delay
+
+	idemTTL, err := time.ParseDuration(getEnv("IDEMPOTENCY_KEY_TTL", "24h"))
+	if err != nil {
+		log.Fatalf("invalid IDEMPOTENCY_KEY_TTL: %v", err)
+	}
+	cfg.IdempotencyKeyTTL = idemTTL
+
+	fyStart, err := strconv.Atoi(getEnv("FISCAL_YEAR_START_MONTH", "1"))
+	if err != nil || fyStart < 1 || fyStart > 12 {
+		log.Fatalf("invalid FISCAL_YEAR_START_MONTH: %q", os.Getenv("FISCAL_YEAR_START_MONTH"))
+	}
+	cfg.FiscalYearStartMonth = fyStart
+
+	taxRate, err := strconv.ParseFloat(getEnv("TAX_RATE", "0"), 64)
+	if err != nil || taxRate < 0 || taxRate >= 100 {
+		log.Fatalf("invalid TAX_RATE: %q", os.Getenv("TAX_RATE"))
+	}
+	cfg.TaxRateBasisPoints = int(math.Round(taxRate * 100))
+	cfg.TaxRates = tax.Rates{
+		Mode:       getEnv("TAX_MODE", tax.ModeInclusive),
+		Class:      taxRateEnv("TAX_RATE_CLASS", cfg.TaxRateBasisPoints),
+		Membership: taxRateEnv("TAX_RATE_MEMBERSHIP", cfg.TaxRateBasisPoints),
+		Product:    taxRateEnv("TAX_RATE_PRODUCT", cfg.TaxRateBasisPoints),
+	}
+	if err := cfg.TaxRates.Validate(); err != nil {
+		log.Fatalf("invalid tax settings: %v", err)
+	}
+
+	graceDays, err := strconv.Atoi(getEnv("MEMBERSHIP_GRACE_DAYS", "7"))
+	if err != nil || graceDays < 0 {
+		log.Fatalf("invalid MEMBERSHIP_GRACE_DAYS: %q", os.Getenv("MEMBERSHIP_GRACE_DAYS"))
+	}
+	cfg.MembershipGracePeriod = time.Duration(graceDays) * 24 * time.Hour
+
+	retry, err := time.ParseDuration(getEnv("MEMBERSHIP_RETRY_INTERVAL", "24h"))
+	if err != nil || retry <= 0 {
+		log.Fatalf("invalid MEMBERSHIP_RETRY_INTERVAL: %q", os.Getenv("MEMBERSHIP_RETRY_INTERVAL"))
+	}
+	cfg.MembershipRetryInterval = retry
+
+	if cfg.MembershipLapseAction != "downgrade" && cfg.MembershipLapseAction != "deactivate" {
+		log.Fatalf("invalid MEMBERSHIP_LAPSE_ACTION: %q", cfg.MembershipLapseAction)
+	}
+
+	cfg.Currency = strings.ToUpper(getEnv("DEFAULT_CURRENCY", "USD"))
+	if !money.Known(cfg.Currency) {
+		log.Fatalf("invalid DEFAULT_CURRENCY: %q", cfg.Currency)
+	}
+
+	threshold, err := money.Parse(getEnv("REFUND_APPROVAL_THRESHOLD", "100"), cfg.Currency)
+	if err != nil {
+		log.Fatalf("invalid REFUND_APPROVAL_THRESHOLD: %v", err)
+	}
+	cfg.RefundApprovalThreshold = threshold
+
 	return cfg
 }
 
+// taxRateEnv reads a percentage such as "20" or "5.5" as basis points.
+func taxRateEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 || rate >= 100 {
+		log.Fatalf("invalid %s: %q", key, v)
+	}
+	return int(math.Round(rate * 100))
+}
+
 func getEnv(key, def string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
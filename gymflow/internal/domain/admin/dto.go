@@ -1,10 +1,33 @@
 package admin
 
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// DashboardQuery selects the tax report of the dashboard: totals per day,
+// month (the default) or year between From and To, the last year by default.
+type DashboardQuery struct {
+	TaxPeriod string     `form:"tax_period"`
+	From      *time.Time `form:"from" time_format:"2006-01-02"`
+	To        *time.Time `form:"to" time_format:"2006-01-02"`
+}
+
 type DashboardResponse struct {
 	TotalUsers     int64   `json:"total_users"`
 	TotalClasses   int64   `json:"total_classes"`
 	TotalBookings  int64   `json:"total_bookings"`
-	TotalRevenue   float64 `json:"total_revenue"`
+	TotalRevenue   []money.Money `json:"total_revenue"` // one entry per currency
 	ActiveMembers  int64   `json:"active_members"`
 	UpcomingClasses int64  `json:"upcoming_classes"`
+	TaxCollected   []TaxPeriod `json:"tax_collected"` // per period and currency
+}
+
+// TaxPeriod is what invoices issued in one period came to, less credit notes.
+type TaxPeriod struct {
+	Period string      `json:"period"` // e.g. 2026-01; 2026-01-15 by day, 2026 by year
+	Net    money.Money `json:"net"`
+	Tax    money.Money `json:"tax"`
+	Gross  money.Money `json:"gross"`
 }
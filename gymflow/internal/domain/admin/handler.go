@@ -1,6 +1,7 @@
 package admin
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,9 +15,18 @@ func NewHandler(service Service) *Handler {
 	return &Handler{service: service}
 }
 
-// GET /api/v1/admin/dashboard
+// GET /api/v1/admin/dashboard?tax_period=month&from=2026-01-01&to=2027-01-01
 func (h *Handler) Dashboard(c *gin.Context) {
-	resp, err := h.service.GetDashboard()
+	var q DashboardQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.service.GetDashboard(q)
+	if errors.Is(err, ErrInvalidPeriod) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load dashboard"})
 		return
@@ -1,16 +1,29 @@
 package admin
 
 import (
+	"errors"
+	"sort"
 	"time"
 
 	"gymflow/internal/domain/booking"
-	"gymflow/internal/domain/payment"
+	"gymflow/internal/domain/invoice"
+	"gymflow/internal/domain/ledger"
 	"gymflow/internal/domain/user"
+	"gymflow/internal/money"
 	"gorm.io/gorm"
 )
 
 type Service interface {
-	GetDashboard() (*DashboardResponse, error)
+	GetDashboard(q DashboardQuery) (*DashboardResponse, error)
+}
+
+var ErrInvalidPeriod = errors.New("tax_period must be day, month or year")
+
+// periodLayouts formats the start of a reporting period.
+var periodLayouts = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
 }
 
 type service struct {
@@ -21,7 +34,14 @@ func NewService(db *gorm.DB) Service {
 	return &service{db: db}
 }
 
-func (s *service) GetDashboard() (*DashboardResponse, error) {
+func (s *service) GetDashboard(q DashboardQuery) (*DashboardResponse, error) {
+	if q.TaxPeriod == "" {
+		q.TaxPeriod = "month"
+	}
+	layout, ok := periodLayouts[q.TaxPeriod]
+	if !ok {
+		return nil, ErrInvalidPeriod
+	}
 	var resp DashboardResponse
 
 	s.db.Model(&user.User{}).Count(&resp.TotalUsers)
@@ -31,12 +51,76 @@ func (s *service) GetDashboard() (*DashboardResponse, error) {
 	s.db.Model(&user.User{}).Where("active = ?", true).Count(&resp.ActiveMembers)
 	s.db.Model(&booking.GymClass{}).Where("start_time > ?", time.Now()).Count(&resp.UpcomingClasses)
 
+	// Revenue is what was collected, net of refunds: the balance of the
+	// ledger's asset accounts. Amounts in different currencies can't be
+	// added, so there is one total per currency.
 	type res struct {
-		Sum float64
+		Currency string
+		Sum      int64
+	}
+	var rows []res
+	s.db.Model(&ledger.Entry{}).
+		Select("amount_currency AS currency, COALESCE(SUM(amount_minor), 0) AS sum").
+		Where("account IN ?", ledger.AssetAccounts).
+		Group("amount_currency").
+		Order("amount_currency").
+		Scan(&rows)
+	resp.TotalRevenue = []money.Money{}
+	for _, r := range rows {
+		resp.TotalRevenue = append(resp.TotalRevenue, money.New(r.Sum, r.Currency))
 	}
-	var r res
-	s.db.Model(&payment.Payment{}).Select("COALESCE(sum(amount),0) as sum").Scan(&r)
-	resp.TotalRevenue = r.Sum
+
+	resp.TaxCollected = s.taxCollected(q, layout)
 
 	return &resp, nil
 }
+
+// taxCollected totals issued invoices per period and currency, less their
+// credit notes. It defaults to the last twelve months. Periods are bucketed
+// here rather than in SQL, which has no portable date truncation.
+func (s *service) taxCollected(q DashboardQuery, layout string) []TaxPeriod {
+	to := time.Now().UTC()
+	if q.To != nil {
+		to = q.To.UTC()
+	}
+	from := to.AddDate(-1, 0, 0)
+	if q.From != nil {
+		from = q.From.UTC()
+	}
+
+	var docs []invoice.Invoice
+	s.db.Model(&invoice.Invoice{}).
+		Select("type", "issued_at", "net_minor", "net_currency", "tax_minor", "tax_currency", "total_minor", "total_currency").
+		Where("issued_at >= ? AND issued_at < ?", from, to).
+		Find(&docs)
+
+	type key struct{ period, currency string }
+	totals := map[key]*TaxPeriod{}
+	for _, d := range docs {
+		k := key{d.IssuedAt.UTC().Format(layout), d.Total.Currency}
+		t, ok := totals[k]
+		if !ok {
+			t = &TaxPeriod{Period: k.period, Net: money.Zero(k.currency), Tax: money.Zero(k.currency), Gross: money.Zero(k.currency)}
+			totals[k] = t
+		}
+		sign := int64(1)
+		if d.Type == invoice.TypeCreditNote {
+			sign = -1
+		}
+		t.Net.Amount += sign * d.Net.Amount
+		t.Tax.Amount += sign * d.Tax.Amount
+		t.Gross.Amount += sign * d.Total.Amount
+	}
+
+	out := make([]TaxPeriod, 0, len(totals))
+	for _, t := range totals {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Period != out[j].Period {
+			return out[i].Period < out[j].Period
+		}
+		return out[i].Gross.Currency < out[j].Gross.Currency
+	})
+	return out
+}
@@ -1,9 +1,16 @@
 package admin
 
 import (
+	"fmt"
 	"testing"
+	"time"
+
+	"gymflow/internal/domain/invoice"
+	"gymflow/internal/domain/ledger"
+	"gymflow/internal/money"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
@@ -21,7 +28,7 @@ func TestGetDashboard_Success(t *testing.T) {
 	service := NewService(db)
 
 	// Create service
-	dashboard, err := service.GetDashboard()
+	dashboard, err := service.GetDashboard(DashboardQuery{})
 
 	// Should not error even with empty database
 	assert.NoError(t, err)
@@ -31,19 +38,73 @@ func TestGetDashboard_Success(t *testing.T) {
 	assert.GreaterOrEqual(t, dashboard.TotalUsers, int64(0))
 	assert.GreaterOrEqual(t, dashboard.TotalClasses, int64(0))
 	assert.GreaterOrEqual(t, dashboard.TotalBookings, int64(0))
-	assert.GreaterOrEqual(t, dashboard.TotalRevenue, 0.0)
+	assert.NotNil(t, dashboard.TotalRevenue)
 }
 
 func TestGetDashboard_EmptyDatabase(t *testing.T) {
 	db := setupTestDB()
 	service := NewService(db)
 
-	dashboard, err := service.GetDashboard()
+	dashboard, err := service.GetDashboard(DashboardQuery{})
 
 	assert.NoError(t, err)
 	assert.NotNil(t, dashboard)
 	assert.Equal(t, int64(0), dashboard.TotalUsers)
 	assert.Equal(t, int64(0), dashboard.TotalClasses)
 	assert.Equal(t, int64(0), dashboard.TotalBookings)
-	assert.Equal(t, 0.0, dashboard.TotalRevenue)
-}
\ No newline at end of file
+	assert.Empty(t, dashboard.TotalRevenue)
+}
+func TestGetDashboard_TaxCollected(t *testing.T) {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&invoice.Invoice{}, &invoice.Line{}))
+	usd := func(minor int64) money.Money { return money.New(minor, "USD") }
+	jan := time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC)
+	feb := time.Date(2026, 2, 3, 12, 0, 0, 0, time.UTC)
+	for i, inv := range []invoice.Invoice{
+		{Type: invoice.TypeInvoice, IssuedAt: jan, Net: usd(1000), Tax: usd(200), Total: usd(1200)},
+		{Type: invoice.TypeInvoice, IssuedAt: jan.Add(time.Hour), Net: usd(500), Tax: usd(100), Total: usd(600)},
+		{Type: invoice.TypeCreditNote, IssuedAt: feb, Net: usd(500), Tax: usd(100), Total: usd(600)},
+	} {
+		inv.Number = fmt.Sprintf("GF-%d", i)
+		inv.ClubCode = "GF"
+		inv.Sequence = int64(i)
+		require.NoError(t, db.Create(&inv).Error)
+	}
+	service := NewService(db)
+
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	dashboard, err := service.GetDashboard(DashboardQuery{From: &from, To: &to})
+	require.NoError(t, err)
+	assert.Equal(t, []TaxPeriod{
+		{Period: "2026-01", Net: usd(1500), Tax: usd(300), Gross: usd(1800)},
+		{Period: "2026-02", Net: usd(-500), Tax: usd(-100), Gross: usd(-600)},
+	}, dashboard.TaxCollected)
+
+	dashboard, err = service.GetDashboard(DashboardQuery{TaxPeriod: "year", From: &from, To: &to})
+	require.NoError(t, err)
+	assert.Equal(t, []TaxPeriod{{Period: "2026", Net: usd(1000), Tax: usd(200), Gross: usd(1200)}}, dashboard.TaxCollected)
+
+	_, err = service.GetDashboard(DashboardQuery{TaxPeriod: "week"})
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+}
+
+func TestGetDashboard_RevenueFromLedger(t *testing.T) {
+	db := setupTestDB()
+	require.NoError(t, db.AutoMigrate(&ledger.Entry{}))
+	for _, e := range []ledger.Entry{
+		{Account: ledger.AccountProviderClearing, Amount: money.New(1200, "USD")},
+		{Account: ledger.AccountClassRevenue, Amount: money.New(-1200, "USD")},
+		{Account: ledger.AccountProviderClearing, Amount: money.New(-200, "USD")},
+		{Account: ledger.AccountClassRevenue, Amount: money.New(200, "USD")},
+		{Account: ledger.AccountProviderClearing, Amount: money.New(900, "EUR")},
+		{Account: ledger.AccountMembershipRevenue, Amount: money.New(-900, "EUR")},
+	} {
+		require.NoError(t, db.Create(&e).Error)
+	}
+	service := NewService(db)
+
+	dashboard, err := service.GetDashboard(DashboardQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []money.Money{money.New(900, "EUR"), money.New(1000, "USD")}, dashboard.TotalRevenue)
+}
@@ -0,0 +1,229 @@
+package booking
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// checkInOpensBefore is how long before StartTime the door opens for check-in.
+// Check-in closes when the class ends; later corrections are admin-only.
+const checkInOpensBefore = 30 * time.Minute
+
+// adminRole mirrors user.RoleAdmin. Admins act as front-desk staff.
+const adminRole = "admin"
+
+var (
+	ErrNotClassStaff     = errors.New("only the class trainer or front-desk staff can check members in")
+	ErrCheckInClosed     = errors.New("check-in is not open for this class")
+	ErrNotCheckable      = errors.New("only booked seats can be checked in")
+	ErrBookingNotInClass = errors.New("booking does not belong to this class")
+	ErrAlreadyAttended   = errors.New("booking is already checked in or closed")
+)
+
+// noShowCorrected is the waive reason of a no-show penalty voided by a
+// late check-in.
+const noShowCorrected = "no-show corrected at check-in"
+
+// CheckIn marks a booked seat as attended. The trainer running the class can
+// check in during the check-in window; admins (front desk) can also check in
+// after the class, which turns a no-show back into attendance and voids its
+// penalty.
+func (s *service) CheckIn(classID, bookingID, staffID uint, staffRole string) (*Booking, error) {
+	var (
+		b      *Booking
+		refund *uint // charged fee of a voided no-show penalty
+	)
+	err := s.repo.Transaction(func(repo Repository) error {
+		// Under the class lock, so the no-show job can't close the seat
+		// while it is checked in.
+		class, err := repo.LockClass(classID)
+		if err != nil {
+			return err
+		}
+		if staffRole != adminRole && class.TrainerID != staffID {
+			return ErrNotClassStaff
+		}
+		if class.Status == ClassStatusCancelled {
+			return ErrClassCancelled
+		}
+
+		if b, err = repo.FindBookingByID(bookingID); err != nil {
+			return err
+		}
+		if b.ClassID != class.ID {
+			return ErrBookingNotInClass
+		}
+
+		now := time.Now()
+		switch b.Status {
+		case BookingStatusBooked:
+			if now.Before(class.StartTime.Add(-checkInOpensBefore)) {
+				return ErrCheckInClosed
+			}
+			if now.After(class.EndTime) && staffRole != adminRole {
+				return ErrCheckInClosed
+			}
+		case BookingStatusNoShow:
+			if staffRole != adminRole {
+				return ErrCheckInClosed
+			}
+			if refund, err = voidNoShowPenalty(repo, b.ID, staffID, now); err != nil {
+				return err
+			}
+		case BookingStatusAttended:
+			return ErrAlreadyAttended
+		default:
+			return ErrNotCheckable
+		}
+
+		b.Status = BookingStatusAttended
+		b.CheckedInAt = &now
+		b.CheckedInBy = &staffID
+		return repo.UpdateBooking(b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refund != nil && s.payments != nil {
+		// SettlePenaltyFees refunds it later if this fails: the fee is
+		// waived and its charge collected.
+		if err := s.payments.RefundPayment(*refund, RefundReasonFeeWaived); err != nil {
+			log.Printf("refund fee of no-show booking %d: %v", b.ID, err)
+		}
+	}
+	return b, nil
+}
+
+// voidNoShowPenalty waives the no-show penalty of the booking, if it has
+// one, and returns the charge of its fee when that needs refunding.
+func voidNoShowPenalty(repo Repository, bookingID, adminID uint, now time.Time) (*uint, error) {
+	found, err := repo.FindPenaltyByBooking(bookingID, PenaltyReasonNoShow)
+	if err != nil || found == nil {
+		return nil, err
+	}
+	pen, err := repo.LockPenalty(found.ID)
+	if err != nil {
+		return nil, err
+	}
+	if pen.WaivedAt != nil {
+		return nil, nil
+	}
+	charged := pen.Type == PenaltyFee && pen.FeeStatus == FeeCharged
+	if err := waive(repo, pen, adminID, noShowCorrected, now); err != nil {
+		return nil, err
+	}
+	if charged {
+		return pen.PaymentID, nil
+	}
+	return nil, nil
+}
+
+// MarkNoShows turns booked seats of finished classes into no-shows, applies
+// the no-show penalty of the cancellation policy and returns the affected
+// bookings. It is driven by a background job.
+func (s *service) MarkNoShows() ([]Booking, error) {
+	pending, err := s.repo.ListBookedEndedBefore(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	marked := make([]Booking, 0, len(pending))
+	for i := range pending {
+		b := &pending[i]
+		skipped := false
+		err := s.repo.Transaction(func(repo Repository) error {
+			// Re-read under the class lock: staff may have checked the
+			// member in since the list was loaded.
+			class, err := repo.LockClass(b.ClassID)
+			if err != nil {
+				return err
+			}
+			current, err := repo.FindBookingByID(b.ID)
+			if err != nil {
+				return err
+			}
+			if current.Status != BookingStatusBooked {
+				skipped = true
+				return nil
+			}
+			*b = *current
+			b.Status = BookingStatusNoShow
+			if err := repo.UpdateBooking(b); err != nil {
+				return err
+			}
+			policy, err := resolvePolicy(repo, b.UserID, class)
+			if err != nil {
+				return err
+			}
+			b.Penalty, err = s.applyPenalty(repo, b, PenaltyReasonNoShow, policy)
+			return err
+		})
+		if err != nil {
+			return marked, err
+		}
+		if skipped {
+			continue
+		}
+		s.chargePenaltyFees(b.Penalty)
+		marked = append(marked, *b)
+	}
+	return marked, nil
+}
+
+func (s *service) GetMemberAttendance(userID uint) (*MemberAttendanceResponse, error) {
+	records, err := s.repo.ListAttendanceByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]string, 0, len(records))
+	for _, r := range records {
+		statuses = append(statuses, r.Status)
+	}
+	return &MemberAttendanceResponse{
+		UserID:  userID,
+		Summary: summarizeAttendance(statuses),
+		Records: records,
+	}, nil
+}
+
+// GetClassAttendance returns the class roster: every booking that holds a seat.
+func (s *service) GetClassAttendance(classID, staffID uint, staffRole string) (*ClassAttendanceResponse, error) {
+	class, err := s.repo.FindClassByID(classID)
+	if err != nil {
+		return nil, err
+	}
+	if staffRole != adminRole && class.TrainerID != staffID {
+		return nil, ErrNotClassStaff
+	}
+	bookings, err := s.repo.ListBookingsForClass(class.ID, seatStatuses...)
+	if err != nil {
+		return nil, err
+	}
+	resp := &ClassAttendanceResponse{
+		ClassID:  class.ID,
+		Bookings: make([]*BookingResponse, 0, len(bookings)),
+	}
+	statuses := make([]string, 0, len(bookings))
+	for i := range bookings {
+		resp.Bookings = append(resp.Bookings, ToBookingResponse(&bookings[i]))
+		statuses = append(statuses, bookings[i].Status)
+	}
+	resp.Summary = summarizeAttendance(statuses)
+	return resp, nil
+}
+
+func summarizeAttendance(statuses []string) AttendanceSummary {
+	var sum AttendanceSummary
+	for _, st := range statuses {
+		switch st {
+		case BookingStatusAttended:
+			sum.Attended++
+		case BookingStatusNoShow:
+			sum.NoShow++
+		}
+	}
+	if total := sum.Attended + sum.NoShow; total > 0 {
+		sum.AttendanceRate = float64(sum.Attended) / float64(total)
+	}
+	return sum
+}
@@ -0,0 +1,185 @@
+package booking
+
+import (
+	"testing"
+	"time"
+
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckIn_WindowAndStaff(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+
+	b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+
+	// A day ahead: the window is not open yet.
+	_, err = service.CheckIn(class.ID, b.ID, 1, trainerRole)
+	assert.ErrorIs(t, err, ErrCheckInClosed)
+
+	// Another trainer cannot check in someone else's class.
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(10 * time.Minute).UTC(),
+		"end_time":   time.Now().Add(70 * time.Minute).UTC(),
+	})
+	_, err = service.CheckIn(class.ID, b.ID, 3, trainerRole)
+	assert.ErrorIs(t, err, ErrNotClassStaff)
+
+	checked, err := service.CheckIn(class.ID, b.ID, 1, trainerRole)
+	require.NoError(t, err)
+	assert.Equal(t, BookingStatusAttended, checked.Status)
+	require.NotNil(t, checked.CheckedInBy)
+	assert.Equal(t, uint(1), *checked.CheckedInBy)
+
+	_, err = service.CheckIn(class.ID, b.ID, 1, trainerRole)
+	assert.ErrorIs(t, err, ErrAlreadyAttended)
+
+	_, err = service.CancelBooking(2, b.ID)
+	assert.ErrorIs(t, err, ErrAlreadyAttended)
+}
+
+// checkInAfterListing lets a test act between the no-show job listing the
+// bookings and marking them.
+type checkInAfterListing struct {
+	Repository
+	after func()
+}
+
+func (r checkInAfterListing) ListBookedEndedBefore(t time.Time) ([]Booking, error) {
+	bookings, err := r.Repository.ListBookedEndedBefore(t)
+	r.after()
+	return bookings, err
+}
+
+func TestMarkNoShows_KeepsLateCheckIn(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{cardStatus: PaymentStatusPaid}
+	class := createTestClass(t, db, 5)
+	_, err := NewService(NewRepository(db), nil).CreatePolicy(CancellationPolicyRequest{
+		NoShowPenalty: PenaltyFee,
+		NoShowFee:     money.New(500, "USD"),
+	})
+	require.NoError(t, err)
+	b, err := NewService(NewRepository(db), nil).CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(-2 * time.Hour).UTC(),
+		"end_time":   time.Now().Add(-time.Hour).UTC(),
+	})
+
+	var desk Service
+	service := NewService(checkInAfterListing{Repository: NewRepository(db), after: func() {
+		_, err := desk.CheckIn(class.ID, b.ID, 9, adminRole)
+		require.NoError(t, err)
+	}}, payments)
+	desk = NewService(NewRepository(db), nil)
+
+	marked, err := service.MarkNoShows()
+	require.NoError(t, err)
+	assert.Empty(t, marked)
+	assert.Empty(t, payments.fees)
+	var got Booking
+	require.NoError(t, db.First(&got, b.ID).Error)
+	assert.Equal(t, BookingStatusAttended, got.Status)
+}
+
+func TestMarkNoShows_AndAdminCorrection(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+
+	missed, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	came, _ := service.CreateBooking(4, CreateBookingRequest{ClassID: class.ID})
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(-20 * time.Minute).UTC(),
+		"end_time":   time.Now().Add(40 * time.Minute).UTC(),
+	})
+	_, err := service.CheckIn(class.ID, came.ID, 1, trainerRole)
+	require.NoError(t, err)
+
+	// Not over yet: nobody is a no-show.
+	marked, err := service.MarkNoShows()
+	require.NoError(t, err)
+	assert.Empty(t, marked)
+
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(-2 * time.Hour).UTC(),
+		"end_time":   time.Now().Add(-time.Hour).UTC(),
+	})
+	marked, err = service.MarkNoShows()
+	require.NoError(t, err)
+	require.Len(t, marked, 1)
+	assert.Equal(t, missed.ID, marked[0].ID)
+
+	roster, err := service.GetClassAttendance(class.ID, 1, trainerRole)
+	require.NoError(t, err)
+	assert.Equal(t, AttendanceSummary{Attended: 1, NoShow: 1, AttendanceRate: 0.5}, roster.Summary)
+
+	// Trainers cannot fix attendance after the class; the front desk can.
+	_, err = service.CheckIn(class.ID, missed.ID, 1, trainerRole)
+	assert.ErrorIs(t, err, ErrCheckInClosed)
+	_, err = service.CheckIn(class.ID, missed.ID, 99, adminRole)
+	require.NoError(t, err)
+
+	history, err := service.GetMemberAttendance(2)
+	require.NoError(t, err)
+	require.Len(t, history.Records, 1)
+	assert.Equal(t, BookingStatusAttended, history.Records[0].Status)
+	assert.Equal(t, 1.0, history.Summary.AttendanceRate)
+}
+
+func TestCheckIn_CorrectionVoidsNoShowPenalty(t *testing.T) {
+	for _, kind := range []string{PenaltyBan, PenaltyCredit, PenaltyFee} {
+		t.Run(kind, func(t *testing.T) {
+			db := setupTestDB(t)
+			payments := &fakePayments{cardStatus: PaymentStatusPaid}
+			service := NewService(NewRepository(db), payments)
+			class := createTestClass(t, db, 5)
+			_, err := service.CreatePolicy(CancellationPolicyRequest{
+				NoShowPenalty: kind,
+				NoShowFee:     money.New(500, "USD"),
+				BanDays:       7,
+			})
+			require.NoError(t, err)
+			_, err = service.GrantCredits(CreditGrant{UserID: 2, Credits: 2, Source: CreditSourceGrant})
+			require.NoError(t, err)
+			b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+			require.NoError(t, err)
+			before, err := service.GetCredits(2)
+			require.NoError(t, err)
+
+			db.Model(class).Updates(map[string]any{
+				"start_time": time.Now().Add(-2 * time.Hour).UTC(),
+				"end_time":   time.Now().Add(-time.Hour).UTC(),
+			})
+			marked, err := service.MarkNoShows()
+			require.NoError(t, err)
+			require.Len(t, marked, 1)
+			require.NotNil(t, marked[0].Penalty)
+
+			_, err = service.CheckIn(class.ID, b.ID, 99, adminRole)
+			require.NoError(t, err)
+
+			pen, err := NewRepository(db).FindPenaltyByID(marked[0].Penalty.ID)
+			require.NoError(t, err)
+			assert.NotNil(t, pen.WaivedAt)
+			after, err := service.GetCredits(2)
+			require.NoError(t, err)
+			assert.Equal(t, before.Balance, after.Balance)
+			switch kind {
+			case PenaltyBan:
+				next := createTestClass(t, db, 5)
+				_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: next.ID})
+				assert.NoError(t, err, "the ban is lifted")
+			case PenaltyFee:
+				assert.Equal(t, FeeWaived, pen.FeeStatus)
+				assert.Equal(t, []uint{*pen.PaymentID}, payments.refundedPayments)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package booking
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClassPageSize = 20
+	maxClassPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid or expired cursor")
+	ErrInvalidSort   = errors.New("unknown sort key")
+)
+
+// classSortColumns whitelists the sort keys of GET /classes. A leading "-"
+// in the query reverses the order.
+var classSortColumns = map[string]string{
+	"start_time": "start_time",
+	"name":       "name",
+	"price":      "price_minor",
+}
+
+// ClassFilter is a parsed ListClassesQuery. Pages are keyset-paginated on
+// (sort column, id), so inserts between requests never shift a page.
+type ClassFilter struct {
+	From             time.Time
+	To               *time.Time
+	TrainerID        uint
+	Name             string
+	ClassType        string
+	OnlyOpen         bool
+	IncludeCancelled bool
+	Sort             string // query key, e.g. "-price"; cursors are bound to it
+	SortColumn       string
+	Desc             bool
+	After            *classCursor
+	Limit            int
+}
+
+// classCursor is the sort value and id of the last class of a page. Sort is
+// kept so a cursor can't be replayed against a different order.
+type classCursor struct {
+	Sort  string    `json:"s"`
+	Time  time.Time `json:"t,omitempty"`
+	Name  string    `json:"n,omitempty"`
+	Price int64     `json:"p,omitempty"` // minor units
+	ID    uint      `json:"id"`
+}
+
+func (c *classCursor) value() any {
+	switch strings.TrimPrefix(c.Sort, "-") {
+	case "name":
+		return c.Name
+	case "price":
+		return c.Price
+	default:
+		return c.Time.UTC()
+	}
+}
+
+func encodeClassCursor(sort string, c *GymClass) string {
+	raw, _ := json.Marshal(classCursor{Sort: sort, Time: c.StartTime.UTC(), Name: c.Name, Price: c.Price.Amount, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeClassCursor(s, sort string) (*classCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c classCursor
+	if err := json.Unmarshal(raw, &c); err != nil || c.ID == 0 || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// parseClassQuery turns query parameters into a ClassFilter. Without from,
+// only classes that have not started yet are listed.
+func parseClassQuery(q ListClassesQuery, now time.Time) (*ClassFilter, error) {
+	f := &ClassFilter{
+		From:             now.UTC(),
+		TrainerID:        q.TrainerID,
+		Name:             strings.TrimSpace(q.Q),
+		ClassType:        normalizeClassType(q.ClassType),
+		OnlyOpen:         q.HasSeats,
+		IncludeCancelled: q.IncludeCancelled,
+		Limit:            q.Limit,
+	}
+	if q.From != "" {
+		from, err := time.Parse(time.RFC3339, q.From)
+		if err != nil {
+			return nil, err
+		}
+		f.From = from.UTC()
+	}
+	if q.To != "" {
+		to, err := time.Parse(time.RFC3339, q.To)
+		if err != nil {
+			return nil, err
+		}
+		to = to.UTC()
+		if to.Before(f.From) {
+			return nil, ErrInvalidTimeRange
+		}
+		f.To = &to
+	}
+
+	sort := q.Sort
+	if sort == "" {
+		sort = "start_time"
+	}
+	f.Desc = strings.HasPrefix(sort, "-")
+	column, ok := classSortColumns[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	f.Sort = sort
+	f.SortColumn = column
+
+	if f.Limit <= 0 {
+		f.Limit = defaultClassPageSize
+	}
+	if f.Limit > maxClassPageSize {
+		f.Limit = maxClassPageSize
+	}
+	if q.Cursor != "" {
+		c, err := decodeClassCursor(q.Cursor, sort)
+		if err != nil {
+			return nil, err
+		}
+		f.After = c
+	}
+	return f, nil
+}
+
+// ListClasses returns one page of classes with their remaining seats and the
+// cursor of the next page ("" on the last page).
+func (s *service) ListClasses(q ListClassesQuery) ([]GymClass, string, error) {
+	f, err := parseClassQuery(q, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	// One extra row tells us whether there is a next page.
+	f.Limit++
+	classes, err := s.repo.ListClasses(*f)
+	if err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if len(classes) == f.Limit {
+		classes = classes[:f.Limit-1]
+		next = encodeClassCursor(f.Sort, &classes[len(classes)-1])
+	}
+
+	ids := make([]uint, 0, len(classes))
+	for _, c := range classes {
+		ids = append(ids, c.ID)
+	}
+	taken, err := s.repo.CountSeatsForClasses(ids)
+	if err != nil {
+		return nil, "", err
+	}
+	for i := range classes {
+		left := classes[i].Capacity - int(taken[classes[i].ID])
+		if left < 0 {
+			left = 0
+		}
+		classes[i].RemainingSeats = &left
+	}
+	return classes, next, nil
+}
@@ -0,0 +1,122 @@
+package booking
+
+import (
+	"testing"
+	"time"
+
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func seedClasses(t *testing.T, db *gorm.DB) []GymClass {
+	base := time.Now().Add(time.Hour).Truncate(time.Hour).UTC()
+	classes := []GymClass{
+		{Name: "Yoga", TrainerID: 1, Capacity: 2, Price: money.New(1000, "USD"), StartTime: base.Add(-48 * time.Hour)}, // past
+		{Name: "Spin", TrainerID: 1, Capacity: 1, Price: money.New(1500, "USD"), StartTime: base},
+		{Name: "Yoga Flow", TrainerID: 3, Capacity: 5, Price: money.New(1000, "USD"), StartTime: base.Add(2 * time.Hour)},
+		{Name: "Boxing", TrainerID: 3, Capacity: 5, Price: money.New(2000, "USD"), StartTime: base.Add(2 * time.Hour)},
+		{Name: "100%_Abs", TrainerID: 1, Capacity: 5, Price: money.New(1000, "USD"), StartTime: base.Add(24 * time.Hour)},
+		{Name: "Pilates", TrainerID: 1, Capacity: 5, Price: money.New(1200, "USD"), StartTime: base.Add(48 * time.Hour), Status: ClassStatusCancelled},
+	}
+	for i := range classes {
+		classes[i].EndTime = classes[i].StartTime.Add(time.Hour)
+		require.NoError(t, db.Create(&classes[i]).Error)
+	}
+	return classes
+}
+
+func classNames(classes []GymClass) []string {
+	names := make([]string, 0, len(classes))
+	for _, c := range classes {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestListClasses_DefaultsToUpcomingByStartTime(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	seeded := seedClasses(t, db)
+
+	_, err := service.CreateBooking(2, CreateBookingRequest{ClassID: seeded[1].ID})
+	require.NoError(t, err)
+
+	classes, next, err := service.ListClasses(ListClassesQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	// Same start time: ties are broken by id.
+	assert.Equal(t, []string{"Spin", "Yoga Flow", "Boxing", "100%_Abs"}, classNames(classes))
+	require.NotNil(t, classes[0].RemainingSeats)
+	assert.Equal(t, 0, *classes[0].RemainingSeats)
+	assert.Equal(t, 5, *classes[1].RemainingSeats)
+}
+
+func TestListClasses_Filters(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	seeded := seedClasses(t, db)
+	service.CreateBooking(2, CreateBookingRequest{ClassID: seeded[1].ID})
+
+	for _, tc := range []struct {
+		name  string
+		query ListClassesQuery
+		want  []string
+	}{
+		{"trainer", ListClassesQuery{TrainerID: 3}, []string{"Yoga Flow", "Boxing"}},
+		{"name search", ListClassesQuery{Q: "yOGa"}, []string{"Yoga Flow"}},
+		{"wildcards are literal", ListClassesQuery{Q: "%_"}, []string{"100%_Abs"}},
+		{"open seats", ListClassesQuery{HasSeats: true}, []string{"Yoga Flow", "Boxing", "100%_Abs"}},
+		{"cancelled", ListClassesQuery{IncludeCancelled: true, TrainerID: 1}, []string{"Spin", "100%_Abs", "Pilates"}},
+		{"range", ListClassesQuery{
+			From: seeded[0].StartTime.Format(time.RFC3339),
+			To:   seeded[2].StartTime.Format(time.RFC3339),
+		}, []string{"Yoga", "Spin", "Yoga Flow", "Boxing"}},
+		{"sort by price desc", ListClassesQuery{Sort: "-price"}, []string{"Boxing", "Spin", "100%_Abs", "Yoga Flow"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			classes, _, err := service.ListClasses(tc.query)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, classNames(classes))
+		})
+	}
+
+	_, _, err := service.ListClasses(ListClassesQuery{
+		From: seeded[2].StartTime.Format(time.RFC3339),
+		To:   seeded[1].StartTime.Format(time.RFC3339),
+	})
+	assert.ErrorIs(t, err, ErrInvalidTimeRange)
+}
+
+func TestListClasses_CursorPagination(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	seedClasses(t, db)
+
+	for _, sort := range []string{"start_time", "-start_time", "name", "-price"} {
+		all, _, err := service.ListClasses(ListClassesQuery{Sort: sort})
+		require.NoError(t, err)
+
+		var paged []GymClass
+		cursor := ""
+		for {
+			page, next, err := service.ListClasses(ListClassesQuery{Sort: sort, Limit: 1, Cursor: cursor})
+			require.NoError(t, err)
+			paged = append(paged, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		assert.Equal(t, classNames(all), classNames(paged), sort)
+	}
+
+	_, next, err := service.ListClasses(ListClassesQuery{Limit: 1})
+	require.NoError(t, err)
+	_, _, err = service.ListClasses(ListClassesQuery{Limit: 1, Cursor: next, Sort: "name"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = service.ListClasses(ListClassesQuery{Cursor: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
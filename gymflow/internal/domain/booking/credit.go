@@ -0,0 +1,235 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Where the credits of a lot came from.
+const (
+	CreditSourcePack  = "pack"
+	CreditSourceGrant = "grant"
+)
+
+// Credit ledger reasons.
+const (
+	CreditReasonPurchase = "purchase"
+	CreditReasonGrant    = "grant"
+	CreditReasonBooking  = "booking"
+	CreditReasonRefund   = "booking_refund"
+	CreditReasonPenalty  = "penalty"
+	CreditReasonWaived   = "penalty_waived"
+	CreditReasonExpiry   = "expiry"
+)
+
+var (
+	ErrInsufficientCredits = errors.New("not enough class credits")
+	ErrInvalidCreditGrant  = errors.New("invalid credit grant")
+)
+
+// CreditGrant adds a lot of credits to a member's wallet. Grants for a class
+// pack purchase carry its PurchaseID and are applied at most once.
+type CreditGrant struct {
+	UserID     uint
+	Credits    int
+	ExpiresAt  *time.Time
+	Source     string
+	PurchaseID *uint
+	Note       string
+	GrantedBy  *uint
+}
+
+func (s *service) GrantCredits(g CreditGrant) (*CreditLot, error) {
+	if g.Credits <= 0 {
+		return nil, fmt.Errorf("%w: credits must be positive", ErrInvalidCreditGrant)
+	}
+	if g.ExpiresAt != nil && !g.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at is in the past", ErrInvalidCreditGrant)
+	}
+	reason := CreditReasonGrant
+	if g.Source == "" {
+		g.Source = CreditSourceGrant
+	}
+	if g.Source == CreditSourcePack {
+		reason = CreditReasonPurchase
+	}
+
+	var lot *CreditLot
+	err := s.repo.Transaction(func(repo Repository) error {
+		if g.PurchaseID != nil {
+			existing, err := repo.FindCreditLotByPurchase(*g.PurchaseID)
+			if err == nil {
+				lot = existing
+				return nil
+			}
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		lot = &CreditLot{
+			UserID:     g.UserID,
+			Source:     g.Source,
+			PurchaseID: g.PurchaseID,
+			Credits:    g.Credits,
+			Remaining:  g.Credits,
+			ExpiresAt:  g.ExpiresAt,
+		}
+		if err := repo.CreateCreditLot(lot); err != nil {
+			return err
+		}
+		return repo.CreateCreditEntry(&CreditEntry{
+			UserID:    g.UserID,
+			LotID:     lot.ID,
+			Delta:     g.Credits,
+			Reason:    reason,
+			Note:      g.Note,
+			CreatedBy: g.GrantedBy,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lot, nil
+}
+
+func (s *service) GetCredits(userID uint) (*CreditBalanceResponse, error) {
+	lots, err := s.repo.ListUsableCreditLots(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	resp := &CreditBalanceResponse{UserID: userID, Lots: lots}
+	for _, l := range lots {
+		resp.Balance += l.Remaining
+	}
+	return resp, nil
+}
+
+func (s *service) ListCreditLedger(userID uint) ([]CreditEntry, error) {
+	return s.repo.ListCreditEntries(userID)
+}
+
+// ExpireCredits forfeits what is left in lots past their expiry, writing it
+// to the ledger. It is driven by a background job.
+func (s *service) ExpireCredits() error {
+	now := time.Now()
+	lots, err := s.repo.ListExpiredCreditLots(now)
+	if err != nil {
+		return err
+	}
+	for _, l := range lots {
+		err := s.repo.Transaction(func(repo Repository) error {
+			lot, err := repo.LockCreditLot(l.ID)
+			if err != nil {
+				return err
+			}
+			if lot.Remaining <= 0 {
+				return nil
+			}
+			forfeited := lot.Remaining
+			lot.Remaining = 0
+			if err := repo.UpdateCreditLot(lot); err != nil {
+				return err
+			}
+			return repo.CreateCreditEntry(&CreditEntry{
+				UserID: lot.UserID,
+				LotID:  lot.ID,
+				Delta:  -forfeited,
+				Reason: CreditReasonExpiry,
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeCredit debits one credit from the member's lot that expires first.
+// Must be called inside a transaction.
+func takeCredit(repo Repository, userID uint, bookingID uint, reason string, now time.Time) (*CreditLot, error) {
+	lots, err := repo.ListUsableCreditLots(userID, now)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range lots {
+		lot, err := repo.LockCreditLot(l.ID)
+		if err != nil {
+			return nil, err
+		}
+		// Re-check under the lock: a concurrent booking may have emptied it.
+		if lot.Remaining <= 0 || (lot.ExpiresAt != nil && !lot.ExpiresAt.After(now)) {
+			continue
+		}
+		lot.Remaining--
+		if err := repo.UpdateCreditLot(lot); err != nil {
+			return nil, err
+		}
+		err = repo.CreateCreditEntry(&CreditEntry{
+			UserID:    userID,
+			LotID:     lot.ID,
+			Delta:     -1,
+			Reason:    reason,
+			BookingID: &bookingID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return lot, nil
+	}
+	return nil, ErrInsufficientCredits
+}
+
+// payWithCredit pays the seat of b with one credit. Must be called inside a
+// transaction.
+func payWithCredit(repo Repository, b *Booking, now time.Time) error {
+	lot, err := takeCredit(repo, b.UserID, b.ID, CreditReasonBooking, now)
+	if err != nil {
+		return err
+	}
+	b.CreditLotID = &lot.ID
+	b.PaymentStatus = PaymentStatusPaid
+	return repo.UpdateBooking(b)
+}
+
+// returnCredit puts the credit that paid for b back into its lot. A lot that
+// expired meanwhile takes it back too; the expiry job then forfeits it.
+// Must be called inside a transaction.
+func returnCredit(repo Repository, b *Booking) error {
+	if b.CreditLotID == nil || b.PaymentStatus != PaymentStatusPaid {
+		return nil
+	}
+	if err := giveCredit(repo, b.UserID, *b.CreditLotID, b.ID, CreditReasonRefund); err != nil {
+		return err
+	}
+	b.PaymentStatus = PaymentStatusRefunded
+	return repo.UpdateBooking(b)
+}
+
+// giveCredit puts one credit taken for bookingID back into its lot. Must be
+// called inside a transaction.
+func giveCredit(repo Repository, userID, lotID, bookingID uint, reason string) error {
+	lot, err := repo.LockCreditLot(lotID)
+	if err != nil {
+		return err
+	}
+	lot.Remaining++
+	if err := repo.UpdateCreditLot(lot); err != nil {
+		return err
+	}
+	return repo.CreateCreditEntry(&CreditEntry{
+		UserID:    userID,
+		LotID:     lot.ID,
+		Delta:     1,
+		Reason:    reason,
+		BookingID: &bookingID,
+	})
+}
+
+// hasCredits reports whether the member has at least one usable credit.
+func hasCredits(repo Repository, userID uint, now time.Time) (bool, error) {
+	lots, err := repo.ListUsableCreditLots(userID, now)
+	return len(lots) > 0, err
+}
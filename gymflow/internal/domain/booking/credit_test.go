@@ -0,0 +1,187 @@
+package booking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func creditBalance(t *testing.T, service Service, userID uint) int {
+	resp, err := service.GetCredits(userID)
+	require.NoError(t, err)
+	return resp.Balance
+}
+
+func TestPayWithCredits_ConsumedAndReturnedOnFreeCancel(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+
+	_, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 10})
+	require.NoError(t, err)
+
+	b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPaid, b.PaymentStatus)
+	require.NotNil(t, b.CreditLotID)
+	assert.Equal(t, 9, creditBalance(t, service, 2))
+
+	cancelled, err := service.CancelBooking(2, b.ID)
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusRefunded, cancelled.PaymentStatus)
+	assert.Equal(t, 10, creditBalance(t, service, 2))
+
+	ledger, err := service.ListCreditLedger(2)
+	require.NoError(t, err)
+	require.Len(t, ledger, 3)
+	assert.Equal(t, CreditReasonRefund, ledger[0].Reason)
+	assert.Equal(t, 1, ledger[0].Delta)
+	assert.Equal(t, CreditReasonBooking, ledger[1].Reason)
+	assert.Equal(t, -1, ledger[1].Delta)
+	assert.Equal(t, b.ID, *ledger[1].BookingID)
+	assert.Equal(t, CreditReasonGrant, ledger[2].Reason)
+}
+
+func TestPayWithCredits_LateCancelForfeits(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+	_, err := service.CreatePolicy(CancellationPolicyRequest{FreeCancelMinutes: 48 * 60})
+	require.NoError(t, err)
+	_, err = service.GrantCredits(CreditGrant{UserID: 2, Credits: 1})
+	require.NoError(t, err)
+
+	b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	require.NoError(t, err)
+	_, err = service.CancelBooking(2, b.ID)
+	require.NoError(t, err)
+	assert.Zero(t, creditBalance(t, service, 2))
+}
+
+func TestPayWithCredits_Insufficient(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+
+	_, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	assert.ErrorIs(t, err, ErrInsufficientCredits)
+
+	var count int64
+	require.NoError(t, db.Model(&Booking{}).Count(&count).Error)
+	assert.Zero(t, count)
+}
+
+func TestPayWithCredits_SoonestExpiringLotFirst(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+
+	later := time.Now().AddDate(0, 2, 0)
+	sooner := time.Now().AddDate(0, 1, 0)
+	_, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 5})
+	require.NoError(t, err)
+	_, err = service.GrantCredits(CreditGrant{UserID: 2, Credits: 5, ExpiresAt: &later})
+	require.NoError(t, err)
+	soonest, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 5, ExpiresAt: &sooner})
+	require.NoError(t, err)
+
+	b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	require.NoError(t, err)
+	assert.Equal(t, soonest.ID, *b.CreditLotID)
+}
+
+func TestExpireCredits(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	expires := time.Now().Add(time.Hour)
+	lot, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 10, ExpiresAt: &expires})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(lot).Update("expires_at", time.Now().Add(-time.Minute).UTC()).Error)
+	assert.Zero(t, creditBalance(t, service, 2))
+
+	require.NoError(t, service.ExpireCredits())
+	require.NoError(t, service.ExpireCredits())
+
+	ledger, err := service.ListCreditLedger(2)
+	require.NoError(t, err)
+	require.Len(t, ledger, 2)
+	assert.Equal(t, CreditReasonExpiry, ledger[0].Reason)
+	assert.Equal(t, -10, ledger[0].Delta)
+}
+
+func TestPayWithCredits_WaitlistPaysOnPromotion(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+	_, err := service.GrantCredits(CreditGrant{UserID: 5, Credits: 2})
+	require.NoError(t, err)
+
+	first, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	waiting, err := service.CreateBooking(5, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	require.NoError(t, err)
+	assert.Equal(t, BookingStatusWaitlist, waiting.Status)
+	assert.Nil(t, waiting.CreditLotID)
+	assert.Equal(t, 2, creditBalance(t, service, 5))
+
+	_, err = service.CancelBooking(2, first.ID)
+	require.NoError(t, err)
+	var promoted Booking
+	require.NoError(t, db.First(&promoted, waiting.ID).Error)
+	assert.Equal(t, BookingStatusBooked, promoted.Status)
+	assert.Equal(t, PaymentStatusPaid, promoted.PaymentStatus)
+	assert.Equal(t, 1, creditBalance(t, service, 5))
+
+	// A cancelled class gives the credit back.
+	_, err = service.CancelClass(class.ID, 0, adminRole)
+	require.NoError(t, err)
+	assert.Equal(t, 2, creditBalance(t, service, 5))
+}
+
+func TestCancelBooking_DemotedPaidBookingIsRefunded(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 3)
+	_, err := service.GrantCredits(CreditGrant{UserID: 5, Credits: 1})
+	require.NoError(t, err)
+
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	byCard, err := service.CreateBooking(3, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(&Booking{}).Where("id = ?", byCard.ID).Update("payment_status", PaymentStatusPaid).Error)
+	byCredit, err := service.CreateBooking(5, CreateBookingRequest{ClassID: class.ID, PayWithCredits: true})
+	require.NoError(t, err)
+	assert.Equal(t, 0, creditBalance(t, service, 5))
+
+	_, err = service.UpdateClass(class.ID, 0, adminRole, UpdateClassRequest{Capacity: ptr(1)})
+	require.NoError(t, err)
+	for _, b := range []*Booking{byCard, byCredit} {
+		cancelled, err := service.CancelBooking(b.UserID, b.ID)
+		require.NoError(t, err)
+		assert.Equal(t, BookingStatusCancelled, cancelled.Status)
+	}
+	// The credit already came back with the seat and is not given twice.
+	assert.Equal(t, []uint{byCard.ID}, payments.refunded[RefundReasonMemberCancel])
+	assert.Equal(t, 1, creditBalance(t, service, 5))
+}
+
+func TestGrantCredits_OncePerPurchase(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	purchaseID := uint(7)
+
+	first, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 10, Source: CreditSourcePack, PurchaseID: &purchaseID})
+	require.NoError(t, err)
+	again, err := service.GrantCredits(CreditGrant{UserID: 2, Credits: 10, Source: CreditSourcePack, PurchaseID: &purchaseID})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, 10, creditBalance(t, service, 2))
+
+	_, err = service.GrantCredits(CreditGrant{UserID: 2, Credits: 0})
+	assert.ErrorIs(t, err, ErrInvalidCreditGrant)
+}
@@ -1,36 +1,228 @@
 package booking
 
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
 type CreateClassRequest struct {
-	Name        string  `json:"name" binding:"required"`
-	Description string  `json:"description"`
-	TrainerID   uint    `json:"trainer_id" binding:"required"`
-	Capacity    int     `json:"capacity" binding:"required,min=1"`
-	StartTime   string  `json:"start_time" binding:"required"` // ISO8601
-	EndTime     string  `json:"end_time" binding:"required"`
-	Price       float64 `json:"price" binding:"required,min=0"`
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description"`
+	ClassType   string      `json:"class_type"`
+	TrainerID   uint        `json:"trainer_id" binding:"required"`
+	Capacity    int         `json:"capacity" binding:"required,min=1"`
+	StartTime   string      `json:"start_time" binding:"required"` // ISO8601
+	EndTime     string      `json:"end_time" binding:"required"`
+	Price       money.Money `json:"price"`
+	RoomID      uint        `json:"room_id" binding:"required"`
+}
+
+// UpdateClassRequest is a partial update; nil fields are left unchanged.
+type UpdateClassRequest struct {
+	Name        *string      `json:"name"`
+	Description *string      `json:"description"`
+	ClassType   *string      `json:"class_type"`
+	Capacity    *int         `json:"capacity" binding:"omitempty,min=1"`
+	Price       *money.Money `json:"price"`
+	TrainerID   *uint        `json:"trainer_id"`
+	RoomID      *uint        `json:"room_id"`
+	StartTime   *string      `json:"start_time"`
+	EndTime     *string      `json:"end_time"`
+}
+
+type ConflictResponse struct {
+	Reason string `json:"reason"` // trainer or room
+	*ClassResponse
+}
+
+type ScheduleConflictResponse struct {
+	Error     string              `json:"error"`
+	Conflicts []*ConflictResponse `json:"conflicts"`
+}
+
+type CreateRoomRequest struct {
+	Name      string   `json:"name" binding:"required"`
+	Capacity  int      `json:"capacity" binding:"required,min=1"`
+	Equipment []string `json:"equipment"`
+}
+
+type UpdateRoomRequest struct {
+	Name      *string   `json:"name"`
+	Capacity  *int      `json:"capacity" binding:"omitempty,min=1"`
+	Equipment *[]string `json:"equipment"`
+}
+
+type RoomResponse struct {
+	ID        uint     `json:"id"`
+	Name      string   `json:"name"`
+	Capacity  int      `json:"capacity"`
+	Equipment []string `json:"equipment"`
 }
 
 type ClassResponse struct {
-	ID          uint    `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	TrainerID   uint    `json:"trainer_id"`
-	Capacity    int     `json:"capacity"`
-	StartTime   string  `json:"start_time"`
-	EndTime     string  `json:"end_time"`
-	Price       float64 `json:"price"`
+	ID          uint        `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	ClassType   string      `json:"class_type"`
+	TrainerID   uint        `json:"trainer_id"`
+	Capacity    int         `json:"capacity"`
+	StartTime   string      `json:"start_time"`
+	EndTime     string      `json:"end_time"`
+	Price       money.Money `json:"price"`
+	Status      string      `json:"status"`
+	RoomID      *uint       `json:"room_id,omitempty"`
+	SeriesID    *uint       `json:"series_id,omitempty"`
+
+	RemainingSeats *int `json:"remaining_seats,omitempty"`
+}
+
+// ListClassesQuery are the query parameters of GET /classes.
+type ListClassesQuery struct {
+	From             string `form:"from"` // RFC3339, defaults to now
+	To               string `form:"to"`
+	TrainerID        uint   `form:"trainer_id"`
+	Q                string `form:"q"` // case-insensitive name search
+	ClassType        string `form:"class_type"`
+	HasSeats         bool   `form:"has_seats"`
+	IncludeCancelled bool   `form:"include_cancelled"`
+	Sort             string `form:"sort" binding:"omitempty,oneof=start_time -start_time name -name price -price"`
+	Limit            int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor           string `form:"cursor"`
+}
+
+type ClassListResponse struct {
+	Items      []*ClassResponse `json:"items"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type CreateSeriesRequest struct {
+	Name        string      `json:"name" binding:"required"`
+	Description string      `json:"description"`
+	ClassType   string      `json:"class_type"`
+	TrainerID   uint        `json:"trainer_id" binding:"required"`
+	Capacity    int         `json:"capacity" binding:"required,min=1"`
+	Price       money.Money `json:"price"`
+	RoomID      uint        `json:"room_id" binding:"required"`
+	StartTime   string      `json:"start_time" binding:"required"` // first occurrence, RFC3339
+	EndTime     string      `json:"end_time" binding:"required"`
+	Timezone    string      `json:"timezone"`                 // IANA name, defaults to UTC
+	RRule       string      `json:"rrule" binding:"required"` // e.g. FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20261231
+	ExDates     []string    `json:"exdates"`                  // RFC3339 starts of skipped occurrences
+}
+
+const (
+	EditScopeThis      = "this"
+	EditScopeFollowing = "following"
+)
+
+// UpdateOccurrenceRequest edits one occurrence ("this") or the occurrence and
+// everything after it ("following"). Nil fields are left unchanged.
+type UpdateOccurrenceRequest struct {
+	Scope string `json:"scope" binding:"required,oneof=this following"`
+	UpdateClassRequest
+	RRule *string `json:"rrule"` // only with scope=following
+}
+
+type SeriesResponse struct {
+	ID          uint             `json:"id"`
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	ClassType   string           `json:"class_type"`
+	TrainerID   uint             `json:"trainer_id"`
+	Capacity    int              `json:"capacity"`
+	Price       money.Money      `json:"price"`
+	RoomID      *uint            `json:"room_id,omitempty"`
+	Timezone    string           `json:"timezone"`
+	StartTime   string           `json:"start_time"`
+	EndTime     string           `json:"end_time"`
+	RRule       string           `json:"rrule"`
+	ExDates     []string         `json:"exdates"`
+	Occurrences []*ClassResponse `json:"occurrences"`
 }
 
 type CreateBookingRequest struct {
 	ClassID uint `json:"class_id" binding:"required"`
+	// PayWithCredits pays the seat with one class credit instead of a payment.
+	PayWithCredits bool `json:"pay_with_credits"`
 }
 
 type BookingResponse struct {
-	ID            uint   `json:"id"`
-	UserID        uint   `json:"user_id"`
-	ClassID       uint   `json:"class_id"`
-	Status        string `json:"status"`
-	PaymentStatus string `json:"payment_status"`
+	ID               uint       `json:"id"`
+	UserID           uint       `json:"user_id"`
+	ClassID          uint       `json:"class_id"`
+	Status           string     `json:"status"`
+	PaymentStatus    string     `json:"payment_status"`
+	PaidWithCredits  bool       `json:"paid_with_credits"`
+	WaitlistPosition int64      `json:"waitlist_position,omitempty"`
+	CheckedInAt      *time.Time `json:"checked_in_at,omitempty"`
+	Penalty          *Penalty   `json:"penalty,omitempty"`
+}
+
+// CancellationPolicyRequest creates or replaces a policy. Empty membership_tier
+// or class_type make the policy apply to every tier or class type.
+type CancellationPolicyRequest struct {
+	MembershipTier    string      `json:"membership_tier" binding:"omitempty,oneof=basic premium vip"`
+	ClassType         string      `json:"class_type"`
+	FreeCancelMinutes int         `json:"free_cancel_minutes" binding:"min=0"`
+	LateCancelPenalty string      `json:"late_cancel_penalty" binding:"omitempty,oneof=none fee credit ban"`
+	LateCancelFee     money.Money `json:"late_cancel_fee"`
+	NoShowPenalty     string      `json:"no_show_penalty" binding:"omitempty,oneof=none fee credit ban"`
+	NoShowFee         money.Money `json:"no_show_fee"`
+	BanDays           int         `json:"ban_days" binding:"min=0"`
+}
+
+// WaivePenaltyRequest is an admin waiving a late-cancel or no-show fee.
+type WaivePenaltyRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// BookingRuleResponse explains which per-member rule rejected a booking.
+type BookingRuleResponse struct {
+	Error string `json:"error"`
+	Rule  string `json:"rule"`
+	Tier  string `json:"tier,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type CheckInRequest struct {
+	BookingID uint `json:"booking_id" binding:"required"`
+}
+
+// AttendanceRecord is one attended or missed class of a member.
+type AttendanceRecord struct {
+	BookingID   uint       `json:"booking_id"`
+	UserID      uint       `json:"user_id"`
+	ClassID     uint       `json:"class_id"`
+	ClassName   string     `json:"class_name"`
+	StartTime   time.Time  `json:"start_time"`
+	Status      string     `json:"status"`
+	CheckedInAt *time.Time `json:"checked_in_at,omitempty"`
+}
+
+type AttendanceSummary struct {
+	Attended       int     `json:"attended"`
+	NoShow         int     `json:"no_show"`
+	AttendanceRate float64 `json:"attendance_rate"` // attended / (attended + no_show), 0 when no history
+}
+
+type MemberAttendanceResponse struct {
+	UserID  uint               `json:"user_id"`
+	Summary AttendanceSummary  `json:"summary"`
+	Records []AttendanceRecord `json:"records"`
+}
+
+type ClassAttendanceResponse struct {
+	ClassID  uint               `json:"class_id"`
+	Summary  AttendanceSummary  `json:"summary"`
+	Bookings []*BookingResponse `json:"bookings"`
+}
+
+type WaitlistPositionResponse struct {
+	BookingID    uint  `json:"booking_id"`
+	ClassID      uint  `json:"class_id"`
+	Position     int64 `json:"position"`
+	WaitlistSize int64 `json:"waitlist_size"`
 }
 
 func ToClassResponse(c *GymClass) *ClassResponse {
@@ -38,21 +230,111 @@ func ToClassResponse(c *GymClass) *ClassResponse {
 		ID:          c.ID,
 		Name:        c.Name,
 		Description: c.Description,
+		ClassType:   c.ClassType,
 		TrainerID:   c.TrainerID,
 		Capacity:    c.Capacity,
 		StartTime:   c.StartTime.Format("2006-01-02T15:04:05Z07:00"),
 		EndTime:     c.EndTime.Format("2006-01-02T15:04:05Z07:00"),
 		Price:       c.Price,
+		Status:      c.Status,
+		RoomID:      c.RoomID,
+		SeriesID:    c.SeriesID,
+
+		RemainingSeats: c.RemainingSeats,
+	}
+}
+
+func ToBookingRuleResponse(e *BookingRuleError) *BookingRuleResponse {
+	return &BookingRuleResponse{
+		Error: e.Error(),
+		Rule:  e.Rule,
+		Tier:  e.Tier,
+		Limit: e.Limit,
 	}
 }
 
+func ToScheduleConflictResponse(e *ScheduleConflictError) *ScheduleConflictResponse {
+	resp := &ScheduleConflictResponse{
+		Error:     e.Error(),
+		Conflicts: make([]*ConflictResponse, 0, len(e.Conflicts)),
+	}
+	type key struct {
+		reason string
+		id     uint
+	}
+	seen := map[key]bool{}
+	for i := range e.Conflicts {
+		k := key{e.Conflicts[i].Reason, e.Conflicts[i].Class.ID}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		resp.Conflicts = append(resp.Conflicts, &ConflictResponse{
+			Reason:        e.Conflicts[i].Reason,
+			ClassResponse: ToClassResponse(&e.Conflicts[i].Class),
+		})
+	}
+	return resp
+}
+
+func ToRoomResponse(r *Room) *RoomResponse {
+	return &RoomResponse{
+		ID:        r.ID,
+		Name:      r.Name,
+		Capacity:  r.Capacity,
+		Equipment: r.equipmentTags(),
+	}
+}
+
+func ToSeriesResponse(cs *ClassSeries, occurrences []GymClass) *SeriesResponse {
+	loc := cs.location()
+	start := cs.DTStart.In(loc)
+	resp := &SeriesResponse{
+		ID:          cs.ID,
+		Name:        cs.Name,
+		Description: cs.Description,
+		ClassType:   cs.ClassType,
+		TrainerID:   cs.TrainerID,
+		Capacity:    cs.Capacity,
+		Price:       cs.Price,
+		RoomID:      cs.RoomID,
+		Timezone:    loc.String(),
+		StartTime:   start.Format(time.RFC3339),
+		EndTime:     start.Add(cs.duration()).Format(time.RFC3339),
+		RRule:       cs.RRule,
+		ExDates:     cs.exDateList(),
+		Occurrences: make([]*ClassResponse, 0, len(occurrences)),
+	}
+	for i := range occurrences {
+		resp.Occurrences = append(resp.Occurrences, ToClassResponse(&occurrences[i]))
+	}
+	return resp
+}
+
 func ToBookingResponse(b *Booking) *BookingResponse {
 	return &BookingResponse{
-		ID:            b.ID,
-		UserID:        b.UserID,
-		ClassID:       b.ClassID,
-		Status:        b.Status,
-		PaymentStatus: b.PaymentStatus,
+		ID:               b.ID,
+		UserID:           b.UserID,
+		ClassID:          b.ClassID,
+		Status:           b.Status,
+		PaymentStatus:    b.PaymentStatus,
+		PaidWithCredits:  b.CreditLotID != nil,
+		WaitlistPosition: b.WaitlistPosition,
+		CheckedInAt:      b.CheckedInAt,
+		Penalty:          b.Penalty,
 	}
 }
-	
\ No newline at end of file
+
+// GrantCreditsRequest adds class credits to a member's wallet, e.g. for packs
+// sold before the wallet existed.
+type GrantCreditsRequest struct {
+	Credits   int        `json:"credits" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	Note      string     `json:"note"`
+}
+
+type CreditBalanceResponse struct {
+	UserID  uint        `json:"user_id"`
+	Balance int         `json:"balance"`
+	Lots    []CreditLot `json:"lots"`
+}
@@ -1,11 +1,14 @@
 package booking
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"gymflow/internal/middleware"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -16,6 +19,17 @@ func NewHandler(service Service) *Handler {
 	return &Handler{service: service}
 }
 
+// writeConflict answers 409 with the clashing classes when err is a schedule
+// conflict and reports whether it did.
+func writeConflict(c *gin.Context, err error) bool {
+	var conflict *ScheduleConflictError
+	if !errors.As(err, &conflict) {
+		return false
+	}
+	c.JSON(http.StatusConflict, ToScheduleConflictResponse(conflict))
+	return true
+}
+
 // POST /api/v1/classes (admin/trainer)
 func (h *Handler) CreateClass(c *gin.Context) {
 	var req CreateClassRequest
@@ -25,22 +39,103 @@ func (h *Handler) CreateClass(c *gin.Context) {
 	}
 	class, err := h.service.CreateClass(req)
 	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusCreated, ToClassResponse(class))
 }
 
-// GET /api/v1/classes
+// PATCH /api/v1/classes/:id (admin/trainer)
+func (h *Handler) UpdateClass(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdateClassRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	class, err := h.service.UpdateClass(uri.ID, userIDAny.(uint), roleAny.(string), req)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		if errors.Is(err, ErrNotClassTrainer) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "class not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ToClassResponse(class))
+}
+
+// DELETE /api/v1/classes/:id (admin/trainer)
+// The class is kept and marked cancelled so bookings and payments stay traceable.
+func (h *Handler) CancelClass(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	class, err := h.service.CancelClass(uri.ID, userIDAny.(uint), roleAny.(string))
+	if err != nil {
+		if errors.Is(err, ErrNotClassTrainer) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "class not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ToClassResponse(class))
+}
+
+// GET /api/v1/classes?from=&to=&trainer_id=&q=&has_seats=true&sort=-start_time&limit=20&cursor=
 func (h *Handler) ListClasses(c *gin.Context) {
-	classes, err := h.service.ListClasses()
+	var q ListClassesQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	classes, next, err := h.service.ListClasses(q)
 	if err != nil {
+		var parseErr *time.ParseError
+		if errors.Is(err, ErrInvalidCursor) || errors.Is(err, ErrInvalidSort) || errors.Is(err, ErrInvalidTimeRange) || errors.As(err, &parseErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list classes"})
 		return
 	}
-	resp := make([]*ClassResponse, 0, len(classes))
+	resp := &ClassListResponse{
+		Items:      make([]*ClassResponse, 0, len(classes)),
+		NextCursor: next,
+	}
 	for i := range classes {
-		resp = append(resp, ToClassResponse(&classes[i]))
+		resp.Items = append(resp.Items, ToClassResponse(&classes[i]))
 	}
 	c.JSON(http.StatusOK, resp)
 }
@@ -57,6 +152,19 @@ func (h *Handler) CreateBooking(c *gin.Context) {
 
 	b, err := h.service.CreateBooking(userID, req)
 	if err != nil {
+		var rule *BookingRuleError
+		if errors.As(err, &rule) {
+			status := http.StatusConflict
+			if rule.Rule == RuleBookingBanned {
+				status = http.StatusForbidden
+			}
+			c.JSON(status, ToBookingRuleResponse(rule))
+			return
+		}
+		if errors.Is(err, ErrInsufficientCredits) {
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -99,3 +207,450 @@ func (h *Handler) CancelBooking(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, ToBookingResponse(b))
 }
+
+// GET /api/v1/bookings/:id/waitlist
+func (h *Handler) GetWaitlistPosition(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDAny.(uint)
+
+	resp, err := h.service.GetWaitlistPosition(userID, uri.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "booking not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/v1/class-series (admin/trainer)
+func (h *Handler) CreateSeries(c *gin.Context) {
+	var req CreateSeriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, occurrences, err := h.service.CreateSeries(req)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, ToSeriesResponse(series, occurrences))
+}
+
+// GET /api/v1/class-series/:id
+func (h *Handler) GetSeries(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	series, occurrences, err := h.service.GetSeries(uri.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "series not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load series"})
+		return
+	}
+	c.JSON(http.StatusOK, ToSeriesResponse(series, occurrences))
+}
+
+// PATCH /api/v1/class-series/:id/occurrences/:class_id (admin/trainer)
+func (h *Handler) UpdateOccurrence(c *gin.Context) {
+	var uri struct {
+		ID      uint `uri:"id" binding:"required"`
+		ClassID uint `uri:"class_id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdateOccurrenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	series, occurrences, err := h.service.UpdateOccurrence(uri.ID, uri.ClassID, userIDAny.(uint), roleAny.(string), req)
+	if err != nil {
+		if writeConflict(c, err) {
+			return
+		}
+		if errors.Is(err, ErrNotClassTrainer) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "series or class not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ToSeriesResponse(series, occurrences))
+}
+
+// POST /api/v1/admin/rooms
+func (h *Handler) CreateRoom(c *gin.Context) {
+	var req CreateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	room, err := h.service.CreateRoom(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, ToRoomResponse(room))
+}
+
+// GET /api/v1/rooms?equipment=bikes
+func (h *Handler) ListRooms(c *gin.Context) {
+	rooms, err := h.service.ListRooms(c.Query("equipment"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rooms"})
+		return
+	}
+	resp := make([]*RoomResponse, 0, len(rooms))
+	for i := range rooms {
+		resp = append(resp, ToRoomResponse(&rooms[i]))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// PATCH /api/v1/admin/rooms/:id
+func (h *Handler) UpdateRoom(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdateRoomRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	room, err := h.service.UpdateRoom(uri.ID, req)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "room not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, ToRoomResponse(room))
+}
+
+// writeAttendanceError maps check-in/roster errors to status codes.
+func writeAttendanceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, ErrNotClassStaff):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlreadyAttended):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// POST /api/v1/classes/:id/check-in (admin/trainer)
+func (h *Handler) CheckIn(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CheckInRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	b, err := h.service.CheckIn(uri.ID, req.BookingID, userIDAny.(uint), roleAny.(string))
+	if err != nil {
+		writeAttendanceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToBookingResponse(b))
+}
+
+// GET /api/v1/classes/:id/attendance (admin/trainer)
+func (h *Handler) GetClassAttendance(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	resp, err := h.service.GetClassAttendance(uri.ID, userIDAny.(uint), roleAny.(string))
+	if err != nil {
+		writeAttendanceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/v1/attendance
+func (h *Handler) GetMyAttendance(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDAny.(uint)
+
+	resp, err := h.service.GetMemberAttendance(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/v1/users/:id/attendance (admin/trainer)
+func (h *Handler) GetMemberAttendance(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	resp, err := h.service.GetMemberAttendance(uri.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/v1/penalties
+func (h *Handler) ListPenalties(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	userID := userIDAny.(uint)
+
+	penalties, err := h.service.ListPenalties(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list penalties"})
+		return
+	}
+	c.JSON(http.StatusOK, penalties)
+}
+
+// GET /api/v1/admin/users/:id/penalties
+func (h *Handler) GetMemberPenalties(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	penalties, err := h.service.ListPenalties(uri.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list penalties"})
+		return
+	}
+	c.JSON(http.StatusOK, penalties)
+}
+
+// POST /api/v1/admin/penalties/:id/waive
+func (h *Handler) WaivePenalty(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req WaivePenaltyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	adminIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	pen, err := h.service.WaivePenalty(uri.ID, adminIDAny.(uint), req.Reason)
+	switch {
+	case errors.Is(err, ErrPenaltyNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotWaivable), errors.Is(err, ErrPenaltyWaived):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to waive penalty"})
+	default:
+		c.JSON(http.StatusOK, pen)
+	}
+}
+
+// GET /api/v1/credits
+func (h *Handler) GetCredits(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	resp, err := h.service.GetCredits(userIDAny.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load credits"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/v1/credits/ledger
+func (h *Handler) ListCreditLedger(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	entries, err := h.service.ListCreditLedger(userIDAny.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list credit ledger"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// GET /api/v1/admin/users/:id/credits/ledger
+func (h *Handler) GetMemberCreditLedger(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := h.service.ListCreditLedger(uri.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list credit ledger"})
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// POST /api/v1/admin/users/:id/credits
+func (h *Handler) GrantCredits(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req GrantCreditsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	staffIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	staffID := staffIDAny.(uint)
+
+	lot, err := h.service.GrantCredits(CreditGrant{
+		UserID:    uri.ID,
+		Credits:   req.Credits,
+		ExpiresAt: req.ExpiresAt,
+		Source:    CreditSourceGrant,
+		Note:      req.Note,
+		GrantedBy: &staffID,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, lot)
+}
+
+// POST /api/v1/admin/cancellation-policies
+func (h *Handler) CreatePolicy(c *gin.Context) {
+	var req CancellationPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := h.service.CreatePolicy(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, p)
+}
+
+// GET /api/v1/admin/cancellation-policies
+func (h *Handler) ListPolicies(c *gin.Context) {
+	policies, err := h.service.ListPolicies()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list policies"})
+		return
+	}
+	c.JSON(http.StatusOK, policies)
+}
+
+// PUT /api/v1/admin/cancellation-policies/:id
+func (h *Handler) UpdatePolicy(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CancellationPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := h.service.UpdatePolicy(uri.ID, req)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// DELETE /api/v1/admin/cancellation-policies/:id
+func (h *Handler) DeletePolicy(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DeletePolicy(uri.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "policy not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
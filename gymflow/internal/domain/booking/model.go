@@ -2,36 +2,190 @@ package booking
 
 import (
 	"time"
+
+	"gymflow/internal/money"
 )
 
 const (
 	BookingStatusBooked    = "booked"
 	BookingStatusCancelled = "cancelled"
 	BookingStatusWaitlist  = "waitlist"
+	// BookingStatusClassCancelled is set on every active booking when staff cancel the class.
+	BookingStatusClassCancelled = "class_cancelled"
+	BookingStatusAttended       = "attended"
+	// BookingStatusNoShow is set by the no-show job on booked seats nobody checked in for.
+	BookingStatusNoShow = "no_show"
+
+	ClassStatusScheduled = "scheduled"
+	ClassStatusCancelled = "cancelled"
 
-	PaymentStatusPending = "pending"
-	PaymentStatusPaid    = "paid"
+	// PaymentStatus mirrors the state of the booking's class payment and is
+	// kept in sync by the payment service.
+	PaymentStatusPending           = "pending"
+	PaymentStatusAuthorized        = "authorized"
+	PaymentStatusPaid              = "paid"
+	PaymentStatusFailed            = "failed"
+	PaymentStatusRefunded          = "refunded"
+	PaymentStatusPartiallyRefunded = "partially_refunded"
 )
 
+// seatStatuses are the booking statuses that occupy a seat in the class.
+var seatStatuses = []string{BookingStatusBooked, BookingStatusAttended, BookingStatusNoShow}
+
 type GymClass struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	TrainerID   uint      `json:"trainer_id"`
-	Capacity    int       `json:"capacity"`
-	StartTime   time.Time `json:"start_time"`
-	EndTime     time.Time `json:"end_time"`
-	Price       float64   `json:"price"`
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	ClassType   string      `gorm:"index" json:"class_type"` // e.g. "spin", "yoga"; selects the cancellation policy
+	TrainerID   uint        `json:"trainer_id"`
+	Capacity    int         `json:"capacity"`
+	StartTime   time.Time   `gorm:"index" json:"start_time"`
+	EndTime     time.Time   `json:"end_time"`
+	Price       money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	Status      string      `gorm:"default:scheduled" json:"status"`
+	RoomID      *uint       `gorm:"index" json:"room_id,omitempty"`
+
+	// Set for occurrences generated from a ClassSeries. OriginalStart is the
+	// slot the rule produced (the RFC 5545 RECURRENCE-ID) and does not move when
+	// a single occurrence is rescheduled; Detached marks such one-off edits so
+	// later series-wide edits leave them alone.
+	SeriesID      *uint      `gorm:"index" json:"series_id,omitempty"`
+	OriginalStart *time.Time `json:"original_start,omitempty"`
+	Detached      bool       `json:"detached"`
+
+	// RemainingSeats is computed on read by ListClasses.
+	RemainingSeats *int `gorm:"-" json:"remaining_seats,omitempty"`
+}
+
+// Room is a physical studio. A class must fit into its room and a room can
+// only host one class at a time.
+type Room struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Name      string    `gorm:"uniqueIndex" json:"name"`
+	Capacity  int       `json:"capacity"`
+	Equipment string    `json:"equipment"` // comma-separated lowercase tags, e.g. "bikes,mirrors"
+}
+
+// ClassSeries generates GymClass occurrences from an RRULE over a rolling horizon.
+type ClassSeries struct {
+	ID              uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt       time.Time   `json:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at"`
+	Name            string      `json:"name"`
+	Description     string      `json:"description"`
+	ClassType       string      `json:"class_type"`
+	TrainerID       uint        `json:"trainer_id"`
+	Capacity        int         `json:"capacity"`
+	Price           money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	RoomID          *uint       `json:"room_id,omitempty"`
+	Timezone        string      `json:"timezone"`
+	DTStart         time.Time   `json:"dtstart"`
+	DurationMinutes int         `json:"duration_minutes"`
+	RRule           string      `json:"rrule"`
+	ExDates         string      `json:"exdates"` // comma-separated RFC3339 occurrence starts
+	GeneratedUntil  time.Time   `json:"generated_until"`
 }
 
 type Booking struct {
-	ID            uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	UserID        uint      `json:"user_id"`
-	ClassID       uint      `json:"class_id"`
-	Status        string    `json:"status"`
-	PaymentStatus string    `json:"payment_status"`
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	UserID        uint       `json:"user_id"`
+	ClassID       uint       `gorm:"index" json:"class_id"`
+	Status        string     `json:"status"`
+	PaymentStatus string     `json:"payment_status"`
+	CheckedInAt   *time.Time `json:"checked_in_at,omitempty"`
+	CheckedInBy   *uint      `json:"checked_in_by,omitempty"`
+
+	// PayWithCredits asks for the seat to be paid with one class credit;
+	// CreditLotID is the lot it was taken from once the booking got a seat.
+	PayWithCredits bool  `json:"pay_with_credits"`
+	CreditLotID    *uint `gorm:"index" json:"credit_lot_id,omitempty"`
+
+	// WaitlistPosition is computed on read for waitlisted bookings (1 = next in line).
+	WaitlistPosition int64 `gorm:"-" json:"waitlist_position,omitempty"`
+	// Penalty is set on the response of a late cancellation.
+	Penalty *Penalty `gorm:"-" json:"penalty,omitempty"`
+}
+
+// CancellationPolicy sets the free cancellation window and the penalties for
+// late cancellations and no-shows. An empty MembershipTier or ClassType matches
+// any; the most specific policy wins (see resolvePolicy).
+type CancellationPolicy struct {
+	ID                uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt         time.Time   `json:"created_at"`
+	UpdatedAt         time.Time   `json:"updated_at"`
+	MembershipTier    string      `gorm:"uniqueIndex:idx_policy_scope" json:"membership_tier"`
+	ClassType         string      `gorm:"uniqueIndex:idx_policy_scope" json:"class_type"`
+	FreeCancelMinutes int         `json:"free_cancel_minutes"` // cancelling earlier than this before StartTime is free
+	LateCancelPenalty string      `json:"late_cancel_penalty"` // none, fee, credit or ban
+	LateCancelFee     money.Money `gorm:"embedded;embeddedPrefix:late_cancel_fee_" json:"late_cancel_fee"`
+	NoShowPenalty     string      `json:"no_show_penalty"`
+	NoShowFee         money.Money `gorm:"embedded;embeddedPrefix:no_show_fee_" json:"no_show_fee"`
+	BanDays           int         `json:"ban_days"` // length of a ban penalty
+}
+
+// Penalty records one late cancellation or no-show consequence. Fees are
+// charged to the member's stored payment method, or else taken as a credit
+// from their wallet; FeeStatus says which. Credit penalties are settled
+// against the member's class credits; a ban blocks new bookings until
+// BannedUntil.
+type Penalty struct {
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UserID      uint        `gorm:"index" json:"user_id"`
+	BookingID   uint        `gorm:"index" json:"booking_id"`
+	PolicyID    *uint       `json:"policy_id,omitempty"`
+	Reason      string      `json:"reason"` // late_cancel or no_show
+	Type        string      `json:"type"`   // fee, credit or ban
+	Amount      money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Credits     int         `json:"credits,omitempty"`
+	BannedUntil *time.Time  `gorm:"index" json:"banned_until,omitempty"`
+
+	// FeeStatus is set on fee penalties. PaymentID is the charge to the
+	// stored payment method, CreditLotID the wallet lot that paid instead,
+	// or for a credit penalty the lot the credit was forfeited from.
+	FeeStatus   string `gorm:"size:20;index" json:"fee_status,omitempty"`
+	PaymentID   *uint  `gorm:"index" json:"payment_id,omitempty"`
+	CreditLotID *uint  `json:"credit_lot_id,omitempty"`
+	// ChargingSince is set while a charge of the fee is being made, so the
+	// inline charge and the settlement job never both charge it.
+	ChargingSince *time.Time `json:"-"`
+	// WaivedBy is the admin who waived the penalty: a fee's charge is
+	// refunded, a forfeited credit given back and a ban lifted.
+	WaivedAt    *time.Time `json:"waived_at,omitempty"`
+	WaivedBy    *uint      `json:"waived_by,omitempty"`
+	WaiveReason string     `json:"waive_reason,omitempty"`
+}
+
+// CreditLot is a batch of class credits from one class pack purchase or staff
+// grant. Bookings take one credit from the lot that expires first; credits
+// left in a lot after ExpiresAt are forfeited.
+type CreditLot struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `gorm:"index" json:"user_id"`
+	Source     string     `json:"source"` // pack or grant
+	PurchaseID *uint      `gorm:"uniqueIndex" json:"purchase_id,omitempty"`
+	Credits    int        `json:"credits"`
+	Remaining  int        `json:"remaining"`
+	ExpiresAt  *time.Time `gorm:"index" json:"expires_at,omitempty"`
+}
+
+// CreditEntry is one line of a member's credit ledger. The ledger is
+// append-only; the Remaining counts of the lots are its running totals.
+type CreditEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	LotID     uint      `gorm:"index" json:"lot_id"`
+	Delta     int       `json:"delta"`
+	Reason    string    `json:"reason"`
+	BookingID *uint     `gorm:"index" json:"booking_id,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedBy *uint     `json:"created_by,omitempty"`
 }
@@ -0,0 +1,461 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PenaltyNone   = "none"
+	PenaltyFee    = "fee"
+	PenaltyCredit = "credit"
+	PenaltyBan    = "ban"
+
+	PenaltyReasonLateCancel = "late_cancel"
+	PenaltyReasonNoShow     = "no_show"
+)
+
+// How a fee penalty was settled.
+const (
+	FeePending     = "pending"     // not charged yet, or the charge has not settled
+	FeeCharged     = "charged"     // collected from the stored payment method
+	FeeCredit      = "credit"      // paid with a credit from the wallet
+	FeeOutstanding = "outstanding" // nothing to charge it to
+	FeeWaived      = "waived"
+)
+
+var (
+	ErrInvalidPolicy   = errors.New("invalid cancellation policy")
+	ErrBookingBanned   = errors.New("booking is suspended")
+	ErrNoPaymentMethod = errors.New("member has no stored payment method")
+	ErrPenaltyNotFound = errors.New("penalty not found")
+	ErrNotWaivable     = errors.New("only fees can be waived")
+	ErrPenaltyWaived   = errors.New("fee is already waived")
+)
+
+// feeBatch bounds one SettlePenaltyFees run.
+const feeBatch = 100
+
+// feeClaimTTL is how long a claim to charge a fee holds. A claim older than
+// that is from a charge that died midway, and the fee may be charged again.
+const feeClaimTTL = 10 * time.Minute
+
+// defaultPolicy applies when no configured policy matches: cancelling is
+// always free and no-shows have no consequence.
+var defaultPolicy = CancellationPolicy{
+	LateCancelPenalty: PenaltyNone,
+	NoShowPenalty:     PenaltyNone,
+}
+
+func normalizeClassType(t string) string {
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// resolvePolicy picks the most specific policy for the member's tier and the
+// class type: tier and type, then type only, then tier only, then the
+// wildcard policy, then defaultPolicy.
+func resolvePolicy(repo Repository, userID uint, class *GymClass) (*CancellationPolicy, error) {
+	tier, err := repo.FindUserMembership(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	policies, err := repo.ListPoliciesMatching(tier, class.ClassType)
+	if err != nil {
+		return nil, err
+	}
+	best, bestScore := &defaultPolicy, -1
+	for i := range policies {
+		score := 0
+		if policies[i].ClassType != "" {
+			score += 2
+		}
+		if policies[i].MembershipTier != "" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = &policies[i], score
+		}
+	}
+	return best, nil
+}
+
+// isLateCancel reports whether cancelling at now falls outside the free window.
+func (p *CancellationPolicy) isLateCancel(class *GymClass, now time.Time) bool {
+	window := time.Duration(p.FreeCancelMinutes) * time.Minute
+	return now.After(class.StartTime.Add(-window))
+}
+
+// penaltyFor builds the penalty of the policy for reason, or nil when the
+// policy has none.
+func (p *CancellationPolicy) penaltyFor(reason string, b *Booking, now time.Time) *Penalty {
+	kind, fee := p.LateCancelPenalty, p.LateCancelFee
+	if reason == PenaltyReasonNoShow {
+		kind, fee = p.NoShowPenalty, p.NoShowFee
+	}
+	pen := &Penalty{
+		UserID:    b.UserID,
+		BookingID: b.ID,
+		Reason:    reason,
+		Type:      kind,
+	}
+	if p.ID != 0 {
+		id := p.ID
+		pen.PolicyID = &id
+	}
+	switch kind {
+	case PenaltyFee:
+		pen.Amount = fee
+		pen.FeeStatus = FeePending
+	case PenaltyCredit:
+		pen.Credits = 1
+	case PenaltyBan:
+		until := now.AddDate(0, 0, p.BanDays).UTC()
+		pen.BannedUntil = &until
+	default:
+		return nil
+	}
+	return pen
+}
+
+// applyPenalty records the penalty for b, if the resolved policy has one.
+// Fees are charged by the caller once the transaction has committed.
+func (s *service) applyPenalty(repo Repository, b *Booking, reason string, policy *CancellationPolicy) (*Penalty, error) {
+	now := time.Now()
+	pen := policy.penaltyFor(reason, b, now)
+	if pen == nil {
+		return nil, nil
+	}
+	if pen.Type == PenaltyCredit {
+		// The credit is forfeited from the wallet when there is one to take;
+		// the ledger shows whether it was.
+		lot, err := takeCredit(repo, b.UserID, b.ID, CreditReasonPenalty, now)
+		switch {
+		case err == nil:
+			pen.CreditLotID = &lot.ID
+		case !errors.Is(err, ErrInsufficientCredits):
+			return nil, err
+		}
+	}
+	if err := repo.CreatePenalty(pen); err != nil {
+		return nil, err
+	}
+	return pen, nil
+}
+
+// chargePenaltyFees charges the fees among penalties once the booking change
+// that caused them has committed. A charge that errors leaves the fee
+// pending for SettlePenaltyFees rather than failing the committed change.
+func (s *service) chargePenaltyFees(penalties ...*Penalty) {
+	if s.payments == nil {
+		return
+	}
+	for _, pen := range penalties {
+		if pen == nil || pen.Type != PenaltyFee || !pen.Amount.IsPositive() {
+			continue
+		}
+		if err := s.chargeFee(pen); err != nil {
+			log.Printf("fee of penalty %d: %v", pen.ID, err)
+		}
+	}
+}
+
+// chargeFee charges a fee to the member's stored payment method, unless
+// another charge of it is already under way or made.
+func (s *service) chargeFee(pen *Penalty) error {
+	claimed, err := s.claimFee(pen, true)
+	if err != nil || !claimed {
+		return err
+	}
+	status, err := s.payments.ChargePenalty(pen)
+	if errors.Is(err, ErrNoPaymentMethod) {
+		// Nothing to charge is settled like a declined charge.
+		status, err = PaymentStatusFailed, nil
+	}
+	if err != nil {
+		// Let the next SettlePenaltyFees run try again.
+		if _, releaseErr := s.claimFee(pen, false); releaseErr != nil {
+			log.Printf("release fee of penalty %d: %v", pen.ID, releaseErr)
+		}
+		return err
+	}
+	return s.settleFee(pen, status)
+}
+
+// claimFee takes (or with claim false, gives up) the right to charge a
+// pending fee, under the penalty's lock. It is not taken while another
+// claim holds or once the fee has a charge.
+func (s *service) claimFee(pen *Penalty, claim bool) (bool, error) {
+	claimed := false
+	err := s.repo.Transaction(func(repo Repository) error {
+		current, err := repo.LockPenalty(pen.ID)
+		if err != nil {
+			return err
+		}
+		if !claim {
+			current.ChargingSince = nil
+			return repo.UpdatePenalty(current)
+		}
+		now := time.Now()
+		if current.FeeStatus != FeePending || current.PaymentID != nil ||
+			(current.ChargingSince != nil && now.Sub(*current.ChargingSince) < feeClaimTTL) {
+			return nil
+		}
+		if _, _, err := repo.FindFeeCharge(pen.ID); !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		current.ChargingSince = &now
+		if err := repo.UpdatePenalty(current); err != nil {
+			return err
+		}
+		*pen = *current
+		claimed = true
+		return nil
+	})
+	return claimed, err
+}
+
+// SettlePenaltyFees finishes fees whose charge did not settle when they were
+// applied: it charges fees that were never charged and settles charges an
+// asynchronous provider has since paid or failed. It is driven by a
+// background job.
+func (s *service) SettlePenaltyFees() error {
+	if s.payments == nil {
+		return nil
+	}
+	penalties, err := s.repo.ListUnsettledFees(feeBatch)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := range penalties {
+		pen := &penalties[i]
+		paymentID, status, err := s.repo.FindFeeCharge(pen.ID)
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			err = s.chargeFee(pen)
+		case err == nil:
+			pen.PaymentID = &paymentID
+			err = s.settleFee(pen, status)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("penalty %d: %w", pen.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func feeCollected(status string) bool {
+	return status == PaymentStatusPaid || status == PaymentStatusPartiallyRefunded || status == PaymentStatusRefunded
+}
+
+// settleFee records the status of a fee's charge. A collected charge settles
+// the fee. When the charge failed one credit is taken from the wallet
+// instead; with neither the fee stays outstanding. A charge still pending
+// leaves the fee pending. A fee waived before its charge was collected gets
+// the charge refunded.
+func (s *service) settleFee(pen *Penalty, status string) error {
+	paymentID := pen.PaymentID
+	refund := false
+	err := s.repo.Transaction(func(repo Repository) error {
+		current, err := repo.LockPenalty(pen.ID)
+		if err != nil {
+			return err
+		}
+		*pen = *current
+		if current.FeeStatus == FeeWaived {
+			refund = paymentID != nil && feeCollected(status)
+			return nil
+		}
+		if current.FeeStatus != FeePending && current.FeeStatus != FeeCharged {
+			return nil
+		}
+		if paymentID != nil {
+			pen.PaymentID = paymentID
+		}
+		pen.ChargingSince = nil
+		switch {
+		case feeCollected(status):
+			pen.FeeStatus = FeeCharged
+		case status == PaymentStatusFailed:
+			lot, err := takeCredit(repo, pen.UserID, pen.BookingID, CreditReasonPenalty, time.Now())
+			switch {
+			case errors.Is(err, ErrInsufficientCredits):
+				pen.FeeStatus = FeeOutstanding
+			case err != nil:
+				return err
+			default:
+				pen.FeeStatus = FeeCredit
+				pen.CreditLotID = &lot.ID
+			}
+		default:
+			pen.FeeStatus = FeePending
+		}
+		return repo.UpdatePenalty(pen)
+	})
+	if err != nil {
+		return err
+	}
+	if refund {
+		return s.payments.RefundPayment(*paymentID, RefundReasonFeeWaived)
+	}
+	return nil
+}
+
+func (s *service) WaivePenalty(id, adminID uint, reason string) (*Penalty, error) {
+	pen, err := s.repo.FindPenaltyByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPenaltyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pen.Type != PenaltyFee {
+		return nil, ErrNotWaivable
+	}
+	// Refunding is idempotent, so a concurrent waiver can't pay back twice.
+	if pen.FeeStatus == FeeCharged && pen.PaymentID != nil && s.payments != nil {
+		if err := s.payments.RefundPayment(*pen.PaymentID, RefundReasonFeeWaived); err != nil {
+			return nil, err
+		}
+	}
+	err = s.repo.Transaction(func(repo Repository) error {
+		if pen, err = repo.LockPenalty(id); err != nil {
+			return err
+		}
+		if pen.FeeStatus == FeeWaived {
+			return ErrPenaltyWaived
+		}
+		return waive(repo, pen, adminID, reason, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pen, nil
+}
+
+// waive voids a locked penalty: a ban is lifted, a forfeited credit is given
+// back and a fee is marked waived, with the wallet credit that paid it
+// returned. Refunding a charged fee is left to the caller, outside the
+// transaction.
+func waive(repo Repository, pen *Penalty, adminID uint, reason string, now time.Time) error {
+	switch pen.Type {
+	case PenaltyBan:
+		if pen.BannedUntil != nil && pen.BannedUntil.After(now) {
+			lifted := now.UTC()
+			pen.BannedUntil = &lifted
+		}
+	case PenaltyCredit:
+		if pen.CreditLotID != nil {
+			if err := giveCredit(repo, pen.UserID, *pen.CreditLotID, pen.BookingID, CreditReasonWaived); err != nil {
+				return err
+			}
+		}
+	case PenaltyFee:
+		if pen.FeeStatus == FeeCredit && pen.CreditLotID != nil {
+			if err := giveCredit(repo, pen.UserID, *pen.CreditLotID, pen.BookingID, CreditReasonWaived); err != nil {
+				return err
+			}
+		}
+		pen.FeeStatus = FeeWaived
+	}
+	pen.WaivedAt = &now
+	pen.WaivedBy = &adminID
+	pen.WaiveReason = reason
+	return repo.UpdatePenalty(pen)
+}
+
+// checkBan rejects bookings from members serving a ban penalty.
+func checkBan(repo Repository, userID uint) error {
+	ban, err := repo.FindActiveBan(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if ban != nil {
+		return fmt.Errorf("%w until %s", ErrBookingBanned, ban.BannedUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func policyFromRequest(p *CancellationPolicy, req CancellationPolicyRequest) error {
+	p.MembershipTier = strings.ToLower(strings.TrimSpace(req.MembershipTier))
+	p.ClassType = normalizeClassType(req.ClassType)
+	p.FreeCancelMinutes = req.FreeCancelMinutes
+	p.LateCancelPenalty = req.LateCancelPenalty
+	p.LateCancelFee = req.LateCancelFee
+	p.NoShowPenalty = req.NoShowPenalty
+	p.NoShowFee = req.NoShowFee
+	p.BanDays = req.BanDays
+	if p.LateCancelPenalty == "" {
+		p.LateCancelPenalty = PenaltyNone
+	}
+	if p.NoShowPenalty == "" {
+		p.NoShowPenalty = PenaltyNone
+	}
+
+	for _, pair := range []struct {
+		kind string
+		fee  money.Money
+	}{{p.LateCancelPenalty, p.LateCancelFee}, {p.NoShowPenalty, p.NoShowFee}} {
+		switch pair.kind {
+		case PenaltyNone, PenaltyCredit:
+		case PenaltyFee:
+			if !pair.fee.IsPositive() {
+				return fmt.Errorf("%w: fee penalty needs a positive fee", ErrInvalidPolicy)
+			}
+			if err := pair.fee.Validate(); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+			}
+		case PenaltyBan:
+			if p.BanDays <= 0 {
+				return fmt.Errorf("%w: ban penalty needs ban_days", ErrInvalidPolicy)
+			}
+		default:
+			return fmt.Errorf("%w: unknown penalty %q", ErrInvalidPolicy, pair.kind)
+		}
+	}
+	return nil
+}
+
+func (s *service) CreatePolicy(req CancellationPolicyRequest) (*CancellationPolicy, error) {
+	p := &CancellationPolicy{}
+	if err := policyFromRequest(p, req); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreatePolicy(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) ListPolicies() ([]CancellationPolicy, error) {
+	return s.repo.ListPolicies()
+}
+
+func (s *service) UpdatePolicy(id uint, req CancellationPolicyRequest) (*CancellationPolicy, error) {
+	p, err := s.repo.FindPolicyByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := policyFromRequest(p, req); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePolicy(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) DeletePolicy(id uint) error {
+	return s.repo.DeletePolicy(id)
+}
+
+func (s *service) ListPenalties(userID uint) ([]Penalty, error) {
+	return s.repo.ListPenaltiesByUser(userID)
+}
@@ -0,0 +1,331 @@
+package booking
+
+import (
+	"testing"
+	"time"
+
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePolicy_Validates(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	_, err := service.CreatePolicy(CancellationPolicyRequest{LateCancelPenalty: PenaltyFee})
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+	_, err = service.CreatePolicy(CancellationPolicyRequest{NoShowPenalty: PenaltyBan})
+	assert.ErrorIs(t, err, ErrInvalidPolicy)
+
+	p, err := service.CreatePolicy(CancellationPolicyRequest{ClassType: " Spin ", FreeCancelMinutes: 60})
+	require.NoError(t, err)
+	assert.Equal(t, "spin", p.ClassType)
+	assert.Equal(t, PenaltyNone, p.LateCancelPenalty)
+}
+
+func TestResolvePolicy_MostSpecificWins(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewRepository(db)
+	service := NewService(repo, nil)
+
+	fallback, _ := service.CreatePolicy(CancellationPolicyRequest{FreeCancelMinutes: 10})
+	vip, _ := service.CreatePolicy(CancellationPolicyRequest{MembershipTier: "vip", FreeCancelMinutes: 20})
+	spin, _ := service.CreatePolicy(CancellationPolicyRequest{ClassType: "spin", FreeCancelMinutes: 30})
+	vipSpin, _ := service.CreatePolicy(CancellationPolicyRequest{MembershipTier: "vip", ClassType: "spin", FreeCancelMinutes: 40})
+
+	yoga := &GymClass{ClassType: "yoga"}
+	cycling := &GymClass{ClassType: "spin"}
+	for _, tc := range []struct {
+		user  uint
+		class *GymClass
+		want  uint
+	}{
+		{2, yoga, fallback.ID},
+		{5, yoga, vip.ID},
+		{2, cycling, spin.ID},
+		{5, cycling, vipSpin.ID},
+		{99, cycling, spin.ID}, // unknown user: no tier
+	} {
+		p, err := resolvePolicy(repo, tc.user, tc.class)
+		require.NoError(t, err)
+		assert.Equal(t, tc.want, p.ID)
+	}
+
+	require.NoError(t, service.DeletePolicy(fallback.ID))
+	p, err := resolvePolicy(repo, 2, yoga)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), p.ID, "falls back to the free default")
+}
+
+func TestCancelBooking_LateCancelFee(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 1) // starts in 24h
+
+	_, err := service.CreatePolicy(CancellationPolicyRequest{
+		FreeCancelMinutes: 12 * 60,
+		LateCancelPenalty: PenaltyFee,
+		LateCancelFee:     money.New(750, "USD"),
+	})
+	require.NoError(t, err)
+
+	// Inside the free window.
+	early, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	waiting, _ := service.CreateBooking(4, CreateBookingRequest{ClassID: class.ID})
+	cancelled, err := service.CancelBooking(2, early.ID)
+	require.NoError(t, err)
+	assert.Nil(t, cancelled.Penalty)
+	assert.Equal(t, []uint{early.ID}, payments.refunded[RefundReasonMemberCancel])
+
+	// The promoted booking cancels late; the class is now 6h away.
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(6 * time.Hour).UTC(),
+		"end_time":   time.Now().Add(7 * time.Hour).UTC(),
+	})
+	late, err := service.CancelBooking(4, waiting.ID)
+	require.NoError(t, err)
+	require.NotNil(t, late.Penalty)
+	assert.Equal(t, PenaltyReasonLateCancel, late.Penalty.Reason)
+	assert.Equal(t, []money.Money{money.New(750, "USD")}, payments.fees)
+	assert.Len(t, payments.refunded[RefundReasonMemberCancel], 1, "late cancellation is not refunded")
+
+	// Leaving the waitlist is always free.
+	service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	queued, _ := service.CreateBooking(4, CreateBookingRequest{ClassID: class.ID})
+	require.Equal(t, BookingStatusWaitlist, queued.Status)
+	left, err := service.CancelBooking(4, queued.ID)
+	require.NoError(t, err)
+	assert.Nil(t, left.Penalty)
+	assert.Len(t, payments.fees, 1)
+	assert.Len(t, payments.refunded[RefundReasonMemberCancel], 1)
+}
+
+func TestCancelBooking_BanBlocksBooking(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+	other := createTestClass(t, db, 5)
+
+	_, err := service.CreatePolicy(CancellationPolicyRequest{
+		MembershipTier:    "basic",
+		FreeCancelMinutes: 48 * 60,
+		LateCancelPenalty: PenaltyBan,
+		BanDays:           3,
+	})
+	require.NoError(t, err)
+
+	// VIP member 5 is not covered by the basic policy.
+	vip, _ := service.CreateBooking(5, CreateBookingRequest{ClassID: class.ID})
+	_, err = service.CancelBooking(5, vip.ID)
+	require.NoError(t, err)
+	_, err = service.CreateBooking(5, CreateBookingRequest{ClassID: other.ID})
+	require.NoError(t, err)
+
+	b, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	cancelled, err := service.CancelBooking(2, b.ID)
+	require.NoError(t, err)
+	require.NotNil(t, cancelled.Penalty)
+	require.NotNil(t, cancelled.Penalty.BannedUntil)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 3), *cancelled.Penalty.BannedUntil, time.Minute)
+
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: other.ID})
+	assert.ErrorIs(t, err, ErrBookingBanned)
+}
+
+func TestMarkNoShows_AppliesNoShowPenalty(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+	db.Model(class).Update("class_type", "spin")
+
+	_, err := service.CreatePolicy(CancellationPolicyRequest{ClassType: "spin", NoShowPenalty: PenaltyCredit})
+	require.NoError(t, err)
+
+	b, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	db.Model(class).Updates(map[string]any{
+		"start_time": time.Now().Add(-2 * time.Hour).UTC(),
+		"end_time":   time.Now().Add(-time.Hour).UTC(),
+	})
+	marked, err := service.MarkNoShows()
+	require.NoError(t, err)
+	require.Len(t, marked, 1)
+
+	penalties, err := service.ListPenalties(2)
+	require.NoError(t, err)
+	require.Len(t, penalties, 1)
+	assert.Equal(t, b.ID, penalties[0].BookingID)
+	assert.Equal(t, PenaltyReasonNoShow, penalties[0].Reason)
+	assert.Equal(t, 1, penalties[0].Credits)
+}
+
+func TestPenaltyFee_CardThenWallet(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{cardStatus: PaymentStatusPaid}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 5)
+	_, err := service.CreatePolicy(CancellationPolicyRequest{
+		FreeCancelMinutes: 48 * 60,
+		LateCancelPenalty: PenaltyFee,
+		LateCancelFee:     money.New(750, "USD"),
+	})
+	require.NoError(t, err)
+	cancelLate := func(userID uint) *Penalty {
+		b, err := service.CreateBooking(userID, CreateBookingRequest{ClassID: class.ID})
+		require.NoError(t, err)
+		cancelled, err := service.CancelBooking(userID, b.ID)
+		require.NoError(t, err)
+		require.NotNil(t, cancelled.Penalty)
+		return cancelled.Penalty
+	}
+
+	// Charged to the stored payment method; waiving refunds the charge.
+	charged := cancelLate(2)
+	assert.Equal(t, FeeCharged, charged.FeeStatus)
+	require.NotNil(t, charged.PaymentID)
+	waived, err := service.WaivePenalty(charged.ID, 9, "first time")
+	require.NoError(t, err)
+	assert.Equal(t, FeeWaived, waived.FeeStatus)
+	assert.Equal(t, uint(9), *waived.WaivedBy)
+	assert.Equal(t, []uint{*charged.PaymentID}, payments.refundedPayments)
+	_, err = service.WaivePenalty(charged.ID, 9, "again")
+	assert.ErrorIs(t, err, ErrPenaltyWaived)
+
+	// Declined: a credit is taken from the wallet; waiving gives it back.
+	payments.cardStatus = PaymentStatusFailed
+	_, err = service.GrantCredits(CreditGrant{UserID: 3, Credits: 1, Source: CreditSourceGrant})
+	require.NoError(t, err)
+	paidWithCredit := cancelLate(3)
+	assert.Equal(t, FeeCredit, paidWithCredit.FeeStatus)
+	balance, err := service.GetCredits(3)
+	require.NoError(t, err)
+	assert.Equal(t, 0, balance.Balance)
+	_, err = service.WaivePenalty(paidWithCredit.ID, 9, "injured")
+	require.NoError(t, err)
+	balance, err = service.GetCredits(3)
+	require.NoError(t, err)
+	assert.Equal(t, 1, balance.Balance)
+
+	// No stored method and an empty wallet.
+	payments.cardStatus = ""
+	owed := cancelLate(4)
+	assert.Equal(t, FeeOutstanding, owed.FeeStatus)
+	assert.Nil(t, owed.PaymentID)
+
+	penalties, err := service.ListPenalties(4)
+	require.NoError(t, err)
+	require.Len(t, penalties, 1)
+	assert.Equal(t, FeeOutstanding, penalties[0].FeeStatus)
+}
+
+func TestSettlePenaltyFees_SkipsFeeBeingCharged(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{cardStatus: PaymentStatusPending}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 5)
+	_, err := service.CreatePolicy(CancellationPolicyRequest{
+		FreeCancelMinutes: 48 * 60,
+		LateCancelPenalty: PenaltyFee,
+		LateCancelFee:     money.New(750, "USD"),
+	})
+	require.NoError(t, err)
+
+	// The job runs while the cancellation is still charging the fee.
+	payments.onCharge = func() {
+		require.NoError(t, service.SettlePenaltyFees())
+	}
+	b, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	cancelled, err := service.CancelBooking(2, b.ID)
+	require.NoError(t, err)
+
+	assert.Len(t, payments.fees, 1)
+	pen, err := NewRepository(db).FindPenaltyByID(cancelled.Penalty.ID)
+	require.NoError(t, err)
+	assert.Equal(t, FeePending, pen.FeeStatus)
+	assert.NotNil(t, pen.PaymentID)
+	assert.Nil(t, pen.ChargingSince)
+}
+
+func TestSettlePenaltyFees(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{cardStatus: PaymentStatusPending}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 5)
+	_, err := service.CreatePolicy(CancellationPolicyRequest{
+		FreeCancelMinutes: 48 * 60,
+		LateCancelPenalty: PenaltyFee,
+		LateCancelFee:     money.New(750, "USD"),
+	})
+	require.NoError(t, err)
+	cancelLate := func(userID uint) *Penalty {
+		b, err := service.CreateBooking(userID, CreateBookingRequest{ClassID: class.ID})
+		require.NoError(t, err)
+		cancelled, err := service.CancelBooking(userID, b.ID)
+		require.NoError(t, err, "the cancellation stands whatever the charge does")
+		require.NotNil(t, cancelled.Penalty)
+		return cancelled.Penalty
+	}
+	feeStatus := func(id uint) string {
+		pen, err := NewRepository(db).FindPenaltyByID(id)
+		require.NoError(t, err)
+		return pen.FeeStatus
+	}
+
+	// Charges an asynchronous provider has not settled stay pending.
+	paid := cancelLate(2)
+	declined := cancelLate(3)
+	waived := cancelLate(4)
+	for _, pen := range []*Penalty{paid, declined, waived} {
+		assert.Equal(t, FeePending, pen.FeeStatus)
+		require.NotNil(t, pen.PaymentID)
+	}
+	// Charging errored: nothing was charged yet.
+	payments.chargeErr = assert.AnError
+	unreached := cancelLate(5)
+	assert.Equal(t, FeePending, unreached.FeeStatus)
+	assert.Nil(t, unreached.PaymentID)
+
+	_, err = service.WaivePenalty(waived.ID, 9, "goodwill")
+	require.NoError(t, err)
+	assert.Empty(t, payments.refundedPayments, "nothing collected to refund yet")
+	require.NoError(t, db.Exec("INSERT INTO payments (id, penalty_id, status) VALUES (?, ?, 'paid'), (?, ?, 'failed'), (?, ?, 'paid')",
+		*paid.PaymentID, paid.ID, *declined.PaymentID, declined.ID, *waived.PaymentID, waived.ID).Error)
+
+	payments.chargeErr = nil
+	payments.cardStatus = PaymentStatusPaid
+	require.NoError(t, service.SettlePenaltyFees())
+	assert.Equal(t, FeeCharged, feeStatus(paid.ID))
+	assert.Equal(t, FeeOutstanding, feeStatus(declined.ID), "declined with an empty wallet")
+	assert.Equal(t, FeeWaived, feeStatus(waived.ID))
+	assert.Equal(t, []uint{*waived.PaymentID}, payments.refundedPayments, "collected after the waiver")
+	assert.Equal(t, FeeCharged, feeStatus(unreached.ID))
+	assert.Len(t, payments.fees, 4)
+
+	// A charge recorded as collected that failed afterwards.
+	require.NoError(t, db.Exec("UPDATE payments SET status = 'failed' WHERE id = ?", *paid.PaymentID).Error)
+	_, err = service.GrantCredits(CreditGrant{UserID: 2, Credits: 1, Source: CreditSourceGrant})
+	require.NoError(t, err)
+	require.NoError(t, service.SettlePenaltyFees())
+	assert.Equal(t, FeeCredit, feeStatus(paid.ID))
+	require.NoError(t, service.SettlePenaltyFees())
+	assert.Len(t, payments.fees, 4, "settled fees are not charged again")
+}
+
+func TestWaivePenalty_OnlyFees(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 5)
+	_, err := service.CreatePolicy(CancellationPolicyRequest{FreeCancelMinutes: 48 * 60, LateCancelPenalty: PenaltyBan, BanDays: 1})
+	require.NoError(t, err)
+	b, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	cancelled, err := service.CancelBooking(2, b.ID)
+	require.NoError(t, err)
+
+	_, err = service.WaivePenalty(cancelled.Penalty.ID, 9, "sorry")
+	assert.ErrorIs(t, err, ErrNotWaivable)
+	_, err = service.WaivePenalty(999, 9, "sorry")
+	assert.ErrorIs(t, err, ErrPenaltyNotFound)
+}
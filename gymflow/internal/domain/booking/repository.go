@@ -1,17 +1,94 @@
 package booking
 
-import "gorm.io/gorm"
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Repository interface {
+	// Transaction runs fn against a repository bound to a single DB transaction.
+	Transaction(fn func(repo Repository) error) error
+
 	CreateClass(c *GymClass) error
-	ListClasses() ([]GymClass, error)
+	ListClasses(f ClassFilter) ([]GymClass, error)
+	CountSeatsForClasses(classIDs []uint) (map[uint]int64, error)
 	FindClassByID(id uint) (*GymClass, error)
+	LockClass(id uint) (*GymClass, error)
+	UpdateClass(c *GymClass) error
+	DeleteClass(id uint) error
+
+	ListTrainerClassesOverlapping(trainerID uint, start, end time.Time, excludeID uint) ([]GymClass, error)
+	ListRoomClassesOverlapping(roomID uint, start, end time.Time, excludeID uint) ([]GymClass, error)
+	FindUserRole(userID uint) (string, error)
+	FindUserMembership(userID uint) (string, error)
+
+	CreatePolicy(p *CancellationPolicy) error
+	UpdatePolicy(p *CancellationPolicy) error
+	DeletePolicy(id uint) error
+	FindPolicyByID(id uint) (*CancellationPolicy, error)
+	ListPolicies() ([]CancellationPolicy, error)
+	ListPoliciesMatching(tier, classType string) ([]CancellationPolicy, error)
+
+	CreatePenalty(p *Penalty) error
+	UpdatePenalty(p *Penalty) error
+	FindPenaltyByID(id uint) (*Penalty, error)
+	// LockPenalty loads the penalty and holds its row until the transaction ends.
+	LockPenalty(id uint) (*Penalty, error)
+	ListPenaltiesByUser(userID uint) ([]Penalty, error)
+	FindActiveBan(userID uint, at time.Time) (*Penalty, error)
+	// FindPenaltyByBooking returns the booking's penalty for reason, or nil.
+	FindPenaltyByBooking(bookingID uint, reason string) (*Penalty, error)
+	// ListUnsettledFees returns fee penalties still pending, those recorded
+	// as charged whose charge failed afterwards and waived ones whose charge
+	// was collected after the waiver.
+	ListUnsettledFees(limit int) ([]Penalty, error)
+	// FindFeeCharge returns the id and status of the latest payment charging
+	// the penalty, or gorm.ErrRecordNotFound when none was made.
+	FindFeeCharge(penaltyID uint) (uint, string, error)
+
+	CreateRoom(room *Room) error
+	UpdateRoom(room *Room) error
+	FindRoomByID(id uint) (*Room, error)
+	LockRoom(id uint) (*Room, error)
+	ListRooms() ([]Room, error)
+	ListUpcomingRoomClassesAbove(roomID uint, capacity int, from time.Time) ([]GymClass, error)
+
+	CreateSeries(cs *ClassSeries) error
+	UpdateSeries(cs *ClassSeries) error
+	FindSeriesByID(id uint) (*ClassSeries, error)
+	ListSeriesGeneratedBefore(t time.Time) ([]ClassSeries, error)
+	ListSeriesOccurrences(seriesID uint, from time.Time) ([]GymClass, error)
 
 	CreateBooking(b *Booking) error
 	ListBookingsByUser(userID uint) ([]Booking, error)
+	LockMember(userID uint) error
+	HasActiveBooking(userID, classID uint) (bool, error)
+	CountUpcomingBookingsByUser(userID uint, now time.Time) (int64, error)
 	CountBookingsForClass(classID uint) (int64, error)
+	CountActiveBookingsForClass(classID uint) (int64, error)
+	ListBookingsForClass(classID uint, statuses ...string) ([]Booking, error)
+	ListNewestBooked(classID uint, limit int) ([]Booking, error)
+	FindOldestWaitlisted(classID uint) (*Booking, error)
+	ListBookedEndedBefore(t time.Time) ([]Booking, error)
+	ListAttendanceByUser(userID uint) ([]AttendanceRecord, error)
+	WaitlistPosition(b *Booking) (int64, error)
+	CountWaitlistForClass(classID uint) (int64, error)
 	UpdateBooking(b *Booking) error
 	FindBookingByID(id uint) (*Booking, error)
+
+	CreateCreditLot(l *CreditLot) error
+	UpdateCreditLot(l *CreditLot) error
+	// LockCreditLot loads the lot and holds its row until the transaction ends.
+	LockCreditLot(id uint) (*CreditLot, error)
+	FindCreditLotByPurchase(purchaseID uint) (*CreditLot, error)
+	// ListUsableCreditLots returns the member's unexpired lots with credits
+	// left, the soonest to expire first.
+	ListUsableCreditLots(userID uint, now time.Time) ([]CreditLot, error)
+	ListExpiredCreditLots(now time.Time) ([]CreditLot, error)
+	CreateCreditEntry(e *CreditEntry) error
+	ListCreditEntries(userID uint) ([]CreditEntry, error)
 }
 
 type repository struct {
@@ -22,18 +99,83 @@ func NewRepository(db *gorm.DB) Repository {
 	return &repository{db: db}
 }
 
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
+
 func (r *repository) CreateClass(c *GymClass) error {
 	return r.db.Create(c).Error
 }
 
-func (r *repository) ListClasses() ([]GymClass, error) {
+func (r *repository) ListClasses(f ClassFilter) ([]GymClass, error) {
+	q := r.db.Model(&GymClass{}).Where("start_time >= ?", f.From)
+	if f.To != nil {
+		q = q.Where("start_time <= ?", *f.To)
+	}
+	if f.TrainerID != 0 {
+		q = q.Where("trainer_id = ?", f.TrainerID)
+	}
+	if f.Name != "" {
+		q = q.Where(`LOWER(name) LIKE ? ESCAPE '\'`, "%"+escapeLike(strings.ToLower(f.Name))+"%")
+	}
+	if f.ClassType != "" {
+		q = q.Where("class_type = ?", f.ClassType)
+	}
+	if !f.IncludeCancelled {
+		q = q.Where("status <> ?", ClassStatusCancelled)
+	}
+	if f.OnlyOpen {
+		q = q.Where("capacity > (SELECT COUNT(*) FROM bookings WHERE bookings.class_id = gym_classes.id AND bookings.status IN ?)", seatStatuses)
+	}
+
+	dir, cmp := "ASC", ">"
+	if f.Desc {
+		dir, cmp = "DESC", "<"
+	}
+	if f.After != nil {
+		v := f.After.value()
+		q = q.Where("("+f.SortColumn+" "+cmp+" ?) OR ("+f.SortColumn+" = ? AND id "+cmp+" ?)", v, v, f.After.ID)
+	}
+
 	var classes []GymClass
-	if err := r.db.Find(&classes).Error; err != nil {
+	err := q.Order(f.SortColumn + " " + dir).Order("id " + dir).Limit(f.Limit).Find(&classes).Error
+	if err != nil {
 		return nil, err
 	}
 	return classes, nil
 }
 
+// CountSeatsForClasses returns the number of taken seats per class.
+func (r *repository) CountSeatsForClasses(classIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64, len(classIDs))
+	if len(classIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		ClassID uint
+		Seats   int64
+	}
+	err := r.db.Model(&Booking{}).
+		Select("class_id, COUNT(*) AS seats").
+		Where("class_id IN ? AND status IN ?", classIDs, seatStatuses).
+		Group("class_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.ClassID] = row.Seats
+	}
+	return counts, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so user input matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 func (r *repository) FindClassByID(id uint) (*GymClass, error) {
 	var c GymClass
 	if err := r.db.First(&c, id).Error; err != nil {
@@ -42,6 +184,315 @@ func (r *repository) FindClassByID(id uint) (*GymClass, error) {
 	return &c, nil
 }
 
+// LockClass serialises writers on a class until the surrounding transaction ends.
+// A no-op UPDATE is used instead of SELECT ... FOR UPDATE: Postgres takes a row
+// lock for it, and SQLite (which ignores locking clauses) takes its write lock.
+func (r *repository) LockClass(id uint) (*GymClass, error) {
+	res := r.db.Model(&GymClass{}).Where("id = ?", id).UpdateColumn("capacity", gorm.Expr("capacity"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.FindClassByID(id)
+}
+
+func (r *repository) UpdateClass(c *GymClass) error {
+	return r.db.Save(c).Error
+}
+
+func (r *repository) DeleteClass(id uint) error {
+	return r.db.Delete(&GymClass{}, id).Error
+}
+
+// ListTrainerClassesOverlapping returns the trainer's non-cancelled classes
+// that intersect [start, end). Touching intervals do not overlap.
+func (r *repository) ListTrainerClassesOverlapping(trainerID uint, start, end time.Time, excludeID uint) ([]GymClass, error) {
+	var classes []GymClass
+	err := r.db.Where("trainer_id = ? AND id <> ? AND status <> ?", trainerID, excludeID, ClassStatusCancelled).
+		Where("start_time < ? AND end_time > ?", end.UTC(), start.UTC()).
+		Order("start_time ASC").
+		Find(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// ListRoomClassesOverlapping is ListTrainerClassesOverlapping for a room.
+func (r *repository) ListRoomClassesOverlapping(roomID uint, start, end time.Time, excludeID uint) ([]GymClass, error) {
+	var classes []GymClass
+	err := r.db.Where("room_id = ? AND id <> ? AND status <> ?", roomID, excludeID, ClassStatusCancelled).
+		Where("start_time < ? AND end_time > ?", end.UTC(), start.UTC()).
+		Order("start_time ASC").
+		Find(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+// FindUserRole reads the role straight from the users table so booking does
+// not have to depend on the user package.
+func (r *repository) FindUserRole(userID uint) (string, error) {
+	var role string
+	res := r.db.Table("users").Select("role").Where("id = ?", userID).Limit(1).Scan(&role)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return role, nil
+}
+
+// FindUserMembership reads the membership tier the same way as FindUserRole.
+func (r *repository) FindUserMembership(userID uint) (string, error) {
+	var tier string
+	res := r.db.Table("users").Select("membership_tier").Where("id = ?", userID).Limit(1).Scan(&tier)
+	if res.Error != nil {
+		return "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return tier, nil
+}
+
+func (r *repository) CreatePolicy(p *CancellationPolicy) error {
+	return r.db.Create(p).Error
+}
+
+func (r *repository) UpdatePolicy(p *CancellationPolicy) error {
+	return r.db.Save(p).Error
+}
+
+func (r *repository) DeletePolicy(id uint) error {
+	res := r.db.Delete(&CancellationPolicy{}, id)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) FindPolicyByID(id uint) (*CancellationPolicy, error) {
+	var p CancellationPolicy
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) ListPolicies() ([]CancellationPolicy, error) {
+	var policies []CancellationPolicy
+	if err := r.db.Order("membership_tier, class_type").Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+// ListPoliciesMatching returns the policies that apply to tier and classType,
+// including the wildcard ones.
+func (r *repository) ListPoliciesMatching(tier, classType string) ([]CancellationPolicy, error) {
+	var policies []CancellationPolicy
+	err := r.db.
+		Where("membership_tier IN ?", []string{tier, ""}).
+		Where("class_type IN ?", []string{classType, ""}).
+		Find(&policies).Error
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (r *repository) CreatePenalty(p *Penalty) error {
+	return r.db.Create(p).Error
+}
+
+func (r *repository) UpdatePenalty(p *Penalty) error {
+	return r.db.Save(p).Error
+}
+
+func (r *repository) FindPenaltyByID(id uint) (*Penalty, error) {
+	var p Penalty
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// LockPenalty uses a no-op UPDATE, like LockClass.
+func (r *repository) LockPenalty(id uint) (*Penalty, error) {
+	res := r.db.Model(&Penalty{}).Where("id = ?", id).UpdateColumn("fee_status", gorm.Expr("fee_status"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var p Penalty
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) ListPenaltiesByUser(userID uint) ([]Penalty, error) {
+	var penalties []Penalty
+	if err := r.db.Where("user_id = ?", userID).Order("created_at DESC, id DESC").Find(&penalties).Error; err != nil {
+		return nil, err
+	}
+	return penalties, nil
+}
+
+// FindActiveBan returns the ban that runs longest past at, or nil.
+func (r *repository) FindActiveBan(userID uint, at time.Time) (*Penalty, error) {
+	var bans []Penalty
+	err := r.db.
+		Where("user_id = ? AND type = ? AND banned_until > ?", userID, PenaltyBan, at.UTC()).
+		Order("banned_until DESC").Limit(1).
+		Find(&bans).Error
+	if err != nil || len(bans) == 0 {
+		return nil, err
+	}
+	return &bans[0], nil
+}
+
+func (r *repository) FindPenaltyByBooking(bookingID uint, reason string) (*Penalty, error) {
+	var penalties []Penalty
+	err := r.db.Where("booking_id = ? AND reason = ?", bookingID, reason).
+		Order("id DESC").Limit(1).Find(&penalties).Error
+	if err != nil || len(penalties) == 0 {
+		return nil, err
+	}
+	return &penalties[0], nil
+}
+
+// ListUnsettledFees reads the payments table straight, like FindUserRole
+// reads users.
+func (r *repository) ListUnsettledFees(limit int) ([]Penalty, error) {
+	var penalties []Penalty
+	err := r.db.
+		Where("type = ? AND amount_minor > 0", PenaltyFee).
+		Where("fee_status = ? OR (fee_status = ? AND payment_id IN (SELECT id FROM payments WHERE status = ?)) "+
+			"OR (fee_status = ? AND payment_id IN (SELECT id FROM payments WHERE status = ?))",
+			FeePending, FeeCharged, PaymentStatusFailed, FeeWaived, PaymentStatusPaid).
+		Order("id ASC").Limit(limit).
+		Find(&penalties).Error
+	if err != nil {
+		return nil, err
+	}
+	return penalties, nil
+}
+
+func (r *repository) FindFeeCharge(penaltyID uint) (uint, string, error) {
+	var charge struct {
+		ID     uint
+		Status string
+	}
+	res := r.db.Table("payments").Select("id, status").
+		Where("penalty_id = ?", penaltyID).
+		Order("id DESC").Limit(1).
+		Scan(&charge)
+	if res.Error != nil {
+		return 0, "", res.Error
+	}
+	if res.RowsAffected == 0 {
+		return 0, "", gorm.ErrRecordNotFound
+	}
+	return charge.ID, charge.Status, nil
+}
+
+func (r *repository) CreateRoom(room *Room) error {
+	return r.db.Create(room).Error
+}
+
+func (r *repository) UpdateRoom(room *Room) error {
+	return r.db.Save(room).Error
+}
+
+func (r *repository) FindRoomByID(id uint) (*Room, error) {
+	var room Room
+	if err := r.db.First(&room, id).Error; err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// LockRoom is LockClass for a room: it serialises scheduling into the room.
+func (r *repository) LockRoom(id uint) (*Room, error) {
+	res := r.db.Model(&Room{}).Where("id = ?", id).UpdateColumn("capacity", gorm.Expr("capacity"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.FindRoomByID(id)
+}
+
+func (r *repository) ListRooms() ([]Room, error) {
+	var rooms []Room
+	if err := r.db.Order("name ASC").Find(&rooms).Error; err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// ListUpcomingRoomClassesAbove returns scheduled classes in the room starting
+// after from whose capacity is greater than capacity.
+func (r *repository) ListUpcomingRoomClassesAbove(roomID uint, capacity int, from time.Time) ([]GymClass, error) {
+	var classes []GymClass
+	err := r.db.Where("room_id = ? AND status <> ? AND capacity > ? AND start_time > ?",
+		roomID, ClassStatusCancelled, capacity, from.UTC()).
+		Order("start_time ASC").
+		Find(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
+func (r *repository) CreateSeries(cs *ClassSeries) error {
+	return r.db.Create(cs).Error
+}
+
+func (r *repository) UpdateSeries(cs *ClassSeries) error {
+	return r.db.Save(cs).Error
+}
+
+func (r *repository) FindSeriesByID(id uint) (*ClassSeries, error) {
+	var cs ClassSeries
+	if err := r.db.First(&cs, id).Error; err != nil {
+		return nil, err
+	}
+	return &cs, nil
+}
+
+func (r *repository) ListSeriesGeneratedBefore(t time.Time) ([]ClassSeries, error) {
+	var series []ClassSeries
+	if err := r.db.Where("generated_until < ?", t.UTC()).Find(&series).Error; err != nil {
+		return nil, err
+	}
+	return series, nil
+}
+
+// ListSeriesOccurrences returns occurrences whose original slot is at or after from.
+func (r *repository) ListSeriesOccurrences(seriesID uint, from time.Time) ([]GymClass, error) {
+	var classes []GymClass
+	err := r.db.Where("series_id = ? AND original_start >= ?", seriesID, from.UTC()).
+		Order("original_start ASC").
+		Find(&classes).Error
+	if err != nil {
+		return nil, err
+	}
+	return classes, nil
+}
+
 func (r *repository) CreateBooking(b *Booking) error {
 	return r.db.Create(b).Error
 }
@@ -54,10 +505,133 @@ func (r *repository) ListBookingsByUser(userID uint) ([]Booking, error) {
 	return bookings, nil
 }
 
+// LockMember serialises concurrent bookings of one member so the per-member
+// limits can't be raced. Like LockClass it is a no-op update; a member without
+// a users row (never the case outside tests) is not an error.
+func (r *repository) LockMember(userID uint) error {
+	return r.db.Exec("UPDATE users SET id = id WHERE id = ?", userID).Error
+}
+
+// HasActiveBooking reports whether the member holds a seat or a waitlist spot
+// in the class.
+func (r *repository) HasActiveBooking(userID, classID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&Booking{}).
+		Where("user_id = ? AND class_id = ? AND status IN ?", userID, classID, append([]string{BookingStatusWaitlist}, seatStatuses...)).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// CountUpcomingBookingsByUser counts booked and waitlisted bookings for
+// classes that start after now.
+func (r *repository) CountUpcomingBookingsByUser(userID uint, now time.Time) (int64, error) {
+	var count int64
+	err := r.db.Model(&Booking{}).
+		Where("user_id = ? AND status IN ?", userID, []string{BookingStatusBooked, BookingStatusWaitlist}).
+		Where("class_id IN (?)", r.db.Model(&GymClass{}).Select("id").Where("start_time > ?", now.UTC())).
+		Count(&count).Error
+	return count, err
+}
+
+// CountBookingsForClass counts occupied seats, including checked-in and no-show ones.
 func (r *repository) CountBookingsForClass(classID uint) (int64, error) {
 	var count int64
 	err := r.db.Model(&Booking{}).
-		Where("class_id = ? AND status = ?", classID, BookingStatusBooked).
+		Where("class_id = ? AND status IN ?", classID, seatStatuses).
+		Count(&count).Error
+	return count, err
+}
+
+// CountActiveBookingsForClass counts bookings that still hold a seat or a waitlist spot.
+func (r *repository) CountActiveBookingsForClass(classID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&Booking{}).
+		Where("class_id = ? AND status IN ?", classID, append([]string{BookingStatusWaitlist}, seatStatuses...)).
+		Count(&count).Error
+	return count, err
+}
+
+func (r *repository) ListBookingsForClass(classID uint, statuses ...string) ([]Booking, error) {
+	var bookings []Booking
+	err := r.db.Where("class_id = ? AND status IN ?", classID, statuses).
+		Order("created_at ASC, id ASC").
+		Find(&bookings).Error
+	if err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// ListNewestBooked returns the most recently created booked seats of a class.
+func (r *repository) ListNewestBooked(classID uint, limit int) ([]Booking, error) {
+	var bookings []Booking
+	err := r.db.Where("class_id = ? AND status = ?", classID, BookingStatusBooked).
+		Order("created_at DESC, id DESC").
+		Limit(limit).
+		Find(&bookings).Error
+	if err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// FindOldestWaitlisted returns the head of the class waitlist (FIFO by creation).
+func (r *repository) FindOldestWaitlisted(classID uint) (*Booking, error) {
+	var b Booking
+	err := r.db.Where("class_id = ? AND status = ?", classID, BookingStatusWaitlist).
+		Order("created_at ASC, id ASC").
+		First(&b).Error
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListBookedEndedBefore returns booked (not checked-in) seats of scheduled
+// classes that ended before t.
+func (r *repository) ListBookedEndedBefore(t time.Time) ([]Booking, error) {
+	var bookings []Booking
+	err := r.db.Where("status = ?", BookingStatusBooked).
+		Where("class_id IN (?)", r.db.Model(&GymClass{}).Select("id").
+			Where("end_time < ? AND status <> ?", t.UTC(), ClassStatusCancelled)).
+		Find(&bookings).Error
+	if err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// ListAttendanceByUser returns the user's attended and no-show bookings with
+// their class, most recent class first.
+func (r *repository) ListAttendanceByUser(userID uint) ([]AttendanceRecord, error) {
+	var records []AttendanceRecord
+	err := r.db.Model(&Booking{}).
+		Select("bookings.id AS booking_id, bookings.user_id, bookings.class_id, gym_classes.name AS class_name, "+
+			"gym_classes.start_time, bookings.status, bookings.checked_in_at").
+		Joins("JOIN gym_classes ON gym_classes.id = bookings.class_id").
+		Where("bookings.user_id = ? AND bookings.status IN ?", userID, []string{BookingStatusAttended, BookingStatusNoShow}).
+		Order("gym_classes.start_time DESC").
+		Scan(&records).Error
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// WaitlistPosition returns the 1-based place of b in its class waitlist.
+func (r *repository) WaitlistPosition(b *Booking) (int64, error) {
+	var ahead int64
+	err := r.db.Model(&Booking{}).
+		Where("class_id = ? AND status = ?", b.ClassID, BookingStatusWaitlist).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", b.CreatedAt, b.CreatedAt, b.ID).
+		Count(&ahead).Error
+	return ahead + 1, err
+}
+
+func (r *repository) CountWaitlistForClass(classID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&Booking{}).
+		Where("class_id = ? AND status = ?", classID, BookingStatusWaitlist).
 		Count(&count).Error
 	return count, err
 }
@@ -73,3 +647,68 @@ func (r *repository) FindBookingByID(id uint) (*Booking, error) {
 	}
 	return &b, nil
 }
+
+func (r *repository) CreateCreditLot(l *CreditLot) error {
+	return r.db.Create(l).Error
+}
+
+func (r *repository) UpdateCreditLot(l *CreditLot) error {
+	return r.db.Save(l).Error
+}
+
+func (r *repository) LockCreditLot(id uint) (*CreditLot, error) {
+	res := r.db.Model(&CreditLot{}).Where("id = ?", id).UpdateColumn("remaining", gorm.Expr("remaining"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	var l CreditLot
+	if err := r.db.First(&l, id).Error; err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (r *repository) FindCreditLotByPurchase(purchaseID uint) (*CreditLot, error) {
+	var l CreditLot
+	if err := r.db.Where("purchase_id = ?", purchaseID).First(&l).Error; err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+func (r *repository) ListUsableCreditLots(userID uint, now time.Time) ([]CreditLot, error) {
+	var lots []CreditLot
+	err := r.db.Where("user_id = ? AND remaining > 0", userID).
+		Where("expires_at IS NULL OR expires_at > ?", now.UTC()).
+		Order("expires_at IS NULL, expires_at ASC, id ASC").
+		Find(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+func (r *repository) ListExpiredCreditLots(now time.Time) ([]CreditLot, error) {
+	var lots []CreditLot
+	err := r.db.Where("remaining > 0 AND expires_at <= ?", now.UTC()).
+		Order("expires_at ASC, id ASC").
+		Find(&lots).Error
+	if err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+func (r *repository) CreateCreditEntry(e *CreditEntry) error {
+	return r.db.Create(e).Error
+}
+
+// ListCreditEntries returns the member's credit ledger, newest first.
+func (r *repository) ListCreditEntries(userID uint) ([]CreditEntry, error) {
+	var entries []CreditEntry
+	err := r.db.Where("user_id = ?", userID).Order("id DESC").Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,104 @@
+package booking
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrRoomTooSmall = errors.New("room capacity is below the capacity of upcoming classes in it")
+
+func (r *Room) equipmentTags() []string {
+	if r.Equipment == "" {
+		return []string{}
+	}
+	return strings.Split(r.Equipment, ",")
+}
+
+func (r *Room) hasEquipment(tag string) bool {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	for _, t := range r.equipmentTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeEquipment lowercases, trims and de-duplicates tags.
+func normalizeEquipment(tags []string) string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	return strings.Join(out, ",")
+}
+
+func (s *service) CreateRoom(req CreateRoomRequest) (*Room, error) {
+	room := &Room{
+		Name:      req.Name,
+		Capacity:  req.Capacity,
+		Equipment: normalizeEquipment(req.Equipment),
+	}
+	if err := s.repo.CreateRoom(room); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// ListRooms returns all rooms, or only those carrying the equipment tag.
+func (s *service) ListRooms(equipment string) ([]Room, error) {
+	rooms, err := s.repo.ListRooms()
+	if err != nil {
+		return nil, err
+	}
+	if equipment == "" {
+		return rooms, nil
+	}
+	filtered := make([]Room, 0, len(rooms))
+	for _, r := range rooms {
+		if r.hasEquipment(equipment) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// UpdateRoom refuses to shrink a room below the capacity of classes already
+// scheduled in it; those classes have to be resized or moved first.
+func (s *service) UpdateRoom(id uint, req UpdateRoomRequest) (*Room, error) {
+	var room *Room
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if room, err = repo.LockRoom(id); err != nil {
+			return err
+		}
+		if req.Name != nil {
+			room.Name = *req.Name
+		}
+		if req.Equipment != nil {
+			room.Equipment = normalizeEquipment(*req.Equipment)
+		}
+		if req.Capacity != nil {
+			over, err := repo.ListUpcomingRoomClassesAbove(room.ID, *req.Capacity, time.Now())
+			if err != nil {
+				return err
+			}
+			if len(over) > 0 {
+				return ErrRoomTooSmall
+			}
+			room.Capacity = *req.Capacity
+		}
+		return repo.UpdateRoom(room)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return room, nil
+}
@@ -0,0 +1,205 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	FreqDaily  = "DAILY"
+	FreqWeekly = "WEEKLY"
+
+	// maxOccurrences guards expansion against rules that never terminate
+	// inside the requested window (e.g. a huge INTERVAL).
+	maxOccurrences = 5000
+)
+
+var ErrInvalidRRule = errors.New("invalid recurrence rule")
+
+var weekdayNames = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// RRule is the subset of RFC 5545 recurrence rules used for class series:
+// FREQ=DAILY|WEEKLY with optional INTERVAL, BYDAY (weekly only), UNTIL and COUNT.
+type RRule struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	Until    time.Time // zero when unbounded
+	Count    int       // zero when unbounded
+}
+
+// ParseRRule parses e.g. "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20261231T235959Z".
+// A leading "RRULE:" is accepted. loc is the series' time zone, in which a
+// date-only UNTIL is read.
+func ParseRRule(s string, loc *time.Location) (*RRule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, ErrInvalidRRule
+	}
+
+	r := &RRule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("%w: malformed part %q", ErrInvalidRRule, part)
+		}
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.Freq = strings.ToUpper(val)
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%w: bad INTERVAL %q", ErrInvalidRRule, val)
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%w: bad COUNT %q", ErrInvalidRRule, val)
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseICalTime(val, loc)
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad UNTIL %q", ErrInvalidRRule, val)
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, code := range strings.Split(val, ",") {
+				wd, ok := weekdayCodes[strings.ToUpper(code)]
+				if !ok {
+					return nil, fmt.Errorf("%w: bad BYDAY %q", ErrInvalidRRule, code)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				return nil, fmt.Errorf("%w: only WKST=MO is supported", ErrInvalidRRule)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unsupported part %q", ErrInvalidRRule, key)
+		}
+	}
+
+	switch r.Freq {
+	case FreqDaily:
+		if len(r.ByDay) > 0 {
+			return nil, fmt.Errorf("%w: BYDAY is only supported with FREQ=WEEKLY", ErrInvalidRRule)
+		}
+	case FreqWeekly:
+	default:
+		return nil, fmt.Errorf("%w: unsupported FREQ %q", ErrInvalidRRule, r.Freq)
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("%w: COUNT and UNTIL are mutually exclusive", ErrInvalidRRule)
+	}
+
+	sort.Slice(r.ByDay, func(i, j int) bool {
+		return mondayIndex(r.ByDay[i]) < mondayIndex(r.ByDay[j])
+	})
+	return r, nil
+}
+
+// String renders the rule back into RFC 5545 form.
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := make([]string, 0, len(r.ByDay))
+		for _, wd := range r.ByDay {
+			codes = append(codes, weekdayNames[wd])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Between returns the occurrence starts generated from dtstart that fall in
+// [from, to]. Wall-clock time is kept in dtstart's location, so a 18:00 class
+// stays at 18:00 across DST changes. COUNT is applied from dtstart, not from.
+func (r *RRule) Between(dtstart, from, to time.Time) []time.Time {
+	var out []time.Time
+	n := 0
+	emit := func(t time.Time) bool {
+		if t.Before(dtstart) {
+			return true
+		}
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		if t.After(to) {
+			return false
+		}
+		n++
+		if r.Count > 0 && n > r.Count {
+			return false
+		}
+		if !t.Before(from) {
+			out = append(out, t)
+		}
+		return n < maxOccurrences
+	}
+
+	switch r.Freq {
+	case FreqDaily:
+		for i := 0; ; i++ {
+			if !emit(dtstart.AddDate(0, 0, i*r.Interval)) {
+				return out
+			}
+		}
+	case FreqWeekly:
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		weekStart := dtstart.AddDate(0, 0, -mondayIndex(dtstart.Weekday()))
+		for w := 0; ; w++ {
+			week := weekStart.AddDate(0, 0, 7*w*r.Interval)
+			for _, wd := range days {
+				if !emit(week.AddDate(0, 0, mondayIndex(wd))) {
+					return out
+				}
+			}
+		}
+	}
+	return out
+}
+
+func mondayIndex(wd time.Weekday) int {
+	return (int(wd) + 6) % 7
+}
+
+// parseICalTime accepts the RFC 5545 DATE and DATE-TIME forms used in UNTIL.
+// A bare date means "until the end of that day" in loc.
+func parseICalTime(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("20060102", s, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
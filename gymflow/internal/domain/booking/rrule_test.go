@@ -0,0 +1,98 @@
+package booking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRRule_RoundTrip(t *testing.T) {
+	r, err := ParseRRule("RRULE:FREQ=WEEKLY;BYDAY=WE,MO;UNTIL=20261231", time.UTC)
+	require.NoError(t, err)
+
+	assert.Equal(t, FreqWeekly, r.Freq)
+	assert.Equal(t, []time.Weekday{time.Monday, time.Wednesday}, r.ByDay)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20261231T235959Z", r.String())
+}
+
+func TestParseRRule_DateUntilIsEndOfDayInSeriesZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	r, err := ParseRRule("FREQ=DAILY;UNTIL=20261111", loc)
+	require.NoError(t, err)
+
+	assert.True(t, r.Until.Equal(time.Date(2026, 11, 11, 23, 59, 59, 0, loc)))
+	// A 21:00 class on the UNTIL date is still part of the series, although
+	// it starts after midnight UTC.
+	dtstart := time.Date(2026, 11, 10, 21, 0, 0, 0, loc)
+	got := r.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0))
+	assert.Len(t, got, 2)
+}
+
+func TestParseRRule_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"FREQ=MONTHLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;INTERVAL=0",
+		"FREQ=DAILY;BYDAY=MO",
+		"FREQ=WEEKLY;COUNT=3;UNTIL=20261231",
+	} {
+		_, err := ParseRRule(s, time.UTC)
+		assert.ErrorIs(t, err, ErrInvalidRRule, s)
+	}
+}
+
+func TestRRuleBetween_WeeklyMonWedUntil(t *testing.T) {
+	r, err := ParseRRule("FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20261111", time.UTC)
+	require.NoError(t, err)
+
+	// Wednesday 2026-10-21 18:00 UTC: the Monday of that week is before dtstart.
+	dtstart := time.Date(2026, 10, 21, 18, 0, 0, 0, time.UTC)
+	got := r.Between(dtstart, dtstart, dtstart.AddDate(1, 0, 0))
+
+	want := []time.Time{
+		time.Date(2026, 10, 21, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 26, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 28, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 2, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 4, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 9, 18, 0, 0, 0, time.UTC),
+		time.Date(2026, 11, 11, 18, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestRRuleBetween_CountIsFromDTStart(t *testing.T) {
+	r, err := ParseRRule("FREQ=DAILY;INTERVAL=2;COUNT=4", time.UTC)
+	require.NoError(t, err)
+
+	dtstart := time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC)
+	got := r.Between(dtstart, dtstart.AddDate(0, 0, 3), dtstart.AddDate(0, 1, 0))
+
+	assert.Equal(t, []time.Time{
+		time.Date(2026, 1, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 7, 9, 0, 0, 0, time.UTC),
+	}, got)
+}
+
+func TestRRuleBetween_KeepsWallClockAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("tzdata not available")
+	}
+	r, err := ParseRRule("FREQ=WEEKLY;COUNT=2", time.UTC)
+	require.NoError(t, err)
+
+	// Clocks go back on 2026-10-25 in Berlin.
+	dtstart := time.Date(2026, 10, 19, 18, 0, 0, 0, loc)
+	got := r.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0))
+
+	require.Len(t, got, 2)
+	assert.Equal(t, 18, got[1].Hour())
+	assert.Equal(t, 7*24*time.Hour+time.Hour, got[1].Sub(got[0]))
+}
@@ -0,0 +1,101 @@
+package booking
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RuleDuplicateBooking  = "duplicate_booking"
+	RuleMaxActiveBookings = "max_active_bookings"
+	RuleBookingHorizon    = "booking_horizon"
+	RuleBookingBanned     = "booking_banned"
+)
+
+var (
+	ErrDuplicateBooking   = errors.New("member already has an active booking for this class")
+	ErrTooManyBookings    = errors.New("active booking limit reached")
+	ErrBookingTooFarAhead = errors.New("class is too far ahead to book")
+)
+
+// TierLimits bounds how much a member of a membership tier can book. Zero
+// means unlimited.
+type TierLimits struct {
+	MaxActiveBookings int // booked or waitlisted bookings for classes that have not started
+	MaxDaysAhead      int // how far ahead of StartTime a class can be booked
+}
+
+// tierLimits is keyed by user.MembershipTier. Unknown tiers get the basic limits.
+var tierLimits = map[string]TierLimits{
+	"basic":   {MaxActiveBookings: 3, MaxDaysAhead: 7},
+	"premium": {MaxActiveBookings: 8, MaxDaysAhead: 14},
+	"vip":     {MaxActiveBookings: 0, MaxDaysAhead: 30},
+}
+
+func limitsFor(tier string) TierLimits {
+	if l, ok := tierLimits[tier]; ok {
+		return l
+	}
+	return tierLimits["basic"]
+}
+
+// BookingRuleError tells which per-member rule rejected a booking. It
+// unwraps to one of the Err* sentinels above (or ErrBookingBanned).
+type BookingRuleError struct {
+	Rule  string
+	Tier  string
+	Limit int
+	err   error
+}
+
+func (e *BookingRuleError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("%v (%s tier limit: %d)", e.err, e.Tier, e.Limit)
+	}
+	return e.err.Error()
+}
+
+func (e *BookingRuleError) Unwrap() error {
+	return e.err
+}
+
+// checkBookingRules runs under the class and member locks of CreateBooking.
+func checkBookingRules(repo Repository, userID uint, class *GymClass, now time.Time) error {
+	if err := checkBan(repo, userID); err != nil {
+		return &BookingRuleError{Rule: RuleBookingBanned, err: err}
+	}
+
+	active, err := repo.HasActiveBooking(userID, class.ID)
+	if err != nil {
+		return err
+	}
+	if active {
+		return &BookingRuleError{Rule: RuleDuplicateBooking, err: ErrDuplicateBooking}
+	}
+
+	tier, err := repo.FindUserMembership(userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	limits := limitsFor(tier)
+	if tier == "" {
+		tier = "basic"
+	}
+
+	if limits.MaxDaysAhead > 0 && class.StartTime.After(now.AddDate(0, 0, limits.MaxDaysAhead)) {
+		return &BookingRuleError{Rule: RuleBookingHorizon, Tier: tier, Limit: limits.MaxDaysAhead, err: ErrBookingTooFarAhead}
+	}
+	if limits.MaxActiveBookings > 0 {
+		count, err := repo.CountUpcomingBookingsByUser(userID, now)
+		if err != nil {
+			return err
+		}
+		if int(count) >= limits.MaxActiveBookings {
+			return &BookingRuleError{Rule: RuleMaxActiveBookings, Tier: tier, Limit: limits.MaxActiveBookings, err: ErrTooManyBookings}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package booking
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateBooking_RejectsDuplicate(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+
+	first, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	assert.ErrorIs(t, err, ErrDuplicateBooking)
+
+	// A waitlist spot counts as an active booking too.
+	_, err = service.CreateBooking(5, CreateBookingRequest{ClassID: class.ID})
+	require.NoError(t, err)
+	_, err = service.CreateBooking(5, CreateBookingRequest{ClassID: class.ID})
+	assert.ErrorIs(t, err, ErrDuplicateBooking)
+
+	// Cancelling frees the member to book again.
+	_, err = service.CancelBooking(2, first.ID)
+	require.NoError(t, err)
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	assert.NoError(t, err)
+}
+
+func TestCreateBooking_TierLimits(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	classAt := func(days int) *GymClass {
+		c := createTestClass(t, db, 10)
+		start := time.Now().AddDate(0, 0, days).UTC()
+		db.Model(c).Updates(map[string]any{"start_time": start, "end_time": start.Add(time.Hour)})
+		return c
+	}
+
+	// Basic members book at most 7 days ahead; VIPs 30.
+	far := classAt(10)
+	_, err := service.CreateBooking(2, CreateBookingRequest{ClassID: far.ID})
+	var rule *BookingRuleError
+	require.True(t, errors.As(err, &rule))
+	assert.Equal(t, RuleBookingHorizon, rule.Rule)
+	assert.Equal(t, "basic", rule.Tier)
+	assert.Equal(t, 7, rule.Limit)
+	_, err = service.CreateBooking(5, CreateBookingRequest{ClassID: far.ID})
+	assert.NoError(t, err)
+
+	// Basic members hold at most 3 upcoming bookings; past ones don't count.
+	past := classAt(-1)
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: past.ID})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := service.CreateBooking(2, CreateBookingRequest{ClassID: classAt(1).ID})
+		require.NoError(t, err)
+	}
+	_, err = service.CreateBooking(2, CreateBookingRequest{ClassID: classAt(1).ID})
+	assert.ErrorIs(t, err, ErrTooManyBookings)
+	require.True(t, errors.As(err, &rule))
+	assert.Equal(t, RuleMaxActiveBookings, rule.Rule)
+
+	// VIPs are not capped.
+	for i := 0; i < 5; i++ {
+		_, err := service.CreateBooking(5, CreateBookingRequest{ClassID: classAt(2).ID})
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,464 @@
+package booking
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// seriesHorizon is how far ahead series occurrences are materialised as GymClass rows.
+const seriesHorizon = 8 * 7 * 24 * time.Hour
+
+var (
+	ErrNotSeriesOccurrence = errors.New("class is not an occurrence of this series")
+	ErrRRuleNeedsFollowing = errors.New("rrule can only be changed with scope=following")
+	ErrUnknownTimezone     = errors.New("unknown timezone")
+)
+
+func (cs *ClassSeries) location() *time.Location {
+	if cs.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(cs.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (cs *ClassSeries) duration() time.Duration {
+	return time.Duration(cs.DurationMinutes) * time.Minute
+}
+
+func (cs *ClassSeries) exDateList() []string {
+	if cs.ExDates == "" {
+		return []string{}
+	}
+	return strings.Split(cs.ExDates, ",")
+}
+
+func (cs *ClassSeries) exDateSet() map[int64]bool {
+	set := map[int64]bool{}
+	for _, s := range cs.exDateList() {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			set[t.Unix()] = true
+		}
+	}
+	return set
+}
+
+// occurrence builds the GymClass for one generated slot.
+func (cs *ClassSeries) occurrence(start time.Time) *GymClass {
+	c := &GymClass{}
+	cs.applyTo(c, start)
+	return c
+}
+
+// applyTo overwrites c with the series' details for the slot starting at start.
+func (cs *ClassSeries) applyTo(c *GymClass, start time.Time) {
+	id := cs.ID
+	slot := start.UTC()
+	c.Name = cs.Name
+	c.Description = cs.Description
+	c.ClassType = cs.ClassType
+	c.TrainerID = cs.TrainerID
+	c.Capacity = cs.Capacity
+	c.Price = cs.Price
+	if cs.RoomID != nil {
+		room := *cs.RoomID
+		c.RoomID = &room
+	}
+	c.StartTime = start.UTC()
+	c.EndTime = start.Add(cs.duration()).UTC()
+	c.SeriesID = &id
+	c.OriginalStart = &slot
+}
+
+func (s *service) CreateSeries(req CreateSeriesRequest) (*ClassSeries, []GymClass, error) {
+	loc := time.UTC
+	if req.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(req.Timezone); err != nil {
+			return nil, nil, ErrUnknownTimezone
+		}
+	}
+	start, err := time.Parse(time.RFC3339, req.StartTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	end, err := time.Parse(time.RFC3339, req.EndTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !end.After(start) {
+		return nil, nil, ErrInvalidTimeRange
+	}
+	rule, err := ParseRRule(req.RRule, loc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.RoomID == 0 {
+		return nil, nil, ErrRoomRequired
+	}
+	exdates := make([]string, 0, len(req.ExDates))
+	for _, d := range req.ExDates {
+		t, err := time.Parse(time.RFC3339, d)
+		if err != nil {
+			return nil, nil, err
+		}
+		exdates = append(exdates, t.UTC().Format(time.RFC3339))
+	}
+
+	cs := &ClassSeries{
+		Name:            req.Name,
+		Description:     req.Description,
+		ClassType:       normalizeClassType(req.ClassType),
+		TrainerID:       req.TrainerID,
+		Capacity:        req.Capacity,
+		Price:           req.Price,
+		RoomID:          &req.RoomID,
+		Timezone:        loc.String(),
+		DTStart:         start.In(loc),
+		DurationMinutes: int(end.Sub(start) / time.Minute),
+		RRule:           rule.String(),
+		ExDates:         strings.Join(exdates, ","),
+	}
+
+	var occurrences []GymClass
+	err = s.repo.Transaction(func(repo Repository) error {
+		if err := repo.CreateSeries(cs); err != nil {
+			return err
+		}
+		if err := s.generateOccurrences(repo, cs, time.Now().Add(seriesHorizon), true); err != nil {
+			return err
+		}
+		occurrences, err = repo.ListSeriesOccurrences(cs.ID, cs.DTStart)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cs, occurrences, nil
+}
+
+func (s *service) GetSeries(id uint) (*ClassSeries, []GymClass, error) {
+	cs, err := s.repo.FindSeriesByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	occurrences, err := s.repo.ListSeriesOccurrences(cs.ID, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return cs, occurrences, nil
+}
+
+// ExtendSeriesHorizon materialises occurrences up to the rolling horizon.
+// It is safe to run repeatedly; it is driven by a background job.
+func (s *service) ExtendSeriesHorizon() error {
+	upTo := time.Now().Add(seriesHorizon)
+	due, err := s.repo.ListSeriesGeneratedBefore(upTo)
+	if err != nil {
+		return err
+	}
+	for i := range due {
+		err := s.repo.Transaction(func(repo Repository) error {
+			cs, err := repo.FindSeriesByID(due[i].ID)
+			if err != nil {
+				return err
+			}
+			return s.generateOccurrences(repo, cs, upTo, false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generateOccurrences creates the occurrences between the series watermark
+// (GeneratedUntil) and upTo, then advances the watermark. In strict mode any
+// trainer conflict fails the whole call; otherwise (the background job) the
+// clashing slot is skipped and logged.
+func (s *service) generateOccurrences(repo Repository, cs *ClassSeries, upTo time.Time, strict bool) error {
+	if !upTo.After(cs.GeneratedUntil) {
+		return nil
+	}
+	rule, err := ParseRRule(cs.RRule, cs.location())
+	if err != nil {
+		return err
+	}
+	dtstart := cs.DTStart.In(cs.location())
+	from := dtstart
+	if !cs.GeneratedUntil.IsZero() {
+		from = cs.GeneratedUntil.Add(time.Second)
+	}
+
+	excluded := cs.exDateSet()
+	conflicts := &ScheduleConflictError{}
+	for _, start := range rule.Between(dtstart, from, upTo) {
+		if excluded[start.Unix()] {
+			continue
+		}
+		class := cs.occurrence(start)
+		if err := s.validateSchedule(repo, class); err != nil {
+			var conflict *ScheduleConflictError
+			if !strict && errors.As(err, &conflict) {
+				log.Printf("series %d: skipping occurrence at %s: %v", cs.ID, start.Format(time.RFC3339), err)
+				continue
+			}
+			if err := mergeConflict(conflicts, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := repo.CreateClass(class); err != nil {
+			return err
+		}
+	}
+	if len(conflicts.Conflicts) > 0 {
+		return conflicts
+	}
+	cs.GeneratedUntil = upTo
+	return repo.UpdateSeries(cs)
+}
+
+// UpdateOccurrence edits an occurrence, or splits the series at it. Only the
+// trainer of the occurrence, and of the series for scope=following, or an
+// admin may do so.
+func (s *service) UpdateOccurrence(seriesID, classID, staffID uint, staffRole string, req UpdateOccurrenceRequest) (*ClassSeries, []GymClass, error) {
+	if req.RRule != nil && req.Scope != EditScopeFollowing {
+		return nil, nil, ErrRRuleNeedsFollowing
+	}
+
+	var result *ClassSeries
+	err := s.repo.Transaction(func(repo Repository) error {
+		cs, err := repo.FindSeriesByID(seriesID)
+		if err != nil {
+			return err
+		}
+		class, err := repo.LockClass(classID)
+		if err != nil {
+			return err
+		}
+		if class.SeriesID == nil || *class.SeriesID != cs.ID || class.OriginalStart == nil {
+			return ErrNotSeriesOccurrence
+		}
+		if !canManageClass(class, staffID, staffRole) {
+			return ErrNotClassTrainer
+		}
+		if req.Scope != EditScopeThis && staffRole != adminRole && cs.TrainerID != staffID {
+			return ErrNotClassTrainer
+		}
+
+		if req.Scope == EditScopeThis {
+			result = cs
+			return s.updateSingleOccurrence(repo, class, req)
+		}
+		result, err = s.splitSeries(repo, cs, class, req)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	occurrences, err := s.repo.ListSeriesOccurrences(result.ID, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, occurrences, nil
+}
+
+// updateSingleOccurrence edits one occurrence in place and detaches it from
+// the series, so bookings keep pointing at the same GymClass row.
+func (s *service) updateSingleOccurrence(repo Repository, class *GymClass, req UpdateOccurrenceRequest) error {
+	if err := applyClassUpdate(class, req.UpdateClassRequest); err != nil {
+		return err
+	}
+	if err := s.validateSchedule(repo, class); err != nil {
+		return err
+	}
+	class.Detached = true
+	if err := repo.UpdateClass(class); err != nil {
+		return err
+	}
+	return s.rebalanceSeats(repo, class)
+}
+
+// splitSeries implements "this and following": the original series is ended
+// just before pivot and a new series carrying the changes takes over from it.
+// Already generated occurrences are updated in place where the new rule still
+// produces a matching slot, so their bookings survive. Occurrences the new rule
+// no longer produces are deleted, unless members hold bookings for them, in
+// which case they are kept as detached one-offs.
+func (s *service) splitSeries(repo Repository, cs *ClassSeries, pivot *GymClass, req UpdateOccurrenceRequest) (*ClassSeries, error) {
+	loc := cs.location()
+	oldRule, err := ParseRRule(cs.RRule, loc)
+	if err != nil {
+		return nil, err
+	}
+	pivotStart := pivot.OriginalStart.In(loc)
+	horizon := cs.GeneratedUntil
+
+	next := *cs
+	next.ID = 0
+	next.CreatedAt = time.Time{}
+	next.UpdatedAt = time.Time{}
+	next.DTStart = pivotStart
+	next.GeneratedUntil = time.Time{}
+	if req.Name != nil {
+		next.Name = *req.Name
+	}
+	if req.Description != nil {
+		next.Description = *req.Description
+	}
+	if req.ClassType != nil {
+		next.ClassType = normalizeClassType(*req.ClassType)
+	}
+	if req.Price != nil {
+		next.Price = *req.Price
+	}
+	if req.Capacity != nil {
+		next.Capacity = *req.Capacity
+	}
+	if req.TrainerID != nil {
+		next.TrainerID = *req.TrainerID
+	}
+	if req.RoomID != nil {
+		next.RoomID = req.RoomID
+	}
+	if req.StartTime != nil {
+		start, err := time.Parse(time.RFC3339, *req.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		next.DTStart = start.In(loc)
+	}
+	if req.EndTime != nil {
+		end, err := time.Parse(time.RFC3339, *req.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		if !end.After(next.DTStart) {
+			return nil, ErrInvalidTimeRange
+		}
+		next.DurationMinutes = int(end.Sub(next.DTStart) / time.Minute)
+	}
+
+	newRule := *oldRule
+	if req.RRule != nil {
+		parsed, err := ParseRRule(*req.RRule, loc)
+		if err != nil {
+			return nil, err
+		}
+		newRule = *parsed
+	} else if oldRule.Count > 0 {
+		// Carry over only the occurrences the original COUNT had left.
+		before := oldRule.Between(cs.DTStart.In(loc), cs.DTStart.In(loc), pivotStart.Add(-time.Second))
+		newRule.Count = oldRule.Count - len(before)
+	}
+	next.RRule = newRule.String()
+
+	endRule := *oldRule
+	endRule.Count = 0
+	endRule.Until = pivotStart.Add(-time.Second)
+	cs.RRule = endRule.String()
+	if err := repo.UpdateSeries(cs); err != nil {
+		return nil, err
+	}
+	if err := repo.CreateSeries(&next); err != nil {
+		return nil, err
+	}
+
+	if horizon.Before(next.DTStart) {
+		horizon = next.DTStart
+	}
+	excluded := next.exDateSet()
+	wanted := map[int64]time.Time{}
+	for _, t := range newRule.Between(next.DTStart, next.DTStart, horizon) {
+		if !excluded[t.Unix()] {
+			wanted[t.Unix()] = t
+		}
+	}
+
+	existing, err := repo.ListSeriesOccurrences(cs.ID, pivotStart)
+	if err != nil {
+		return nil, err
+	}
+	delta := next.DTStart.Sub(pivotStart)
+	conflicts := &ScheduleConflictError{}
+	for i := range existing {
+		occ := &existing[i]
+		nextID := next.ID
+		occ.SeriesID = &nextID
+
+		keep := occ.Detached || occ.Status == ClassStatusCancelled
+		if keep && occ.ID != pivot.ID {
+			if err := repo.UpdateClass(occ); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		target := occ.OriginalStart.Add(delta)
+		if slot, ok := wanted[target.Unix()]; ok {
+			delete(wanted, target.Unix())
+			if _, err := repo.LockClass(occ.ID); err != nil {
+				return nil, err
+			}
+			next.applyTo(occ, slot)
+			occ.Detached = false
+			if err := mergeConflict(conflicts, s.validateSchedule(repo, occ)); err != nil {
+				return nil, err
+			}
+			if err := repo.UpdateClass(occ); err != nil {
+				return nil, err
+			}
+			if err := s.rebalanceSeats(repo, occ); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		active, err := repo.CountActiveBookingsForClass(occ.ID)
+		if err != nil {
+			return nil, err
+		}
+		if active > 0 {
+			occ.Detached = true
+			if err := repo.UpdateClass(occ); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err := repo.DeleteClass(occ.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	slots := make([]time.Time, 0, len(wanted))
+	for _, t := range wanted {
+		slots = append(slots, t)
+	}
+	sort.Slice(slots, func(i, j int) bool { return slots[i].Before(slots[j]) })
+	for _, t := range slots {
+		class := next.occurrence(t)
+		if err := mergeConflict(conflicts, s.validateSchedule(repo, class)); err != nil {
+			return nil, err
+		}
+		if err := repo.CreateClass(class); err != nil {
+			return nil, err
+		}
+	}
+	if len(conflicts.Conflicts) > 0 {
+		return nil, conflicts
+	}
+
+	next.GeneratedUntil = horizon
+	if err := repo.UpdateSeries(&next); err != nil {
+		return nil, err
+	}
+	return &next, nil
+}
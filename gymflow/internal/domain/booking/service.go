@@ -2,23 +2,129 @@ package booking
 
 import (
 	"errors"
+	"fmt"
 	"time"
+
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrForeignBooking   = errors.New("booking belongs to another member")
+	ErrAlreadyCancelled = errors.New("booking already cancelled")
+	ErrNotWaitlisted    = errors.New("booking is not on the waitlist")
+	ErrClassCancelled   = errors.New("class has been cancelled")
+	ErrInvalidTimeRange = errors.New("end_time must be after start_time")
+	ErrTrainerNotFound  = errors.New("trainer not found")
+	ErrNotTrainer       = errors.New("user does not have the trainer role")
+	ErrRoomRequired     = errors.New("class must be assigned to a room")
+	ErrRoomNotFound     = errors.New("room not found")
+	ErrExceedsRoom      = errors.New("class capacity exceeds room capacity")
+	ErrInvalidPrice     = errors.New("invalid price")
+	ErrNotClassTrainer  = errors.New("only the class trainer or an admin can change this class")
+)
+
+// trainerRole mirrors user.RoleTrainer.
+const trainerRole = "trainer"
+
+const (
+	ConflictReasonTrainer = "trainer"
+	ConflictReasonRoom    = "room"
+)
+
+// ScheduleConflict is an existing class that clashes with a proposed slot,
+// either because it has the same trainer or the same room.
+type ScheduleConflict struct {
+	Reason string
+	Class  GymClass
+}
+
+// ScheduleConflictError lists the classes a proposed slot overlaps with.
+type ScheduleConflictError struct {
+	Conflicts []ScheduleConflict
+}
+
+func (e *ScheduleConflictError) Error() string {
+	return "class overlaps with existing classes of the same trainer or room"
+}
+
+// Payments is the part of the payment service booking depends on. Booking
+// declares it rather than importing the payment package so the dependency
+// only runs one way (payment builds on bookings and penalties) and so tests
+// can run booking without a payment provider.
+type Payments interface {
+	// RefundBookings refunds whatever is still refundable on the paid
+	// payments of the given bookings. It must be idempotent: a retried class
+	// cancellation calls it again.
+	RefundBookings(bookingIDs []uint, reason string) error
+	// ChargePenalty charges a fee penalty off-session to the member's stored
+	// payment method and sets pen.PaymentID. It returns the payment's status
+	// (failed when declined, pending until an asynchronous provider settles)
+	// or ErrNoPaymentMethod when the member has none on file.
+	ChargePenalty(pen *Penalty) (string, error)
+	// RefundPayment refunds whatever is still refundable on a payment.
+	RefundPayment(paymentID uint, reason string) error
+}
+
+// Refund reason codes passed to Payments.
+const (
+	RefundReasonMemberCancel   = "member_cancel"
+	RefundReasonClassCancelled = "class_cancelled"
+	RefundReasonFeeWaived      = "fee_waived"
 )
 
 type Service interface {
 	CreateClass(req CreateClassRequest) (*GymClass, error)
-	ListClasses() ([]GymClass, error)
+	ListClasses(q ListClassesQuery) ([]GymClass, string, error)
+	UpdateClass(id, staffID uint, staffRole string, req UpdateClassRequest) (*GymClass, error)
+	CancelClass(id, staffID uint, staffRole string) (*GymClass, error)
 	CreateBooking(userID uint, req CreateBookingRequest) (*Booking, error)
 	ListBookings(userID uint) ([]Booking, error)
 	CancelBooking(userID, bookingID uint) (*Booking, error)
+	GetWaitlistPosition(userID, bookingID uint) (*WaitlistPositionResponse, error)
+
+	CheckIn(classID, bookingID, staffID uint, staffRole string) (*Booking, error)
+	MarkNoShows() ([]Booking, error)
+	GetMemberAttendance(userID uint) (*MemberAttendanceResponse, error)
+	GetClassAttendance(classID, staffID uint, staffRole string) (*ClassAttendanceResponse, error)
+
+	CreatePolicy(req CancellationPolicyRequest) (*CancellationPolicy, error)
+	ListPolicies() ([]CancellationPolicy, error)
+	UpdatePolicy(id uint, req CancellationPolicyRequest) (*CancellationPolicy, error)
+	DeletePolicy(id uint) error
+	ListPenalties(userID uint) ([]Penalty, error)
+	// SettlePenaltyFees charges fees left pending and settles fee charges
+	// the provider has since paid or failed.
+	SettlePenaltyFees() error
+	// WaivePenalty is the admin override of a fee: a card charge is refunded
+	// and a wallet credit given back.
+	WaivePenalty(id, adminID uint, reason string) (*Penalty, error)
+
+	CreateRoom(req CreateRoomRequest) (*Room, error)
+	ListRooms(equipment string) ([]Room, error)
+	UpdateRoom(id uint, req UpdateRoomRequest) (*Room, error)
+
+	CreateSeries(req CreateSeriesRequest) (*ClassSeries, []GymClass, error)
+	GetSeries(id uint) (*ClassSeries, []GymClass, error)
+	UpdateOccurrence(seriesID, classID, staffID uint, staffRole string, req UpdateOccurrenceRequest) (*ClassSeries, []GymClass, error)
+	ExtendSeriesHorizon() error
+
+	GrantCredits(g CreditGrant) (*CreditLot, error)
+	GetCredits(userID uint) (*CreditBalanceResponse, error)
+	ListCreditLedger(userID uint) ([]CreditEntry, error)
+	ExpireCredits() error
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	payments Payments
 }
 
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+// NewService wires the booking service. payments may be nil when payment
+// side effects are not needed (e.g. tests).
+func NewService(repo Repository, payments Payments) Service {
+	return &service{repo: repo, payments: payments}
 }
 
 func (s *service) CreateClass(req CreateClassRequest) (*GymClass, error) {
@@ -33,65 +139,509 @@ func (s *service) CreateClass(req CreateClassRequest) (*GymClass, error) {
 	c := &GymClass{
 		Name:        req.Name,
 		Description: req.Description,
+		ClassType:   normalizeClassType(req.ClassType),
 		TrainerID:   req.TrainerID,
 		Capacity:    req.Capacity,
-		StartTime:   start,
-		EndTime:     end,
+		StartTime:   start.UTC(),
+		EndTime:     end.UTC(),
 		Price:       req.Price,
 	}
-	if err := s.repo.CreateClass(c); err != nil {
+	if req.RoomID != 0 {
+		c.RoomID = &req.RoomID
+	}
+	err = s.repo.Transaction(func(repo Repository) error {
+		if c.RoomID == nil {
+			return ErrRoomRequired
+		}
+		if err := s.validateSchedule(repo, c); err != nil {
+			return err
+		}
+		return repo.CreateClass(c)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
-func (s *service) ListClasses() ([]GymClass, error) {
-	return s.repo.ListClasses()
+// validatePrice accepts free classes (a zero price needs no currency) but not
+// negative prices or unknown currencies.
+func validatePrice(p money.Money) error {
+	if p == (money.Money{}) {
+		return nil
+	}
+	if p.IsNegative() {
+		return fmt.Errorf("%w: must not be negative", ErrInvalidPrice)
+	}
+	if err := p.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPrice, err)
+	}
+	return nil
+}
+
+// validateSchedule checks that the class has a valid interval and price, is taught by
+// an existing trainer, fits into its room, and overlaps neither the trainer's
+// nor the room's other classes. The room row is locked so two classes can't
+// be squeezed into the same slot concurrently. Classes created before rooms
+// existed have no room and skip the room checks.
+func (s *service) validateSchedule(repo Repository, class *GymClass) error {
+	if !class.EndTime.After(class.StartTime) {
+		return ErrInvalidTimeRange
+	}
+	if err := validatePrice(class.Price); err != nil {
+		return err
+	}
+	role, err := repo.FindUserRole(class.TrainerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrTrainerNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if role != trainerRole {
+		return ErrNotTrainer
+	}
+
+	conflict := &ScheduleConflictError{}
+	clashes, err := repo.ListTrainerClassesOverlapping(class.TrainerID, class.StartTime, class.EndTime, class.ID)
+	if err != nil {
+		return err
+	}
+	for _, c := range clashes {
+		conflict.Conflicts = append(conflict.Conflicts, ScheduleConflict{Reason: ConflictReasonTrainer, Class: c})
+	}
+
+	if class.RoomID != nil {
+		room, err := repo.LockRoom(*class.RoomID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrRoomNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if class.Capacity > room.Capacity {
+			return ErrExceedsRoom
+		}
+		clashes, err := repo.ListRoomClassesOverlapping(room.ID, class.StartTime, class.EndTime, class.ID)
+		if err != nil {
+			return err
+		}
+		for _, c := range clashes {
+			conflict.Conflicts = append(conflict.Conflicts, ScheduleConflict{Reason: ConflictReasonRoom, Class: c})
+		}
+	}
+
+	if len(conflict.Conflicts) > 0 {
+		return conflict
+	}
+	return nil
 }
 
-func (s *service) CreateBooking(userID uint, req CreateBookingRequest) (*Booking, error) {
-	class, err := s.repo.FindClassByID(req.ClassID)
+// mergeConflict folds a schedule conflict into acc so multi-slot operations
+// can report every clash at once. Other errors are returned unchanged.
+func mergeConflict(acc *ScheduleConflictError, err error) error {
+	var conflict *ScheduleConflictError
+	if errors.As(err, &conflict) {
+		acc.Conflicts = append(acc.Conflicts, conflict.Conflicts...)
+		return nil
+	}
+	return err
+}
+
+// canManageClass reports whether the caller may edit or cancel the class:
+// admins can change any class, trainers only their own.
+func canManageClass(class *GymClass, staffID uint, staffRole string) bool {
+	return staffRole == adminRole || class.TrainerID == staffID
+}
+
+// UpdateClass edits one class. Only its trainer or an admin may do so.
+func (s *service) UpdateClass(id, staffID uint, staffRole string, req UpdateClassRequest) (*GymClass, error) {
+	var class *GymClass
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if class, err = repo.LockClass(id); err != nil {
+			return err
+		}
+		if !canManageClass(class, staffID, staffRole) {
+			return ErrNotClassTrainer
+		}
+		if class.Status == ClassStatusCancelled {
+			return ErrClassCancelled
+		}
+		if err := applyClassUpdate(class, req); err != nil {
+			return err
+		}
+		if err := s.validateSchedule(repo, class); err != nil {
+			return err
+		}
+		// An individually edited occurrence no longer follows its series.
+		if class.SeriesID != nil {
+			class.Detached = true
+		}
+		if err := repo.UpdateClass(class); err != nil {
+			return err
+		}
+		return s.rebalanceSeats(repo, class)
+	})
 	if err != nil {
 		return nil, err
 	}
+	return class, nil
+}
+
+// CancelClass cancels the class, moves every active booking to
+// class_cancelled and flags their payments for refund. Cancelling an already
+// cancelled class only repeats the refund step, so a failed call can be retried.
+// Only the class trainer or an admin may cancel it.
+func (s *service) CancelClass(id, staffID uint, staffRole string) (*GymClass, error) {
+	var class *GymClass
+	var affected []uint
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if class, err = repo.LockClass(id); err != nil {
+			return err
+		}
+		if !canManageClass(class, staffID, staffRole) {
+			return ErrNotClassTrainer
+		}
+		if class.Status != ClassStatusCancelled {
+			class.Status = ClassStatusCancelled
+			if err := repo.UpdateClass(class); err != nil {
+				return err
+			}
+			active, err := repo.ListBookingsForClass(class.ID, BookingStatusBooked, BookingStatusWaitlist)
+			if err != nil {
+				return err
+			}
+			for i := range active {
+				active[i].Status = BookingStatusClassCancelled
+				if err := repo.UpdateBooking(&active[i]); err != nil {
+					return err
+				}
+				if err := returnCredit(repo, &active[i]); err != nil {
+					return err
+				}
+			}
+		}
 
-	count, err := s.repo.CountBookingsForClass(class.ID)
+		dropped, err := repo.ListBookingsForClass(class.ID, BookingStatusClassCancelled)
+		if err != nil {
+			return err
+		}
+		for _, b := range dropped {
+			affected = append(affected, b.ID)
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	status := BookingStatusBooked
-	if int(count) >= class.Capacity {
-		status = BookingStatusWaitlist
+	if s.payments != nil && len(affected) > 0 {
+		if err := s.payments.RefundBookings(affected, RefundReasonClassCancelled); err != nil {
+			return nil, err
+		}
+	}
+	return class, nil
+}
+
+// applyClassUpdate copies the set fields of req onto class. Changing only the
+// start time keeps the class length. The result is checked by validateSchedule.
+func applyClassUpdate(class *GymClass, req UpdateClassRequest) error {
+	if req.Name != nil {
+		class.Name = *req.Name
+	}
+	if req.Description != nil {
+		class.Description = *req.Description
+	}
+	if req.ClassType != nil {
+		class.ClassType = normalizeClassType(*req.ClassType)
+	}
+	if req.Price != nil {
+		class.Price = *req.Price
+	}
+	if req.Capacity != nil {
+		class.Capacity = *req.Capacity
+	}
+	if req.TrainerID != nil {
+		class.TrainerID = *req.TrainerID
+	}
+	if req.RoomID != nil {
+		class.RoomID = req.RoomID
+	}
+	if req.StartTime != nil || req.EndTime != nil {
+		length := class.EndTime.Sub(class.StartTime)
+		if req.StartTime != nil {
+			start, err := time.Parse(time.RFC3339, *req.StartTime)
+			if err != nil {
+				return err
+			}
+			class.StartTime = start.UTC()
+			class.EndTime = start.Add(length).UTC()
+		}
+		if req.EndTime != nil {
+			end, err := time.Parse(time.RFC3339, *req.EndTime)
+			if err != nil {
+				return err
+			}
+			class.EndTime = end.UTC()
+		}
+	}
+	return nil
+}
+
+// rebalanceSeats brings the booked count in line with class.Capacity.
+// When capacity shrinks, the most recently booked members are moved back to
+// the waitlist; they keep their original booking time, so they queue ahead of
+// everyone who booked after them. When it grows, the waitlist is promoted FIFO.
+// Must be called inside a transaction holding the class lock.
+func (s *service) rebalanceSeats(repo Repository, class *GymClass) error {
+	booked, err := repo.CountBookingsForClass(class.ID)
+	if err != nil {
+		return err
 	}
 
-	b := &Booking{
-		UserID:        userID,
-		ClassID:       class.ID,
-		Status:        status,
-		PaymentStatus: PaymentStatusPending,
+	if extra := int(booked) - class.Capacity; extra > 0 {
+		demoted, err := repo.ListNewestBooked(class.ID, extra)
+		if err != nil {
+			return err
+		}
+		for i := range demoted {
+			d := &demoted[i]
+			d.Status = BookingStatusWaitlist
+			if d.CreditLotID != nil {
+				// The credit comes back with the seat and is taken again on promotion.
+				if err := returnCredit(repo, d); err != nil {
+					return err
+				}
+				d.CreditLotID = nil
+				d.PaymentStatus = PaymentStatusPending
+			}
+			if err := repo.UpdateBooking(d); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	if err := s.repo.CreateBooking(b); err != nil {
+
+	for free := class.Capacity - int(booked); free > 0; free-- {
+		if err := s.promoteFromWaitlist(repo, class.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) CreateBooking(userID uint, req CreateBookingRequest) (*Booking, error) {
+	var b *Booking
+	err := s.repo.Transaction(func(repo Repository) error {
+		// Lock the class first so the seat count below can't change under us.
+		class, err := repo.LockClass(req.ClassID)
+		if err != nil {
+			return err
+		}
+		if err := repo.LockMember(userID); err != nil {
+			return err
+		}
+		if err := checkBookingRules(repo, userID, class, time.Now()); err != nil {
+			return err
+		}
+		if class.Status == ClassStatusCancelled {
+			return ErrClassCancelled
+		}
+
+		count, err := repo.CountBookingsForClass(class.ID)
+		if err != nil {
+			return err
+		}
+
+		status := BookingStatusBooked
+		if int(count) >= class.Capacity {
+			status = BookingStatusWaitlist
+		}
+
+		now := time.Now()
+		if req.PayWithCredits {
+			ok, err := hasCredits(repo, userID, now)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return ErrInsufficientCredits
+			}
+		}
+
+		b = &Booking{
+			UserID:         userID,
+			ClassID:        class.ID,
+			Status:         status,
+			PaymentStatus:  PaymentStatusPending,
+			PayWithCredits: req.PayWithCredits,
+		}
+		if err := repo.CreateBooking(b); err != nil {
+			return err
+		}
+		// A waitlisted booking pays its credit when it is promoted.
+		if req.PayWithCredits && status == BookingStatusBooked {
+			if err := payWithCredit(repo, b, now); err != nil {
+				return err
+			}
+		}
+		return s.fillWaitlistPosition(repo, b)
+	})
+	if err != nil {
 		return nil, err
 	}
 	return b, nil
 }
 
 func (s *service) ListBookings(userID uint) ([]Booking, error) {
-	return s.repo.ListBookingsByUser(userID)
+	bookings, err := s.repo.ListBookingsByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range bookings {
+		if err := s.fillWaitlistPosition(s.repo, &bookings[i]); err != nil {
+			return nil, err
+		}
+	}
+	return bookings, nil
 }
 
 func (s *service) CancelBooking(userID, bookingID uint) (*Booking, error) {
+	var (
+		b      *Booking
+		refund bool
+	)
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		b, err = repo.FindBookingByID(bookingID)
+		if err != nil {
+			return err
+		}
+		if b.UserID != userID {
+			return ErrForeignBooking
+		}
+		if b.Status == BookingStatusCancelled || b.Status == BookingStatusClassCancelled {
+			return ErrAlreadyCancelled
+		}
+		if b.Status == BookingStatusAttended || b.Status == BookingStatusNoShow {
+			return ErrAlreadyAttended
+		}
+
+		// Re-read under the class lock: the booking may have been promoted
+		// off the waitlist since we first loaded it.
+		class, err := repo.LockClass(b.ClassID)
+		if err != nil {
+			return err
+		}
+		if b, err = repo.FindBookingByID(bookingID); err != nil {
+			return err
+		}
+
+		freedSeat := b.Status == BookingStatusBooked
+		// A booking a capacity cut moved back to the waitlist may already
+		// be paid. Demotion gives a credit back, so that is normally a card.
+		paid := b.PaymentStatus == PaymentStatusPaid || b.PaymentStatus == PaymentStatusPartiallyRefunded
+		b.Status = BookingStatusCancelled
+		b.WaitlistPosition = 0
+		if err := repo.UpdateBooking(b); err != nil {
+			return err
+		}
+		if !freedSeat {
+			// Leaving the waitlist never costs anything.
+			refund = paid
+			return returnCredit(repo, b)
+		}
+
+		policy, err := resolvePolicy(repo, b.UserID, class)
+		if err != nil {
+			return err
+		}
+		if policy.isLateCancel(class, time.Now()) {
+			if b.Penalty, err = s.applyPenalty(repo, b, PenaltyReasonLateCancel, policy); err != nil {
+				return err
+			}
+		} else {
+			refund = true
+			if err := returnCredit(repo, b); err != nil {
+				return err
+			}
+		}
+		return s.promoteFromWaitlist(repo, b.ClassID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.chargePenaltyFees(b.Penalty)
+	if refund && s.payments != nil {
+		if err := s.payments.RefundBookings([]uint{b.ID}, RefundReasonMemberCancel); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (s *service) GetWaitlistPosition(userID, bookingID uint) (*WaitlistPositionResponse, error) {
 	b, err := s.repo.FindBookingByID(bookingID)
 	if err != nil {
 		return nil, err
 	}
 	if b.UserID != userID {
-		return nil, errors.New("cannot cancel foreign booking")
+		return nil, ErrForeignBooking
+	}
+	if b.Status != BookingStatusWaitlist {
+		return nil, ErrNotWaitlisted
 	}
-	b.Status = BookingStatusCancelled
-	if err := s.repo.UpdateBooking(b); err != nil {
+
+	pos, err := s.repo.WaitlistPosition(b)
+	if err != nil {
 		return nil, err
 	}
-	return b, nil
+	size, err := s.repo.CountWaitlistForClass(b.ClassID)
+	if err != nil {
+		return nil, err
+	}
+	return &WaitlistPositionResponse{
+		BookingID:    b.ID,
+		ClassID:      b.ClassID,
+		Position:     pos,
+		WaitlistSize: size,
+	}, nil
+}
+
+// promoteFromWaitlist moves the oldest waitlisted booking into a freed seat.
+// Must be called inside a transaction holding the class lock.
+func (s *service) promoteFromWaitlist(repo Repository, classID uint) error {
+	next, err := repo.FindOldestWaitlisted(classID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	next.Status = BookingStatusBooked
+	if err := repo.UpdateBooking(next); err != nil {
+		return err
+	}
+	if next.PayWithCredits && next.CreditLotID == nil {
+		// Out of credits by now: the seat is kept and can be paid by card.
+		err := payWithCredit(repo, next, time.Now())
+		if err != nil && !errors.Is(err, ErrInsufficientCredits) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) fillWaitlistPosition(repo Repository, b *Booking) error {
+	if b.Status != BookingStatusWaitlist {
+		return nil
+	}
+	pos, err := repo.WaitlistPosition(b)
+	if err != nil {
+		return err
+	}
+	b.WaitlistPosition = pos
+	return nil
 }
@@ -0,0 +1,560 @@
+package booking
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB uses a file database so parallel goroutines get real, separate
+// connections (":memory:" would give each connection its own empty database).
+func setupTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "booking.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&GymClass{}, &ClassSeries{}, &Room{}, &Booking{}, &CancellationPolicy{}, &Penalty{}, &CreditLot{}, &CreditEntry{}))
+
+	// Minimal stand-in for the user package's table: trainers 1 and 3, basic
+	// member 2 and VIP members 5, 7 and 8. Other ids get the basic limits.
+	require.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, role TEXT, membership_tier TEXT)").Error)
+	require.NoError(t, db.Exec(`INSERT INTO users (id, role, membership_tier) VALUES
+		(1, 'trainer', 'basic'), (2, 'member', 'basic'), (3, 'trainer', 'basic'),
+		(5, 'member', 'vip'), (7, 'member', 'vip'), (8, 'member', 'vip')`).Error)
+	// The payment package owns payments; only the columns read here.
+	require.NoError(t, db.Exec("CREATE TABLE payments (id INTEGER PRIMARY KEY, penalty_id INTEGER, status TEXT)").Error)
+
+	// Rooms 1-3 are studios, room 4 is the small spin room.
+	for _, r := range []Room{
+		{Name: "Studio A", Capacity: 20},
+		{Name: "Studio B", Capacity: 20},
+		{Name: "Studio C", Capacity: 20},
+		{Name: "Spin", Capacity: 8, Equipment: "bikes"},
+	} {
+		require.NoError(t, db.Create(&r).Error)
+	}
+	return db
+}
+
+func createTestClass(t *testing.T, db *gorm.DB, capacity int) *GymClass {
+	class := &GymClass{
+		Name:      "Spin",
+		TrainerID: 1,
+		Capacity:  capacity,
+		StartTime: time.Now().Add(24 * time.Hour),
+		EndTime:   time.Now().Add(25 * time.Hour),
+		Price:     money.New(1000, "USD"),
+	}
+	require.NoError(t, db.Create(class).Error)
+	return class
+}
+
+func TestCreateBooking_WaitlistWhenFull(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+
+	first, err := service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, BookingStatusBooked, first.Status)
+
+	second, err := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, BookingStatusWaitlist, second.Status)
+}
+
+func TestCreateBooking_ClassNotFound(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	b, err := service.CreateBooking(1, CreateBookingRequest{ClassID: 42})
+
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Nil(t, b)
+}
+
+func TestCreateBooking_ConcurrentNeverOverbooks(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	const capacity = 5
+	const members = 300
+	class := createTestClass(t, db, capacity)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, members)
+	for i := 1; i <= members; i++ {
+		wg.Add(1)
+		go func(userID uint) {
+			defer wg.Done()
+			if _, err := service.CreateBooking(userID, CreateBookingRequest{ClassID: class.ID}); err != nil {
+				errs <- err
+			}
+		}(uint(i))
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("unexpected booking error: %v", err)
+	}
+
+	var booked, waitlisted int64
+	db.Model(&Booking{}).Where("class_id = ? AND status = ?", class.ID, BookingStatusBooked).Count(&booked)
+	db.Model(&Booking{}).Where("class_id = ? AND status = ?", class.ID, BookingStatusWaitlist).Count(&waitlisted)
+
+	assert.Equal(t, int64(capacity), booked)
+	assert.Equal(t, int64(members-capacity), waitlisted)
+}
+
+func TestCancelBooking_PromotesOldestWaitlisted(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+
+	booked, _ := service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+	first, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	second, _ := service.CreateBooking(3, CreateBookingRequest{ClassID: class.ID})
+	assert.Equal(t, int64(1), first.WaitlistPosition)
+	assert.Equal(t, int64(2), second.WaitlistPosition)
+
+	cancelled, err := service.CancelBooking(1, booked.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, BookingStatusCancelled, cancelled.Status)
+
+	var promoted Booking
+	db.First(&promoted, first.ID)
+	assert.Equal(t, BookingStatusBooked, promoted.Status)
+
+	pos, err := service.GetWaitlistPosition(3, second.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pos.Position)
+	assert.Equal(t, int64(1), pos.WaitlistSize)
+}
+
+func TestCancelBooking_WaitlistedDoesNotPromote(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+
+	service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+	first, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	second, _ := service.CreateBooking(3, CreateBookingRequest{ClassID: class.ID})
+
+	_, err := service.CancelBooking(2, first.ID)
+	assert.NoError(t, err)
+
+	var still Booking
+	db.First(&still, second.ID)
+	assert.Equal(t, BookingStatusWaitlist, still.Status)
+
+	_, err = service.CancelBooking(2, first.ID)
+	assert.ErrorIs(t, err, ErrAlreadyCancelled)
+}
+
+func TestCancelBooking_ForeignBooking(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 1)
+
+	b, _ := service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+
+	_, err := service.CancelBooking(2, b.ID)
+	assert.ErrorIs(t, err, ErrForeignBooking)
+}
+
+func nextWeekday(wd time.Weekday, hour int) time.Time {
+	t := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	for t.Weekday() != wd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Duration(hour) * time.Hour)
+}
+
+func createTestSeries(t *testing.T, service Service, rrule string, exdates ...string) (*ClassSeries, []GymClass) {
+	start := nextWeekday(time.Monday, 18)
+	series, occurrences, err := service.CreateSeries(CreateSeriesRequest{
+		Name:      "Yoga",
+		TrainerID: 1,
+		RoomID:    1,
+		Capacity:  2,
+		StartTime: start.Format(time.RFC3339),
+		EndTime:   start.Add(time.Hour).Format(time.RFC3339),
+		RRule:     rrule,
+		ExDates:   exdates,
+	})
+	require.NoError(t, err)
+	return series, occurrences
+}
+
+func TestCreateSeries_GeneratesOccurrencesWithExDates(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+
+	skipped := nextWeekday(time.Monday, 18).AddDate(0, 0, 2)
+	_, occurrences := createTestSeries(t, service, "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=6", skipped.Format(time.RFC3339))
+
+	require.Len(t, occurrences, 5)
+	for _, o := range occurrences {
+		assert.NotEqual(t, skipped.Unix(), o.StartTime.Unix())
+		assert.Equal(t, time.Hour, o.EndTime.Sub(o.StartTime))
+	}
+}
+
+func TestUpdateOccurrence_ThisDetachesSingleClass(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	series, occurrences := createTestSeries(t, service, "FREQ=WEEKLY;COUNT=3")
+
+	name := "Yoga (guest teacher)"
+	_, _, err := service.UpdateOccurrence(series.ID, occurrences[1].ID, 0, adminRole, UpdateOccurrenceRequest{
+		Scope:              EditScopeThis,
+		UpdateClassRequest: UpdateClassRequest{Name: &name},
+	})
+	require.NoError(t, err)
+
+	var classes []GymClass
+	db.Order("start_time").Find(&classes)
+	assert.Equal(t, "Yoga", classes[0].Name)
+	assert.Equal(t, name, classes[1].Name)
+	assert.True(t, classes[1].Detached)
+	assert.Equal(t, "Yoga", classes[2].Name)
+}
+
+func TestUpdateOccurrence_OnlyOwnTrainerOrAdmin(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	series, occurrences := createTestSeries(t, service, "FREQ=WEEKLY;COUNT=3")
+
+	name := "Yoga (guest teacher)"
+	for _, scope := range []string{EditScopeThis, EditScopeFollowing} {
+		_, _, err := service.UpdateOccurrence(series.ID, occurrences[1].ID, 2, trainerRole, UpdateOccurrenceRequest{
+			Scope:              scope,
+			UpdateClassRequest: UpdateClassRequest{Name: &name},
+		})
+		assert.ErrorIs(t, err, ErrNotClassTrainer, scope)
+	}
+	var class GymClass
+	db.First(&class, occurrences[1].ID)
+	assert.Equal(t, "Yoga", class.Name)
+
+	_, _, err := service.UpdateOccurrence(series.ID, occurrences[1].ID, series.TrainerID, trainerRole, UpdateOccurrenceRequest{
+		Scope:              EditScopeThis,
+		UpdateClassRequest: UpdateClassRequest{Name: &name},
+	})
+	require.NoError(t, err)
+}
+
+func TestUpdateOccurrence_FollowingKeepsBookings(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	series, occurrences := createTestSeries(t, service, "FREQ=WEEKLY;BYDAY=MO;COUNT=4")
+
+	// Members hold bookings on the 3rd and 4th Monday.
+	kept, err := service.CreateBooking(7, CreateBookingRequest{ClassID: occurrences[2].ID})
+	require.NoError(t, err)
+	orphan, err := service.CreateBooking(8, CreateBookingRequest{ClassID: occurrences[3].ID})
+	require.NoError(t, err)
+
+	// From the 2nd occurrence on, move the class to Tuesdays 19:00 for two weeks.
+	newStart := occurrences[1].StartTime.AddDate(0, 0, 1).Add(time.Hour)
+	rrule := "FREQ=WEEKLY;BYDAY=TU;COUNT=2"
+	newSeries, _, err := service.UpdateOccurrence(series.ID, occurrences[1].ID, 0, adminRole, UpdateOccurrenceRequest{
+		Scope:              EditScopeFollowing,
+		UpdateClassRequest: UpdateClassRequest{StartTime: ptr(newStart.Format(time.RFC3339))},
+		RRule:              &rrule,
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, series.ID, newSeries.ID)
+
+	var first GymClass
+	db.First(&first, occurrences[0].ID)
+	assert.Equal(t, series.ID, *first.SeriesID)
+
+	// The booked 3rd Monday maps onto the 2nd Tuesday and is moved in place.
+	var moved GymClass
+	db.First(&moved, occurrences[2].ID)
+	assert.Equal(t, newSeries.ID, *moved.SeriesID)
+	assert.Equal(t, time.Tuesday, moved.StartTime.Weekday())
+	assert.Equal(t, 19, moved.StartTime.Hour())
+
+	// The 4th Monday is outside the new rule but still booked, so it survives detached.
+	var detached GymClass
+	require.NoError(t, db.First(&detached, occurrences[3].ID).Error)
+	assert.True(t, detached.Detached)
+
+	var bookings int64
+	db.Model(&Booking{}).Where("id IN ?", []uint{kept.ID, orphan.ID}).Count(&bookings)
+	assert.Equal(t, int64(2), bookings)
+
+	var total int64
+	db.Model(&GymClass{}).Count(&total)
+	assert.Equal(t, int64(4), total)
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+type fakePayments struct {
+	refunded map[string][]uint // booking ids by refund reason
+	fees     []money.Money
+	// cardStatus is the status of fee charges; without it the member has no
+	// stored payment method.
+	cardStatus       string
+	chargeErr        error
+	refundedPayments []uint
+	// onCharge runs as a fee charge starts, before it is recorded.
+	onCharge func()
+}
+
+func (f *fakePayments) RefundBookings(bookingIDs []uint, reason string) error {
+	if f.refunded == nil {
+		f.refunded = map[string][]uint{}
+	}
+	f.refunded[reason] = append(f.refunded[reason], bookingIDs...)
+	return nil
+}
+
+func (f *fakePayments) ChargePenalty(pen *Penalty) (string, error) {
+	if f.onCharge != nil {
+		onCharge := f.onCharge
+		f.onCharge = nil
+		onCharge()
+	}
+	if f.chargeErr != nil {
+		return "", f.chargeErr
+	}
+	f.fees = append(f.fees, pen.Amount)
+	if f.cardStatus == "" {
+		return "", ErrNoPaymentMethod
+	}
+	id := uint(len(f.fees))
+	pen.PaymentID = &id
+	return f.cardStatus, nil
+}
+
+func (f *fakePayments) RefundPayment(paymentID uint, reason string) error {
+	f.refundedPayments = append(f.refundedPayments, paymentID)
+	return nil
+}
+
+func TestUpdateClass_LowerCapacityDemotesNewestBooked(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 3)
+
+	oldest, _ := service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+	middle, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	newest, _ := service.CreateBooking(3, CreateBookingRequest{ClassID: class.ID})
+	waiting, _ := service.CreateBooking(4, CreateBookingRequest{ClassID: class.ID})
+
+	_, err := service.UpdateClass(class.ID, 0, adminRole, UpdateClassRequest{Capacity: ptr(1)})
+	require.NoError(t, err)
+
+	statusOf := func(id uint) string {
+		var b Booking
+		db.First(&b, id)
+		return b.Status
+	}
+	assert.Equal(t, BookingStatusBooked, statusOf(oldest.ID))
+	assert.Equal(t, BookingStatusWaitlist, statusOf(middle.ID))
+	assert.Equal(t, BookingStatusWaitlist, statusOf(newest.ID))
+
+	// Demoted members queue ahead of the member who was already waiting.
+	pos, err := service.GetWaitlistPosition(2, middle.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pos.Position)
+	pos, err = service.GetWaitlistPosition(4, waiting.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), pos.Position)
+
+	// Raising capacity again promotes them back in queue order.
+	_, err = service.UpdateClass(class.ID, 0, adminRole, UpdateClassRequest{Capacity: ptr(2)})
+	require.NoError(t, err)
+	assert.Equal(t, BookingStatusBooked, statusOf(middle.ID))
+	assert.Equal(t, BookingStatusWaitlist, statusOf(newest.ID))
+}
+
+func TestUpdateClass_RejectsEndBeforeStart(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 3)
+
+	end := class.StartTime.Add(-time.Hour).Format(time.RFC3339)
+	_, err := service.UpdateClass(class.ID, 0, adminRole, UpdateClassRequest{EndTime: &end})
+
+	assert.ErrorIs(t, err, ErrInvalidTimeRange)
+}
+
+func TestUpdateAndCancelClass_OnlyOwnTrainerOrAdmin(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	class := createTestClass(t, db, 3)
+
+	_, err := service.UpdateClass(class.ID, 2, trainerRole, UpdateClassRequest{Capacity: ptr(1)})
+	assert.ErrorIs(t, err, ErrNotClassTrainer)
+	_, err = service.CancelClass(class.ID, 2, trainerRole)
+	assert.ErrorIs(t, err, ErrNotClassTrainer)
+
+	updated, err := service.UpdateClass(class.ID, class.TrainerID, trainerRole, UpdateClassRequest{Capacity: ptr(2)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, updated.Capacity)
+	cancelled, err := service.CancelClass(class.ID, class.TrainerID, trainerRole)
+	require.NoError(t, err)
+	assert.Equal(t, ClassStatusCancelled, cancelled.Status)
+}
+
+func TestCancelClass_CascadesToBookingsAndPayments(t *testing.T) {
+	db := setupTestDB(t)
+	payments := &fakePayments{}
+	service := NewService(NewRepository(db), payments)
+	class := createTestClass(t, db, 1)
+
+	booked, _ := service.CreateBooking(1, CreateBookingRequest{ClassID: class.ID})
+	waiting, _ := service.CreateBooking(2, CreateBookingRequest{ClassID: class.ID})
+	gone, _ := service.CreateBooking(3, CreateBookingRequest{ClassID: class.ID})
+	_, err := service.CancelBooking(3, gone.ID)
+	require.NoError(t, err)
+
+	cancelled, err := service.CancelClass(class.ID, 0, adminRole)
+	require.NoError(t, err)
+	assert.Equal(t, ClassStatusCancelled, cancelled.Status)
+
+	var bookings []Booking
+	db.Where("class_id = ?", class.ID).Order("id").Find(&bookings)
+	assert.Equal(t, BookingStatusClassCancelled, bookings[0].Status)
+	assert.Equal(t, BookingStatusClassCancelled, bookings[1].Status)
+	assert.Equal(t, BookingStatusCancelled, bookings[2].Status)
+	assert.Equal(t, []uint{booked.ID, waiting.ID}, payments.refunded[RefundReasonClassCancelled])
+	assert.Empty(t, payments.refunded[RefundReasonMemberCancel], "a waitlisted booking has nothing to refund")
+
+	_, err = service.CreateBooking(4, CreateBookingRequest{ClassID: class.ID})
+	assert.ErrorIs(t, err, ErrClassCancelled)
+}
+
+func TestCreateClass_ValidatesTrainerAndInterval(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	start := time.Now().Add(48 * time.Hour).UTC()
+
+	req := func(trainerID uint, from, to time.Time) CreateClassRequest {
+		return CreateClassRequest{
+			Name:      "HIIT",
+			TrainerID: trainerID,
+			RoomID:    1,
+			Capacity:  10,
+			StartTime: from.Format(time.RFC3339),
+			EndTime:   to.Format(time.RFC3339),
+		}
+	}
+
+	_, err := service.CreateClass(req(1, start, start.Add(-time.Hour)))
+	assert.ErrorIs(t, err, ErrInvalidTimeRange)
+
+	_, err = service.CreateClass(req(99, start, start.Add(time.Hour)))
+	assert.ErrorIs(t, err, ErrTrainerNotFound)
+
+	_, err = service.CreateClass(req(2, start, start.Add(time.Hour)))
+	assert.ErrorIs(t, err, ErrNotTrainer)
+
+	for _, price := range []money.Money{money.New(-100, "USD"), money.New(1000, "XYZ")} {
+		bad := req(1, start, start.Add(time.Hour))
+		bad.Price = price
+		_, err = service.CreateClass(bad)
+		assert.ErrorIs(t, err, ErrInvalidPrice, price.String())
+	}
+
+	_, err = service.CreateClass(req(1, start, start.Add(time.Hour)))
+	assert.NoError(t, err)
+}
+
+func TestCreateClass_TrainerConflict(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Hour)
+
+	existing, err := service.CreateClass(CreateClassRequest{
+		Name: "Yoga", TrainerID: 1, RoomID: 1, Capacity: 10,
+		StartTime: start.Format(time.RFC3339),
+		EndTime:   start.Add(time.Hour).Format(time.RFC3339),
+	})
+	require.NoError(t, err)
+
+	// Back-to-back is fine.
+	_, err = service.CreateClass(CreateClassRequest{
+		Name: "Pilates", TrainerID: 1, RoomID: 2, Capacity: 10,
+		StartTime: start.Add(time.Hour).Format(time.RFC3339),
+		EndTime:   start.Add(2 * time.Hour).Format(time.RFC3339),
+	})
+	require.NoError(t, err)
+
+	_, err = service.CreateClass(CreateClassRequest{
+		Name: "Stretch", TrainerID: 1, RoomID: 3, Capacity: 10,
+		StartTime: start.Add(30 * time.Minute).Format(time.RFC3339),
+		EndTime:   start.Add(90 * time.Minute).Format(time.RFC3339),
+	})
+	var conflict *ScheduleConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Len(t, conflict.Conflicts, 2)
+	assert.Equal(t, ConflictReasonTrainer, conflict.Conflicts[0].Reason)
+	assert.Equal(t, existing.ID, conflict.Conflicts[0].Class.ID)
+
+	// A cancelled class frees the slot.
+	_, err = service.CancelClass(existing.ID, 0, adminRole)
+	require.NoError(t, err)
+	_, err = service.UpdateClass(conflict.Conflicts[1].Class.ID, 0, adminRole, UpdateClassRequest{
+		StartTime: ptr(start.Format(time.RFC3339)),
+	})
+	assert.NoError(t, err)
+}
+
+func TestCreateClass_RoomRules(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil)
+	start := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Hour)
+	req := func(trainerID, roomID uint, capacity int) CreateClassRequest {
+		return CreateClassRequest{
+			Name: "Ride", TrainerID: trainerID, RoomID: roomID, Capacity: capacity,
+			StartTime: start.Format(time.RFC3339),
+			EndTime:   start.Add(45 * time.Minute).Format(time.RFC3339),
+		}
+	}
+
+	_, err := service.CreateClass(req(1, 0, 8))
+	assert.ErrorIs(t, err, ErrRoomRequired)
+
+	_, err = service.CreateClass(req(1, 42, 8))
+	assert.ErrorIs(t, err, ErrRoomNotFound)
+
+	_, err = service.CreateClass(req(1, 4, 9))
+	assert.ErrorIs(t, err, ErrExceedsRoom)
+
+	ride, err := service.CreateClass(req(1, 4, 8))
+	require.NoError(t, err)
+
+	// Another trainer, same room, same time.
+	_, err = service.CreateClass(req(3, 4, 8))
+	var conflict *ScheduleConflictError
+	require.ErrorAs(t, err, &conflict)
+	require.Len(t, conflict.Conflicts, 1)
+	assert.Equal(t, ConflictReasonRoom, conflict.Conflicts[0].Reason)
+	assert.Equal(t, ride.ID, conflict.Conflicts[0].Class.ID)
+
+	// The spin room cannot shrink below the scheduled ride.
+	_, err = service.UpdateRoom(4, UpdateRoomRequest{Capacity: ptr(6)})
+	assert.ErrorIs(t, err, ErrRoomTooSmall)
+
+	rooms, err := service.ListRooms("Bikes")
+	require.NoError(t, err)
+	require.Len(t, rooms, 1)
+	assert.Equal(t, "Spin", rooms[0].Name)
+}
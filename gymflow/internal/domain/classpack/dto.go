@@ -0,0 +1,95 @@
+package classpack
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+type CreatePackRequest struct {
+	Name         string      `json:"name" binding:"required"`
+	Description  string      `json:"description"`
+	Credits      int         `json:"credits" binding:"required,min=1"`
+	Price        money.Money `json:"price"`
+	ValidityDays int         `json:"validity_days" binding:"min=0"`
+}
+
+// UpdatePackRequest changes a pack. Purchases already made keep their terms.
+type UpdatePackRequest struct {
+	Name         *string      `json:"name"`
+	Description  *string      `json:"description"`
+	Credits      *int         `json:"credits" binding:"omitempty,min=1"`
+	Price        *money.Money `json:"price"`
+	ValidityDays *int         `json:"validity_days" binding:"omitempty,min=0"`
+	Active       *bool        `json:"active"`
+}
+
+type PurchaseRequest struct {
+	// PaymentToken is charged for the pack; without it the member's default
+	// saved payment method is.
+	PaymentToken string `json:"payment_token"`
+}
+
+type PackResponse struct {
+	ID           uint        `json:"id"`
+	Name         string      `json:"name"`
+	Description  string      `json:"description"`
+	Credits      int         `json:"credits"`
+	Price        money.Money `json:"price"`
+	ValidityDays int         `json:"validity_days"`
+	Active       bool        `json:"active"`
+}
+
+func ToPackResponse(p *Pack) *PackResponse {
+	return &PackResponse{
+		ID:           p.ID,
+		Name:         p.Name,
+		Description:  p.Description,
+		Credits:      p.Credits,
+		Price:        p.Price,
+		ValidityDays: p.ValidityDays,
+		Active:       p.Active,
+	}
+}
+
+func ToPackResponses(packs []Pack) []*PackResponse {
+	out := make([]*PackResponse, len(packs))
+	for i := range packs {
+		out[i] = ToPackResponse(&packs[i])
+	}
+	return out
+}
+
+type PurchaseResponse struct {
+	ID        uint        `json:"id"`
+	PackID    uint        `json:"pack_id"`
+	PackName  string      `json:"pack_name"`
+	Credits   int         `json:"credits"`
+	Price     money.Money `json:"price"`
+	Status    string      `json:"status"`
+	PaymentID *uint       `json:"payment_id,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+func ToPurchaseResponse(p *Purchase) *PurchaseResponse {
+	return &PurchaseResponse{
+		ID:        p.ID,
+		PackID:    p.PackID,
+		PackName:  p.Pack.Name,
+		Credits:   p.Credits,
+		Price:     p.Price,
+		Status:    p.Status,
+		PaymentID: p.PaymentID,
+		ExpiresAt: p.ExpiresAt,
+		CreatedAt: p.CreatedAt,
+	}
+}
+
+func ToPurchaseResponses(purchases []Purchase) []*PurchaseResponse {
+	out := make([]*PurchaseResponse, len(purchases))
+	for i := range purchases {
+		out[i] = ToPurchaseResponse(&purchases[i])
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package classpack
+
+import (
+	"errors"
+	"net/http"
+
+	"gymflow/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GET /api/v1/class-packs
+func (h *Handler) ListPacks(c *gin.Context) {
+	packs, err := h.service.ListPacks(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list class packs"})
+		return
+	}
+	c.JSON(http.StatusOK, ToPackResponses(packs))
+}
+
+// POST /api/v1/class-packs/:id/purchase
+func (h *Handler) Purchase(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req PurchaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	purchase, err := h.service.Purchase(userIDAny.(uint), uri.ID, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	// The credits arrive once the provider confirms a pending payment.
+	status := http.StatusCreated
+	if purchase.Status == PurchasePending {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, ToPurchaseResponse(purchase))
+}
+
+// GET /api/v1/class-packs/purchases
+func (h *Handler) ListPurchases(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	purchases, err := h.service.ListPurchases(userIDAny.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list purchases"})
+		return
+	}
+	c.JSON(http.StatusOK, ToPurchaseResponses(purchases))
+}
+
+// GET /api/v1/admin/class-packs
+func (h *Handler) AdminListPacks(c *gin.Context) {
+	packs, err := h.service.ListPacks(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list class packs"})
+		return
+	}
+	c.JSON(http.StatusOK, ToPackResponses(packs))
+}
+
+// POST /api/v1/admin/class-packs
+func (h *Handler) CreatePack(c *gin.Context) {
+	var req CreatePackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pack, err := h.service.CreatePack(req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ToPackResponse(pack))
+}
+
+// PATCH /api/v1/admin/class-packs/:id
+func (h *Handler) UpdatePack(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdatePackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	pack, err := h.service.UpdatePack(uri.ID, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToPackResponse(pack))
+}
+
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPackNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPaymentDeclined):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,49 @@
+package classpack
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// Purchase states. A purchase is pending until its payment settles; paid
+// purchases have had their credits added to the member's wallet.
+const (
+	PurchasePending = "pending"
+	PurchasePaid    = "paid"
+	PurchaseFailed  = "failed"
+)
+
+// Pack is a purchasable bundle of class credits, e.g. 10 classes valid for
+// three months.
+type Pack struct {
+	ID          uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+	Name        string      `gorm:"size:100;not null;uniqueIndex" json:"name"`
+	Description string      `json:"description"`
+	Credits     int         `gorm:"not null" json:"credits"`
+	Price       money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	// ValidityDays is how long the credits last after purchase; 0 never expires.
+	ValidityDays int  `gorm:"not null;default:0" json:"validity_days"`
+	Active       bool `gorm:"not null;default:true" json:"active"`
+}
+
+// Purchase is one member buying a pack. Credits, Price and ValidityDays are
+// copied from the pack so later edits don't change what was sold.
+type Purchase struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	UserID       uint        `gorm:"index" json:"user_id"`
+	PackID       uint        `gorm:"index" json:"pack_id"`
+	Pack         Pack        `gorm:"foreignKey:PackID" json:"pack"`
+	Credits      int         `json:"credits"`
+	Price        money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	ValidityDays int         `json:"validity_days"`
+	Status       string      `gorm:"size:20;index" json:"status"`
+	PaymentID    *uint       `json:"payment_id,omitempty"`
+	// LotID is the wallet lot (booking.CreditLot) the credits went into.
+	LotID     *uint      `json:"lot_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
@@ -0,0 +1,72 @@
+package classpack
+
+import "gorm.io/gorm"
+
+type Repository interface {
+	CreatePack(p *Pack) error
+	UpdatePack(p *Pack) error
+	FindPackByID(id uint) (*Pack, error)
+	ListPacks(activeOnly bool) ([]Pack, error)
+
+	CreatePurchase(p *Purchase) error
+	UpdatePurchase(p *Purchase) error
+	ListPurchasesByUser(userID uint) ([]Purchase, error)
+	// ListPending returns purchases whose payment was still pending.
+	ListPending() ([]Purchase, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) CreatePack(p *Pack) error {
+	return r.db.Create(p).Error
+}
+
+func (r *repository) UpdatePack(p *Pack) error {
+	return r.db.Save(p).Error
+}
+
+func (r *repository) FindPackByID(id uint) (*Pack, error) {
+	var p Pack
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) ListPacks(activeOnly bool) ([]Pack, error) {
+	var packs []Pack
+	q := r.db.Order("price_minor, id")
+	if activeOnly {
+		q = q.Where("active = ?", true)
+	}
+	err := q.Find(&packs).Error
+	return packs, err
+}
+
+func (r *repository) CreatePurchase(p *Purchase) error {
+	return r.db.Omit("Pack").Create(p).Error
+}
+
+func (r *repository) UpdatePurchase(p *Purchase) error {
+	return r.db.Omit("Pack").Save(p).Error
+}
+
+func (r *repository) ListPurchasesByUser(userID uint) ([]Purchase, error) {
+	var purchases []Purchase
+	err := r.db.Preload("Pack").Where("user_id = ?", userID).Order("id DESC").Find(&purchases).Error
+	return purchases, err
+}
+
+func (r *repository) ListPending() ([]Purchase, error) {
+	var purchases []Purchase
+	err := r.db.Preload("Pack").
+		Where("status = ? AND payment_id IS NOT NULL", PurchasePending).
+		Order("id").Find(&purchases).Error
+	return purchases, err
+}
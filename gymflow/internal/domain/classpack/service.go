@@ -0,0 +1,226 @@
+package classpack
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPackNotFound    = errors.New("class pack not found")
+	ErrPackUnavailable = errors.New("class pack is not available")
+	ErrInvalidPack     = errors.New("invalid class pack")
+	ErrPaymentDeclined = errors.New("class pack payment was declined")
+)
+
+// Payments charges pack purchases; payment.Service satisfies it.
+type Payments interface {
+	ChargeClassPack(userID, purchaseID uint, amount money.Money, token string) (*payment.Payment, error)
+	GetPayment(id uint) (*payment.Payment, error)
+}
+
+// Wallet adds purchased credits to the member's wallet; booking.Service
+// satisfies it.
+type Wallet interface {
+	GrantCredits(g booking.CreditGrant) (*booking.CreditLot, error)
+}
+
+type Service interface {
+	ListPacks(includeInactive bool) ([]Pack, error)
+	CreatePack(req CreatePackRequest) (*Pack, error)
+	UpdatePack(id uint, req UpdatePackRequest) (*Pack, error)
+
+	// Purchase charges the pack and credits the wallet once paid. A purchase
+	// still pending at the provider is completed by SettlePending.
+	Purchase(userID, packID uint, req PurchaseRequest) (*Purchase, error)
+	ListPurchases(userID uint) ([]Purchase, error)
+	SettlePending() error
+}
+
+type service struct {
+	repo     Repository
+	payments Payments
+	wallet   Wallet
+}
+
+func NewService(repo Repository, payments Payments, wallet Wallet) Service {
+	return &service{repo: repo, payments: payments, wallet: wallet}
+}
+
+func (s *service) ListPacks(includeInactive bool) ([]Pack, error) {
+	return s.repo.ListPacks(!includeInactive)
+}
+
+func validatePack(p *Pack) error {
+	if p.Credits < 1 {
+		return fmt.Errorf("%w: credits must be at least 1", ErrInvalidPack)
+	}
+	if p.ValidityDays < 0 {
+		return fmt.Errorf("%w: validity_days can't be negative", ErrInvalidPack)
+	}
+	if !p.Price.IsPositive() {
+		return fmt.Errorf("%w: price must be positive", ErrInvalidPack)
+	}
+	if err := p.Price.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPack, err)
+	}
+	return nil
+}
+
+func (s *service) CreatePack(req CreatePackRequest) (*Pack, error) {
+	p := &Pack{
+		Name:         req.Name,
+		Description:  req.Description,
+		Credits:      req.Credits,
+		Price:        req.Price,
+		ValidityDays: req.ValidityDays,
+		Active:       true,
+	}
+	if err := validatePack(p); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreatePack(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) UpdatePack(id uint, req UpdatePackRequest) (*Pack, error) {
+	p, err := s.findPack(id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		p.Name = *req.Name
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.Credits != nil {
+		p.Credits = *req.Credits
+	}
+	if req.Price != nil {
+		p.Price = *req.Price
+	}
+	if req.ValidityDays != nil {
+		p.ValidityDays = *req.ValidityDays
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	if err := validatePack(p); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePack(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) findPack(id uint) (*Pack, error) {
+	p, err := s.repo.FindPackByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPackNotFound
+	}
+	return p, err
+}
+
+func (s *service) Purchase(userID, packID uint, req PurchaseRequest) (*Purchase, error) {
+	pack, err := s.findPack(packID)
+	if err != nil {
+		return nil, err
+	}
+	if !pack.Active {
+		return nil, ErrPackUnavailable
+	}
+	purchase := &Purchase{
+		UserID:       userID,
+		PackID:       pack.ID,
+		Pack:         *pack,
+		Credits:      pack.Credits,
+		Price:        pack.Price,
+		ValidityDays: pack.ValidityDays,
+		Status:       PurchasePending,
+	}
+	if err := s.repo.CreatePurchase(purchase); err != nil {
+		return nil, err
+	}
+	p, err := s.payments.ChargeClassPack(userID, purchase.ID, purchase.Price, req.PaymentToken)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.apply(purchase, p); err != nil {
+		return nil, err
+	}
+	if purchase.Status == PurchaseFailed {
+		return purchase, ErrPaymentDeclined
+	}
+	return purchase, nil
+}
+
+func (s *service) ListPurchases(userID uint) ([]Purchase, error) {
+	return s.repo.ListPurchasesByUser(userID)
+}
+
+func (s *service) SettlePending() error {
+	pending, err := s.repo.ListPending()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i := range pending {
+		purchase := &pending[i]
+		p, err := s.payments.GetPayment(*purchase.PaymentID)
+		if err == nil {
+			err = s.apply(purchase, p)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purchase %d: %w", purchase.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// apply records the outcome of the purchase's payment and credits the wallet
+// once it is collected. Validity runs from the moment of payment.
+func (s *service) apply(purchase *Purchase, p *payment.Payment) error {
+	purchase.PaymentID = &p.ID
+	switch p.Status {
+	case payment.StatusPending, payment.StatusAuthorized:
+		return s.repo.UpdatePurchase(purchase)
+	case payment.StatusPaid:
+	default:
+		purchase.Status = PurchaseFailed
+		return s.repo.UpdatePurchase(purchase)
+	}
+
+	paidAt := time.Now()
+	if p.PaidAt != nil {
+		paidAt = *p.PaidAt
+	}
+	if purchase.ValidityDays > 0 {
+		expires := paidAt.AddDate(0, 0, purchase.ValidityDays)
+		purchase.ExpiresAt = &expires
+	}
+	purchaseID := purchase.ID
+	lot, err := s.wallet.GrantCredits(booking.CreditGrant{
+		UserID:     purchase.UserID,
+		Credits:    purchase.Credits,
+		ExpiresAt:  purchase.ExpiresAt,
+		Source:     booking.CreditSourcePack,
+		PurchaseID: &purchaseID,
+		Note:       purchase.Pack.Name,
+	})
+	if err != nil {
+		return err
+	}
+	purchase.LotID = &lot.ID
+	purchase.Status = PurchasePaid
+	return s.repo.UpdatePurchase(purchase)
+}
@@ -0,0 +1,127 @@
+package classpack
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakePayments settles each charge with status, paid unless set.
+type fakePayments struct {
+	status   string
+	payments map[uint]*payment.Payment
+}
+
+func (f *fakePayments) ChargeClassPack(userID, purchaseID uint, amount money.Money, token string) (*payment.Payment, error) {
+	status := f.status
+	if status == "" {
+		status = payment.StatusPaid
+	}
+	p := &payment.Payment{ID: uint(len(f.payments) + 1), UserID: userID, PackPurchaseID: &purchaseID,
+		Amount: amount, Kind: payment.KindClassPack, Status: status}
+	if status == payment.StatusPaid {
+		now := time.Now()
+		p.PaidAt = &now
+	}
+	f.payments[p.ID] = p
+	return p, nil
+}
+
+func (f *fakePayments) GetPayment(id uint) (*payment.Payment, error) {
+	return f.payments[id], nil
+}
+
+func setup(t *testing.T) (Service, *fakePayments, booking.Service, *Pack) {
+	dsn := filepath.Join(t.TempDir(), "classpack.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Pack{}, &Purchase{}, &booking.CreditLot{}, &booking.CreditEntry{}))
+
+	payments := &fakePayments{payments: map[uint]*payment.Payment{}}
+	wallet := booking.NewService(booking.NewRepository(db), nil)
+	service := NewService(NewRepository(db), payments, wallet)
+	pack, err := service.CreatePack(CreatePackRequest{Name: "10 classes", Credits: 10,
+		Price: money.New(12000, "USD"), ValidityDays: 90})
+	require.NoError(t, err)
+	return service, payments, wallet, pack
+}
+
+func balance(t *testing.T, wallet booking.Service, userID uint) int {
+	resp, err := wallet.GetCredits(userID)
+	require.NoError(t, err)
+	return resp.Balance
+}
+
+func TestPurchase_CreditsWallet(t *testing.T) {
+	service, payments, wallet, pack := setup(t)
+
+	purchase, err := service.Purchase(2, pack.ID, PurchaseRequest{PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	assert.Equal(t, PurchasePaid, purchase.Status)
+	require.NotNil(t, purchase.ExpiresAt)
+	assert.WithinDuration(t, time.Now().AddDate(0, 0, 90), *purchase.ExpiresAt, time.Minute)
+	assert.Equal(t, int64(12000), payments.payments[*purchase.PaymentID].Amount.Amount)
+	assert.Equal(t, 10, balance(t, wallet, 2))
+
+	credits, err := wallet.GetCredits(2)
+	require.NoError(t, err)
+	require.Len(t, credits.Lots, 1)
+	assert.Equal(t, purchase.ID, *credits.Lots[0].PurchaseID)
+}
+
+func TestPurchase_Declined(t *testing.T) {
+	service, payments, wallet, pack := setup(t)
+	payments.status = payment.StatusFailed
+
+	purchase, err := service.Purchase(2, pack.ID, PurchaseRequest{PaymentToken: "tok_declined"})
+	assert.ErrorIs(t, err, ErrPaymentDeclined)
+	assert.Equal(t, PurchaseFailed, purchase.Status)
+	assert.Zero(t, balance(t, wallet, 2))
+}
+
+func TestPurchase_PendingSettledOnce(t *testing.T) {
+	service, payments, wallet, pack := setup(t)
+	payments.status = payment.StatusPending
+
+	purchase, err := service.Purchase(2, pack.ID, PurchaseRequest{PaymentToken: "tok_3ds"})
+	require.NoError(t, err)
+	assert.Equal(t, PurchasePending, purchase.Status)
+	assert.Zero(t, balance(t, wallet, 2))
+
+	require.NoError(t, service.SettlePending())
+	assert.Zero(t, balance(t, wallet, 2))
+
+	p := payments.payments[*purchase.PaymentID]
+	now := time.Now()
+	p.Status, p.PaidAt = payment.StatusPaid, &now
+	require.NoError(t, service.SettlePending())
+	require.NoError(t, service.SettlePending())
+	assert.Equal(t, 10, balance(t, wallet, 2))
+
+	purchases, err := service.ListPurchases(2)
+	require.NoError(t, err)
+	require.Len(t, purchases, 1)
+	assert.Equal(t, PurchasePaid, purchases[0].Status)
+}
+
+func TestPurchase_InactivePack(t *testing.T) {
+	service, _, _, pack := setup(t)
+	inactive := false
+	_, err := service.UpdatePack(pack.ID, UpdatePackRequest{Active: &inactive})
+	require.NoError(t, err)
+
+	_, err = service.Purchase(2, pack.ID, PurchaseRequest{PaymentToken: "tok_visa"})
+	assert.ErrorIs(t, err, ErrPackUnavailable)
+	_, err = service.Purchase(2, 99, PurchaseRequest{PaymentToken: "tok_visa"})
+	assert.ErrorIs(t, err, ErrPackNotFound)
+}
@@ -0,0 +1,105 @@
+package invoice
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+type CreateCreditNoteRequest struct {
+	// Amount is optional; without it everything not credited yet is credited.
+	Amount *money.Money `json:"amount"`
+	Reason string       `json:"reason" binding:"required"`
+}
+
+type LineResponse struct {
+	Description string      `json:"description"`
+	Quantity    int         `json:"quantity"`
+	UnitPrice   money.Money `json:"unit_price"`
+	TaxRate     int         `json:"tax_rate"` // basis points
+	Net         money.Money `json:"net"`
+	Tax         money.Money `json:"tax"`
+	Total       money.Money `json:"total"`
+}
+
+type InvoiceResponse struct {
+	ID                uint      `json:"id"`
+	Type              string    `json:"type"`
+	Number            string    `json:"number"`
+	IssuedAt          time.Time `json:"issued_at"`
+	PaymentID         uint      `json:"payment_id"`
+	UserID            uint      `json:"user_id"`
+	CreditedInvoiceID *uint     `json:"credited_invoice_id,omitempty"`
+	RefundID          *uint     `json:"refund_id,omitempty"`
+	Reason            string    `json:"reason,omitempty"`
+
+	Club     ClubResponse     `json:"club"`
+	Customer CustomerResponse `json:"customer"`
+
+	Lines []LineResponse `json:"lines"`
+	Net   money.Money    `json:"net"`
+	Tax   money.Money    `json:"tax"`
+	Total money.Money    `json:"total"`
+}
+
+type ClubResponse struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxID   string `json:"tax_id,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+type CustomerResponse struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+func ToInvoiceResponse(inv *Invoice) *InvoiceResponse {
+	lines := make([]LineResponse, 0, len(inv.Lines))
+	for _, l := range inv.Lines {
+		lines = append(lines, LineResponse{
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitPrice:   l.UnitPrice,
+			TaxRate:     l.TaxRate,
+			Net:         l.Net,
+			Tax:         l.Tax,
+			Total:       l.Total,
+		})
+	}
+	return &InvoiceResponse{
+		ID:                inv.ID,
+		Type:              inv.Type,
+		Number:            inv.Number,
+		IssuedAt:          inv.IssuedAt,
+		PaymentID:         inv.PaymentID,
+		UserID:            inv.UserID,
+		CreditedInvoiceID: inv.CreditedInvoiceID,
+		RefundID:          inv.RefundID,
+		Reason:            inv.Reason,
+
+		Club: ClubResponse{
+			Name:    inv.ClubName,
+			Address: inv.ClubAddress,
+			TaxID:   inv.ClubTaxID,
+			Email:   inv.ClubEmail,
+		},
+		Customer: CustomerResponse{
+			Name:  inv.CustomerName,
+			Email: inv.CustomerEmail,
+		},
+
+		Lines: lines,
+		Net:   inv.Net,
+		Tax:   inv.Tax,
+		Total: inv.Total,
+	}
+}
+
+func ToInvoiceResponses(invoices []Invoice) []*InvoiceResponse {
+	out := make([]*InvoiceResponse, 0, len(invoices))
+	for i := range invoices {
+		out = append(out, ToInvoiceResponse(&invoices[i]))
+	}
+	return out
+}
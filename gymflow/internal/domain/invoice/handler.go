@@ -0,0 +1,136 @@
+package invoice
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"gymflow/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GET /api/v1/invoices
+func (h *Handler) ListInvoices(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	invoices, err := h.service.ListInvoices(userIDAny.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list invoices"})
+		return
+	}
+	c.JSON(http.StatusOK, ToInvoiceResponses(invoices))
+}
+
+// GET /api/v1/invoices/:id
+func (h *Handler) GetInvoice(c *gin.Context) {
+	inv, ok := h.find(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, ToInvoiceResponse(inv))
+}
+
+// GET /api/v1/invoices/:id/download?format=pdf|html
+func (h *Handler) Download(c *gin.Context) {
+	inv, ok := h.find(c)
+	if !ok {
+		return
+	}
+	switch format := c.DefaultQuery("format", "pdf"); format {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, inv.Number))
+		c.Data(http.StatusOK, "application/pdf", RenderPDF(inv))
+	case "html":
+		body, err := RenderHTML(inv)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render invoice"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.html"`, inv.Number))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", body)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be pdf or html"})
+	}
+}
+
+// GET /api/v1/admin/invoices/:id/credit-notes
+func (h *Handler) ListCreditNotes(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	notes, err := h.service.ListCreditNotes(uri.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list credit notes"})
+		return
+	}
+	c.JSON(http.StatusOK, ToInvoiceResponses(notes))
+}
+
+// POST /api/v1/admin/invoices/:id/credit-notes
+func (h *Handler) CreateCreditNote(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CreateCreditNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	note, err := h.service.CreateCreditNote(uri.ID, userIDAny.(uint), req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ToInvoiceResponse(note))
+}
+
+func (h *Handler) find(c *gin.Context) (*Invoice, bool) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	inv, err := h.service.GetInvoice(uri.ID, userIDAny.(uint), roleAny.(string))
+	if err != nil {
+		writeError(c, err)
+		return nil, false
+	}
+	return inv, true
+}
+
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrInvoiceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrForeignInvoice):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrNotAnInvoice), errors.Is(err, ErrCreditTooLarge):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
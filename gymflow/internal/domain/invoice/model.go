@@ -0,0 +1,88 @@
+package invoice
+
+import (
+	"errors"
+	"time"
+
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TypeInvoice    = "invoice"
+	TypeCreditNote = "credit_note"
+)
+
+var ErrImmutable = errors.New("issued invoices cannot be changed; issue a credit note instead")
+
+// Invoice is an issued invoice for a paid payment, or a credit note that
+// corrects one. Club and customer details are copied at issue time, so later
+// edits to either never change an issued document. Amounts of credit notes
+// are positive and reduce the credited invoice.
+type Invoice struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	Type      string `gorm:"size:16;not null;uniqueIndex:idx_invoice_sequence"`
+	// Number is ClubCode-FiscalYear-Sequence, with a CN- prefix on credit
+	// notes, e.g. GF-2026-000042 or GF-CN-2026-000003.
+	Number     string `gorm:"size:64;not null;uniqueIndex"`
+	ClubCode   string `gorm:"size:32;not null;uniqueIndex:idx_invoice_sequence"`
+	FiscalYear int    `gorm:"not null;uniqueIndex:idx_invoice_sequence"`
+	Sequence   int64  `gorm:"not null;uniqueIndex:idx_invoice_sequence"`
+	IssuedAt   time.Time
+
+	PaymentID uint `gorm:"index"`
+	UserID    uint `gorm:"index"`
+	// CreditedInvoiceID is the invoice a credit note corrects.
+	CreditedInvoiceID *uint `gorm:"index"`
+	// RefundID is the refund a credit note was issued for, if any.
+	RefundID *uint `gorm:"uniqueIndex"`
+	Reason   string
+	IssuedBy *uint
+
+	ClubName    string
+	ClubAddress string
+	ClubTaxID   string
+	ClubEmail   string
+
+	CustomerName  string
+	CustomerEmail string
+
+	Net   money.Money `gorm:"embedded;embeddedPrefix:net_"`
+	Tax   money.Money `gorm:"embedded;embeddedPrefix:tax_"`
+	Total money.Money `gorm:"embedded;embeddedPrefix:total_"`
+
+	Lines []Line `gorm:"foreignKey:InvoiceID"`
+}
+
+// Line is one item of an invoice. UnitPrice and Total include tax.
+type Line struct {
+	ID          uint `gorm:"primaryKey"`
+	InvoiceID   uint `gorm:"index"`
+	Description string
+	Quantity    int
+	UnitPrice   money.Money `gorm:"embedded;embeddedPrefix:unit_price_"`
+	// TaxRate is in basis points: 2000 is 20%.
+	TaxRate int
+	Net     money.Money `gorm:"embedded;embeddedPrefix:net_"`
+	Tax     money.Money `gorm:"embedded;embeddedPrefix:tax_"`
+	Total   money.Money `gorm:"embedded;embeddedPrefix:total_"`
+}
+
+func (Line) TableName() string { return "invoice_lines" }
+
+// Sequence is the last number issued per club, document type and fiscal year.
+type Sequence struct {
+	ClubCode   string `gorm:"primaryKey;size:32"`
+	Type       string `gorm:"primaryKey;size:16"`
+	FiscalYear int    `gorm:"primaryKey"`
+	Last       int64  `gorm:"not null;default:0"`
+}
+
+func (Sequence) TableName() string { return "invoice_sequences" }
+
+func (*Invoice) BeforeUpdate(*gorm.DB) error { return ErrImmutable }
+func (*Invoice) BeforeDelete(*gorm.DB) error { return ErrImmutable }
+func (*Line) BeforeUpdate(*gorm.DB) error    { return ErrImmutable }
+func (*Line) BeforeDelete(*gorm.DB) error    { return ErrImmutable }
@@ -0,0 +1,127 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 in points, and the margins of the text area.
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+)
+
+type pdfText struct {
+	x, y float64
+	size float64
+	bold bool
+	s    string
+}
+
+// pdfDoc lays out lines of text on A4 pages. It only uses the standard
+// Helvetica fonts, which every PDF reader provides, so nothing is embedded.
+type pdfDoc struct {
+	pages [][]pdfText
+	y     float64
+}
+
+func newPDFDoc() *pdfDoc {
+	d := &pdfDoc{}
+	d.addPage()
+	return d
+}
+
+func (d *pdfDoc) addPage() {
+	d.pages = append(d.pages, nil)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// text writes s at x on the current line.
+func (d *pdfDoc) text(x, size float64, bold bool, s string) {
+	if s == "" {
+		return
+	}
+	last := len(d.pages) - 1
+	d.pages[last] = append(d.pages[last], pdfText{x: x, y: d.y, size: size, bold: bold, s: s})
+}
+
+// newline moves down by h points, starting a new page at the bottom margin.
+func (d *pdfDoc) newline(h float64) {
+	d.y -= h
+	if d.y < pdfMargin {
+		d.addPage()
+	}
+}
+
+// bytes serializes the document: catalog, page tree, two fonts, then a page
+// and a content stream per page, followed by the cross-reference table.
+func (d *pdfDoc) bytes() []byte {
+	var objects []string
+	add := func(body string) int {
+		objects = append(objects, body)
+		return len(objects)
+	}
+
+	add("<< /Type /Catalog /Pages 2 0 R >>")
+	add("") // page tree, filled in once the page ids are known
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	add("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	var kids []string
+	for _, page := range d.pages {
+		var content bytes.Buffer
+		for _, t := range page {
+			font := "F1"
+			if t.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "BT /%s %.1f Tf %.1f %.1f Td (%s) Tj ET\n", font, t.size, t.x, t.y, pdfString(t.s))
+		}
+		stream := add(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+		id := add(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, stream,
+		))
+		kids = append(kids, fmt.Sprintf("%d 0 R", id))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
+
+// pdfString escapes s for a PDF literal string in WinAnsiEncoding. Latin-1
+// characters and the euro sign are kept; anything else becomes "?".
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
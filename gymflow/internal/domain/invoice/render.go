@@ -0,0 +1,148 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// Title is the document heading: "Invoice" or "Credit note".
+func (inv *Invoice) Title() string {
+	if inv.Type == TypeCreditNote {
+		return "Credit note"
+	}
+	return "Invoice"
+}
+
+// formatRate prints a basis-point tax rate as a percentage, e.g. "7.5%".
+func formatRate(bp int) string {
+	s := fmt.Sprintf("%d.%02d", bp/100, bp%100)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s + "%"
+}
+
+var htmlTemplate = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"rate": formatRate,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; margin: 40px; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-top: 24px; }
+th, td { padding: 6px 8px; border-bottom: 1px solid #ddd; text-align: left; }
+td.num, th.num { text-align: right; }
+.parties { display: flex; gap: 80px; margin-top: 24px; }
+.totals td { border: none; }
+</style>
+</head>
+<body>
+<h1>{{.Title}} {{.Number}}</h1>
+<p>Issued {{.IssuedAt.Format "2 January 2006"}}{{if .Reason}}<br>Reason: {{.Reason}}{{end}}</p>
+<div class="parties">
+<div>
+<strong>{{.ClubName}}</strong><br>
+{{if .ClubAddress}}{{.ClubAddress}}<br>{{end}}
+{{if .ClubTaxID}}Tax ID: {{.ClubTaxID}}<br>{{end}}
+{{if .ClubEmail}}{{.ClubEmail}}{{end}}
+</div>
+<div>
+<strong>Bill to</strong><br>
+{{.CustomerName}}<br>
+{{.CustomerEmail}}
+</div>
+</div>
+<table>
+<tr><th>Description</th><th class="num">Qty</th><th class="num">Unit price</th><th class="num">Tax rate</th><th class="num">Net</th><th class="num">Tax</th><th class="num">Total</th></tr>
+{{range .Lines}}<tr><td>{{.Description}}</td><td class="num">{{.Quantity}}</td><td class="num">{{.UnitPrice.Major}}</td><td class="num">{{rate .TaxRate}}</td><td class="num">{{.Net.Major}}</td><td class="num">{{.Tax.Major}}</td><td class="num">{{.Total.Major}}</td></tr>
+{{end}}</table>
+<table class="totals">
+<tr><td class="num">Net</td><td class="num">{{.Net}}</td></tr>
+<tr><td class="num">Tax</td><td class="num">{{.Tax}}</td></tr>
+<tr><td class="num"><strong>Total</strong></td><td class="num"><strong>{{.Total}}</strong></td></tr>
+</table>
+</body>
+</html>
+`))
+
+// RenderHTML renders the invoice as a standalone HTML page.
+func RenderHTML(inv *Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, inv); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderPDF renders the invoice as a PDF.
+func RenderPDF(inv *Invoice) []byte {
+	d := newPDFDoc()
+	d.text(pdfMargin, 20, true, inv.Title()+" "+inv.Number)
+	d.newline(24)
+	d.text(pdfMargin, 10, false, "Issued "+inv.IssuedAt.Format("2 January 2006"))
+	if inv.Reason != "" {
+		d.newline(14)
+		d.text(pdfMargin, 10, false, "Reason: "+inv.Reason)
+	}
+	d.newline(30)
+
+	seller := []string{inv.ClubAddress, taxIDLine(inv.ClubTaxID), inv.ClubEmail}
+	buyer := []string{inv.CustomerName, inv.CustomerEmail}
+	d.text(pdfMargin, 11, true, inv.ClubName)
+	d.text(320, 11, true, "Bill to")
+	for i := 0; i < len(seller) || i < len(buyer); i++ {
+		d.newline(14)
+		if i < len(seller) {
+			d.text(pdfMargin, 10, false, seller[i])
+		}
+		if i < len(buyer) {
+			d.text(320, 10, false, buyer[i])
+		}
+	}
+	d.newline(30)
+
+	columns := []float64{pdfMargin, 300, 330, 385, 440, 495}
+	for i, h := range []string{"Description", "Qty", "Tax rate", "Net", "Tax", "Total"} {
+		d.text(columns[i], 10, true, h)
+	}
+	for _, l := range inv.Lines {
+		d.newline(16)
+		cells := []string{truncate(l.Description, 44), fmt.Sprint(l.Quantity), formatRate(l.TaxRate), l.Net.Major(), l.Tax.Major(), l.Total.Major()}
+		for i, c := range cells {
+			d.text(columns[i], 10, false, c)
+		}
+	}
+	d.newline(30)
+
+	for _, row := range []struct {
+		label string
+		value string
+		bold  bool
+	}{
+		{"Net", inv.Net.String(), false},
+		{"Tax", inv.Tax.String(), false},
+		{"Total", inv.Total.String(), true},
+	} {
+		d.text(385, 10, row.bold, row.label)
+		d.text(440, 10, row.bold, row.value)
+		d.newline(14)
+	}
+	return d.bytes()
+}
+
+func taxIDLine(id string) string {
+	if id == "" {
+		return ""
+	}
+	return "Tax ID: " + id
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
@@ -0,0 +1,170 @@
+package invoice
+
+import (
+	"gymflow/internal/domain/payment"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Customer is the billing name and email of a member, read from users.
+type Customer struct {
+	Name  string
+	Email string
+}
+
+type Repository interface {
+	Transaction(fn func(repo Repository) error) error
+	// LockPayment serializes issuing documents for one payment.
+	LockPayment(id uint) (*payment.Payment, error)
+	FindRefundByID(id uint) (*payment.Refund, error)
+	// NextSequence reserves the next number of a club's document type in a
+	// fiscal year. The sequence row stays locked until the transaction ends,
+	// so numbers are gapless and issued in order.
+	NextSequence(clubCode, docType string, fiscalYear int) (int64, error)
+	Create(inv *Invoice) error
+	FindByID(id uint) (*Invoice, error)
+	FindByPayment(paymentID uint) (*Invoice, error)
+	FindByRefund(refundID uint) (*Invoice, error)
+	ListByUser(userID uint) ([]Invoice, error)
+	ListCreditNotes(invoiceID uint) ([]Invoice, error)
+	// SumCredited is the total of the credit notes of an invoice, in minor units.
+	SumCredited(invoiceID uint) (int64, error)
+	ListUninvoicedPayments(limit int) ([]payment.Payment, error)
+	ListUncreditedRefunds(limit int) ([]payment.Refund, error)
+	FindCustomer(userID uint) (Customer, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
+
+// LockPayment uses a no-op UPDATE, like payment's LockPayment.
+func (r *repository) LockPayment(id uint) (*payment.Payment, error) {
+	res := r.db.Model(&payment.Payment{}).Where("id = ?", id).UpdateColumn("amount_minor", gorm.Expr("amount_minor"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var p payment.Payment
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) FindRefundByID(id uint) (*payment.Refund, error) {
+	var ref payment.Refund
+	if err := r.db.First(&ref, id).Error; err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (r *repository) NextSequence(clubCode, docType string, fiscalYear int) (int64, error) {
+	seq := Sequence{ClubCode: clubCode, Type: docType, FiscalYear: fiscalYear}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+		return 0, err
+	}
+	where := r.db.Model(&Sequence{}).Where("club_code = ? AND type = ? AND fiscal_year = ?", clubCode, docType, fiscalYear)
+	if err := where.UpdateColumn("last", gorm.Expr("last + 1")).Error; err != nil {
+		return 0, err
+	}
+	if err := r.db.Where("club_code = ? AND type = ? AND fiscal_year = ?", clubCode, docType, fiscalYear).First(&seq).Error; err != nil {
+		return 0, err
+	}
+	return seq.Last, nil
+}
+
+func (r *repository) Create(inv *Invoice) error {
+	return r.db.Create(inv).Error
+}
+
+func (r *repository) FindByID(id uint) (*Invoice, error) {
+	var inv Invoice
+	if err := r.db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&inv, id).Error; err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *repository) FindByPayment(paymentID uint) (*Invoice, error) {
+	var inv Invoice
+	err := r.db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("payment_id = ? AND type = ?", paymentID, TypeInvoice).First(&inv).Error
+	if err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *repository) FindByRefund(refundID uint) (*Invoice, error) {
+	var inv Invoice
+	if err := r.db.Where("refund_id = ?", refundID).First(&inv).Error; err != nil {
+		return nil, err
+	}
+	return &inv, nil
+}
+
+func (r *repository) ListByUser(userID uint) ([]Invoice, error) {
+	var invoices []Invoice
+	err := r.db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Where("user_id = ?", userID).Order("issued_at DESC, id DESC").Find(&invoices).Error
+	return invoices, err
+}
+
+func (r *repository) ListCreditNotes(invoiceID uint) ([]Invoice, error) {
+	var notes []Invoice
+	err := r.db.Where("credited_invoice_id = ?", invoiceID).Order("id").Find(&notes).Error
+	return notes, err
+}
+
+func (r *repository) SumCredited(invoiceID uint) (int64, error) {
+	var sum int64
+	err := r.db.Model(&Invoice{}).Where("credited_invoice_id = ?", invoiceID).
+		Select("COALESCE(SUM(total_minor), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+// ListUninvoicedPayments finds collected payments that have no invoice yet,
+// oldest first.
+func (r *repository) ListUninvoicedPayments(limit int) ([]payment.Payment, error) {
+	var payments []payment.Payment
+	err := r.db.Where("status IN ?", []string{payment.StatusPaid, payment.StatusPartiallyRefunded, payment.StatusRefunded}).
+		Where("NOT EXISTS (SELECT 1 FROM invoices WHERE invoices.payment_id = payments.id AND invoices.type = ?)", TypeInvoice).
+		Order("id").Limit(limit).Find(&payments).Error
+	return payments, err
+}
+
+// ListUncreditedRefunds finds succeeded refunds without a credit note, oldest
+// first. Refunds of an invoice already credited in full get none.
+func (r *repository) ListUncreditedRefunds(limit int) ([]payment.Refund, error) {
+	var refunds []payment.Refund
+	err := r.db.Where("status = ?", payment.RefundSucceeded).
+		Where("NOT EXISTS (SELECT 1 FROM invoices WHERE invoices.refund_id = refunds.id)").
+		Where(`NOT EXISTS (SELECT 1 FROM invoices inv WHERE inv.payment_id = refunds.payment_id AND inv.type = ?
+			AND inv.total_minor <= (SELECT COALESCE(SUM(cn.total_minor), 0) FROM invoices cn WHERE cn.credited_invoice_id = inv.id))`,
+			TypeInvoice).
+		Order("id").Limit(limit).Find(&refunds).Error
+	return refunds, err
+}
+
+// FindCustomer reads the users table directly, like booking's FindUserRole,
+// so invoice does not depend on the user package.
+func (r *repository) FindCustomer(userID uint) (Customer, error) {
+	var c Customer
+	err := r.db.Table("users").Select("name, email").Where("id = ?", userID).Limit(1).Scan(&c).Error
+	return c, err
+}
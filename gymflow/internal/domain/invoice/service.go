@@ -0,0 +1,379 @@
+package invoice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
+
+	"gorm.io/gorm"
+)
+
+// adminRole mirrors user.RoleAdmin; admins may read every invoice.
+const adminRole = "admin"
+
+// backfillBatch bounds one IssueMissing run.
+const backfillBatch = 100
+
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrForeignInvoice  = errors.New("invoice belongs to another member")
+	ErrNotInvoiceable  = errors.New("only collected payments are invoiced")
+	ErrNotAnInvoice    = errors.New("credit notes can only correct invoices")
+	ErrCreditTooLarge  = errors.New("credit exceeds what is left on the invoice")
+)
+
+type Service interface {
+	// IssueForPayment issues the invoice of a collected payment. It is
+	// idempotent: a payment gets exactly one invoice.
+	IssueForPayment(p *payment.Payment) error
+	// IssueForRefund issues the credit note of a succeeded refund, issuing
+	// the invoice first if the payment has none yet. It is idempotent.
+	IssueForRefund(ref *payment.Refund) error
+	// IssueMissing catches up on payments and refunds whose documents were
+	// not issued when they settled.
+	IssueMissing() error
+
+	CreateCreditNote(invoiceID, staffID uint, req CreateCreditNoteRequest) (*Invoice, error)
+	GetInvoice(id, userID uint, role string) (*Invoice, error)
+	ListInvoices(userID uint) ([]Invoice, error)
+	ListCreditNotes(invoiceID uint) ([]Invoice, error)
+}
+
+// Club is the seller shown on invoices. Code prefixes invoice numbers and
+// keeps a separate sequence per club.
+type Club struct {
+	Code    string
+	Name    string
+	Address string
+	TaxID   string
+	Email   string
+}
+
+type Settings struct {
+	Club Club
+	// FiscalYearStart is the first month of the fiscal year. A fiscal year is
+	// named after the calendar year it starts in.
+	FiscalYearStart time.Month
+	// TaxRate (basis points, included in prices) splits payments that carry
+	// no tax breakdown of their own, i.e. those taken before it was recorded.
+	TaxRate int
+}
+
+type service struct {
+	repo     Repository
+	bookings payment.Bookings
+	settings Settings
+}
+
+func NewService(repo Repository, bookings payment.Bookings, settings Settings) Service {
+	if settings.FiscalYearStart < time.January || settings.FiscalYearStart > time.December {
+		settings.FiscalYearStart = time.January
+	}
+	return &service{repo: repo, bookings: bookings, settings: settings}
+}
+
+func (s *service) IssueForPayment(p *payment.Payment) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		_, err := s.invoiceIn(repo, p.ID)
+		return err
+	})
+}
+
+func (s *service) IssueForRefund(ref *payment.Refund) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		inv, err := s.invoiceIn(repo, ref.PaymentID)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.FindByRefund(ref.ID); err == nil {
+			return nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		current, err := repo.FindRefundByID(ref.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != payment.RefundSucceeded {
+			return fmt.Errorf("refund %d is %s", current.ID, current.Status)
+		}
+		// An admin may have credited the invoice by hand before the refund
+		// went through; the refund's note covers only what is left.
+		credited, err := repo.SumCredited(inv.ID)
+		if err != nil {
+			return err
+		}
+		left := inv.Total.Amount - credited
+		if left <= 0 {
+			return nil
+		}
+		amount := current.Amount
+		if amount.Amount > left {
+			amount = money.New(left, amount.Currency)
+		}
+		_, err = s.creditIn(repo, inv, amount, "Refund: "+current.Reason, &current.ID, nil)
+		return err
+	})
+}
+
+func (s *service) IssueMissing() error {
+	var errs []error
+	payments, err := s.repo.ListUninvoicedPayments(backfillBatch)
+	if err != nil {
+		return err
+	}
+	for i := range payments {
+		if err := s.IssueForPayment(&payments[i]); err != nil {
+			errs = append(errs, fmt.Errorf("payment %d: %w", payments[i].ID, err))
+		}
+	}
+	refunds, err := s.repo.ListUncreditedRefunds(backfillBatch)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range refunds {
+		if err := s.IssueForRefund(&refunds[i]); err != nil {
+			errs = append(errs, fmt.Errorf("refund %d: %w", refunds[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CreateCreditNote corrects an issued invoice. Without an amount it credits
+// whatever has not been credited yet. It moves no money; refunds get their
+// credit notes automatically.
+func (s *service) CreateCreditNote(invoiceID, staffID uint, req CreateCreditNoteRequest) (*Invoice, error) {
+	var note *Invoice
+	err := s.repo.Transaction(func(repo Repository) error {
+		inv, err := repo.FindByID(invoiceID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvoiceNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if inv.Type != TypeInvoice {
+			return ErrNotAnInvoice
+		}
+		if _, err := repo.LockPayment(inv.PaymentID); err != nil {
+			return err
+		}
+		credited, err := repo.SumCredited(inv.ID)
+		if err != nil {
+			return err
+		}
+		amount := money.New(inv.Total.Amount-credited, inv.Total.Currency)
+		if req.Amount != nil {
+			if !req.Amount.IsPositive() {
+				return errors.New("credit amount must be positive")
+			}
+			amount = *req.Amount
+		}
+		note, err = s.creditIn(repo, inv, amount, req.Reason, nil, &staffID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+func (s *service) GetInvoice(id, userID uint, role string) (*Invoice, error) {
+	inv, err := s.repo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if role != adminRole && inv.UserID != userID {
+		return nil, ErrForeignInvoice
+	}
+	return inv, nil
+}
+
+func (s *service) ListInvoices(userID uint) ([]Invoice, error) {
+	return s.repo.ListByUser(userID)
+}
+
+func (s *service) ListCreditNotes(invoiceID uint) ([]Invoice, error) {
+	return s.repo.ListCreditNotes(invoiceID)
+}
+
+// invoiceIn returns the invoice of a payment, issuing it under the payment
+// lock if it has none yet.
+func (s *service) invoiceIn(repo Repository, paymentID uint) (*Invoice, error) {
+	p, err := repo.LockPayment(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if inv, err := repo.FindByPayment(p.ID); err == nil {
+		return inv, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	switch p.Status {
+	case payment.StatusPaid, payment.StatusPartiallyRefunded, payment.StatusRefunded:
+	default:
+		return nil, fmt.Errorf("%w: payment %d is %s", ErrNotInvoiceable, p.ID, p.Status)
+	}
+
+	inv := &Invoice{
+		Type:      TypeInvoice,
+		PaymentID: p.ID,
+		UserID:    p.UserID,
+		Lines:     []Line{s.paymentLine(p)},
+	}
+	if err := s.issue(repo, inv); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// creditIn issues a credit note of amount against inv. Credit notes never add
+// up to more than the invoice.
+func (s *service) creditIn(repo Repository, inv *Invoice, amount money.Money, reason string, refundID, issuedBy *uint) (*Invoice, error) {
+	credited, err := repo.SumCredited(inv.ID)
+	if err != nil {
+		return nil, err
+	}
+	left := money.New(inv.Total.Amount-credited, inv.Total.Currency)
+	c, err := amount.Cmp(left)
+	if err != nil {
+		return nil, err
+	}
+	if c > 0 || !amount.IsPositive() {
+		return nil, fmt.Errorf("%w: %s left", ErrCreditTooLarge, left)
+	}
+
+	rate := s.settings.TaxRate
+	if len(inv.Lines) > 0 {
+		rate = inv.Lines[0].TaxRate
+	}
+	note := &Invoice{
+		Type:              TypeCreditNote,
+		PaymentID:         inv.PaymentID,
+		UserID:            inv.UserID,
+		CreditedInvoiceID: &inv.ID,
+		RefundID:          refundID,
+		Reason:            reason,
+		IssuedBy:          issuedBy,
+		Lines:             []Line{s.line("Credit for invoice "+inv.Number, amount, rate)},
+	}
+	if err := s.issue(repo, note); err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// issue numbers inv, snapshots the club and customer and stores it.
+func (s *service) issue(repo Repository, inv *Invoice) error {
+	now := time.Now().UTC()
+	club := s.settings.Club
+	inv.IssuedAt = now
+	inv.ClubCode = club.Code
+	inv.FiscalYear = s.fiscalYear(now)
+	seq, err := repo.NextSequence(club.Code, inv.Type, inv.FiscalYear)
+	if err != nil {
+		return err
+	}
+	inv.Sequence = seq
+	if inv.Type == TypeCreditNote {
+		inv.Number = fmt.Sprintf("%s-CN-%d-%06d", club.Code, inv.FiscalYear, seq)
+	} else {
+		inv.Number = fmt.Sprintf("%s-%d-%06d", club.Code, inv.FiscalYear, seq)
+	}
+
+	inv.ClubName = club.Name
+	inv.ClubAddress = club.Address
+	inv.ClubTaxID = club.TaxID
+	inv.ClubEmail = club.Email
+	customer, err := repo.FindCustomer(inv.UserID)
+	if err != nil {
+		return err
+	}
+	inv.CustomerName = customer.Name
+	inv.CustomerEmail = customer.Email
+
+	for _, l := range inv.Lines {
+		if inv.Net, err = inv.Net.Add(l.Net); err != nil {
+			return err
+		}
+		if inv.Tax, err = inv.Tax.Add(l.Tax); err != nil {
+			return err
+		}
+		if inv.Total, err = inv.Total.Add(l.Total); err != nil {
+			return err
+		}
+	}
+	return repo.Create(inv)
+}
+
+func (s *service) fiscalYear(t time.Time) int {
+	if t.Month() < s.settings.FiscalYearStart {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// line is a single tax-inclusive item.
+func (s *service) line(description string, gross money.Money, rate int) Line {
+	return lineOf(description, tax.Inclusive(gross, rate))
+}
+
+// paymentLine invoices p with the tax split recorded on it. Payments from
+// before tax breakdowns were recorded are split at the settings' TaxRate.
+func (s *service) paymentLine(p *payment.Payment) Line {
+	if p.Net.Currency == "" {
+		return s.line(s.describe(p), p.Amount, s.settings.TaxRate)
+	}
+	return lineOf(s.describe(p), tax.Breakdown{Rate: p.TaxRate, Net: p.Net, Tax: p.Tax, Gross: p.Amount})
+}
+
+func lineOf(description string, b tax.Breakdown) Line {
+	return Line{
+		Description: description,
+		Quantity:    1,
+		UnitPrice:   b.Gross,
+		TaxRate:     b.Rate,
+		Net:         b.Net,
+		Tax:         b.Tax,
+		Total:       b.Gross,
+	}
+}
+
+// describe names what a payment was for.
+func (s *service) describe(p *payment.Payment) string {
+	switch p.Kind {
+	case payment.KindLateCancelFee:
+		return fmt.Sprintf("Late cancellation fee, booking #%d", p.BookingID)
+	case payment.KindNoShowFee:
+		return fmt.Sprintf("No-show fee, booking #%d", p.BookingID)
+	case payment.KindMembership:
+		if p.SubscriptionID != nil {
+			return fmt.Sprintf("Membership subscription #%d", *p.SubscriptionID)
+		}
+		return "Membership subscription"
+	case payment.KindClassPack:
+		if p.PackPurchaseID != nil {
+			return fmt.Sprintf("Class pack purchase #%d", *p.PackPurchaseID)
+		}
+		return "Class pack"
+	}
+	desc := fmt.Sprintf("Class booking #%d", p.BookingID)
+	if s.bookings != nil {
+		if b, err := s.bookings.FindBookingByID(p.BookingID); err == nil {
+			if class, err := s.bookings.FindClassByID(b.ClassID); err == nil {
+				desc = fmt.Sprintf("Class: %s, %s", class.Name, class.StartTime.UTC().Format("2 Jan 2006 15:04 UTC"))
+			}
+		}
+	}
+	if p.PromoCode != "" {
+		desc += fmt.Sprintf(" (promo code %s, %s off)", p.PromoCode, p.Discount)
+	}
+	return desc
+}
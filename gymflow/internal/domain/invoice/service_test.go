@@ -0,0 +1,245 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testSettings = Settings{
+	Club:    Club{Code: "GF", Name: "GymFlow Club", Address: "1 Main St", TaxID: "DE123", Email: "billing@gymflow.test"},
+	TaxRate: 2000,
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "invoice.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&booking.GymClass{}, &booking.Booking{},
+		&payment.Payment{}, &payment.Refund{},
+		&Invoice{}, &Line{}, &Sequence{},
+	))
+	// Minimal stand-in for the user package's table.
+	require.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT, email TEXT)").Error)
+	require.NoError(t, db.Exec("INSERT INTO users (id, name, email) VALUES (2, 'Ann Member', 'ann@example.com')").Error)
+	return db
+}
+
+// paidPayment stores a collected 50.00 USD class payment of member 2.
+func paidPayment(t *testing.T, db *gorm.DB) *payment.Payment {
+	class := &booking.GymClass{Name: "Yoga", Capacity: 10, Price: money.New(5000, "USD"),
+		StartTime: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC), EndTime: time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)}
+	require.NoError(t, db.Create(class).Error)
+	b := &booking.Booking{UserID: 2, ClassID: class.ID, Status: booking.BookingStatusBooked}
+	require.NoError(t, db.Create(b).Error)
+	now := time.Now()
+	p := &payment.Payment{UserID: 2, BookingID: b.ID, Amount: money.New(5000, "USD"),
+		Kind: payment.KindBooking, Status: payment.StatusPaid, PaidAt: &now}
+	require.NoError(t, db.Create(p).Error)
+	return p
+}
+
+func TestIssueForPayment(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), booking.NewRepository(db), testSettings)
+
+	first := paidPayment(t, db)
+	second := paidPayment(t, db)
+	require.NoError(t, service.IssueForPayment(first))
+	require.NoError(t, service.IssueForPayment(second))
+	// Issuing again is a no-op.
+	require.NoError(t, service.IssueForPayment(first))
+
+	invoices, err := service.ListInvoices(2)
+	require.NoError(t, err)
+	require.Len(t, invoices, 2)
+
+	year := time.Now().UTC().Year()
+	inv := invoices[1]
+	assert.Equal(t, first.ID, inv.PaymentID)
+	assert.Equal(t, fmt.Sprintf("GF-%d-000001", year), inv.Number)
+	assert.Equal(t, fmt.Sprintf("GF-%d-000002", year), invoices[0].Number)
+	assert.Equal(t, "GymFlow Club", inv.ClubName)
+	assert.Equal(t, "DE123", inv.ClubTaxID)
+	assert.Equal(t, "Ann Member", inv.CustomerName)
+	assert.Equal(t, "ann@example.com", inv.CustomerEmail)
+
+	// 50.00 including 20% tax.
+	require.Len(t, inv.Lines, 1)
+	assert.Equal(t, "Class: Yoga, 2 Mar 2026 09:00 UTC", inv.Lines[0].Description)
+	assert.Equal(t, money.New(4167, "USD"), inv.Net)
+	assert.Equal(t, money.New(833, "USD"), inv.Tax)
+	assert.Equal(t, money.New(5000, "USD"), inv.Total)
+
+	pending := &payment.Payment{UserID: 2, Amount: money.New(100, "USD"), Status: payment.StatusPending}
+	require.NoError(t, db.Create(pending).Error)
+	assert.ErrorIs(t, service.IssueForPayment(pending), ErrNotInvoiceable)
+}
+
+func TestIssueForPayment_RecordedTax(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil, testSettings)
+	p := paidPayment(t, db)
+	// A membership at 5%, priced tax-exclusive: 47.62 + 2.38.
+	p.Kind = payment.KindMembership
+	p.Net, p.Tax, p.TaxRate = money.New(4762, "USD"), money.New(238, "USD"), 500
+	require.NoError(t, db.Save(p).Error)
+	require.NoError(t, service.IssueForPayment(p))
+
+	invoices, err := service.ListInvoices(2)
+	require.NoError(t, err)
+	require.Len(t, invoices, 1)
+	inv := invoices[0]
+	assert.Equal(t, money.New(4762, "USD"), inv.Net)
+	assert.Equal(t, money.New(238, "USD"), inv.Tax)
+	assert.Equal(t, money.New(5000, "USD"), inv.Total)
+	assert.Equal(t, 500, inv.Lines[0].TaxRate)
+}
+
+func TestInvoicesAreImmutable(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil, testSettings)
+	p := paidPayment(t, db)
+	require.NoError(t, service.IssueForPayment(p))
+
+	var inv Invoice
+	require.NoError(t, db.Preload("Lines").First(&inv).Error)
+	inv.CustomerName = "Someone Else"
+	assert.ErrorIs(t, db.Save(&inv).Error, ErrImmutable)
+	assert.ErrorIs(t, db.Delete(&inv).Error, ErrImmutable)
+	assert.ErrorIs(t, db.Delete(&inv.Lines[0]).Error, ErrImmutable)
+}
+
+func TestCreditNotes(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil, testSettings)
+	p := paidPayment(t, db)
+
+	// A succeeded refund gets a credit note, issuing the invoice on the way.
+	ref := &payment.Refund{PaymentID: p.ID, UserID: 2, Amount: money.New(2000, "USD"),
+		Reason: payment.RefundReasonGoodwill, Status: payment.RefundSucceeded}
+	require.NoError(t, db.Create(ref).Error)
+	require.NoError(t, service.IssueForRefund(ref))
+	require.NoError(t, service.IssueForRefund(ref))
+
+	invoices, err := service.ListInvoices(2)
+	require.NoError(t, err)
+	require.Len(t, invoices, 2)
+	var inv, note Invoice
+	for _, i := range invoices {
+		if i.Type == TypeInvoice {
+			inv = i
+		} else {
+			note = i
+		}
+	}
+	year := time.Now().UTC().Year()
+	assert.Equal(t, fmt.Sprintf("GF-CN-%d-000001", year), note.Number)
+	assert.Equal(t, inv.ID, *note.CreditedInvoiceID)
+	assert.Equal(t, ref.ID, *note.RefundID)
+	assert.Equal(t, money.New(2000, "USD"), note.Total)
+	assert.Equal(t, 2000, note.Lines[0].TaxRate)
+
+	// Manual credits never exceed what is left on the invoice.
+	tooMuch := money.New(3001, "USD")
+	_, err = service.CreateCreditNote(inv.ID, 1, CreateCreditNoteRequest{Amount: &tooMuch, Reason: "wrong price"})
+	assert.ErrorIs(t, err, ErrCreditTooLarge)
+
+	rest, err := service.CreateCreditNote(inv.ID, 1, CreateCreditNoteRequest{Reason: "wrong price"})
+	require.NoError(t, err)
+	assert.Equal(t, money.New(3000, "USD"), rest.Total)
+	assert.Equal(t, fmt.Sprintf("GF-CN-%d-000002", year), rest.Number)
+
+	_, err = service.CreateCreditNote(rest.ID, 1, CreateCreditNoteRequest{Reason: "again"})
+	assert.ErrorIs(t, err, ErrNotAnInvoice)
+}
+
+func TestIssueMissing(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil, testSettings)
+	p := paidPayment(t, db)
+	ref := &payment.Refund{PaymentID: p.ID, UserID: 2, Amount: money.New(5000, "USD"), Status: payment.RefundSucceeded}
+	require.NoError(t, db.Create(ref).Error)
+
+	require.NoError(t, service.IssueMissing())
+	require.NoError(t, service.IssueMissing())
+
+	var count int64
+	db.Model(&Invoice{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestRefundAfterManualCredit(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(NewRepository(db), nil, testSettings)
+	p := paidPayment(t, db)
+	require.NoError(t, service.IssueForPayment(p))
+	var inv Invoice
+	require.NoError(t, db.Where("type = ?", TypeInvoice).First(&inv).Error)
+
+	// Partly credited by hand: the refund's note covers the rest.
+	part := money.New(3000, "USD")
+	_, err := service.CreateCreditNote(inv.ID, 1, CreateCreditNoteRequest{Amount: &part, Reason: "wrong price"})
+	require.NoError(t, err)
+	first := &payment.Refund{PaymentID: p.ID, UserID: 2, Amount: money.New(2500, "USD"), Status: payment.RefundSucceeded}
+	require.NoError(t, db.Create(first).Error)
+	require.NoError(t, service.IssueMissing())
+	note, err := NewRepository(db).FindByRefund(first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, money.New(2000, "USD"), note.Total)
+
+	// Credited in full: later refunds need no note and are not retried.
+	second := &payment.Refund{PaymentID: p.ID, UserID: 2, Amount: money.New(2500, "USD"), Status: payment.RefundSucceeded}
+	require.NoError(t, db.Create(second).Error)
+	require.NoError(t, service.IssueForRefund(second))
+	refunds, err := NewRepository(db).ListUncreditedRefunds(10)
+	require.NoError(t, err)
+	assert.Empty(t, refunds)
+	require.NoError(t, service.IssueMissing())
+}
+
+func TestFiscalYear(t *testing.T) {
+	s := NewService(nil, nil, Settings{FiscalYearStart: time.April}).(*service)
+	assert.Equal(t, 2025, s.fiscalYear(time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 2026, s.fiscalYear(time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)))
+
+	s = NewService(nil, nil, Settings{}).(*service)
+	assert.Equal(t, 2026, s.fiscalYear(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestRender(t *testing.T) {
+	inv := &Invoice{
+		Type: TypeInvoice, Number: "GF-2026-000001", IssuedAt: time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC),
+		ClubName: "GymFlow Club", CustomerName: "Ann <Member>", CustomerEmail: "ann@example.com",
+		Net: money.New(4167, "EUR"), Tax: money.New(833, "EUR"), Total: money.New(5000, "EUR"),
+		Lines: []Line{{Description: "Class: Yoga (Café)", Quantity: 1, TaxRate: 2000,
+			UnitPrice: money.New(5000, "EUR"), Net: money.New(4167, "EUR"), Tax: money.New(833, "EUR"), Total: money.New(5000, "EUR")}},
+	}
+
+	html, err := RenderHTML(inv)
+	require.NoError(t, err)
+	assert.Contains(t, string(html), "Invoice GF-2026-000001")
+	assert.Contains(t, string(html), "Ann &lt;Member&gt;")
+	assert.Contains(t, string(html), "50.00 EUR")
+	assert.Contains(t, string(html), "20%")
+
+	pdf := RenderPDF(inv)
+	assert.True(t, bytes.HasPrefix(pdf, []byte("%PDF-1.4")))
+	assert.True(t, bytes.HasSuffix(pdf, []byte("%%EOF\n")))
+	assert.Contains(t, string(pdf), "(Invoice GF-2026-000001)")
+	assert.NotContains(t, string(pdf), "()", "empty club details are skipped")
+	assert.Contains(t, string(pdf), `(Class: Yoga \(Caf\351\)) Tj`)
+}
@@ -0,0 +1,49 @@
+package ledger
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// TransactionQuery filters the journal; every field is optional.
+type TransactionQuery struct {
+	From      *time.Time `form:"from" time_format:"2006-01-02"`
+	To        *time.Time `form:"to" time_format:"2006-01-02"`
+	Kind      string     `form:"kind"`
+	PaymentID uint       `form:"payment_id"`
+	Limit     int        `form:"limit"`
+}
+
+// ReconcileQuery selects the days to reconcile, the last week by default.
+type ReconcileQuery struct {
+	From *time.Time `form:"from" time_format:"2006-01-02"`
+	To   *time.Time `form:"to" time_format:"2006-01-02"`
+}
+
+type BalanceResponse struct {
+	Account string      `json:"account"`
+	Balance money.Money `json:"balance"` // debits positive, credits negative
+}
+
+// ReconciliationDay sets what the ledger booked through provider clearing on
+// one day against what the provider settled. Difference is the ledger's net
+// less the provider's.
+type ReconciliationDay struct {
+	Date              time.Time   `json:"date"`
+	Currency          string      `json:"currency"`
+	LedgerCollected   money.Money `json:"ledger_collected"`
+	LedgerRefunded    money.Money `json:"ledger_refunded"`
+	ProviderCollected money.Money `json:"provider_collected"`
+	ProviderRefunded  money.Money `json:"provider_refunded"`
+	Difference        money.Money `json:"difference"`
+	Matched           bool        `json:"matched"`
+}
+
+func ToBalanceResponses(balances []Balance) []BalanceResponse {
+	out := make([]BalanceResponse, 0, len(balances))
+	for _, b := range balances {
+		out = append(out, BalanceResponse{Account: b.Account, Balance: money.New(b.Sum, b.Currency)})
+	}
+	return out
+}
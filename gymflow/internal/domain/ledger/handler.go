@@ -0,0 +1,69 @@
+package ledger
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GET /api/v1/admin/ledger/transactions?from=2026-01-01&to=2026-02-01&kind=refund
+func (h *Handler) ListTransactions(c *gin.Context) {
+	var q TransactionQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	txs, err := h.service.ListTransactions(q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list ledger transactions"})
+		return
+	}
+	c.JSON(http.StatusOK, txs)
+}
+
+// GET /api/v1/admin/ledger/balances
+func (h *Handler) Balances(c *gin.Context) {
+	balances, err := h.service.Balances()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load ledger balances"})
+		return
+	}
+	c.JSON(http.StatusOK, ToBalanceResponses(balances))
+}
+
+// GET /api/v1/admin/reconciliation?from=2026-01-01&to=2026-01-08
+func (h *Handler) Reconcile(c *gin.Context) {
+	var q ReconcileQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	to := midnight(time.Now()).Add(day)
+	if q.To != nil {
+		to = *q.To
+	}
+	from := to.AddDate(0, 0, -7)
+	if q.From != nil {
+		from = *q.From
+	}
+	days, err := h.service.Reconcile(from, to)
+	if errors.Is(err, ErrInvalidRange) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reconcile"})
+		return
+	}
+	c.JSON(http.StatusOK, days)
+}
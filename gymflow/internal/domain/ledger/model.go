@@ -0,0 +1,79 @@
+package ledger
+
+import (
+	"fmt"
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// Accounts of the chart. Provider clearing holds what the gateway collected
+// for us, terminal clearing what the card terminals took at the front desk
+// and cash what is in the tills; class credits are prepaid classes the club
+// still owes.
+const (
+	AccountProviderClearing  = "assets:provider_clearing"
+	AccountTerminalClearing  = "assets:terminal_clearing"
+	AccountCash              = "assets:cash"
+	AccountClassRevenue      = "revenue:classes"
+	AccountMembershipRevenue = "revenue:memberships"
+	AccountPenaltyRevenue    = "revenue:penalty_fees"
+	AccountClassCredits      = "liabilities:class_credits"
+	AccountTaxPayable        = "liabilities:tax_payable"
+)
+
+// AssetAccounts hold the money the club has actually collected.
+var AssetAccounts = []string{AccountProviderClearing, AccountTerminalClearing, AccountCash}
+
+// Kinds of money movement.
+const (
+	KindPayment        = "payment"
+	KindCreditPurchase = "credit_purchase"
+	KindPenaltyFee     = "penalty_fee"
+	KindRefund         = "refund"
+)
+
+// Transaction is one money movement. Its entries are signed, debits positive
+// and credits negative, and sum to zero in every currency.
+type Transaction struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	// Reference names the movement, e.g. payment:12 or refund:3, so each is
+	// recorded once.
+	Reference   string    `gorm:"size:64;not null;uniqueIndex" json:"reference"`
+	Kind        string    `gorm:"size:32;index" json:"kind"`
+	PaymentID   uint      `gorm:"index" json:"payment_id"`
+	RefundID    *uint     `gorm:"uniqueIndex" json:"refund_id,omitempty"`
+	UserID      uint      `gorm:"index" json:"user_id"`
+	Description string    `json:"description"`
+	OccurredAt  time.Time `gorm:"index" json:"occurred_at"`
+	Entries     []Entry   `gorm:"foreignKey:TransactionID" json:"entries"`
+}
+
+func (Transaction) TableName() string { return "ledger_transactions" }
+
+// Entry is one leg of a transaction. OccurredAt repeats the transaction's so
+// accounts can be totalled per day without a join.
+type Entry struct {
+	ID            uint        `gorm:"primaryKey" json:"-"`
+	TransactionID uint        `gorm:"index" json:"-"`
+	Account       string      `gorm:"size:64;index" json:"account"`
+	Amount        money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	OccurredAt    time.Time   `gorm:"index" json:"-"`
+}
+
+func (Entry) TableName() string { return "ledger_entries" }
+
+// balanced checks that debits equal credits in every currency.
+func (t *Transaction) balanced() error {
+	sums := map[string]int64{}
+	for _, e := range t.Entries {
+		sums[e.Amount.Currency] += e.Amount.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("%w: %s is off by %d", ErrUnbalanced, currency, sum)
+		}
+	}
+	return nil
+}
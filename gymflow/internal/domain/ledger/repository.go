@@ -0,0 +1,136 @@
+package ledger
+
+import (
+	"time"
+
+	"gymflow/internal/domain/payment"
+
+	"gorm.io/gorm"
+)
+
+// Balance is the sum of an account's entries in one currency, in minor units.
+type Balance struct {
+	Account  string
+	Currency string
+	Sum      int64
+}
+
+type Repository interface {
+	Transaction(fn func(repo Repository) error) error
+	// LockPayment serializes recording the movements of one payment.
+	LockPayment(id uint) (*payment.Payment, error)
+	FindRefundByID(id uint) (*payment.Refund, error)
+	ExistsReference(reference string) (bool, error)
+	Create(t *Transaction) error
+	List(q TransactionQuery) ([]Transaction, error)
+	Balances() ([]Balance, error)
+	// ListEntries lists the entries of account that occurred in [from, to).
+	ListEntries(account string, from, to time.Time) ([]Entry, error)
+	ListUnrecordedPayments(limit int) ([]payment.Payment, error)
+	ListUnrecordedRefunds(limit int) ([]payment.Refund, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
+
+// LockPayment uses a no-op UPDATE, like payment's LockPayment.
+func (r *repository) LockPayment(id uint) (*payment.Payment, error) {
+	res := r.db.Model(&payment.Payment{}).Where("id = ?", id).UpdateColumn("amount_minor", gorm.Expr("amount_minor"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var p payment.Payment
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) FindRefundByID(id uint) (*payment.Refund, error) {
+	var ref payment.Refund
+	if err := r.db.First(&ref, id).Error; err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (r *repository) ExistsReference(reference string) (bool, error) {
+	var n int64
+	err := r.db.Model(&Transaction{}).Where("reference = ?", reference).Count(&n).Error
+	return n > 0, err
+}
+
+func (r *repository) Create(t *Transaction) error {
+	return r.db.Create(t).Error
+}
+
+func (r *repository) List(q TransactionQuery) ([]Transaction, error) {
+	db := r.db.Preload("Entries", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+	if q.From != nil {
+		db = db.Where("occurred_at >= ?", *q.From)
+	}
+	if q.To != nil {
+		db = db.Where("occurred_at < ?", *q.To)
+	}
+	if q.Kind != "" {
+		db = db.Where("kind = ?", q.Kind)
+	}
+	if q.PaymentID != 0 {
+		db = db.Where("payment_id = ?", q.PaymentID)
+	}
+	var txs []Transaction
+	err := db.Order("occurred_at DESC, id DESC").Limit(q.Limit).Find(&txs).Error
+	return txs, err
+}
+
+func (r *repository) Balances() ([]Balance, error) {
+	var rows []Balance
+	err := r.db.Model(&Entry{}).
+		Select("account, amount_currency AS currency, COALESCE(SUM(amount_minor), 0) AS sum").
+		Group("account, amount_currency").
+		Order("account, amount_currency").
+		Scan(&rows).Error
+	return rows, err
+}
+
+func (r *repository) ListEntries(account string, from, to time.Time) ([]Entry, error) {
+	var entries []Entry
+	err := r.db.Where("account = ? AND occurred_at >= ? AND occurred_at < ?", account, from, to).
+		Order("occurred_at, id").Find(&entries).Error
+	return entries, err
+}
+
+// ListUnrecordedPayments finds collected payments with no ledger
+// transaction yet, oldest first. Free payments move no money and are skipped.
+func (r *repository) ListUnrecordedPayments(limit int) ([]payment.Payment, error) {
+	var payments []payment.Payment
+	err := r.db.Where("status IN ?", []string{payment.StatusPaid, payment.StatusPartiallyRefunded, payment.StatusRefunded}).
+		Where("amount_minor > 0").
+		Where("NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.payment_id = payments.id AND t.refund_id IS NULL)").
+		Order("id").Limit(limit).Find(&payments).Error
+	return payments, err
+}
+
+// ListUnrecordedRefunds finds succeeded refunds with no ledger transaction
+// yet, oldest first.
+func (r *repository) ListUnrecordedRefunds(limit int) ([]payment.Refund, error) {
+	var refunds []payment.Refund
+	err := r.db.Where("status = ?", payment.RefundSucceeded).
+		Where("NOT EXISTS (SELECT 1 FROM ledger_transactions t WHERE t.refund_id = refunds.id)").
+		Order("id").Limit(limit).Find(&refunds).Error
+	return refunds, err
+}
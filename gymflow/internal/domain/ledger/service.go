@@ -0,0 +1,328 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
+)
+
+// backfillBatch bounds one RecordMissing run.
+const backfillBatch = 100
+
+// maxReconcileDays bounds the range of one reconciliation report.
+const maxReconcileDays = 92
+
+const day = 24 * time.Hour
+
+var (
+	ErrUnbalanced   = errors.New("ledger transaction does not balance")
+	ErrNotCollected = errors.New("only collected payments and succeeded refunds are recorded")
+	ErrInvalidRange = errors.New("from must be before to and at most 92 days apart")
+)
+
+// Settlements reports what the payment provider collected and refunded;
+// payment.Provider satisfies it.
+type Settlements interface {
+	Settlements(from, to time.Time) ([]payment.Settlement, error)
+}
+
+type Service interface {
+	// RecordPayment books a collected payment. It is idempotent: a payment
+	// is booked exactly once.
+	RecordPayment(p *payment.Payment) error
+	// RecordRefund books a succeeded refund. It is idempotent.
+	RecordRefund(ref *payment.Refund) error
+	// RecordMissing catches up on payments and refunds that were not booked
+	// when they settled.
+	RecordMissing() error
+
+	ListTransactions(q TransactionQuery) ([]Transaction, error)
+	Balances() ([]Balance, error)
+	// Reconcile compares, per day and currency, what the ledger says went
+	// through the provider with what the provider reports in [from, to).
+	Reconcile(from, to time.Time) ([]ReconciliationDay, error)
+	// CheckSettlements reconciles yesterday and reports its mismatches.
+	CheckSettlements() error
+}
+
+type service struct {
+	repo        Repository
+	settlements Settlements
+	// fallbackTaxRate (basis points, included in prices) splits payments
+	// taken before they recorded their tax, like invoice.Settings.TaxRate.
+	fallbackTaxRate int
+}
+
+func NewService(repo Repository, settlements Settlements, fallbackTaxRate int) Service {
+	return &service{repo: repo, settlements: settlements, fallbackTaxRate: fallbackTaxRate}
+}
+
+// classify is the kind of movement a payment is and the account its net
+// amount is credited to. Class packs are paid in advance, so they are owed
+// to the member as credits until used.
+func classify(kind string) (string, string) {
+	switch kind {
+	case payment.KindMembership:
+		return KindPayment, AccountMembershipRevenue
+	case payment.KindClassPack:
+		return KindCreditPurchase, AccountClassCredits
+	case payment.KindLateCancelFee, payment.KindNoShowFee:
+		return KindPenaltyFee, AccountPenaltyRevenue
+	}
+	return KindPayment, AccountClassRevenue
+}
+
+// assetFor is the account money taken by a payment method lands in.
+func assetFor(method string) string {
+	switch method {
+	case payment.MethodCash:
+		return AccountCash
+	case payment.MethodTerminal:
+		return AccountTerminalClearing
+	}
+	return AccountProviderClearing
+}
+
+// breakdown is the recorded net and tax of a payment.
+func (s *service) breakdown(p *payment.Payment) tax.Breakdown {
+	if p.Net.Currency == "" {
+		return tax.Inclusive(p.Amount, s.fallbackTaxRate)
+	}
+	return tax.Breakdown{Rate: p.TaxRate, Net: p.Net, Tax: p.Tax, Gross: p.Amount}
+}
+
+// legs builds the entries of gross moving into (sign 1) or out of (sign -1)
+// the asset account against the payment's income account and tax.
+func legs(asset, account string, b tax.Breakdown, sign int64, at time.Time) []Entry {
+	entries := []Entry{
+		{Account: asset, Amount: money.New(sign*b.Gross.Amount, b.Gross.Currency)},
+		{Account: account, Amount: money.New(-sign*b.Net.Amount, b.Net.Currency)},
+	}
+	if !b.Tax.IsZero() {
+		entries = append(entries, Entry{Account: AccountTaxPayable, Amount: money.New(-sign*b.Tax.Amount, b.Tax.Currency)})
+	}
+	for i := range entries {
+		entries[i].OccurredAt = at
+	}
+	return entries
+}
+
+func collected(status string) bool {
+	return status == payment.StatusPaid || status == payment.StatusPartiallyRefunded || status == payment.StatusRefunded
+}
+
+func (s *service) RecordPayment(p *payment.Payment) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		current, err := repo.LockPayment(p.ID)
+		if err != nil {
+			return err
+		}
+		if !collected(current.Status) {
+			return fmt.Errorf("%w: payment %d is %s", ErrNotCollected, current.ID, current.Status)
+		}
+		if !current.Amount.IsPositive() {
+			return nil
+		}
+		ref := "payment:" + strconv.FormatUint(uint64(current.ID), 10)
+		if done, err := repo.ExistsReference(ref); err != nil || done {
+			return err
+		}
+
+		at := time.Now()
+		if current.PaidAt != nil {
+			at = *current.PaidAt
+		}
+		kind, account := classify(current.Kind)
+		t := &Transaction{
+			Reference:   ref,
+			Kind:        kind,
+			PaymentID:   current.ID,
+			UserID:      current.UserID,
+			Description: fmt.Sprintf("Payment %d (%s)", current.ID, current.Kind),
+			OccurredAt:  at,
+			Entries:     legs(assetFor(current.Method), account, s.breakdown(current), 1, at),
+		}
+		if err := t.balanced(); err != nil {
+			return err
+		}
+		return repo.Create(t)
+	})
+}
+
+func (s *service) RecordRefund(ref *payment.Refund) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		p, err := repo.LockPayment(ref.PaymentID)
+		if err != nil {
+			return err
+		}
+		current, err := repo.FindRefundByID(ref.ID)
+		if err != nil {
+			return err
+		}
+		if current.Status != payment.RefundSucceeded {
+			return fmt.Errorf("%w: refund %d is %s", ErrNotCollected, current.ID, current.Status)
+		}
+		reference := "refund:" + strconv.FormatUint(uint64(current.ID), 10)
+		if done, err := repo.ExistsReference(reference); err != nil || done {
+			return err
+		}
+
+		// A full refund reverses the payment exactly; a partial one is split
+		// at the payment's rate.
+		b := s.breakdown(p)
+		if current.Amount != p.Amount {
+			b = tax.Inclusive(current.Amount, b.Rate)
+		}
+		at := time.Now()
+		if current.RefundedAt != nil {
+			at = *current.RefundedAt
+		}
+		_, account := classify(p.Kind)
+		t := &Transaction{
+			Reference:   reference,
+			Kind:        KindRefund,
+			PaymentID:   p.ID,
+			RefundID:    &current.ID,
+			UserID:      p.UserID,
+			Description: fmt.Sprintf("Refund %d of payment %d: %s", current.ID, p.ID, current.Reason),
+			OccurredAt:  at,
+			Entries:     legs(assetFor(p.Method), account, b, -1, at),
+		}
+		if err := t.balanced(); err != nil {
+			return err
+		}
+		return repo.Create(t)
+	})
+}
+
+func (s *service) RecordMissing() error {
+	var errs []error
+	payments, err := s.repo.ListUnrecordedPayments(backfillBatch)
+	if err != nil {
+		return err
+	}
+	for i := range payments {
+		if err := s.RecordPayment(&payments[i]); err != nil {
+			errs = append(errs, fmt.Errorf("payment %d: %w", payments[i].ID, err))
+		}
+	}
+	refunds, err := s.repo.ListUnrecordedRefunds(backfillBatch)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range refunds {
+		if err := s.RecordRefund(&refunds[i]); err != nil {
+			errs = append(errs, fmt.Errorf("refund %d: %w", refunds[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *service) ListTransactions(q TransactionQuery) ([]Transaction, error) {
+	if q.Limit <= 0 || q.Limit > 500 {
+		q.Limit = 100
+	}
+	return s.repo.List(q)
+}
+
+func (s *service) Balances() ([]Balance, error) {
+	return s.repo.Balances()
+}
+
+// midnight truncates t to the start of its UTC day.
+func midnight(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func (s *service) Reconcile(from, to time.Time) ([]ReconciliationDay, error) {
+	from, to = midnight(from), midnight(to)
+	if !from.Before(to) || to.Sub(from) > maxReconcileDays*day {
+		return nil, ErrInvalidRange
+	}
+	entries, err := s.repo.ListEntries(AccountProviderClearing, from, to)
+	if err != nil {
+		return nil, err
+	}
+	settlements, err := s.settlements.Settlements(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("provider settlements: %w", err)
+	}
+
+	type key struct {
+		date     time.Time
+		currency string
+	}
+	days := map[key]*ReconciliationDay{}
+	get := func(k key) *ReconciliationDay {
+		d, ok := days[k]
+		if !ok {
+			zero := money.Zero(k.currency)
+			d = &ReconciliationDay{
+				Date: k.date, Currency: k.currency,
+				LedgerCollected: zero, LedgerRefunded: zero,
+				ProviderCollected: zero, ProviderRefunded: zero,
+			}
+			days[k] = d
+		}
+		return d
+	}
+	for _, e := range entries {
+		d := get(key{midnight(e.OccurredAt), e.Amount.Currency})
+		if e.Amount.Amount >= 0 {
+			d.LedgerCollected.Amount += e.Amount.Amount
+		} else {
+			d.LedgerRefunded.Amount -= e.Amount.Amount
+		}
+	}
+	for _, st := range settlements {
+		d := get(key{midnight(st.Date), st.Currency})
+		d.ProviderCollected.Amount += st.Collected.Amount
+		d.ProviderRefunded.Amount += st.Refunded.Amount
+	}
+
+	out := make([]ReconciliationDay, 0, len(days))
+	for _, d := range days {
+		d.Difference = money.New(
+			(d.LedgerCollected.Amount-d.LedgerRefunded.Amount)-(d.ProviderCollected.Amount-d.ProviderRefunded.Amount),
+			d.Currency)
+		d.Matched = d.LedgerCollected == d.ProviderCollected && d.LedgerRefunded == d.ProviderRefunded
+		out = append(out, *d)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].Date.Equal(out[j].Date) {
+			return out[i].Date.Before(out[j].Date)
+		}
+		return out[i].Currency < out[j].Currency
+	})
+	return out, nil
+}
+
+func (s *service) CheckSettlements() error {
+	today := midnight(time.Now())
+	days, err := s.Reconcile(today.Add(-day), today)
+	if err != nil {
+		return err
+	}
+	var mismatches int
+	for _, d := range days {
+		if d.Matched {
+			continue
+		}
+		mismatches++
+		log.Printf("reconciliation %s %s: ledger collected %s refunded %s, provider collected %s refunded %s",
+			d.Date.Format("2006-01-02"), d.Currency,
+			d.LedgerCollected, d.LedgerRefunded, d.ProviderCollected, d.ProviderRefunded)
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d settlement mismatches on %s", mismatches, today.Add(-day).Format("2006-01-02"))
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package ledger
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func usd(minor int64) money.Money { return money.New(minor, "USD") }
+
+func setup(t *testing.T, settlements Settlements) (Service, *gorm.DB) {
+	dsn := filepath.Join(t.TempDir(), "ledger.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&payment.Payment{}, &payment.Refund{}, &Transaction{}, &Entry{}))
+	return NewService(NewRepository(db), settlements, 0), db
+}
+
+func paid(t *testing.T, db *gorm.DB, p payment.Payment) *payment.Payment {
+	now := time.Now()
+	p.Status = payment.StatusPaid
+	p.PaidAt = &now
+	require.NoError(t, db.Create(&p).Error)
+	return &p
+}
+
+func balances(t *testing.T, service Service) map[string]int64 {
+	rows, err := service.Balances()
+	require.NoError(t, err)
+	out := map[string]int64{}
+	for _, r := range rows {
+		out[r.Account] = r.Sum
+	}
+	return out
+}
+
+func TestRecordPayment(t *testing.T) {
+	service, db := setup(t, nil)
+	class := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindBooking, Amount: usd(1200), Net: usd(1000), Tax: usd(200), TaxRate: 2000})
+	// Taken before payments recorded their tax.
+	legacy := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindMembership, Amount: usd(3000)})
+	pack := paid(t, db, payment.Payment{UserID: 3, Kind: payment.KindClassPack, Amount: usd(5000), Net: usd(5000), Tax: usd(0)})
+	fee := paid(t, db, payment.Payment{UserID: 3, Kind: payment.KindNoShowFee, Amount: usd(500), Net: usd(500), Tax: usd(0)})
+
+	for _, p := range []*payment.Payment{class, legacy, pack, fee} {
+		require.NoError(t, service.RecordPayment(p))
+	}
+	// Recording again changes nothing.
+	require.NoError(t, service.RecordPayment(class))
+
+	txs, err := service.ListTransactions(TransactionQuery{PaymentID: class.ID})
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.Equal(t, KindPayment, txs[0].Kind)
+	assert.Equal(t, []string{AccountProviderClearing, AccountClassRevenue, AccountTaxPayable},
+		[]string{txs[0].Entries[0].Account, txs[0].Entries[1].Account, txs[0].Entries[2].Account})
+
+	txs, err = service.ListTransactions(TransactionQuery{Kind: KindCreditPurchase})
+	require.NoError(t, err)
+	require.Len(t, txs, 1)
+	assert.Equal(t, pack.ID, txs[0].PaymentID)
+
+	assert.Equal(t, map[string]int64{
+		AccountProviderClearing:  9700,
+		AccountClassRevenue:      -1000,
+		AccountTaxPayable:        -200,
+		AccountMembershipRevenue: -3000,
+		AccountClassCredits:      -5000,
+		AccountPenaltyRevenue:    -500,
+	}, balances(t, service))
+
+	pending := payment.Payment{UserID: 2, Kind: payment.KindBooking, Amount: usd(1200), Status: payment.StatusPending}
+	require.NoError(t, db.Create(&pending).Error)
+	assert.ErrorIs(t, service.RecordPayment(&pending), ErrNotCollected)
+}
+
+func TestRecordPayment_InPerson(t *testing.T) {
+	service, db := setup(t, nil)
+	cash := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindBooking, Method: payment.MethodCash, Amount: usd(1200), Net: usd(1200), Tax: usd(0)})
+	card := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindMembership, Method: payment.MethodTerminal, Amount: usd(3000), Net: usd(3000), Tax: usd(0)})
+	for _, p := range []*payment.Payment{cash, card} {
+		require.NoError(t, service.RecordPayment(p))
+	}
+	now := time.Now()
+	ref := payment.Refund{PaymentID: cash.ID, UserID: 2, Amount: usd(200), Status: payment.RefundSucceeded, RefundedAt: &now}
+	require.NoError(t, db.Create(&ref).Error)
+	require.NoError(t, service.RecordRefund(&ref))
+
+	// Money taken at the front desk never went through the provider.
+	assert.Equal(t, map[string]int64{
+		AccountCash:              1000,
+		AccountTerminalClearing:  3000,
+		AccountClassRevenue:      -1000,
+		AccountMembershipRevenue: -3000,
+	}, balances(t, service))
+}
+
+func TestRecordRefund(t *testing.T) {
+	service, db := setup(t, nil)
+	p := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindBooking, Amount: usd(1200), Net: usd(1000), Tax: usd(200), TaxRate: 2000})
+	require.NoError(t, service.RecordPayment(p))
+
+	now := time.Now()
+	partial := payment.Refund{PaymentID: p.ID, UserID: 2, Amount: usd(600), Status: payment.RefundSucceeded, RefundedAt: &now}
+	rest := payment.Refund{PaymentID: p.ID, UserID: 2, Amount: usd(600), Status: payment.RefundSucceeded, RefundedAt: &now}
+	failed := payment.Refund{PaymentID: p.ID, UserID: 2, Amount: usd(600), Status: payment.RefundFailed}
+	require.NoError(t, db.Create(&partial).Error)
+	require.NoError(t, db.Create(&rest).Error)
+	require.NoError(t, db.Create(&failed).Error)
+
+	require.NoError(t, service.RecordRefund(&partial))
+	assert.Equal(t, map[string]int64{
+		AccountProviderClearing: 600,
+		AccountClassRevenue:     -500,
+		AccountTaxPayable:       -100,
+	}, balances(t, service))
+
+	require.NoError(t, service.RecordRefund(&rest))
+	require.NoError(t, service.RecordRefund(&rest))
+	assert.ErrorIs(t, service.RecordRefund(&failed), ErrNotCollected)
+	assert.Equal(t, map[string]int64{
+		AccountProviderClearing: 0,
+		AccountClassRevenue:     0,
+		AccountTaxPayable:       0,
+	}, balances(t, service))
+}
+
+func TestRecordMissing(t *testing.T) {
+	service, db := setup(t, nil)
+	p := paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindBooking, Amount: usd(1200), Net: usd(1000), Tax: usd(200), TaxRate: 2000})
+	paid(t, db, payment.Payment{UserID: 2, Kind: payment.KindBooking, Amount: usd(0), Net: usd(0), Tax: usd(0)})
+	require.NoError(t, db.Create(&payment.Payment{UserID: 2, Amount: usd(800), Status: payment.StatusFailed}).Error)
+	now := time.Now()
+	ref := payment.Refund{PaymentID: p.ID, UserID: 2, Amount: usd(1200), Status: payment.RefundSucceeded, RefundedAt: &now}
+	require.NoError(t, db.Create(&ref).Error)
+
+	require.NoError(t, service.RecordMissing())
+	require.NoError(t, service.RecordMissing())
+
+	txs, err := service.ListTransactions(TransactionQuery{})
+	require.NoError(t, err)
+	require.Len(t, txs, 2, "one payment and its refund; free and failed payments are skipped")
+	kinds := []string{txs[0].Kind, txs[1].Kind}
+	assert.ElementsMatch(t, []string{KindPayment, KindRefund}, kinds)
+}
+
+func TestReconcile(t *testing.T) {
+	provider := payment.NewFakeProvider("secret", 0, "")
+	service, db := setup(t, provider)
+
+	// Collected through the provider and booked.
+	for _, amount := range []int64{1200, 800} {
+		in, err := provider.CreateIntent(payment.IntentRequest{Amount: usd(amount)})
+		require.NoError(t, err)
+		_, err = provider.Capture(in.ID, usd(amount))
+		require.NoError(t, err)
+		p := paid(t, db, payment.Payment{UserID: 2, Amount: usd(amount), Net: usd(amount), Tax: usd(0), ProviderRef: in.ID})
+		require.NoError(t, service.RecordPayment(p))
+	}
+	today := midnight(time.Now())
+	days, err := service.Reconcile(today, today.Add(day))
+	require.NoError(t, err)
+	require.Len(t, days, 1)
+	assert.True(t, days[0].Matched)
+	assert.Equal(t, usd(2000), days[0].LedgerCollected)
+	assert.Equal(t, usd(2000), days[0].ProviderCollected)
+	assert.True(t, days[0].Difference.IsZero())
+
+	// Refunded at the provider but never booked.
+	in, err := provider.CreateIntent(payment.IntentRequest{Amount: usd(500)})
+	require.NoError(t, err)
+	_, err = provider.Capture(in.ID, usd(500))
+	require.NoError(t, err)
+	p := paid(t, db, payment.Payment{UserID: 2, Amount: usd(500), Net: usd(500), Tax: usd(0), ProviderRef: in.ID})
+	require.NoError(t, service.RecordPayment(p))
+	_, err = provider.Refund(in.ID, usd(500))
+	require.NoError(t, err)
+
+	days, err = service.Reconcile(today, today.Add(day))
+	require.NoError(t, err)
+	require.Len(t, days, 1)
+	assert.False(t, days[0].Matched)
+	assert.Equal(t, usd(500), days[0].ProviderRefunded)
+	assert.True(t, days[0].LedgerRefunded.IsZero())
+	assert.Equal(t, usd(500), days[0].Difference)
+
+	_, err = service.Reconcile(today, today)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+	_, err = service.Reconcile(today.AddDate(0, -6, 0), today)
+	assert.ErrorIs(t, err, ErrInvalidRange)
+}
@@ -0,0 +1,117 @@
+package membership
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+type CreatePlanRequest struct {
+	Name          string      `json:"name" binding:"required"`
+	Description   string      `json:"description"`
+	Tier          string      `json:"tier" binding:"required"` // basic, premium, vip
+	Price         money.Money `json:"price"`
+	Interval      string      `json:"interval" binding:"required"` // week, month, year
+	IntervalCount int         `json:"interval_count"`              // defaults to 1
+}
+
+// UpdatePlanRequest changes a plan. A new price applies from each
+// subscription's next renewal.
+type UpdatePlanRequest struct {
+	Name        *string      `json:"name"`
+	Description *string      `json:"description"`
+	Price       *money.Money `json:"price"`
+	Active      *bool        `json:"active"`
+}
+
+type SubscribeRequest struct {
+	PlanID uint `json:"plan_id" binding:"required"`
+	// PaymentToken references the member's payment method at the provider; it
+	// is charged for the first period and every renewal. Without it the
+	// member's default saved payment method is charged.
+	PaymentToken string `json:"payment_token"`
+}
+
+type PlanResponse struct {
+	ID            uint        `json:"id"`
+	Name          string      `json:"name"`
+	Description   string      `json:"description"`
+	Tier          string      `json:"tier"`
+	Price         money.Money `json:"price"`
+	Interval      string      `json:"interval"`
+	IntervalCount int         `json:"interval_count"`
+	Active        bool        `json:"active"`
+}
+
+func ToPlanResponse(p *Plan) *PlanResponse {
+	return &PlanResponse{
+		ID:            p.ID,
+		Name:          p.Name,
+		Description:   p.Description,
+		Tier:          p.Tier,
+		Price:         p.Price,
+		Interval:      p.Interval,
+		IntervalCount: p.IntervalCount,
+		Active:        p.Active,
+	}
+}
+
+func ToPlanResponses(plans []Plan) []*PlanResponse {
+	out := make([]*PlanResponse, len(plans))
+	for i := range plans {
+		out[i] = ToPlanResponse(&plans[i])
+	}
+	return out
+}
+
+type SubscriptionResponse struct {
+	ID     uint          `json:"id"`
+	UserID uint          `json:"user_id"`
+	Plan   *PlanResponse `json:"plan"`
+	Status string        `json:"status"`
+
+	StartedAt          *time.Time `json:"started_at,omitempty"`
+	CurrentPeriodStart *time.Time `json:"current_period_start,omitempty"`
+	CurrentPeriodEnd   *time.Time `json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end"`
+	CancelledAt        *time.Time `json:"cancelled_at,omitempty"`
+	EndedAt            *time.Time `json:"ended_at,omitempty"`
+
+	GraceUntil     *time.Time `json:"grace_until,omitempty"`
+	NextRetryAt    *time.Time `json:"next_retry_at,omitempty"`
+	FailedAttempts int        `json:"failed_attempts"`
+
+	PendingPaymentID *uint `json:"pending_payment_id,omitempty"`
+	LastPaymentID    *uint `json:"last_payment_id,omitempty"`
+}
+
+func ToSubscriptionResponse(s *Subscription) *SubscriptionResponse {
+	return &SubscriptionResponse{
+		ID:     s.ID,
+		UserID: s.UserID,
+		Plan:   ToPlanResponse(&s.Plan),
+		Status: s.Status,
+
+		StartedAt:          s.StartedAt,
+		CurrentPeriodStart: s.CurrentPeriodStart,
+		CurrentPeriodEnd:   s.CurrentPeriodEnd,
+		CancelAtPeriodEnd:  s.CancelAtPeriodEnd,
+		CancelledAt:        s.CancelledAt,
+		EndedAt:            s.EndedAt,
+
+		GraceUntil:     s.GraceUntil,
+		NextRetryAt:    s.NextRetryAt,
+		FailedAttempts: s.FailedAttempts,
+
+		PendingPaymentID: s.PendingPaymentID,
+		LastPaymentID:    s.LastPaymentID,
+	}
+}
+
+func ToSubscriptionResponses(subs []Subscription) []*SubscriptionResponse {
+	out := make([]*SubscriptionResponse, len(subs))
+	for i := range subs {
+		out[i] = ToSubscriptionResponse(&subs[i])
+	}
+	return out
+}
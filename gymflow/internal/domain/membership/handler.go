@@ -0,0 +1,144 @@
+package membership
+
+import (
+	"errors"
+	"net/http"
+
+	"gymflow/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// GET /api/v1/membership-plans
+func (h *Handler) ListPlans(c *gin.Context) {
+	plans, err := h.service.ListPlans(false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list membership plans"})
+		return
+	}
+	c.JSON(http.StatusOK, ToPlanResponses(plans))
+}
+
+// POST /api/v1/memberships
+func (h *Handler) Subscribe(c *gin.Context) {
+	var req SubscribeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	sub, err := h.service.Subscribe(userIDAny.(uint), req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	// The first charge may still be waiting for the provider's webhook.
+	status := http.StatusCreated
+	if sub.Status == StatusIncomplete {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, ToSubscriptionResponse(sub))
+}
+
+// GET /api/v1/memberships/me
+func (h *Handler) GetSubscription(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	sub, err := h.service.GetSubscription(userIDAny.(uint))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToSubscriptionResponse(sub))
+}
+
+// POST /api/v1/memberships/me/cancel
+func (h *Handler) CancelSubscription(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	sub, err := h.service.CancelSubscription(userIDAny.(uint))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToSubscriptionResponse(sub))
+}
+
+// GET /api/v1/admin/membership-plans
+func (h *Handler) AdminListPlans(c *gin.Context) {
+	plans, err := h.service.ListPlans(true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list membership plans"})
+		return
+	}
+	c.JSON(http.StatusOK, ToPlanResponses(plans))
+}
+
+// POST /api/v1/admin/membership-plans
+func (h *Handler) CreatePlan(c *gin.Context) {
+	var req CreatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := h.service.CreatePlan(req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ToPlanResponse(plan))
+}
+
+// PATCH /api/v1/admin/membership-plans/:id
+func (h *Handler) UpdatePlan(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req UpdatePlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	plan, err := h.service.UpdatePlan(uri.ID, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToPlanResponse(plan))
+}
+
+// GET /api/v1/admin/subscriptions?status=
+func (h *Handler) ListSubscriptions(c *gin.Context) {
+	subs, err := h.service.ListSubscriptions(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list subscriptions"})
+		return
+	}
+	c.JSON(http.StatusOK, ToSubscriptionResponses(subs))
+}
+
+func writeError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrPlanNotFound), errors.Is(err, ErrNoSubscription):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrAlreadySubscribed), errors.Is(err, ErrSubscriptionNotReady):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPaymentDeclined):
+		c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,91 @@
+package membership
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// Billing intervals of a plan.
+const (
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+	IntervalYear  = "year"
+)
+
+// Subscription states. A subscription starts incomplete until its first
+// payment settles, and expires if that takes longer than the grace period. A
+// failed renewal makes it past_due; it keeps its tier through the grace
+// period and lapses to expired once that ends. A member cancellation takes
+// effect at the end of the paid period.
+const (
+	StatusIncomplete = "incomplete"
+	StatusActive     = "active"
+	StatusPastDue    = "past_due"
+	StatusCancelled  = "cancelled"
+	StatusExpired    = "expired"
+)
+
+// Plan is a purchasable membership. Subscribers get Tier (a
+// user.MembershipTier) for as long as the subscription is paid.
+type Plan struct {
+	ID            uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+	Name          string      `gorm:"size:100;not null;uniqueIndex" json:"name"`
+	Description   string      `json:"description"`
+	Tier          string      `gorm:"size:20;not null" json:"tier"`
+	Price         money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	Interval      string      `gorm:"size:10;not null" json:"interval"`
+	IntervalCount int         `gorm:"not null;default:1" json:"interval_count"`
+	// Inactive plans can't be subscribed to; existing subscriptions keep renewing.
+	Active bool `gorm:"not null;default:true" json:"active"`
+}
+
+// advance returns the end of a billing period that starts at from.
+func (p *Plan) advance(from time.Time) time.Time {
+	n := p.IntervalCount
+	if n < 1 {
+		n = 1
+	}
+	switch p.Interval {
+	case IntervalWeek:
+		return from.AddDate(0, 0, 7*n)
+	case IntervalYear:
+		return from.AddDate(n, 0, 0)
+	default:
+		return from.AddDate(0, n, 0)
+	}
+}
+
+type Subscription struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	UserID    uint      `gorm:"index" json:"user_id"`
+	PlanID    uint      `gorm:"index" json:"plan_id"`
+	Plan      Plan      `gorm:"foreignKey:PlanID" json:"plan"`
+	Status    string    `gorm:"size:20;index" json:"status"`
+
+	StartedAt          *time.Time `json:"started_at,omitempty"`
+	CurrentPeriodStart *time.Time `json:"current_period_start,omitempty"`
+	// CurrentPeriodEnd is the renewal date.
+	CurrentPeriodEnd  *time.Time `gorm:"index" json:"current_period_end,omitempty"`
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	CancelledAt       *time.Time `json:"cancelled_at,omitempty"`
+	EndedAt           *time.Time `json:"ended_at,omitempty"`
+
+	// GraceUntil is when a past_due subscription lapses; NextRetryAt is the
+	// next renewal attempt before then. A renewal charge that stays pending
+	// lapses the subscription at the end of the grace period too.
+	GraceUntil     *time.Time `json:"grace_until,omitempty"`
+	NextRetryAt    *time.Time `json:"next_retry_at,omitempty"`
+	FailedAttempts int        `json:"failed_attempts"`
+
+	// PendingPaymentID is a charge still waiting for the provider's webhook.
+	PendingPaymentID *uint `json:"pending_payment_id,omitempty"`
+	LastPaymentID    *uint `json:"last_payment_id,omitempty"`
+	// PaymentToken is the provider token renewals are charged with; when
+	// empty they are charged to the member's default saved payment method.
+	PaymentToken string `json:"-"`
+}
@@ -0,0 +1,166 @@
+package membership
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreatePlan(p *Plan) error
+	UpdatePlan(p *Plan) error
+	FindPlanByID(id uint) (*Plan, error)
+	ListPlans(activeOnly bool) ([]Plan, error)
+
+	CreateSubscription(s *Subscription) error
+	// UpdateSubscription saves everything but PendingPaymentID, so a stale
+	// copy can't drop a payment the front desk handed over meanwhile.
+	UpdateSubscription(s *Subscription) error
+	// SetPendingPayment and ClearPendingPayment are the only writes of
+	// PendingPaymentID. Both fail with gorm.ErrRecordNotFound when another
+	// payment is pending.
+	SetPendingPayment(subscriptionID, paymentID uint) error
+	ClearPendingPayment(subscriptionID, paymentID uint) error
+	// FindCurrentByUser returns the member's incomplete, active or past_due subscription.
+	FindCurrentByUser(userID uint) (*Subscription, error)
+	ListSubscriptions(status string) ([]Subscription, error)
+	// ListAwaitingPayment returns subscriptions with a charge pending at the provider.
+	ListAwaitingPayment() ([]Subscription, error)
+	// ListDue returns active subscriptions whose period ended by now.
+	ListDue(now time.Time) ([]Subscription, error)
+	ListPastDue() ([]Subscription, error)
+
+	// SetUserTier and SetUserActive write the users table directly, like
+	// booking's FindUserRole reads it, so membership does not depend on the
+	// user package.
+	SetUserTier(userID uint, tier string) error
+	SetUserActive(userID uint, active bool) error
+	// LockMember holds the member's users row until the transaction ends,
+	// so one subscription is started at a time.
+	LockMember(userID uint) error
+
+	// Transaction runs fn against a repository bound to one transaction.
+	Transaction(fn func(repo Repository) error) error
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) CreatePlan(p *Plan) error {
+	return r.db.Create(p).Error
+}
+
+func (r *repository) UpdatePlan(p *Plan) error {
+	return r.db.Save(p).Error
+}
+
+func (r *repository) FindPlanByID(id uint) (*Plan, error) {
+	var p Plan
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) ListPlans(activeOnly bool) ([]Plan, error) {
+	var plans []Plan
+	q := r.db.Order("price_minor, id")
+	if activeOnly {
+		q = q.Where("active = ?", true)
+	}
+	err := q.Find(&plans).Error
+	return plans, err
+}
+
+func (r *repository) CreateSubscription(s *Subscription) error {
+	return r.db.Omit("Plan").Create(s).Error
+}
+
+func (r *repository) UpdateSubscription(s *Subscription) error {
+	return r.db.Omit("Plan", "PendingPaymentID").Save(s).Error
+}
+
+func (r *repository) SetPendingPayment(subscriptionID, paymentID uint) error {
+	return r.updatePending(r.db.Where("id = ? AND (pending_payment_id IS NULL OR pending_payment_id = ?)", subscriptionID, paymentID), &paymentID)
+}
+
+func (r *repository) ClearPendingPayment(subscriptionID, paymentID uint) error {
+	return r.updatePending(r.db.Where("id = ? AND (pending_payment_id IS NULL OR pending_payment_id = ?)", subscriptionID, paymentID), nil)
+}
+
+func (r *repository) updatePending(q *gorm.DB, paymentID *uint) error {
+	res := q.Model(&Subscription{}).UpdateColumn("pending_payment_id", paymentID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) FindCurrentByUser(userID uint) (*Subscription, error) {
+	var s Subscription
+	err := r.db.Preload("Plan").
+		Where("user_id = ? AND status IN ?", userID, []string{StatusIncomplete, StatusActive, StatusPastDue}).
+		Order("id DESC").First(&s).Error
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (r *repository) ListSubscriptions(status string) ([]Subscription, error) {
+	var subs []Subscription
+	q := r.db.Preload("Plan").Order("id DESC")
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	err := q.Find(&subs).Error
+	return subs, err
+}
+
+func (r *repository) ListAwaitingPayment() ([]Subscription, error) {
+	var subs []Subscription
+	err := r.db.Preload("Plan").Where("pending_payment_id IS NOT NULL").Order("id").Find(&subs).Error
+	return subs, err
+}
+
+func (r *repository) ListDue(now time.Time) ([]Subscription, error) {
+	var subs []Subscription
+	err := r.db.Preload("Plan").
+		Where("status = ? AND current_period_end <= ? AND pending_payment_id IS NULL", StatusActive, now).
+		Order("current_period_end, id").Find(&subs).Error
+	return subs, err
+}
+
+func (r *repository) ListPastDue() ([]Subscription, error) {
+	var subs []Subscription
+	err := r.db.Preload("Plan").
+		Where("status = ? AND pending_payment_id IS NULL", StatusPastDue).
+		Order("id").Find(&subs).Error
+	return subs, err
+}
+
+func (r *repository) SetUserTier(userID uint, tier string) error {
+	return r.db.Table("users").Where("id = ?", userID).Update("membership_tier", tier).Error
+}
+
+func (r *repository) SetUserActive(userID uint, active bool) error {
+	return r.db.Table("users").Where("id = ?", userID).Update("active", active).Error
+}
+
+func (r *repository) LockMember(userID uint) error {
+	return r.db.Exec("UPDATE users SET id = id WHERE id = ?", userID).Error
+}
+
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
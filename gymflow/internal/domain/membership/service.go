@@ -0,0 +1,431 @@
+package membership
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+// Lapse actions: what happens to a member whose subscription ends.
+const (
+	LapseDowngrade  = "downgrade"
+	LapseDeactivate = "deactivate"
+)
+
+// tiers mirrors user.MembershipBasic, user.MembershipPremium and user.MembershipVIP.
+var tiers = map[string]bool{"basic": true, "premium": true, "vip": true}
+
+var (
+	ErrPlanNotFound         = errors.New("membership plan not found")
+	ErrPlanUnavailable      = errors.New("membership plan is not available")
+	ErrInvalidPlan          = errors.New("invalid membership plan")
+	ErrAlreadySubscribed    = errors.New("member already has a membership subscription")
+	ErrNoSubscription       = errors.New("member has no membership subscription")
+	ErrSubscriptionNotReady = errors.New("subscription is waiting for its first payment")
+	ErrPaymentDeclined      = errors.New("membership payment was declined")
+)
+
+// Payments charges memberships; payment.Service satisfies it.
+type Payments interface {
+	ChargeMembership(userID, subscriptionID uint, amount money.Money, token string) (*payment.Payment, error)
+	GetPayment(id uint) (*payment.Payment, error)
+}
+
+type Service interface {
+	ListPlans(includeInactive bool) ([]Plan, error)
+	CreatePlan(req CreatePlanRequest) (*Plan, error)
+	UpdatePlan(id uint, req UpdatePlanRequest) (*Plan, error)
+
+	Subscribe(userID uint, req SubscribeRequest) (*Subscription, error)
+	GetSubscription(userID uint) (*Subscription, error)
+	CancelSubscription(userID uint) (*Subscription, error)
+	ListSubscriptions(status string) ([]Subscription, error)
+
+	// ProcessRenewals is the billing job: it settles charges that were
+	// pending at the provider, renews subscriptions whose period ended,
+	// retries past_due ones and lapses those out of grace.
+	ProcessRenewals() error
+}
+
+type Settings struct {
+	// GracePeriod is how long after a failed renewal the member keeps the tier.
+	GracePeriod time.Duration
+	// RetryInterval spaces renewal attempts during the grace period.
+	RetryInterval time.Duration
+	// LapseAction is LapseDowngrade (to LapseTier) or LapseDeactivate.
+	LapseAction string
+	LapseTier   string
+}
+
+type service struct {
+	repo     Repository
+	payments Payments
+	settings Settings
+}
+
+func NewService(repo Repository, payments Payments, settings Settings) Service {
+	if settings.LapseTier == "" {
+		settings.LapseTier = "basic"
+	}
+	if settings.RetryInterval <= 0 {
+		settings.RetryInterval = 24 * time.Hour
+	}
+	return &service{repo: repo, payments: payments, settings: settings}
+}
+
+func (s *service) ListPlans(includeInactive bool) ([]Plan, error) {
+	return s.repo.ListPlans(!includeInactive)
+}
+
+func validatePlan(p *Plan) error {
+	if !tiers[p.Tier] {
+		return fmt.Errorf("%w: unknown tier %q", ErrInvalidPlan, p.Tier)
+	}
+	switch p.Interval {
+	case IntervalWeek, IntervalMonth, IntervalYear:
+	default:
+		return fmt.Errorf("%w: interval must be week, month or year", ErrInvalidPlan)
+	}
+	if p.IntervalCount < 1 {
+		return fmt.Errorf("%w: interval_count must be at least 1", ErrInvalidPlan)
+	}
+	if !p.Price.IsPositive() {
+		return fmt.Errorf("%w: price must be positive", ErrInvalidPlan)
+	}
+	if err := p.Price.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPlan, err)
+	}
+	return nil
+}
+
+func (s *service) CreatePlan(req CreatePlanRequest) (*Plan, error) {
+	p := &Plan{
+		Name:          req.Name,
+		Description:   req.Description,
+		Tier:          req.Tier,
+		Price:         req.Price,
+		Interval:      req.Interval,
+		IntervalCount: req.IntervalCount,
+		Active:        true,
+	}
+	if p.IntervalCount == 0 {
+		p.IntervalCount = 1
+	}
+	if err := validatePlan(p); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreatePlan(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) UpdatePlan(id uint, req UpdatePlanRequest) (*Plan, error) {
+	p, err := s.findPlan(id)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name != nil {
+		p.Name = *req.Name
+	}
+	if req.Description != nil {
+		p.Description = *req.Description
+	}
+	if req.Price != nil {
+		p.Price = *req.Price
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+	if err := validatePlan(p); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdatePlan(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *service) findPlan(id uint) (*Plan, error) {
+	p, err := s.repo.FindPlanByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrPlanNotFound
+	}
+	return p, err
+}
+
+// Subscribe starts a subscription and charges its first period. The member
+// gets the plan's tier once that payment is collected: right away, or when
+// the billing job sees the provider's webhook settle it.
+func (s *service) Subscribe(userID uint, req SubscribeRequest) (*Subscription, error) {
+	plan, err := s.findPlan(req.PlanID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.Active {
+		return nil, ErrPlanUnavailable
+	}
+
+	sub := &Subscription{
+		UserID:       userID,
+		PlanID:       plan.ID,
+		Plan:         *plan,
+		Status:       StatusIncomplete,
+		PaymentToken: req.PaymentToken,
+	}
+	// Under the member's lock, so a double submit can't start two
+	// subscriptions and charge both.
+	err = s.repo.Transaction(func(repo Repository) error {
+		if err := repo.LockMember(userID); err != nil {
+			return err
+		}
+		if _, err := repo.FindCurrentByUser(userID); err == nil {
+			return ErrAlreadySubscribed
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return repo.CreateSubscription(sub)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.charge(sub, time.Now()); err != nil {
+		return nil, err
+	}
+	if sub.Status == StatusExpired {
+		return sub, ErrPaymentDeclined
+	}
+	return sub, nil
+}
+
+func (s *service) GetSubscription(userID uint) (*Subscription, error) {
+	sub, err := s.repo.FindCurrentByUser(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoSubscription
+	}
+	return sub, err
+}
+
+// CancelSubscription stops renewals. A paid subscription runs to the end of
+// its period; a past_due one ends at once.
+func (s *service) CancelSubscription(userID uint) (*Subscription, error) {
+	sub, err := s.GetSubscription(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	switch sub.Status {
+	case StatusIncomplete:
+		return nil, ErrSubscriptionNotReady
+	case StatusPastDue:
+		sub.CancelledAt = &now
+		return sub, s.end(sub, StatusCancelled, now)
+	}
+	sub.CancelAtPeriodEnd = true
+	sub.CancelledAt = &now
+	if err := s.repo.UpdateSubscription(sub); err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (s *service) ListSubscriptions(status string) ([]Subscription, error) {
+	return s.repo.ListSubscriptions(status)
+}
+
+func (s *service) ProcessRenewals() error {
+	return s.processRenewals(time.Now())
+}
+
+func (s *service) processRenewals(now time.Time) error {
+	var errs []error
+	record := func(sub *Subscription, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("subscription %d: %w", sub.ID, err))
+		}
+	}
+
+	awaiting, err := s.repo.ListAwaitingPayment()
+	if err != nil {
+		return err
+	}
+	for i := range awaiting {
+		sub := &awaiting[i]
+		p, err := s.payments.GetPayment(*sub.PendingPaymentID)
+		if err != nil {
+			record(sub, err)
+			continue
+		}
+		if p.Status == payment.StatusPending || p.Status == payment.StatusAuthorized {
+			record(sub, s.unsettled(sub, now))
+			continue
+		}
+		record(sub, s.apply(sub, p, now))
+	}
+
+	due, err := s.repo.ListDue(now)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range due {
+		sub := &due[i]
+		if sub.CancelAtPeriodEnd {
+			record(sub, s.end(sub, StatusCancelled, now))
+			continue
+		}
+		record(sub, s.charge(sub, now))
+	}
+
+	pastDue, err := s.repo.ListPastDue()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range pastDue {
+		sub := &pastDue[i]
+		switch {
+		case sub.GraceUntil != nil && !now.Before(*sub.GraceUntil):
+			record(sub, s.end(sub, StatusExpired, now))
+		case sub.NextRetryAt == nil || !now.Before(*sub.NextRetryAt):
+			record(sub, s.charge(sub, now))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// charge bills the next period of sub at its plan's current price.
+func (s *service) charge(sub *Subscription, now time.Time) error {
+	p, err := s.payments.ChargeMembership(sub.UserID, sub.ID, sub.Plan.Price, sub.PaymentToken)
+	if err != nil {
+		return err
+	}
+	return s.apply(sub, p, now)
+}
+
+// apply moves sub according to the outcome of its latest charge.
+func (s *service) apply(sub *Subscription, p *payment.Payment, now time.Time) error {
+	switch p.Status {
+	case payment.StatusPaid:
+		return s.renewed(sub, p)
+	case payment.StatusFailed:
+		return s.declined(sub, p, now)
+	case payment.StatusPending, payment.StatusAuthorized:
+		sub.PendingPaymentID = &p.ID
+		return s.repo.SetPendingPayment(sub.ID, p.ID)
+	}
+	// Refunded before we saw it paid: treat it as not collected.
+	return s.declined(sub, p, now)
+}
+
+// unsettled runs out the grace period of a renewal whose charge the provider
+// has not settled: a charge that never settles must not keep the tier. A
+// first charge gets the same time from when the member subscribed, so an
+// incomplete subscription doesn't stop them from subscribing again; it never
+// granted the tier, so nothing lapses. The charge stays pending, so the
+// subscription renews if it is paid after all.
+func (s *service) unsettled(sub *Subscription, now time.Time) error {
+	if sub.Status == StatusIncomplete {
+		if now.Before(sub.CreatedAt.Add(s.settings.GracePeriod)) {
+			return nil
+		}
+		sub.Status = StatusExpired
+		sub.EndedAt = &now
+		return s.repo.UpdateSubscription(sub)
+	}
+	if sub.Status != StatusActive && sub.Status != StatusPastDue {
+		return nil
+	}
+	lapses := sub.GraceUntil
+	if lapses == nil {
+		if sub.CurrentPeriodEnd == nil {
+			return nil
+		}
+		grace := sub.CurrentPeriodEnd.Add(s.settings.GracePeriod)
+		lapses = &grace
+	}
+	if now.Before(*lapses) {
+		return nil
+	}
+	return s.end(sub, StatusExpired, now)
+}
+
+// renewed starts the next paid period, right after the previous one so late
+// payments don't shift the billing date, and grants the plan's tier.
+func (s *service) renewed(sub *Subscription, p *payment.Payment) error {
+	start := time.Now()
+	if p.PaidAt != nil {
+		start = *p.PaidAt
+	}
+	if sub.CurrentPeriodEnd != nil {
+		start = *sub.CurrentPeriodEnd
+	}
+	if sub.StartedAt == nil {
+		sub.StartedAt = &start
+	}
+	end := sub.Plan.advance(start)
+	sub.CurrentPeriodStart = &start
+	sub.CurrentPeriodEnd = &end
+	sub.Status = StatusActive
+	sub.EndedAt = nil
+	sub.GraceUntil = nil
+	sub.NextRetryAt = nil
+	sub.FailedAttempts = 0
+	sub.PendingPaymentID = nil
+	sub.LastPaymentID = &p.ID
+	if err := s.repo.ClearPendingPayment(sub.ID, p.ID); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateSubscription(sub); err != nil {
+		return err
+	}
+	if err := s.repo.SetUserTier(sub.UserID, sub.Plan.Tier); err != nil {
+		return err
+	}
+	return s.repo.SetUserActive(sub.UserID, true)
+}
+
+// declined handles a failed charge. A first payment failing ends the
+// subscription; a failed renewal starts (or continues) the grace period.
+func (s *service) declined(sub *Subscription, p *payment.Payment, now time.Time) error {
+	sub.PendingPaymentID = nil
+	sub.LastPaymentID = &p.ID
+	if err := s.repo.ClearPendingPayment(sub.ID, p.ID); err != nil {
+		return err
+	}
+	if sub.EndedAt != nil {
+		// It lapsed while the charge was pending; there is nothing to retry.
+		return s.repo.UpdateSubscription(sub)
+	}
+	sub.FailedAttempts++
+	if sub.Status == StatusIncomplete {
+		sub.Status = StatusExpired
+		sub.EndedAt = &now
+		return s.repo.UpdateSubscription(sub)
+	}
+	if sub.GraceUntil == nil {
+		grace := sub.CurrentPeriodEnd.Add(s.settings.GracePeriod)
+		sub.GraceUntil = &grace
+	}
+	retry := now.Add(s.settings.RetryInterval)
+	sub.NextRetryAt = &retry
+	sub.Status = StatusPastDue
+	return s.repo.UpdateSubscription(sub)
+}
+
+// end closes sub and applies the lapse action to the member.
+func (s *service) end(sub *Subscription, status string, now time.Time) error {
+	sub.Status = status
+	sub.EndedAt = &now
+	sub.NextRetryAt = nil
+	if err := s.repo.UpdateSubscription(sub); err != nil {
+		return err
+	}
+	if s.settings.LapseAction == LapseDeactivate {
+		return s.repo.SetUserActive(sub.UserID, false)
+	}
+	return s.repo.SetUserTier(sub.UserID, s.settings.LapseTier)
+}
@@ -0,0 +1,373 @@
+package membership
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/payment"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testSettings = Settings{
+	GracePeriod:   7 * 24 * time.Hour,
+	RetryInterval: 24 * time.Hour,
+	LapseAction:   LapseDowngrade,
+	LapseTier:     "basic",
+}
+
+// fakePayments settles each charge with the next queued status, paid by default.
+type fakePayments struct {
+	outcomes []string
+	charges  []*payment.Payment
+	byID     map[uint]*payment.Payment
+}
+
+func (f *fakePayments) ChargeMembership(userID, subscriptionID uint, amount money.Money, token string) (*payment.Payment, error) {
+	status := payment.StatusPaid
+	if len(f.outcomes) > 0 {
+		status, f.outcomes = f.outcomes[0], f.outcomes[1:]
+	}
+	p := &payment.Payment{ID: uint(len(f.charges) + 1), UserID: userID, SubscriptionID: &subscriptionID,
+		Amount: amount, Kind: payment.KindMembership, Status: status}
+	if status == payment.StatusPaid {
+		now := time.Now()
+		p.PaidAt = &now
+	}
+	f.charges = append(f.charges, p)
+	if f.byID == nil {
+		f.byID = map[uint]*payment.Payment{}
+	}
+	f.byID[p.ID] = p
+	return p, nil
+}
+
+func (f *fakePayments) GetPayment(id uint) (*payment.Payment, error) {
+	return f.byID[id], nil
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "membership.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Plan{}, &Subscription{}))
+	// Minimal stand-in for the user package's table.
+	require.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, membership_tier TEXT, active BOOLEAN)").Error)
+	require.NoError(t, db.Exec("INSERT INTO users (id, membership_tier, active) VALUES (2, 'basic', true)").Error)
+	return db
+}
+
+func setup(t *testing.T, settings Settings) (*service, *fakePayments, *gorm.DB, *Plan) {
+	db := setupTestDB(t)
+	payments := &fakePayments{}
+	svc := NewService(NewRepository(db), payments, settings).(*service)
+	plan, err := svc.CreatePlan(CreatePlanRequest{Name: "Premium monthly", Tier: "premium",
+		Price: money.New(4900, "USD"), Interval: IntervalMonth})
+	require.NoError(t, err)
+	return svc, payments, db, plan
+}
+
+func userState(t *testing.T, db *gorm.DB) (tier string, active bool) {
+	var row struct {
+		MembershipTier string
+		Active         bool
+	}
+	require.NoError(t, db.Table("users").Where("id = ?", 2).Take(&row).Error)
+	return row.MembershipTier, row.Active
+}
+
+func TestCreatePlan_Validation(t *testing.T) {
+	svc, _, _, _ := setup(t, testSettings)
+
+	_, err := svc.CreatePlan(CreatePlanRequest{Name: "Gold", Tier: "gold", Price: money.New(100, "USD"), Interval: IntervalMonth})
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	_, err = svc.CreatePlan(CreatePlanRequest{Name: "Daily", Tier: "vip", Price: money.New(100, "USD"), Interval: "day"})
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+	_, err = svc.CreatePlan(CreatePlanRequest{Name: "Free", Tier: "vip", Price: money.Zero("USD"), Interval: IntervalYear})
+	assert.ErrorIs(t, err, ErrInvalidPlan)
+}
+
+func TestSubscribe_ActivatesAndGrantsTier(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusActive, sub.Status)
+	require.NotNil(t, sub.CurrentPeriodEnd)
+	assert.Equal(t, sub.CurrentPeriodStart.AddDate(0, 1, 0), *sub.CurrentPeriodEnd)
+	require.Len(t, payments.charges, 1)
+	assert.Equal(t, int64(4900), payments.charges[0].Amount.Amount)
+
+	tier, active := userState(t, db)
+	assert.Equal(t, "premium", tier)
+	assert.True(t, active)
+
+	_, err = svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	assert.ErrorIs(t, err, ErrAlreadySubscribed)
+}
+
+// slowCheckRepo pauses after looking for a current subscription, so
+// concurrent requests all check before any of them creates one.
+type slowCheckRepo struct{ Repository }
+
+func (r slowCheckRepo) FindCurrentByUser(userID uint) (*Subscription, error) {
+	sub, err := r.Repository.FindCurrentByUser(userID)
+	time.Sleep(5 * time.Millisecond)
+	return sub, err
+}
+
+func (r slowCheckRepo) Transaction(fn func(repo Repository) error) error {
+	return r.Repository.Transaction(func(repo Repository) error {
+		return fn(slowCheckRepo{repo})
+	})
+}
+
+func TestSubscribe_OnceUnderConcurrency(t *testing.T) {
+	_, payments, db, plan := setup(t, testSettings)
+	svc := NewService(slowCheckRepo{NewRepository(db)}, payments, testSettings)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, 24)
+	for i := 0; i < 24; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+			errs <- err
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrAlreadySubscribed)
+	}
+	assert.Equal(t, 1, succeeded)
+	assert.Len(t, payments.charges, 1)
+	var count int64
+	require.NoError(t, db.Model(&Subscription{}).Where("user_id = ?", 2).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSubscribe_DeclinedFirstPayment(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	payments.outcomes = []string{payment.StatusFailed}
+
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_declined"})
+	assert.ErrorIs(t, err, ErrPaymentDeclined)
+	assert.Equal(t, StatusExpired, sub.Status)
+
+	tier, _ := userState(t, db)
+	assert.Equal(t, "basic", tier)
+	_, err = svc.GetSubscription(2)
+	assert.ErrorIs(t, err, ErrNoSubscription)
+}
+
+func TestSubscribe_PendingFirstPayment(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	payments.outcomes = []string{payment.StatusPending}
+
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_3ds"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusIncomplete, sub.Status)
+	require.NotNil(t, sub.PendingPaymentID)
+
+	// The provider's webhook settles the charge; the next run activates.
+	payments.byID[*sub.PendingPaymentID].Status = payment.StatusPaid
+	require.NoError(t, svc.processRenewals(time.Now()))
+
+	sub, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	assert.Equal(t, StatusActive, sub.Status)
+	assert.Nil(t, sub.PendingPaymentID)
+	tier, _ := userState(t, db)
+	assert.Equal(t, "premium", tier)
+}
+
+func TestSubscribe_FirstPaymentNeverSettles(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	payments.outcomes = []string{payment.StatusPending, payment.StatusPending}
+
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_3ds"})
+	require.NoError(t, err)
+	require.Equal(t, StatusIncomplete, sub.Status)
+
+	// Still waiting within the grace period.
+	require.NoError(t, svc.processRenewals(sub.CreatedAt.Add(time.Hour)))
+	_, err = svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_3ds"})
+	assert.ErrorIs(t, err, ErrAlreadySubscribed)
+
+	// Past it, the subscription expires and the member may subscribe again.
+	require.NoError(t, svc.processRenewals(sub.CreatedAt.Add(testSettings.GracePeriod)))
+	var expired Subscription
+	require.NoError(t, db.First(&expired, sub.ID).Error)
+	assert.Equal(t, StatusExpired, expired.Status)
+	assert.NotNil(t, expired.EndedAt)
+	tier, active := userState(t, db)
+	assert.Equal(t, "basic", tier)
+	assert.True(t, active)
+
+	again, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_3ds"})
+	require.NoError(t, err)
+	assert.NotEqual(t, sub.ID, again.ID)
+}
+
+func TestUpdateSubscription_KeepsPaymentHandedOverMeanwhile(t *testing.T) {
+	svc, _, _, plan := setup(t, testSettings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+
+	// The front desk hands a payment over while the job holds a stale copy.
+	require.NoError(t, svc.repo.SetPendingPayment(sub.ID, 42))
+	require.NoError(t, svc.repo.UpdateSubscription(sub))
+	assert.Error(t, svc.repo.SetPendingPayment(sub.ID, 43))
+
+	current, err := svc.GetSubscription(2)
+	require.NoError(t, err)
+	require.NotNil(t, current.PendingPaymentID)
+	assert.Equal(t, uint(42), *current.PendingPaymentID)
+}
+
+func TestProcessRenewals_ChargesFromPeriodEnd(t *testing.T) {
+	svc, payments, _, plan := setup(t, testSettings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	periodEnd := *sub.CurrentPeriodEnd
+
+	// Nothing is due yet.
+	require.NoError(t, svc.processRenewals(periodEnd.Add(-time.Hour)))
+	assert.Len(t, payments.charges, 1)
+
+	require.NoError(t, svc.processRenewals(periodEnd.Add(3*time.Hour)))
+	require.Len(t, payments.charges, 2)
+
+	sub, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	assert.Equal(t, StatusActive, sub.Status)
+	assert.True(t, periodEnd.Equal(*sub.CurrentPeriodStart))
+	assert.True(t, periodEnd.AddDate(0, 1, 0).Equal(*sub.CurrentPeriodEnd))
+}
+
+func TestProcessRenewals_GraceThenDowngrade(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	periodEnd := *sub.CurrentPeriodEnd
+	payments.outcomes = []string{payment.StatusFailed, payment.StatusFailed, payment.StatusFailed}
+
+	require.NoError(t, svc.processRenewals(periodEnd))
+	sub, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPastDue, sub.Status)
+	assert.Equal(t, 1, sub.FailedAttempts)
+	assert.True(t, periodEnd.Add(testSettings.GracePeriod).Equal(*sub.GraceUntil))
+	// The member keeps the tier during grace.
+	tier, _ := userState(t, db)
+	assert.Equal(t, "premium", tier)
+
+	// No retry before the retry interval has passed.
+	require.NoError(t, svc.processRenewals(periodEnd.Add(time.Hour)))
+	assert.Len(t, payments.charges, 2)
+
+	require.NoError(t, svc.processRenewals(periodEnd.Add(25*time.Hour)))
+	assert.Len(t, payments.charges, 3)
+
+	require.NoError(t, svc.processRenewals(periodEnd.Add(testSettings.GracePeriod)))
+	_, err = svc.GetSubscription(2)
+	assert.ErrorIs(t, err, ErrNoSubscription)
+	tier, active := userState(t, db)
+	assert.Equal(t, "basic", tier)
+	assert.True(t, active)
+}
+
+func TestProcessRenewals_UnsettledChargeLapses(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	periodEnd := *sub.CurrentPeriodEnd
+	payments.outcomes = []string{payment.StatusPending}
+
+	require.NoError(t, svc.processRenewals(periodEnd))
+	sub, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	require.NotNil(t, sub.PendingPaymentID)
+	pending := *sub.PendingPaymentID
+
+	// The provider never settles: the grace period runs out all the same.
+	require.NoError(t, svc.processRenewals(periodEnd.Add(time.Hour)))
+	_, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	require.NoError(t, svc.processRenewals(periodEnd.Add(testSettings.GracePeriod)))
+	_, err = svc.GetSubscription(2)
+	assert.ErrorIs(t, err, ErrNoSubscription)
+	tier, _ := userState(t, db)
+	assert.Equal(t, "basic", tier)
+	require.NoError(t, svc.processRenewals(periodEnd.Add(testSettings.GracePeriod+time.Hour)))
+	assert.Len(t, payments.charges, 2)
+
+	// Declined at last: it stays lapsed.
+	payments.byID[pending].Status = payment.StatusFailed
+	require.NoError(t, svc.processRenewals(periodEnd.Add(testSettings.GracePeriod+2*time.Hour)))
+	var lapsed Subscription
+	require.NoError(t, db.First(&lapsed, sub.ID).Error)
+	assert.Equal(t, StatusExpired, lapsed.Status)
+	assert.Nil(t, lapsed.PendingPaymentID)
+}
+
+func TestProcessRenewals_RetryRecovers(t *testing.T) {
+	svc, payments, db, plan := setup(t, testSettings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	periodEnd := *sub.CurrentPeriodEnd
+	payments.outcomes = []string{payment.StatusFailed}
+
+	require.NoError(t, svc.processRenewals(periodEnd))
+	require.NoError(t, svc.processRenewals(periodEnd.Add(25*time.Hour)))
+
+	sub, err = svc.GetSubscription(2)
+	require.NoError(t, err)
+	assert.Equal(t, StatusActive, sub.Status)
+	assert.Nil(t, sub.GraceUntil)
+	assert.Zero(t, sub.FailedAttempts)
+	// The late payment doesn't shift the billing date.
+	assert.True(t, periodEnd.AddDate(0, 1, 0).Equal(*sub.CurrentPeriodEnd))
+	tier, _ := userState(t, db)
+	assert.Equal(t, "premium", tier)
+}
+
+func TestCancelSubscription_EndsAtPeriodEnd(t *testing.T) {
+	settings := testSettings
+	settings.LapseAction = LapseDeactivate
+	svc, payments, db, plan := setup(t, settings)
+	sub, err := svc.Subscribe(2, SubscribeRequest{PlanID: plan.ID, PaymentToken: "tok_visa"})
+	require.NoError(t, err)
+	periodEnd := *sub.CurrentPeriodEnd
+
+	sub, err = svc.CancelSubscription(2)
+	require.NoError(t, err)
+	assert.True(t, sub.CancelAtPeriodEnd)
+	assert.Equal(t, StatusActive, sub.Status)
+
+	require.NoError(t, svc.processRenewals(periodEnd))
+	assert.Len(t, payments.charges, 1)
+
+	subs, err := svc.ListSubscriptions(StatusCancelled)
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	_, active := userState(t, db)
+	assert.False(t, active)
+}
@@ -1,27 +1,248 @@
 package payment
 
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
 type CreatePaymentRequest struct {
-	BookingID uint    `json:"booking_id" binding:"required"`
-	Amount    float64 `json:"amount" binding:"required,gt=0"`
-	Method    string  `json:"method" binding:"required"` // card, cash, etc.
+	BookingID uint `json:"booking_id" binding:"required"`
+	// Method is card: cash and card terminal payments are recorded by staff,
+	// see InPersonPaymentRequest.
+	Method string `json:"method" binding:"required,oneof=card"`
+	// PaymentToken references the payment method at the provider. The fake
+	// provider understands tok_success, tok_decline and tok_delay.
+	PaymentToken string `json:"payment_token"`
+	// PromoCode is optional; its discount is taken off the class price.
+	PromoCode string `json:"promo_code"`
 }
 
 type PaymentResponse struct {
-	ID        uint    `json:"id"`
-	UserID    uint    `json:"user_id"`
-	BookingID uint    `json:"booking_id"`
-	Amount    float64 `json:"amount"`
-	Status    string  `json:"status"`
-	Method    string  `json:"method"`
+	ID        uint        `json:"id"`
+	UserID    uint        `json:"user_id"`
+	BookingID uint        `json:"booking_id"`
+	Amount    money.Money `json:"amount"` // gross
+	Net       money.Money `json:"net"`
+	Tax       money.Money `json:"tax"`
+	TaxRate   int         `json:"tax_rate"` // basis points
+	Status    string      `json:"status"`
+	Method    string      `json:"method"`
+	Kind      string      `json:"kind"`
+
+	SubscriptionID  *uint `json:"subscription_id,omitempty"`
+	PackPurchaseID  *uint `json:"pack_purchase_id,omitempty"`
+	PenaltyID       *uint `json:"penalty_id,omitempty"`
+	PaymentMethodID *uint `json:"payment_method_id,omitempty"`
+
+	PromoCode string       `json:"promo_code,omitempty"`
+	Discount  *money.Money `json:"discount,omitempty"`
+
+	RecordedBy    *uint  `json:"recorded_by,omitempty"`
+	TillSessionID *uint  `json:"till_session_id,omitempty"`
+	TerminalRef   string `json:"terminal_ref,omitempty"`
+
+	RefundRequested bool       `json:"refund_requested"`
+	FailureReason   string     `json:"failure_reason,omitempty"`
+	PaidAt          *time.Time `json:"paid_at,omitempty"`
+
+	RefundedAmount money.Money       `json:"refunded_amount"`
+	Refunds        []*RefundResponse `json:"refunds,omitempty"`
 }
 
 func ToPaymentResponse(p *Payment) *PaymentResponse {
-	return &PaymentResponse{
+	resp := &PaymentResponse{
 		ID:        p.ID,
 		UserID:    p.UserID,
 		BookingID: p.BookingID,
 		Amount:    p.Amount,
+		Net:       p.Net,
+		Tax:       p.Tax,
+		TaxRate:   p.TaxRate,
 		Status:    p.Status,
 		Method:    p.Method,
+		Kind:      p.Kind,
+
+		SubscriptionID:  p.SubscriptionID,
+		PackPurchaseID:  p.PackPurchaseID,
+		PenaltyID:       p.PenaltyID,
+		PaymentMethodID: p.PaymentMethodID,
+
+		RecordedBy:    p.RecordedBy,
+		TillSessionID: p.TillSessionID,
+		TerminalRef:   p.TerminalRef,
+
+		RefundRequested: p.RefundRequestedAt != nil,
+		FailureReason:   p.FailureReason,
+		PaidAt:          p.PaidAt,
+
+		RefundedAmount: p.RefundedAmount,
+		Refunds:        ToRefundResponses(p.Refunds),
+	}
+	if p.PromoCode != "" {
+		resp.PromoCode = p.PromoCode
+		resp.Discount = &p.Discount
+	}
+	return resp
+}
+
+type CreateRefundRequest struct {
+	// Amount is optional; without it everything not refunded yet is refunded.
+	Amount *money.Money `json:"amount"`
+	Reason string       `json:"reason" binding:"required"` // member_cancel, class_cancelled, duplicate, service_issue, goodwill, other
+	Note   string       `json:"note"`
+}
+
+type RejectRefundRequest struct {
+	Note string `json:"note"`
+}
+
+type RefundResponse struct {
+	ID            uint        `json:"id"`
+	PaymentID     uint        `json:"payment_id"`
+	UserID        uint        `json:"user_id"`
+	Amount        money.Money `json:"amount"`
+	Reason        string      `json:"reason"`
+	Note          string      `json:"note,omitempty"`
+	Status        string      `json:"status"`
+	RequestedBy   *uint       `json:"requested_by,omitempty"`
+	ReviewedBy    *uint       `json:"reviewed_by,omitempty"`
+	ReviewedAt    *time.Time  `json:"reviewed_at,omitempty"`
+	ReviewNote    string      `json:"review_note,omitempty"`
+	TillSessionID *uint       `json:"till_session_id,omitempty"`
+	FailureReason string      `json:"failure_reason,omitempty"`
+	RefundedAt    *time.Time  `json:"refunded_at,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+}
+
+func ToRefundResponse(r *Refund) *RefundResponse {
+	return &RefundResponse{
+		ID:            r.ID,
+		PaymentID:     r.PaymentID,
+		UserID:        r.UserID,
+		Amount:        r.Amount,
+		Reason:        r.Reason,
+		Note:          r.Note,
+		Status:        r.Status,
+		RequestedBy:   r.RequestedBy,
+		ReviewedBy:    r.ReviewedBy,
+		ReviewedAt:    r.ReviewedAt,
+		ReviewNote:    r.ReviewNote,
+		TillSessionID: r.TillSessionID,
+		FailureReason: r.FailureReason,
+		RefundedAt:    r.RefundedAt,
+		CreatedAt:     r.CreatedAt,
+	}
+}
+
+func ToRefundResponses(refunds []Refund) []*RefundResponse {
+	if len(refunds) == 0 {
+		return nil
+	}
+	resp := make([]*RefundResponse, 0, len(refunds))
+	for i := range refunds {
+		resp = append(resp, ToRefundResponse(&refunds[i]))
+	}
+	return resp
+}
+
+// InPersonPaymentRequest records money a staff member took at the front desk
+// for a booking or a membership subscription; exactly one is set. The amount
+// due is worked out on the server.
+type InPersonPaymentRequest struct {
+	BookingID      uint   `json:"booking_id"`
+	SubscriptionID uint   `json:"subscription_id"`
+	Method         string `json:"method" binding:"required,oneof=cash card_terminal"`
+	// TerminalRef is the receipt reference of a card terminal payment.
+	TerminalRef string `json:"terminal_ref"`
+}
+
+type OpenTillRequest struct {
+	OpeningFloat money.Money `json:"opening_float"`
+}
+
+type CloseTillRequest struct {
+	// Counted is the cash in the drawer at the end of the session.
+	Counted money.Money `json:"counted"`
+	Note    string      `json:"note"`
+}
+
+// CashUpQuery selects the day to cash up, today by default.
+type CashUpQuery struct {
+	Date *time.Time `form:"date" time_format:"2006-01-02"`
+}
+
+// TillSummary is a till session with what went through it. Expected is the
+// opening float plus cash taken less cash refunded; Counted and Variance are
+// set once the till is closed.
+type TillSummary struct {
+	ID           uint         `json:"id"`
+	StaffID      uint         `json:"staff_id"`
+	Status       string       `json:"status"`
+	OpenedAt     time.Time    `json:"opened_at"`
+	ClosedAt     *time.Time   `json:"closed_at,omitempty"`
+	ClosedBy     *uint        `json:"closed_by,omitempty"`
+	OpeningFloat money.Money  `json:"opening_float"`
+	CashTaken    money.Money  `json:"cash_taken"`
+	CashRefunded money.Money  `json:"cash_refunded"`
+	Expected     money.Money  `json:"expected"`
+	Counted      *money.Money `json:"counted,omitempty"`
+	Variance     *money.Money `json:"variance,omitempty"`
+	Terminal     money.Money  `json:"terminal"` // card terminal payments, settled by the acquirer
+	Payments     int64        `json:"payments"`
+	Note         string       `json:"note,omitempty"`
+}
+
+// CashUpTotal adds up one currency's tills of the day. Counted and Variance
+// cover closed tills only; OpenTills were still open.
+type CashUpTotal struct {
+	Currency     string      `json:"currency"`
+	OpeningFloat money.Money `json:"opening_float"`
+	CashTaken    money.Money `json:"cash_taken"`
+	CashRefunded money.Money `json:"cash_refunded"`
+	Expected     money.Money `json:"expected"`
+	Counted      money.Money `json:"counted"`
+	Variance     money.Money `json:"variance"`
+	Terminal     money.Money `json:"terminal"`
+	OpenTills    int         `json:"open_tills"`
+}
+
+// CashUpReport is the end-of-day report of the tills opened on Date.
+type CashUpReport struct {
+	Date   string        `json:"date"`
+	Tills  []TillSummary `json:"tills"`
+	Totals []CashUpTotal `json:"totals"`
+}
+
+type AddPaymentMethodRequest struct {
+	// Token is the card tokenised by the provider on the client; card
+	// numbers are never sent to us.
+	Token string `json:"token" binding:"required"`
+	// MakeDefault makes it the method off-session charges use. A member's
+	// first method is the default anyway.
+	MakeDefault bool `json:"make_default"`
+}
+
+type PaymentMethodResponse struct {
+	ID        uint      `json:"id"`
+	Brand     string    `json:"brand"`
+	Last4     string    `json:"last4"`
+	ExpMonth  int       `json:"exp_month"`
+	ExpYear   int       `json:"exp_year"`
+	Expired   bool      `json:"expired"`
+	IsDefault bool      `json:"is_default"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func ToPaymentMethodResponse(m *PaymentMethod) *PaymentMethodResponse {
+	return &PaymentMethodResponse{
+		ID:        m.ID,
+		Brand:     m.Brand,
+		Last4:     m.Last4,
+		ExpMonth:  m.ExpMonth,
+		ExpYear:   m.ExpYear,
+		Expired:   m.Expired(time.Now()),
+		IsDefault: m.IsDefault,
+		CreatedAt: m.CreatedAt,
 	}
 }
@@ -0,0 +1,256 @@
+package payment
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"gymflow/internal/money"
+)
+
+const FakeProviderName = "fake"
+
+// Tokens understood by FakeProvider. Any other token succeeds.
+const (
+	FakeTokenSuccess = "tok_success"
+	FakeTokenDecline = "tok_decline"
+	FakeTokenDelay   = "tok_delay"
+	FakeTokenExpired = "tok_expired"
+)
+
+var (
+	ErrUnknownIntent = errors.New("unknown payment intent")
+	ErrUnknownToken  = errors.New("unknown payment token")
+)
+
+// FakeProvider is an in-memory gateway for local runs and tests. tok_decline
+// fails the authorisation, tok_delay settles after the configured delay and
+// reports the result through a signed webhook, and tok_expired is a card
+// that expired last year. Refunds of tok_delay payments settle the same way.
+type FakeProvider struct {
+	secret  []byte
+	delay   time.Duration
+	deliver func(payload []byte, signature string)
+
+	mu      sync.Mutex
+	seq     int
+	intents map[string]*Intent
+	delayed map[string]bool // intents paid with tok_delay
+	moves   []fakeMove
+}
+
+// fakeMove is money the fake gateway collected or, when refund is set, paid back.
+type fakeMove struct {
+	at     time.Time
+	amount money.Money
+	refund bool
+}
+
+// NewFakeProvider posts webhooks to callbackURL; pass "" to deliver them only
+// to a handler set with OnWebhook.
+func NewFakeProvider(webhookSecret string, delay time.Duration, callbackURL string) *FakeProvider {
+	f := &FakeProvider{
+		secret:  []byte(webhookSecret),
+		delay:   delay,
+		intents: map[string]*Intent{},
+		delayed: map[string]bool{},
+	}
+	if callbackURL != "" {
+		f.deliver = func(payload []byte, signature string) {
+			req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+			if err != nil {
+				log.Printf("fake provider: %v", err)
+				return
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(WebhookSignatureHeader, signature)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				log.Printf("fake provider: webhook delivery failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+		}
+	}
+	return f
+}
+
+// OnWebhook replaces webhook delivery, e.g. to call the service directly.
+func (f *FakeProvider) OnWebhook(fn func(payload []byte, signature string)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliver = fn
+}
+
+func (f *FakeProvider) Name() string {
+	return FakeProviderName
+}
+
+func (f *FakeProvider) nextID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake_%d", prefix, f.seq)
+}
+
+func (f *FakeProvider) CreateIntent(req IntentRequest) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	in := &Intent{ID: f.nextID("pi")}
+	switch req.Token {
+	case FakeTokenDecline:
+		in.Status = IntentFailed
+		in.FailureReason = "card_declined"
+	case FakeTokenExpired:
+		in.Status = IntentFailed
+		in.FailureReason = "expired_card"
+	case FakeTokenDelay:
+		in.Status = IntentProcessing
+		f.delayed[in.ID] = true
+		id, amount := in.ID, req.Amount
+		time.AfterFunc(f.delay, func() { f.settle(id, amount) })
+	default:
+		in.Status = IntentRequiresCapture
+	}
+	f.intents[in.ID] = in
+	out := *in
+	return &out, nil
+}
+
+// settle completes a delayed intent and reports it like a real gateway would.
+func (f *FakeProvider) settle(intentID string, amount money.Money) {
+	f.mu.Lock()
+	in := f.intents[intentID]
+	in.Status = IntentSucceeded
+	f.moves = append(f.moves, fakeMove{at: time.Now(), amount: amount})
+	deliver := f.deliver
+	f.mu.Unlock()
+
+	f.emit(deliver, WebhookEvent{Type: EventPaymentSucceeded, IntentID: intentID, Amount: amount})
+}
+
+func (f *FakeProvider) emit(deliver func([]byte, string), ev WebhookEvent) {
+	if deliver == nil {
+		return
+	}
+	f.mu.Lock()
+	ev.ID = f.nextID("evt")
+	f.mu.Unlock()
+	payload, _ := json.Marshal(ev)
+	deliver(payload, SignWebhook(f.secret, payload, time.Now()))
+}
+
+func (f *FakeProvider) Capture(intentID string, amount money.Money) (*Intent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	in, ok := f.intents[intentID]
+	if !ok {
+		return nil, ErrUnknownIntent
+	}
+	if in.Status != IntentRequiresCapture {
+		return nil, fmt.Errorf("fake provider: cannot capture intent in status %s", in.Status)
+	}
+	in.Status = IntentSucceeded
+	f.moves = append(f.moves, fakeMove{at: time.Now(), amount: amount})
+	out := *in
+	return &out, nil
+}
+
+func (f *FakeProvider) Refund(intentID string, amount money.Money) (*ProviderRefund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	in, ok := f.intents[intentID]
+	if !ok {
+		return nil, ErrUnknownIntent
+	}
+	if in.Status != IntentSucceeded {
+		return nil, fmt.Errorf("fake provider: cannot refund intent in status %s", in.Status)
+	}
+	ref := &ProviderRefund{ID: f.nextID("re"), Status: IntentSucceeded}
+	if f.delayed[intentID] {
+		ref.Status = IntentProcessing
+		time.AfterFunc(f.delay, func() { f.settleRefund(intentID, ref.ID, amount) })
+		return ref, nil
+	}
+	f.moves = append(f.moves, fakeMove{at: time.Now(), amount: amount, refund: true})
+	return ref, nil
+}
+
+// settleRefund completes a delayed refund and reports it by webhook.
+func (f *FakeProvider) settleRefund(intentID, refundID string, amount money.Money) {
+	f.mu.Lock()
+	f.moves = append(f.moves, fakeMove{at: time.Now(), amount: amount, refund: true})
+	deliver := f.deliver
+	f.mu.Unlock()
+
+	f.emit(deliver, WebhookEvent{Type: EventRefundSucceeded, IntentID: intentID, RefundID: refundID, Amount: amount})
+}
+
+func (f *FakeProvider) Settlements(from, to time.Time) ([]Settlement, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	type key struct {
+		day      time.Time
+		currency string
+	}
+	byDay := map[key]*Settlement{}
+	var order []key
+	for _, m := range f.moves {
+		if m.at.Before(from) || !m.at.Before(to) {
+			continue
+		}
+		at := m.at.UTC()
+		k := key{time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC), m.amount.Currency}
+		st, ok := byDay[k]
+		if !ok {
+			st = &Settlement{Date: k.day, Currency: k.currency, Collected: money.Zero(k.currency), Refunded: money.Zero(k.currency)}
+			byDay[k] = st
+			order = append(order, k)
+		}
+		if m.refund {
+			st.Refunded.Amount += m.amount.Amount
+		} else {
+			st.Collected.Amount += m.amount.Amount
+		}
+	}
+	out := make([]Settlement, 0, len(order))
+	for _, k := range order {
+		out = append(out, *byDay[k])
+	}
+	return out, nil
+}
+
+func (f *FakeProvider) ParseWebhook(payload []byte, signature string) (*WebhookEvent, error) {
+	if err := VerifyWebhook(f.secret, payload, signature, time.Now()); err != nil {
+		return nil, err
+	}
+	var ev WebhookEvent
+	if err := json.Unmarshal(payload, &ev); err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+// DescribeMethod reports every token as a Visa card; tok_decline ends in
+// 0002 like the test card that declines.
+func (f *FakeProvider) DescribeMethod(token string) (*MethodDetails, error) {
+	if token == "" {
+		return nil, ErrUnknownToken
+	}
+	year := time.Now().Year()
+	d := &MethodDetails{Brand: "visa", Last4: "4242", ExpMonth: 12, ExpYear: year + 3}
+	switch token {
+	case FakeTokenDecline:
+		d.Last4 = "0002"
+	case FakeTokenExpired:
+		d.ExpYear = year - 1
+	}
+	return d, nil
+}
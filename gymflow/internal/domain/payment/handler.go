@@ -1,11 +1,16 @@
 package payment
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"time"
 
+	"gymflow/internal/domain/promo"
 	"gymflow/internal/middleware"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 type Handler struct {
@@ -28,12 +33,48 @@ func (h *Handler) CreatePayment(c *gin.Context) {
 
 	p, err := h.service.CreatePayment(userID, req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		switch {
+		case errors.Is(err, ErrBookingNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrForeignBooking):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrPaymentExists), errors.Is(err, ErrBookingNotPayable),
+			errors.Is(err, promo.ErrCodeUsedUp), errors.Is(err, promo.ErrCodeAlreadyUsed):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if p.Status == StatusFailed {
+		c.JSON(http.StatusPaymentRequired, ToPaymentResponse(p))
 		return
 	}
 	c.JSON(http.StatusCreated, ToPaymentResponse(p))
 }
 
+// POST /api/v1/payments/webhook (called by the payment provider, HMAC-signed)
+func (h *Handler) Webhook(c *gin.Context) {
+	payload, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	p, err := h.service.HandleWebhook(payload, c.GetHeader(WebhookSignatureHeader))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSignature):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "payment not found"})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, ToPaymentResponse(p))
+}
+
 // GET /api/v1/payments
 func (h *Handler) ListPayments(c *gin.Context) {
 	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
@@ -51,3 +92,299 @@ func (h *Handler) ListPayments(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, resp)
 }
+
+// POST /api/v1/payments/:id/refunds (trainer/admin)
+func (h *Handler) CreateRefund(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CreateRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	ref, err := h.service.CreateRefund(uri.ID, userIDAny.(uint), roleAny.(string), req)
+	if err != nil {
+		writeRefundError(c, err)
+		return
+	}
+	if ref.Status == RefundPendingApproval {
+		c.JSON(http.StatusAccepted, ToRefundResponse(ref))
+		return
+	}
+	c.JSON(http.StatusCreated, ToRefundResponse(ref))
+}
+
+// GET /api/v1/admin/refunds?status=pending_approval
+func (h *Handler) ListRefunds(c *gin.Context) {
+	refunds, err := h.service.ListRefunds(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list refunds"})
+		return
+	}
+	resp := ToRefundResponses(refunds)
+	if resp == nil {
+		resp = []*RefundResponse{}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/v1/admin/refunds/:id/approve
+func (h *Handler) ApproveRefund(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	ref, err := h.service.ApproveRefund(uri.ID, userIDAny.(uint))
+	if err != nil {
+		writeRefundError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToRefundResponse(ref))
+}
+
+// POST /api/v1/admin/refunds/:id/reject
+func (h *Handler) RejectRefund(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req RejectRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	ref, err := h.service.RejectRefund(uri.ID, userIDAny.(uint), req.Note)
+	if err != nil {
+		writeRefundError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToRefundResponse(ref))
+}
+
+func writeRefundError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, ErrNotRefundable), errors.Is(err, ErrNothingToRefund),
+		errors.Is(err, ErrRefundTooLarge), errors.Is(err, ErrRefundNotPending),
+		errors.Is(err, ErrNoOpenTill), errors.Is(err, ErrTillCurrency):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// POST /api/v1/payments/in-person (trainer/admin)
+func (h *Handler) RecordInPersonPayment(c *gin.Context) {
+	var req InPersonPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	p, err := h.service.RecordInPersonPayment(userIDAny.(uint), req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrBookingNotFound), errors.Is(err, ErrSubscriptionNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, ErrPaymentExists), errors.Is(err, ErrBookingNotPayable),
+			errors.Is(err, ErrSubscriptionNotPayable), errors.Is(err, ErrNothingDue),
+			errors.Is(err, ErrNoOpenTill), errors.Is(err, ErrTillCurrency):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, ToPaymentResponse(p))
+}
+
+// POST /api/v1/tills (trainer/admin)
+func (h *Handler) OpenTill(c *gin.Context) {
+	var req OpenTillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	till, err := h.service.OpenTill(userIDAny.(uint), req)
+	if err != nil {
+		writeTillError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, till)
+}
+
+// GET /api/v1/tills/current (trainer/admin)
+func (h *Handler) CurrentTill(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	sum, err := h.service.CurrentTill(userIDAny.(uint))
+	if err != nil {
+		writeTillError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sum)
+}
+
+// POST /api/v1/tills/:id/close (trainer/admin)
+func (h *Handler) CloseTill(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CloseTillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+	roleAny, _ := c.Get(middleware.ContextRoleKey)
+
+	sum, err := h.service.CloseTill(uri.ID, userIDAny.(uint), roleAny.(string), req)
+	if err != nil {
+		writeTillError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sum)
+}
+
+// GET /api/v1/admin/cash-up?date=2024-05-01
+func (h *Handler) CashUp(c *gin.Context) {
+	var q CashUpQuery
+	if err := c.ShouldBindQuery(&q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	date := time.Now()
+	if q.Date != nil {
+		date = *q.Date
+	}
+	report, err := h.service.CashUp(date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build cash-up report"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func writeTillError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "till session not found"})
+	case errors.Is(err, ErrNoOpenTill):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrForeignTill):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrTillOpen), errors.Is(err, ErrTillClosed), errors.Is(err, ErrTillCurrency):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// GET /api/v1/payment-methods
+func (h *Handler) ListPaymentMethods(c *gin.Context) {
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	methods, err := h.service.ListPaymentMethods(userIDAny.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list payment methods"})
+		return
+	}
+	resp := make([]*PaymentMethodResponse, 0, len(methods))
+	for i := range methods {
+		resp = append(resp, ToPaymentMethodResponse(&methods[i]))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/v1/payment-methods
+func (h *Handler) AddPaymentMethod(c *gin.Context) {
+	var req AddPaymentMethodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	m, err := h.service.AddPaymentMethod(userIDAny.(uint), req)
+	if err != nil {
+		writeMethodError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, ToPaymentMethodResponse(m))
+}
+
+// POST /api/v1/payment-methods/:id/default
+func (h *Handler) SetDefaultPaymentMethod(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	m, err := h.service.SetDefaultPaymentMethod(userIDAny.(uint), uri.ID)
+	if err != nil {
+		writeMethodError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, ToPaymentMethodResponse(m))
+}
+
+// DELETE /api/v1/payment-methods/:id
+func (h *Handler) RemovePaymentMethod(c *gin.Context) {
+	var uri struct {
+		ID uint `uri:"id" binding:"required"`
+	}
+	if err := c.ShouldBindUri(&uri); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userIDAny, _ := c.Get(middleware.ContextUserIDKey)
+
+	if err := h.service.RemovePaymentMethod(userIDAny.(uint), uri.ID); err != nil {
+		writeMethodError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func writeMethodError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrMethodNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMethodExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrMethodExpired), errors.Is(err, ErrInvalidMethod):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payment methods"})
+	}
+}
@@ -0,0 +1,157 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gymflow/internal/domain/booking"
+
+	"gorm.io/gorm"
+)
+
+// failureNoMethod is the failure reason of an off-session charge for a
+// member with no usable stored payment method.
+const failureNoMethod = "no_payment_method"
+
+var (
+	ErrNoPaymentMethod = booking.ErrNoPaymentMethod
+	ErrMethodNotFound  = errors.New("payment method not found")
+	ErrMethodExists    = errors.New("payment method is already saved")
+	ErrMethodExpired   = errors.New("card has expired")
+	ErrInvalidMethod   = errors.New("payment token was not accepted by the provider")
+)
+
+// AddPaymentMethod saves a card the client tokenised with the provider. The
+// provider reports its brand, last digits and expiry.
+func (s *service) AddPaymentMethod(userID uint, req AddPaymentMethodRequest) (*PaymentMethod, error) {
+	details, err := s.provider.DescribeMethod(req.Token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMethod, err)
+	}
+	m := &PaymentMethod{
+		UserID:   userID,
+		Provider: s.provider.Name(),
+		Token:    req.Token,
+		Brand:    details.Brand,
+		Last4:    details.Last4,
+		ExpMonth: details.ExpMonth,
+		ExpYear:  details.ExpYear,
+	}
+	if m.Expired(time.Now()) {
+		return nil, ErrMethodExpired
+	}
+
+	err = s.repo.Transaction(func(repo Repository) error {
+		methods, err := repo.ListMethods(userID)
+		if err != nil {
+			return err
+		}
+		for _, other := range methods {
+			if other.Token == req.Token {
+				return ErrMethodExists
+			}
+		}
+		m.IsDefault = req.MakeDefault || len(methods) == 0 || !methods[0].IsDefault
+		if m.IsDefault {
+			if err := repo.ClearDefaultMethod(userID); err != nil {
+				return err
+			}
+		}
+		return repo.CreateMethod(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *service) ListPaymentMethods(userID uint) ([]PaymentMethod, error) {
+	return s.repo.ListMethods(userID)
+}
+
+// findMethod loads one of the member's methods that was not removed.
+func findMethod(repo Repository, userID, id uint) (*PaymentMethod, error) {
+	m, err := repo.FindMethodByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMethodNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if m.UserID != userID || m.RemovedAt != nil {
+		return nil, ErrMethodNotFound
+	}
+	return m, nil
+}
+
+func (s *service) SetDefaultPaymentMethod(userID, id uint) (*PaymentMethod, error) {
+	var m *PaymentMethod
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if m, err = findMethod(repo, userID, id); err != nil {
+			return err
+		}
+		if m.Expired(time.Now()) {
+			return ErrMethodExpired
+		}
+		if err := repo.ClearDefaultMethod(userID); err != nil {
+			return err
+		}
+		m.IsDefault = true
+		return repo.UpdateMethod(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RemovePaymentMethod forgets the method's token. Removing the default makes
+// the newest card that has not expired the default.
+func (s *service) RemovePaymentMethod(userID, id uint) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		m, err := findMethod(repo, userID, id)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		wasDefault := m.IsDefault
+		m.RemovedAt = &now
+		m.Token = ""
+		m.IsDefault = false
+		if err := repo.UpdateMethod(m); err != nil {
+			return err
+		}
+		if !wasDefault {
+			return nil
+		}
+		methods, err := repo.ListMethods(userID)
+		if err != nil {
+			return err
+		}
+		for i := range methods {
+			if !methods[i].Expired(now) {
+				methods[i].IsDefault = true
+				return repo.UpdateMethod(&methods[i])
+			}
+		}
+		return nil
+	})
+}
+
+// storedMethod is the method off-session charges use: the default, or while
+// that has expired the newest card that has not.
+func (s *service) storedMethod(userID uint) (*PaymentMethod, error) {
+	methods, err := s.repo.ListMethods(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range methods {
+		if !methods[i].Expired(now) {
+			return &methods[i], nil
+		}
+	}
+	return nil, ErrNoPaymentMethod
+}
@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func methodSetup(t *testing.T) Service {
+	dsn := filepath.Join(t.TempDir(), "methods.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Payment{}, &PaymentMethod{}))
+	// Subscriptions 1 and 2 of member 2, owned by the membership package.
+	require.NoError(t, db.Exec("CREATE TABLE plans (id integer primary key, price_minor integer, price_currency text)").Error)
+	require.NoError(t, db.Exec("CREATE TABLE subscriptions (id integer primary key, user_id integer, plan_id integer, status text, pending_payment_id integer)").Error)
+	require.NoError(t, db.Exec("INSERT INTO plans VALUES (1, 3000, 'USD')").Error)
+	require.NoError(t, db.Exec("INSERT INTO subscriptions VALUES (1, 2, 1, 'active', NULL), (2, 2, 1, 'active', NULL)").Error)
+	return NewService(NewRepository(db), NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+}
+
+func TestPaymentMethods(t *testing.T) {
+	service := methodSetup(t)
+
+	first, err := service.AddPaymentMethod(2, AddPaymentMethodRequest{Token: FakeTokenSuccess})
+	require.NoError(t, err)
+	assert.True(t, first.IsDefault, "a member's first method is the default")
+	assert.Equal(t, "visa", first.Brand)
+	assert.Equal(t, "4242", first.Last4)
+
+	second, err := service.AddPaymentMethod(2, AddPaymentMethodRequest{Token: FakeTokenDecline})
+	require.NoError(t, err)
+	assert.False(t, second.IsDefault)
+	assert.Equal(t, "0002", second.Last4)
+
+	_, err = service.AddPaymentMethod(2, AddPaymentMethodRequest{Token: FakeTokenSuccess})
+	assert.ErrorIs(t, err, ErrMethodExists)
+	_, err = service.AddPaymentMethod(2, AddPaymentMethodRequest{Token: FakeTokenExpired})
+	assert.ErrorIs(t, err, ErrMethodExpired)
+	_, err = service.SetDefaultPaymentMethod(3, second.ID)
+	assert.ErrorIs(t, err, ErrMethodNotFound, "another member's method")
+
+	_, err = service.SetDefaultPaymentMethod(2, second.ID)
+	require.NoError(t, err)
+	methods, err := service.ListPaymentMethods(2)
+	require.NoError(t, err)
+	require.Len(t, methods, 2)
+	assert.Equal(t, second.ID, methods[0].ID)
+	assert.True(t, methods[0].IsDefault)
+	assert.False(t, methods[1].IsDefault)
+
+	// Off-session charges use the default, which declines here.
+	p, err := service.ChargeMembership(2, 1, usd(3000), "")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, p.Status)
+	assert.Equal(t, second.ID, *p.PaymentMethodID)
+
+	// Removing the default promotes the other card.
+	require.NoError(t, service.RemovePaymentMethod(2, second.ID))
+	assert.ErrorIs(t, service.RemovePaymentMethod(2, second.ID), ErrMethodNotFound)
+	methods, err = service.ListPaymentMethods(2)
+	require.NoError(t, err)
+	require.Len(t, methods, 1)
+	assert.Equal(t, first.ID, methods[0].ID)
+	assert.True(t, methods[0].IsDefault)
+
+	p, err = service.ChargeClassPack(2, 1, usd(5000), "")
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaid, p.Status)
+	assert.Equal(t, first.ID, *p.PaymentMethodID)
+
+	// Without a stored method the charge fails like a declined card.
+	require.NoError(t, service.RemovePaymentMethod(2, first.ID))
+	p, err = service.ChargeMembership(2, 2, usd(3000), "")
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, p.Status)
+	assert.Equal(t, failureNoMethod, p.FailureReason)
+	assert.Nil(t, p.PaymentMethodID)
+}
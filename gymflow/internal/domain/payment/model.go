@@ -1,14 +1,203 @@
 package payment
 
-import "time"
+import (
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
+)
+
+// Payment states. Allowed moves between them are listed in transitions. They
+// share values with booking.PaymentStatus, which mirrors class payments.
+const (
+	StatusPending           = booking.PaymentStatusPending
+	StatusAuthorized        = booking.PaymentStatusAuthorized
+	StatusPaid              = booking.PaymentStatusPaid
+	StatusFailed            = booking.PaymentStatusFailed
+	StatusRefunded          = booking.PaymentStatusRefunded
+	StatusPartiallyRefunded = booking.PaymentStatusPartiallyRefunded
+)
+
+const (
+	KindBooking       = "booking"
+	KindLateCancelFee = "late_cancel_fee"
+	KindNoShowFee     = "no_show_fee"
+	KindMembership    = "membership"
+	KindClassPack     = "class_pack"
+)
+
+// Payment methods. Members pay online by card; cash and card terminal
+// payments are taken at the front desk and recorded by staff.
+const (
+	MethodCard     = "card"
+	MethodCash     = "cash"
+	MethodTerminal = "card_terminal"
+)
 
 type Payment struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	UserID    uint      `json:"user_id"`
-	BookingID uint      `json:"booking_id"`
-	Amount    float64   `json:"amount"`
-	Status    string    `json:"status"`
-	Method    string    `json:"method"`
+	ID        uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	UserID    uint        `json:"user_id"`
+	BookingID uint        `json:"booking_id"`
+	Amount    money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Status    string      `json:"status"`
+	Method    string      `json:"method"`
+	// Kind tells class payments apart from penalty fees charged on the same booking.
+	Kind string `gorm:"default:booking;index" json:"kind"`
+	// SubscriptionID is the membership subscription a membership payment renews.
+	SubscriptionID *uint `gorm:"index" json:"subscription_id,omitempty"`
+	// PackPurchaseID is the class pack purchase a class_pack payment pays for.
+	PackPurchaseID *uint `gorm:"index" json:"pack_purchase_id,omitempty"`
+	// PenaltyID is the booking penalty a fee payment charges.
+	PenaltyID *uint `gorm:"index" json:"penalty_id,omitempty"`
+	// PaymentMethodID is the stored payment method an off-session charge used.
+	PaymentMethodID *uint `gorm:"index" json:"payment_method_id,omitempty"`
+
+	// Amount is what is charged after Discount, which the promo code
+	// PromoCode took off the class price. PromoRedemptionID is that use of
+	// the code.
+	PromoCode         string      `json:"promo_code,omitempty"`
+	Discount          money.Money `gorm:"embedded;embeddedPrefix:discount_" json:"discount"`
+	PromoRedemptionID *uint       `json:"-"`
+
+	// Amount is the gross; Net and Tax split it at TaxRate (basis points),
+	// the rate of the payment's product type when it was created.
+	Net     money.Money `gorm:"embedded;embeddedPrefix:net_" json:"net"`
+	Tax     money.Money `gorm:"embedded;embeddedPrefix:tax_" json:"tax"`
+	TaxRate int         `json:"tax_rate"`
+
+	// RecordedBy is the staff member who took an in-person payment at the
+	// till session TillSessionID. TerminalRef is the card terminal's receipt
+	// reference.
+	RecordedBy    *uint  `json:"recorded_by,omitempty"`
+	TillSessionID *uint  `gorm:"index" json:"till_session_id,omitempty"`
+	TerminalRef   string `json:"terminal_ref,omitempty"`
+
+	// RefundRequestedAt is set when the booking was dropped by a class cancellation.
+	RefundRequestedAt *time.Time `json:"refund_requested_at,omitempty"`
+
+	// Provider and ProviderRef (the provider's intent id) link the row to the gateway.
+	Provider      string     `json:"provider"`
+	ProviderRef   string     `gorm:"index" json:"provider_ref"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	PaidAt        *time.Time `json:"paid_at,omitempty"`
+
+	// RefundedAmount is the sum of succeeded refunds.
+	RefundedAmount money.Money `gorm:"embedded;embeddedPrefix:refunded_" json:"refunded_amount"`
+	Refunds        []Refund    `gorm:"foreignKey:PaymentID" json:"refunds,omitempty"`
+}
+
+// InPerson reports whether p was taken at the front desk rather than
+// through the provider.
+func (p *Payment) InPerson() bool {
+	return p.Method == MethodCash || p.Method == MethodTerminal
+}
+
+// ProductType is the tax product type of what p pays for. Class packs are
+// prepaid classes, and penalty fees are charged on class bookings.
+func (p *Payment) ProductType() string {
+	if p.Kind == KindMembership {
+		return tax.ProductMembership
+	}
+	return tax.ProductClass
+}
+
+const (
+	RefundPendingApproval = "pending_approval"
+	RefundProcessing      = "processing"
+	RefundSucceeded       = "succeeded"
+	RefundFailed          = "failed"
+	RefundRejected        = "rejected"
+)
+
+// Refund reason codes. The first three are issued automatically by booking.
+const (
+	RefundReasonMemberCancel   = booking.RefundReasonMemberCancel
+	RefundReasonClassCancelled = booking.RefundReasonClassCancelled
+	RefundReasonFeeWaived      = booking.RefundReasonFeeWaived
+	RefundReasonDuplicate      = "duplicate"
+	RefundReasonServiceIssue   = "service_issue"
+	RefundReasonGoodwill       = "goodwill"
+	RefundReasonOther          = "other"
+)
+
+// Refund returns all or part of a paid Payment. Refunds above the approval
+// threshold wait in pending_approval until an admin approves or rejects them.
+type Refund struct {
+	ID        uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	PaymentID uint        `gorm:"index" json:"payment_id"`
+	UserID    uint        `gorm:"index" json:"user_id"`
+	Amount    money.Money `gorm:"embedded;embeddedPrefix:amount_" json:"amount"`
+	Reason    string      `json:"reason"`
+	Note      string      `json:"note,omitempty"`
+	Status    string      `gorm:"index" json:"status"`
+
+	// RequestedBy is the staff member who asked for the refund; nil for
+	// refunds issued automatically by cancellations.
+	RequestedBy *uint      `json:"requested_by,omitempty"`
+	ReviewedBy  *uint      `json:"reviewed_by,omitempty"`
+	ReviewedAt  *time.Time `json:"reviewed_at,omitempty"`
+	ReviewNote  string     `json:"review_note,omitempty"`
+
+	// TillSessionID is the till a cash refund was paid out of.
+	TillSessionID *uint `gorm:"index" json:"till_session_id,omitempty"`
+
+	ProviderRef   string     `json:"provider_ref,omitempty"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	RefundedAt    *time.Time `json:"refunded_at,omitempty"`
+}
+
+const (
+	TillOpen   = "open"
+	TillClosed = "closed"
+)
+
+// TillSession is one staff member's shift at a cash drawer. It opens with a
+// counted float and closes with the cash counted at the end; Expected is
+// the opening float plus cash taken less cash refunded, and Variance is what
+// was counted less that (negative when the till is short).
+type TillSession struct {
+	ID           uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt    time.Time   `json:"created_at"`
+	UpdatedAt    time.Time   `json:"updated_at"`
+	StaffID      uint        `gorm:"index" json:"staff_id"`
+	Status       string      `gorm:"size:10;index" json:"status"`
+	OpeningFloat money.Money `gorm:"embedded;embeddedPrefix:opening_float_" json:"opening_float"`
+	OpenedAt     time.Time   `gorm:"index" json:"opened_at"`
+
+	ClosingFloat money.Money `gorm:"embedded;embeddedPrefix:closing_float_" json:"closing_float"`
+	Expected     money.Money `gorm:"embedded;embeddedPrefix:expected_" json:"expected"`
+	Variance     money.Money `gorm:"embedded;embeddedPrefix:variance_" json:"variance"`
+	ClosedBy     *uint       `json:"closed_by,omitempty"`
+	ClosedAt     *time.Time  `json:"closed_at,omitempty"`
+	Note         string      `json:"note,omitempty"`
+}
+
+// PaymentMethod is a card saved at the provider, charged when the member is
+// not present. Only the provider's token and the details it reports for
+// display are stored, never card data. A removed method keeps its row for
+// the payments that used it but loses its token.
+type PaymentMethod struct {
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	UserID    uint       `gorm:"index" json:"user_id"`
+	Provider  string     `gorm:"size:32" json:"provider"`
+	Token     string     `gorm:"size:255" json:"-"`
+	Brand     string     `gorm:"size:32" json:"brand"`
+	Last4     string     `gorm:"size:4" json:"last4"`
+	ExpMonth  int        `json:"exp_month"`
+	ExpYear   int        `json:"exp_year"`
+	IsDefault bool       `gorm:"not null;default:false" json:"is_default"`
+	RemovedAt *time.Time `gorm:"index" json:"removed_at,omitempty"`
+}
+
+// Expired reports whether the card's expiry month has ended by now.
+func (m *PaymentMethod) Expired(now time.Time) bool {
+	end := time.Date(m.ExpYear, time.Month(m.ExpMonth)+1, 1, 0, 0, 0, 0, time.UTC)
+	return !now.Before(end)
 }
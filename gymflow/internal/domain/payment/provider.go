@@ -0,0 +1,151 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gymflow/internal/money"
+)
+
+// Intent statuses reported by a Provider.
+const (
+	IntentRequiresCapture = "requires_capture"
+	IntentProcessing      = "processing"
+	IntentSucceeded       = "succeeded"
+	IntentFailed          = "failed"
+)
+
+// Webhook event types a Provider can report.
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+	EventRefundSucceeded  = "refund.succeeded"
+	EventRefundFailed     = "refund.failed"
+)
+
+// WebhookSignatureHeader carries "t=<unix>,v1=<hex hmac>" on webhook calls.
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// webhookTolerance bounds how old a signed webhook may be, against replays.
+const webhookTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrUnknownProvider  = errors.New("unknown payment provider")
+)
+
+type IntentRequest struct {
+	Amount    money.Money
+	Token     string // payment method reference from the client
+	Reference string // our payment id, echoed back in webhooks
+}
+
+type Intent struct {
+	ID            string
+	Status        string
+	FailureReason string
+}
+
+type ProviderRefund struct {
+	ID     string
+	Status string
+}
+
+// Settlement is what a provider collected and refunded in one currency on
+// one UTC day.
+type Settlement struct {
+	Date      time.Time // midnight UTC
+	Currency  string
+	Collected money.Money
+	Refunded  money.Money
+}
+
+// MethodDetails is what a provider reports about the card behind a token,
+// for display.
+type MethodDetails struct {
+	Brand    string
+	Last4    string
+	ExpMonth int
+	ExpYear  int
+}
+
+type WebhookEvent struct {
+	ID            string      `json:"id"`
+	Type          string      `json:"type"`
+	IntentID      string      `json:"intent_id"`
+	RefundID      string      `json:"refund_id,omitempty"` // refund events only
+	Amount        money.Money `json:"amount"`
+	FailureReason string      `json:"failure_reason,omitempty"`
+}
+
+// Provider is a payment gateway. CreateIntent authorises the amount; Capture
+// collects it. A provider that settles asynchronously returns
+// IntentProcessing and reports the outcome through a webhook; so can Refund.
+type Provider interface {
+	Name() string
+	CreateIntent(req IntentRequest) (*Intent, error)
+	Capture(intentID string, amount money.Money) (*Intent, error)
+	Refund(intentID string, amount money.Money) (*ProviderRefund, error)
+	// ParseWebhook verifies the signature header and decodes the event.
+	ParseWebhook(payload []byte, signature string) (*WebhookEvent, error)
+	// Settlements reports collections and refunds per day in [from, to).
+	Settlements(from, to time.Time) ([]Settlement, error)
+	// DescribeMethod looks up the card behind a token the client got from
+	// the provider; card data never reaches us.
+	DescribeMethod(token string) (*MethodDetails, error)
+}
+
+// NewProvider builds the provider configured by name.
+func NewProvider(name, webhookSecret, callbackURL string, delay time.Duration) (Provider, error) {
+	switch name {
+	case "", FakeProviderName:
+		return NewFakeProvider(webhookSecret, delay, callbackURL), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+	}
+}
+
+// SignWebhook returns the signature header value for payload.
+func SignWebhook(secret, payload []byte, at time.Time) string {
+	ts := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + ts + ",v1=" + webhookMAC(secret, ts, payload)
+}
+
+// VerifyWebhook checks a header produced by SignWebhook.
+func VerifyWebhook(secret, payload []byte, header string, now time.Time) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(webhookMAC(secret, ts, payload))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func webhookMAC(secret []byte, ts string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
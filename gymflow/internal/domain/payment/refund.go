@@ -0,0 +1,354 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+// adminRole mirrors user.RoleAdmin; admins approve their own refunds.
+const adminRole = "admin"
+
+var (
+	ErrNotRefundable       = errors.New("only paid payments can be refunded")
+	ErrNothingToRefund     = errors.New("payment is already fully refunded")
+	ErrRefundTooLarge      = errors.New("refund exceeds the refundable amount")
+	ErrInvalidRefundReason = errors.New("invalid refund reason")
+	ErrRefundNotPending    = errors.New("refund is not awaiting approval")
+)
+
+var refundReasons = map[string]bool{
+	RefundReasonMemberCancel:   true,
+	RefundReasonClassCancelled: true,
+	RefundReasonDuplicate:      true,
+	RefundReasonServiceIssue:   true,
+	RefundReasonGoodwill:       true,
+	RefundReasonOther:          true,
+}
+
+// CreateRefund is a staff refund of a payment. Without an amount it refunds
+// whatever is left. Refunds above the approval threshold requested by
+// non-admins wait for an admin.
+func (s *service) CreateRefund(paymentID, staffID uint, staffRole string, req CreateRefundRequest) (*Refund, error) {
+	if !refundReasons[req.Reason] {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidRefundReason, req.Reason)
+	}
+	if req.Amount != nil && !req.Amount.IsPositive() {
+		return nil, errors.New("refund amount must be positive")
+	}
+	approve := func(amount money.Money) bool {
+		if staffRole == adminRole {
+			return true
+		}
+		// A threshold in another currency can't be compared; ask an admin.
+		c, err := amount.Cmp(s.refundApprovalThreshold)
+		return err == nil && c <= 0
+	}
+	return s.issueRefund(paymentID, req.Amount, req.Reason, req.Note, &staffID, approve)
+}
+
+// RefundBookings refunds the paid class payments of bookings dropped by a
+// free cancellation or a class cancellation. These follow the cancellation
+// policy, so they skip admin approval. Payments with nothing left to refund
+// (or refunded since they were listed) are skipped, which keeps retried
+// cancellations harmless.
+func (s *service) RefundBookings(bookingIDs []uint, reason string) error {
+	if len(bookingIDs) == 0 {
+		return nil
+	}
+	if reason == RefundReasonClassCancelled {
+		if err := s.MarkForRefund(bookingIDs); err != nil {
+			return err
+		}
+	}
+	payments, err := s.repo.ListRefundableByBookings(bookingIDs)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, p := range payments {
+		_, err := s.issueRefund(p.ID, nil, reason, "", nil, func(money.Money) bool { return true })
+		if err != nil && !errors.Is(err, ErrNothingToRefund) && !errors.Is(err, ErrNotRefundable) {
+			errs = append(errs, fmt.Errorf("payment %d: %w", p.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RefundPayment refunds whatever is left on a payment, e.g. a waived fee. It
+// is idempotent.
+func (s *service) RefundPayment(paymentID uint, reason string) error {
+	_, err := s.issueRefund(paymentID, nil, reason, "", nil, func(money.Money) bool { return true })
+	if errors.Is(err, ErrNothingToRefund) {
+		return nil
+	}
+	return err
+}
+
+// issueRefund reserves the amount (nil for all that is left) against the
+// payment under its lock, then executes the refund at the provider unless it
+// needs approval first.
+func (s *service) issueRefund(paymentID uint, amount *money.Money, reason, note string, requestedBy *uint, approved func(amount money.Money) bool) (*Refund, error) {
+	var (
+		ref     *Refund
+		payment *Payment
+	)
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if payment, err = repo.LockPayment(paymentID); err != nil {
+			return err
+		}
+		if !payment.refundable() {
+			return fmt.Errorf("%w: payment is %s", ErrNotRefundable, payment.Status)
+		}
+		held, err := repo.SumActiveRefunds(payment.ID)
+		if err != nil {
+			return err
+		}
+		left := money.New(payment.Amount.Amount-held, payment.Amount.Currency)
+		if !left.IsPositive() {
+			return ErrNothingToRefund
+		}
+		want := left
+		if amount != nil {
+			want = *amount
+		}
+		c, err := want.Cmp(left)
+		if err != nil {
+			return err
+		}
+		if c > 0 {
+			return fmt.Errorf("%w: %s left", ErrRefundTooLarge, left)
+		}
+
+		ref = &Refund{
+			PaymentID:   payment.ID,
+			UserID:      payment.UserID,
+			Amount:      want,
+			Reason:      reason,
+			Note:        note,
+			Status:      RefundPendingApproval,
+			RequestedBy: requestedBy,
+		}
+		if approved(want) {
+			ref.Status = RefundProcessing
+		}
+		if payment.Method == MethodCash {
+			if err := payOutOf(repo, ref, requestedBy); err != nil {
+				return err
+			}
+		}
+		return repo.CreateRefund(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ref.Status == RefundProcessing {
+		if err := s.executeRefund(ref, payment); err != nil {
+			return nil, err
+		}
+	}
+	return ref, nil
+}
+
+// executeRefund sends a processing refund to the provider. A provider error
+// fails the refund, which releases its amount; it is not returned. A succeeded
+// refund moves the payment to partially_refunded or refunded. A refund the
+// provider is still processing stays processing until its webhook arrives.
+// In-person payments are refunded at the desk, in cash or on the card
+// terminal, so their refunds succeed without the provider.
+func (s *service) executeRefund(ref *Refund, payment *Payment) error {
+	if payment.InPerson() {
+		now := time.Now()
+		ref.Status = RefundSucceeded
+		ref.RefundedAt = &now
+	} else {
+		s.refundAtProvider(ref, payment)
+	}
+
+	err := s.repo.Transaction(func(repo Repository) error {
+		if err := repo.UpdateRefund(ref); err != nil {
+			return err
+		}
+		if ref.Status != RefundSucceeded {
+			return nil
+		}
+		p, err := repo.LockPayment(payment.ID)
+		if err != nil {
+			return err
+		}
+		return applyRefund(repo, p, ref)
+	})
+	if err != nil {
+		return err
+	}
+	s.bookRefund(ref)
+	return nil
+}
+
+// applyRefund adds a succeeded refund to its locked payment.
+func applyRefund(repo Repository, p *Payment, ref *Refund) error {
+	var err error
+	if p.RefundedAmount, err = p.RefundedAmount.Add(ref.Amount); err != nil {
+		return err
+	}
+	next := StatusPartiallyRefunded
+	if p.RefundedAmount.Amount >= p.Amount.Amount {
+		next = StatusRefunded
+	}
+	if err := p.transition(next); err != nil {
+		return err
+	}
+	return saveIn(repo, p)
+}
+
+// bookRefund issues the credit note and the journal entry of a refund that
+// just succeeded.
+func (s *service) bookRefund(ref *Refund) {
+	if ref.Status != RefundSucceeded {
+		return
+	}
+	if s.invoices != nil {
+		// Like invoices, a missing credit note is caught up by the backfill job.
+		if err := s.invoices.IssueForRefund(ref); err != nil {
+			log.Printf("credit note for refund %d: %v", ref.ID, err)
+		}
+	}
+	if s.ledger != nil {
+		if err := s.ledger.RecordRefund(ref); err != nil {
+			log.Printf("ledger for refund %d: %v", ref.ID, err)
+		}
+	}
+}
+
+// settleRefund applies a refund webhook to the processing refund it reports
+// on. The refund is re-read under its payment's lock, so a repeated event
+// finds it settled and changes nothing.
+func (s *service) settleRefund(payment *Payment, ev *WebhookEvent) (*Payment, error) {
+	found, err := s.repo.FindRefundByProviderRef(ev.RefundID)
+	if err != nil {
+		return nil, err
+	}
+	if found.PaymentID != payment.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var ref *Refund
+	err = s.repo.Transaction(func(repo Repository) error {
+		p, err := repo.LockPayment(payment.ID)
+		if err != nil {
+			return err
+		}
+		payment = p
+		if ref, err = repo.FindRefundByID(found.ID); err != nil {
+			return err
+		}
+		if ref.Status != RefundProcessing {
+			ref = nil
+			return nil
+		}
+		if ev.Type == EventRefundFailed {
+			ref.Status = RefundFailed
+			ref.FailureReason = ev.FailureReason
+			return repo.UpdateRefund(ref)
+		}
+		now := time.Now()
+		ref.Status = RefundSucceeded
+		ref.RefundedAt = &now
+		if err := repo.UpdateRefund(ref); err != nil {
+			return err
+		}
+		return applyRefund(repo, p, ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ref != nil {
+		s.bookRefund(ref)
+	}
+	return payment, nil
+}
+
+func (s *service) refundAtProvider(ref *Refund, payment *Payment) {
+	res, err := s.provider.Refund(payment.ProviderRef, ref.Amount)
+	switch {
+	case err != nil:
+		ref.Status = RefundFailed
+		ref.FailureReason = err.Error()
+	case res.Status == IntentSucceeded:
+		now := time.Now()
+		ref.Status = RefundSucceeded
+		ref.ProviderRef = res.ID
+		ref.RefundedAt = &now
+	case res.Status == IntentFailed:
+		ref.Status = RefundFailed
+		ref.ProviderRef = res.ID
+	default:
+		ref.ProviderRef = res.ID
+	}
+}
+
+// reviewRefund moves a pending refund out of pending_approval under the lock
+// of its payment, so two admins cannot both approve it.
+func (s *service) reviewRefund(id, adminID uint, status, note string) (*Refund, *Payment, error) {
+	var (
+		ref     *Refund
+		payment *Payment
+	)
+	err := s.repo.Transaction(func(repo Repository) error {
+		var err error
+		if ref, err = repo.FindRefundByID(id); err != nil {
+			return err
+		}
+		if payment, err = repo.LockPayment(ref.PaymentID); err != nil {
+			return err
+		}
+		if ref, err = repo.FindRefundByID(id); err != nil {
+			return err
+		}
+		if ref.Status != RefundPendingApproval {
+			return fmt.Errorf("%w: refund is %s", ErrRefundNotPending, ref.Status)
+		}
+		now := time.Now()
+		ref.Status = status
+		ref.ReviewedBy = &adminID
+		ref.ReviewedAt = &now
+		if note != "" {
+			ref.ReviewNote = note
+		}
+		if payment.Method == MethodCash {
+			if err := payOutOf(repo, ref, &adminID); err != nil {
+				return err
+			}
+		}
+		return repo.UpdateRefund(ref)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, payment, nil
+}
+
+func (s *service) ApproveRefund(id, adminID uint) (*Refund, error) {
+	ref, payment, err := s.reviewRefund(id, adminID, RefundProcessing, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := s.executeRefund(ref, payment); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (s *service) RejectRefund(id, adminID uint, note string) (*Refund, error) {
+	ref, _, err := s.reviewRefund(id, adminID, RefundRejected, note)
+	return ref, err
+}
+
+func (s *service) ListRefunds(status string) ([]Refund, error) {
+	return s.repo.ListRefunds(status)
+}
@@ -0,0 +1,232 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// paidPayment pays booking 1 (50.00 USD) through the fake provider so that the
+// provider knows the intent being refunded.
+func paidPayment(t *testing.T, service Service, mockRepo *MockPaymentRepository) *Payment {
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound).Twice()
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil).Once()
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil).Twice()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.AnythingOfType("string")).Return(nil).Times(3)
+
+	p, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card"})
+	require.NoError(t, err)
+	require.Equal(t, StatusPaid, p.Status)
+	p.ID = 1
+	return p
+}
+
+func TestCreateRefund_PartialThenRest(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	invoices := &fakeInvoices{}
+	ledger := &fakeLedger{}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), invoices, ledger, nil, testApprovalThreshold, testTaxes)
+	p := paidPayment(t, service, mockRepo)
+
+	mockRepo.On("LockPayment", uint(1)).Return(p, nil)
+	mockRepo.On("CreateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("UpdateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(0), nil).Once()
+	mockRepo.On("Update", p).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusPartiallyRefunded).Return(nil).Once()
+
+	ref, err := service.CreateRefund(1, 9, "trainer", CreateRefundRequest{Amount: ptr(usd(2000)), Reason: RefundReasonServiceIssue})
+	require.NoError(t, err)
+	assert.Equal(t, RefundSucceeded, ref.Status)
+	assert.Equal(t, usd(2000), ref.Amount)
+	assert.NotEmpty(t, ref.ProviderRef)
+	assert.Equal(t, uint(9), *ref.RequestedBy)
+	assert.Equal(t, StatusPartiallyRefunded, p.Status)
+	assert.Equal(t, usd(2000), p.RefundedAmount)
+
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(2000), nil)
+	_, err = service.CreateRefund(1, 9, "trainer", CreateRefundRequest{Amount: ptr(usd(3001)), Reason: RefundReasonServiceIssue})
+	assert.ErrorIs(t, err, ErrRefundTooLarge)
+
+	// No amount refunds what is left.
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusRefunded).Return(nil).Once()
+	rest, err := service.CreateRefund(1, 9, "trainer", CreateRefundRequest{Reason: RefundReasonServiceIssue})
+	require.NoError(t, err)
+	assert.Equal(t, usd(3000), rest.Amount)
+	assert.Equal(t, RefundSucceeded, rest.Status)
+	assert.Equal(t, StatusRefunded, p.Status)
+
+	_, err = service.CreateRefund(1, 9, "trainer", CreateRefundRequest{Reason: RefundReasonServiceIssue})
+	assert.ErrorIs(t, err, ErrNotRefundable)
+	// Each succeeded refund asked for a credit note, even though invoicing failed.
+	assert.Len(t, invoices.refunds, 2)
+	assert.Len(t, ledger.refunds, 2)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateRefund_AboveThresholdNeedsApproval(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+	p := paidPayment(t, service, mockRepo)
+
+	var stored *Refund
+	mockRepo.On("LockPayment", uint(1)).Return(p, nil)
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(0), nil)
+	mockRepo.On("CreateRefund", mock.AnythingOfType("*payment.Refund")).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*Refund)
+		stored.ID = 4
+	}).Return(nil)
+
+	ref, err := service.CreateRefund(1, 9, "trainer", CreateRefundRequest{Reason: RefundReasonDuplicate})
+	require.NoError(t, err)
+	assert.Equal(t, RefundPendingApproval, ref.Status)
+	assert.Equal(t, usd(5000), ref.Amount)
+	assert.Equal(t, StatusPaid, p.Status)
+
+	mockRepo.On("FindRefundByID", uint(4)).Return(stored, nil)
+	mockRepo.On("UpdateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("Update", p).Return(nil).Once()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusRefunded).Return(nil).Once()
+
+	approved, err := service.ApproveRefund(4, 1)
+	require.NoError(t, err)
+	assert.Equal(t, RefundSucceeded, approved.Status)
+	assert.Equal(t, uint(1), *approved.ReviewedBy)
+
+	_, err = service.ApproveRefund(4, 1)
+	assert.ErrorIs(t, err, ErrRefundNotPending)
+	_, err = service.RejectRefund(4, 1, "too late")
+	assert.ErrorIs(t, err, ErrRefundNotPending)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateRefund_AdminSkipsApproval(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+	p := paidPayment(t, service, mockRepo)
+
+	mockRepo.On("LockPayment", uint(1)).Return(p, nil)
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(0), nil)
+	mockRepo.On("CreateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("UpdateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("Update", p).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusRefunded).Return(nil)
+
+	ref, err := service.CreateRefund(1, 1, "admin", CreateRefundRequest{Reason: RefundReasonGoodwill})
+	require.NoError(t, err)
+	assert.Equal(t, RefundSucceeded, ref.Status)
+}
+
+func TestCreateRefund_Validates(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	_, err := service.CreateRefund(1, 1, "admin", CreateRefundRequest{Reason: "because"})
+	assert.ErrorIs(t, err, ErrInvalidRefundReason)
+
+	mockRepo.On("LockPayment", uint(2)).Return(&Payment{ID: 2, Amount: usd(5000), Status: StatusPending}, nil)
+	_, err = service.CreateRefund(2, 1, "admin", CreateRefundRequest{Reason: RefundReasonOther})
+	assert.ErrorIs(t, err, ErrNotRefundable)
+	mockRepo.AssertNotCalled(t, "CreateRefund", mock.Anything)
+}
+
+func TestCreateRefund_ProviderFailureIsRecorded(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("LockPayment", uint(3)).Return(&Payment{ID: 3, Amount: usd(1000), Status: StatusPaid, ProviderRef: "pi_unknown"}, nil)
+	mockRepo.On("SumActiveRefunds", uint(3)).Return(int64(0), nil)
+	mockRepo.On("CreateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+	mockRepo.On("UpdateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil)
+
+	ref, err := service.CreateRefund(3, 1, "admin", CreateRefundRequest{Reason: RefundReasonOther})
+	require.NoError(t, err)
+	assert.Equal(t, RefundFailed, ref.Status)
+	assert.NotEmpty(t, ref.FailureReason)
+	mockRepo.AssertNotCalled(t, "UpdateBookingPaymentStatus", mock.Anything, mock.Anything)
+}
+
+func TestRefundBookings_ClassCancelledIsIdempotent(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+	p := paidPayment(t, service, mockRepo)
+
+	mockRepo.On("MarkRefundRequested", []uint{1}, mock.AnythingOfType("time.Time")).Return(nil)
+	mockRepo.On("ListRefundableByBookings", []uint{1}).Return([]Payment{*p}, nil)
+	mockRepo.On("LockPayment", uint(1)).Return(p, nil)
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(0), nil).Once()
+	mockRepo.On("CreateRefund", mock.MatchedBy(func(r *Refund) bool {
+		// Automatic refunds skip approval even above the threshold.
+		return r.Amount == usd(5000) && r.Reason == RefundReasonClassCancelled && r.Status == RefundProcessing && r.RequestedBy == nil
+	})).Return(nil).Once()
+	mockRepo.On("UpdateRefund", mock.AnythingOfType("*payment.Refund")).Return(nil).Once()
+	mockRepo.On("Update", p).Return(nil).Once()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusRefunded).Return(nil).Once()
+
+	require.NoError(t, service.RefundBookings([]uint{1}, RefundReasonClassCancelled))
+
+	// A retried cancellation finds nothing left to refund.
+	mockRepo.On("SumActiveRefunds", uint(1)).Return(int64(5000), nil)
+	require.NoError(t, service.RefundBookings([]uint{1}, RefundReasonClassCancelled))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateRefund_PendingAtProviderSettlesByWebhook(t *testing.T) {
+	db := tillDB(t)
+	provider := NewFakeProvider(testWebhookSecret, 50*time.Millisecond, "")
+	invoices := &fakeInvoices{}
+	ledger := &fakeLedger{}
+	service := NewService(NewRepository(db), provider, testBookings(), invoices, ledger, nil, testApprovalThreshold, testTaxes)
+	webhooks := make(chan []byte, 2)
+	provider.OnWebhook(func(payload []byte, signature string) {
+		_, err := service.HandleWebhook(payload, signature)
+		assert.NoError(t, err)
+		webhooks <- payload
+	})
+	next := func() []byte {
+		select {
+		case payload := <-webhooks:
+			return payload
+		case <-time.After(time.Second):
+			t.Fatal("webhook was not delivered")
+			return nil
+		}
+	}
+
+	p, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: MethodCard, PaymentToken: FakeTokenDelay})
+	require.NoError(t, err)
+	next()
+
+	ref, err := service.CreateRefund(p.ID, 9, adminRole, CreateRefundRequest{Reason: RefundReasonServiceIssue})
+	require.NoError(t, err)
+	assert.Equal(t, RefundProcessing, ref.Status)
+	assert.NotEmpty(t, ref.ProviderRef)
+	_, err = service.CreateRefund(p.ID, 9, adminRole, CreateRefundRequest{Reason: RefundReasonServiceIssue})
+	assert.ErrorIs(t, err, ErrNothingToRefund)
+
+	payload := next()
+	var got Refund
+	require.NoError(t, db.First(&got, ref.ID).Error)
+	assert.Equal(t, RefundSucceeded, got.Status)
+	assert.NotNil(t, got.RefundedAt)
+	var paid Payment
+	require.NoError(t, db.First(&paid, p.ID).Error)
+	assert.Equal(t, StatusRefunded, paid.Status)
+	assert.Equal(t, p.Amount, paid.RefundedAmount)
+	assert.Equal(t, []uint{ref.ID}, invoices.refunds)
+	assert.Equal(t, []uint{ref.ID}, ledger.refunds)
+
+	// A redelivered event changes nothing.
+	_, err = service.HandleWebhook(payload, SignWebhook([]byte(testWebhookSecret), payload, time.Now()))
+	require.NoError(t, err)
+	assert.Len(t, ledger.refunds, 1)
+}
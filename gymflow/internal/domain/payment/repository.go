@@ -1,11 +1,90 @@
 package payment
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
 
 type Repository interface {
 	Create(p *Payment) error
 	ListByUser(userID uint) ([]Payment, error)
+	FindByID(id uint) (*Payment, error)
 	FindByBookingID(bookingID uint) (*Payment, error)
+	FindByProviderRef(ref string) (*Payment, error)
+	Update(p *Payment) error
+	MarkRefundRequested(bookingIDs []uint, at time.Time) error
+	ListRefundableByBookings(bookingIDs []uint) ([]Payment, error)
+	// UpdateBookingPaymentStatus mirrors a class payment's status onto its booking.
+	UpdateBookingPaymentStatus(bookingID uint, status string) error
+	// LockBooking holds the booking's row until the transaction ends, so
+	// only one payment for it is created at a time.
+	LockBooking(bookingID uint) error
+	// FindStoredPaymentToken is the provider token the member's subscription
+	// is charged with, or "" when there is none.
+	FindStoredPaymentToken(userID uint) (string, error)
+	// FindSubscriptionDue reads what an in-person membership payment pays
+	// for; SetSubscriptionPendingPayment hands the payment to the membership
+	// billing job, which renews the subscription with it. It fails with
+	// gorm.ErrRecordNotFound when another payment is already pending.
+	FindSubscriptionDue(subscriptionID uint) (*SubscriptionDue, error)
+	SetSubscriptionPendingPayment(subscriptionID, paymentID uint) error
+	// LockSubscription holds the subscription's row until the transaction
+	// ends, so only one payment for it is taken at a time.
+	LockSubscription(subscriptionID uint) error
+
+	// Transaction runs fn against a repository bound to one transaction.
+	Transaction(fn func(repo Repository) error) error
+	// LockPayment loads the payment and holds its row until the transaction ends.
+	LockPayment(id uint) (*Payment, error)
+
+	CreateRefund(r *Refund) error
+	UpdateRefund(r *Refund) error
+	FindRefundByID(id uint) (*Refund, error)
+	FindRefundByProviderRef(ref string) (*Refund, error)
+	ListRefunds(status string) ([]Refund, error)
+	// SumActiveRefunds totals the refunds of a payment that are not failed or rejected.
+	SumActiveRefunds(paymentID uint) (int64, error) // minor units
+
+	CreateTill(t *TillSession) error
+	UpdateTill(t *TillSession) error
+	FindTillByID(id uint) (*TillSession, error)
+	// LockTill loads the till session and holds its row until the
+	// transaction ends.
+	LockTill(id uint) (*TillSession, error)
+	// FindOpenTill is the staff member's open till session.
+	FindOpenTill(staffID uint) (*TillSession, error)
+	// ListTills lists the till sessions opened in [from, to).
+	ListTills(from, to time.Time) ([]TillSession, error)
+	SumTill(tillID uint) (*TillTotals, error)
+
+	CreateMethod(m *PaymentMethod) error
+	UpdateMethod(m *PaymentMethod) error
+	FindMethodByID(id uint) (*PaymentMethod, error)
+	// ListMethods lists the member's methods that were not removed, the
+	// default first, then newest first.
+	ListMethods(userID uint) ([]PaymentMethod, error)
+	ClearDefaultMethod(userID uint) error
+}
+
+// SubscriptionDue is a membership subscription as an in-person payment sees it.
+type SubscriptionDue struct {
+	UserID           uint
+	Status           string
+	PendingPaymentID *uint
+	Price            money.Money
+}
+
+// TillTotals sums the in-person movements of a till session in its
+// currency, in minor units.
+type TillTotals struct {
+	CashTaken    int64
+	CashRefunded int64
+	Terminal     int64
+	Payments     int64
 }
 
 type repository struct {
@@ -22,17 +101,301 @@ func (r *repository) Create(p *Payment) error {
 
 func (r *repository) FindByBookingID(bookingID uint) (*Payment, error) {
 	var p Payment
-	err := r.db.Where("booking_id = ?", bookingID).First(&p).Error
+	err := r.db.Where("booking_id = ? AND kind = ?", bookingID, KindBooking).Order("id DESC").First(&p).Error
 	if err != nil {
 		return nil, err
 	}
 	return &p, nil
 }
 
+func (r *repository) FindByID(id uint) (*Payment, error) {
+	var p Payment
+	if err := r.db.First(&p, id).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) FindByProviderRef(ref string) (*Payment, error) {
+	var p Payment
+	if err := r.db.Where("provider_ref = ?", ref).First(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *repository) Update(p *Payment) error {
+	return r.db.Save(p).Error
+}
+
 func (r *repository) ListByUser(userID uint) ([]Payment, error) {
 	var pay []Payment
-	if err := r.db.Where("user_id = ?", userID).Find(&pay).Error; err != nil {
+	err := r.db.Preload("Refunds", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("user_id = ?", userID).Find(&pay).Error
+	if err != nil {
 		return nil, err
 	}
 	return pay, nil
 }
+
+// MarkRefundRequested flags payments of the given bookings that are not flagged yet.
+func (r *repository) MarkRefundRequested(bookingIDs []uint, at time.Time) error {
+	return r.db.Model(&Payment{}).
+		Where("booking_id IN ? AND refund_requested_at IS NULL", bookingIDs).
+		Update("refund_requested_at", at).Error
+}
+
+// ListRefundableByBookings returns the class payments of the given bookings
+// that still hold money.
+func (r *repository) ListRefundableByBookings(bookingIDs []uint) ([]Payment, error) {
+	var pay []Payment
+	err := r.db.Where("booking_id IN ? AND kind = ? AND status IN ?", bookingIDs, KindBooking,
+		[]string{StatusPaid, StatusPartiallyRefunded}).
+		Order("id").Find(&pay).Error
+	if err != nil {
+		return nil, err
+	}
+	return pay, nil
+}
+
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
+
+// LockPayment uses a no-op UPDATE, like booking's LockClass, so it also locks under SQLite.
+func (r *repository) LockPayment(id uint) (*Payment, error) {
+	res := r.db.Model(&Payment{}).Where("id = ?", id).UpdateColumn("amount_minor", gorm.Expr("amount_minor"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.FindByID(id)
+}
+
+func (r *repository) CreateRefund(ref *Refund) error {
+	return r.db.Create(ref).Error
+}
+
+func (r *repository) UpdateRefund(ref *Refund) error {
+	return r.db.Save(ref).Error
+}
+
+func (r *repository) FindRefundByID(id uint) (*Refund, error) {
+	var ref Refund
+	if err := r.db.First(&ref, id).Error; err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (r *repository) FindRefundByProviderRef(providerRef string) (*Refund, error) {
+	var ref Refund
+	if err := r.db.Where("provider_ref = ?", providerRef).First(&ref).Error; err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+func (r *repository) ListRefunds(status string) ([]Refund, error) {
+	q := r.db.Order("id")
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var refs []Refund
+	if err := q.Find(&refs).Error; err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+func (r *repository) SumActiveRefunds(paymentID uint) (int64, error) {
+	var sum int64
+	err := r.db.Model(&Refund{}).
+		Where("payment_id = ? AND status NOT IN ?", paymentID, []string{RefundFailed, RefundRejected}).
+		Select("COALESCE(SUM(amount_minor), 0)").Scan(&sum).Error
+	return sum, err
+}
+
+func (r *repository) UpdateBookingPaymentStatus(bookingID uint, status string) error {
+	return r.db.Model(&booking.Booking{}).Where("id = ?", bookingID).
+		Update("payment_status", status).Error
+}
+
+// LockBooking uses a no-op UPDATE, like LockPayment.
+func (r *repository) LockBooking(bookingID uint) error {
+	res := r.db.Model(&booking.Booking{}).Where("id = ?", bookingID).UpdateColumn("payment_status", gorm.Expr("payment_status"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// FindStoredPaymentToken reads the token renewals are charged with from the
+// member's latest live subscription (membership.StatusActive or
+// StatusPastDue); membership imports payment, so the table is read directly.
+func (r *repository) FindStoredPaymentToken(userID uint) (string, error) {
+	var tokens []string
+	err := r.db.Table("subscriptions").
+		Where("user_id = ? AND status IN ? AND payment_token <> ''", userID, []string{"active", "past_due"}).
+		Order("id DESC").Limit(1).Pluck("payment_token", &tokens).Error
+	if err != nil || len(tokens) == 0 {
+		return "", err
+	}
+	return tokens[0], nil
+}
+
+// FindSubscriptionDue reads the subscriptions and plans tables directly, like
+// FindStoredPaymentToken.
+func (r *repository) FindSubscriptionDue(subscriptionID uint) (*SubscriptionDue, error) {
+	var rows []struct {
+		UserID           uint
+		Status           string
+		PendingPaymentID *uint
+		PriceMinor       int64
+		PriceCurrency    string
+	}
+	err := r.db.Table("subscriptions").
+		Select("subscriptions.user_id, subscriptions.status, subscriptions.pending_payment_id, plans.price_minor, plans.price_currency").
+		Joins("JOIN plans ON plans.id = subscriptions.plan_id").
+		Where("subscriptions.id = ?", subscriptionID).
+		Limit(1).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	row := rows[0]
+	return &SubscriptionDue{
+		UserID:           row.UserID,
+		Status:           row.Status,
+		PendingPaymentID: row.PendingPaymentID,
+		Price:            money.New(row.PriceMinor, row.PriceCurrency),
+	}, nil
+}
+
+func (r *repository) SetSubscriptionPendingPayment(subscriptionID, paymentID uint) error {
+	res := r.db.Table("subscriptions").Where("id = ? AND pending_payment_id IS NULL", subscriptionID).
+		Update("pending_payment_id", paymentID)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) LockSubscription(subscriptionID uint) error {
+	res := r.db.Table("subscriptions").Where("id = ?", subscriptionID).
+		UpdateColumn("pending_payment_id", gorm.Expr("pending_payment_id"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (r *repository) CreateTill(t *TillSession) error {
+	return r.db.Create(t).Error
+}
+
+func (r *repository) UpdateTill(t *TillSession) error {
+	return r.db.Save(t).Error
+}
+
+func (r *repository) FindTillByID(id uint) (*TillSession, error) {
+	var t TillSession
+	if err := r.db.First(&t, id).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// LockTill uses a no-op UPDATE, like LockPayment.
+func (r *repository) LockTill(id uint) (*TillSession, error) {
+	res := r.db.Model(&TillSession{}).Where("id = ?", id).UpdateColumn("status", gorm.Expr("status"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.FindTillByID(id)
+}
+
+func (r *repository) FindOpenTill(staffID uint) (*TillSession, error) {
+	var t TillSession
+	err := r.db.Where("staff_id = ? AND status = ?", staffID, TillOpen).Order("id DESC").First(&t).Error
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (r *repository) ListTills(from, to time.Time) ([]TillSession, error) {
+	var tills []TillSession
+	err := r.db.Where("opened_at >= ? AND opened_at < ?", from, to).Order("opened_at, id").Find(&tills).Error
+	return tills, err
+}
+
+// SumTill totals the payments taken at the till, refunded since or not, and
+// the cash refunds paid out of it.
+func (r *repository) SumTill(tillID uint) (*TillTotals, error) {
+	var totals TillTotals
+	collected := []string{StatusPaid, StatusPartiallyRefunded, StatusRefunded}
+	err := r.db.Model(&Payment{}).
+		Select("COALESCE(SUM(CASE WHEN method = ? THEN amount_minor ELSE 0 END), 0) AS cash_taken, "+
+			"COALESCE(SUM(CASE WHEN method = ? THEN amount_minor ELSE 0 END), 0) AS terminal, "+
+			"COUNT(*) AS payments", MethodCash, MethodTerminal).
+		Where("till_session_id = ? AND status IN ?", tillID, collected).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, err
+	}
+	err = r.db.Model(&Refund{}).
+		Where("till_session_id = ? AND status = ?", tillID, RefundSucceeded).
+		Select("COALESCE(SUM(amount_minor), 0)").Scan(&totals.CashRefunded).Error
+	if err != nil {
+		return nil, err
+	}
+	return &totals, nil
+}
+
+func (r *repository) CreateMethod(m *PaymentMethod) error {
+	return r.db.Create(m).Error
+}
+
+func (r *repository) UpdateMethod(m *PaymentMethod) error {
+	return r.db.Save(m).Error
+}
+
+func (r *repository) FindMethodByID(id uint) (*PaymentMethod, error) {
+	var m PaymentMethod
+	if err := r.db.First(&m, id).Error; err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (r *repository) ListMethods(userID uint) ([]PaymentMethod, error) {
+	var methods []PaymentMethod
+	err := r.db.Where("user_id = ? AND removed_at IS NULL", userID).
+		Order("is_default DESC, id DESC").Find(&methods).Error
+	return methods, err
+}
+
+func (r *repository) ClearDefaultMethod(userID uint) error {
+	return r.db.Model(&PaymentMethod{}).Where("user_id = ? AND is_default", userID).
+		Update("is_default", false).Error
+}
@@ -1,45 +1,547 @@
 package payment
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/promo"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
+
+	"gorm.io/gorm"
+)
 
 type Service interface {
 	CreatePayment(userID uint, req CreatePaymentRequest) (*Payment, error)
 	ListPayments(userID uint) ([]Payment, error)
+	MarkForRefund(bookingIDs []uint) error
+	// ChargePenalty charges a fee penalty to the member's stored payment
+	// method; see booking.Payments.
+	ChargePenalty(pen *booking.Penalty) (string, error)
+	// ChargeMembership charges a membership period off-session with the
+	// payment token, or without one the member's stored payment method. The
+	// payment comes back paid, failed (also when there is no usable stored
+	// method), or pending until the provider's webhook arrives.
+	ChargeMembership(userID, subscriptionID uint, amount money.Money, token string) (*Payment, error)
+	// ChargeClassPack charges a class pack purchase the same way.
+	ChargeClassPack(userID, purchaseID uint, amount money.Money, token string) (*Payment, error)
+	GetPayment(id uint) (*Payment, error)
+	HandleWebhook(payload []byte, signature string) (*Payment, error)
+
+	// RecordInPersonPayment records cash or a card terminal payment a staff
+	// member took at the front desk.
+	RecordInPersonPayment(staffID uint, req InPersonPaymentRequest) (*Payment, error)
+	OpenTill(staffID uint, req OpenTillRequest) (*TillSession, error)
+	CurrentTill(staffID uint) (*TillSummary, error)
+	CloseTill(id, staffID uint, staffRole string, req CloseTillRequest) (*TillSummary, error)
+	// CashUp is the end-of-day report of the tills opened on date.
+	CashUp(date time.Time) (*CashUpReport, error)
+
+	// Stored payment methods: cards the member saved at the provider.
+	AddPaymentMethod(userID uint, req AddPaymentMethodRequest) (*PaymentMethod, error)
+	ListPaymentMethods(userID uint) ([]PaymentMethod, error)
+	SetDefaultPaymentMethod(userID, id uint) (*PaymentMethod, error)
+	RemovePaymentMethod(userID, id uint) error
+
+	RefundBookings(bookingIDs []uint, reason string) error
+	RefundPayment(paymentID uint, reason string) error
+	CreateRefund(paymentID, staffID uint, staffRole string, req CreateRefundRequest) (*Refund, error)
+	ApproveRefund(id, adminID uint) (*Refund, error)
+	RejectRefund(id, adminID uint, note string) (*Refund, error)
+	ListRefunds(status string) ([]Refund, error)
+}
+
+var (
+	ErrPaymentExists     = errors.New("payment already exists for this booking")
+	ErrBookingNotFound   = errors.New("booking not found")
+	ErrForeignBooking    = errors.New("booking belongs to another member")
+	ErrBookingNotPayable = errors.New("booking cannot be paid")
+	ErrNothingDue        = errors.New("nothing to pay for this booking")
+)
+
+// Promotions applies promo codes to class payments; promo.Service satisfies it.
+type Promotions interface {
+	Redeem(claim promo.Claim) (*promo.Redemption, error)
+	Release(redemptionID uint) error
+}
+
+// Bookings is the read access to bookings and classes the payment service
+// needs to price a payment; booking.Repository satisfies it.
+type Bookings interface {
+	FindBookingByID(id uint) (*booking.Booking, error)
+	FindClassByID(id uint) (*booking.GymClass, error)
+}
+
+// Invoices issues the invoices of collected payments and the credit notes of
+// succeeded refunds; invoice.Service satisfies it.
+type Invoices interface {
+	IssueForPayment(p *Payment) error
+	IssueForRefund(ref *Refund) error
+}
+
+// Ledger books collected payments and succeeded refunds as balanced journal
+// transactions; ledger.Service satisfies it.
+type Ledger interface {
+	RecordPayment(p *Payment) error
+	RecordRefund(ref *Refund) error
 }
 
 type service struct {
-	repo Repository
+	repo     Repository
+	provider Provider
+	bookings Bookings
+	// invoices, ledger and promos may be nil, e.g. in tests.
+	invoices Invoices
+	ledger   Ledger
+	promos   Promotions
+	taxes    tax.Rates
+
+	// refundApprovalThreshold is the largest refund staff may issue without
+	// an admin approving it.
+	refundApprovalThreshold money.Money
 }
 
-func NewService(repo Repository) Service {
-	return &service{repo: repo}
+func NewService(repo Repository, provider Provider, bookings Bookings, invoices Invoices, ledger Ledger, promos Promotions, refundApprovalThreshold money.Money, taxes tax.Rates) Service {
+	return &service{repo: repo, provider: provider, bookings: bookings, invoices: invoices, ledger: ledger, promos: promos, refundApprovalThreshold: refundApprovalThreshold, taxes: taxes}
+}
+
+// price sets the amount charged for p from the list price: in exclusive
+// mode tax is added on top, in inclusive mode it is split out of the price.
+func (s *service) price(p *Payment, list money.Money) {
+	b := s.taxes.Apply(list, p.ProductType())
+	p.Amount = b.Gross
+	p.Net = b.Net
+	p.Tax = b.Tax
+	p.TaxRate = b.Rate
+}
+
+// classDue checks that userID may pay for the booking and returns its class,
+// whose price is due. The client never sends the amount.
+func (s *service) classDue(userID, bookingID uint) (*booking.GymClass, error) {
+	b, err := s.bookings.FindBookingByID(bookingID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrBookingNotFound
+		}
+		return nil, err
+	}
+	if b.UserID != userID {
+		return nil, ErrForeignBooking
+	}
+	switch b.Status {
+	case booking.BookingStatusCancelled, booking.BookingStatusClassCancelled:
+		return nil, fmt.Errorf("%w: booking is %s", ErrBookingNotPayable, b.Status)
+	case booking.BookingStatusWaitlist:
+		return nil, fmt.Errorf("%w: waitlisted bookings are paid once promoted", ErrBookingNotPayable)
+	}
+	if b.CreditLotID != nil {
+		return nil, fmt.Errorf("%w: booking was paid with class credits", ErrBookingNotPayable)
+	}
+	class, err := s.bookings.FindClassByID(b.ClassID)
+	if err != nil {
+		return nil, err
+	}
+	if class.Status == booking.ClassStatusCancelled {
+		return nil, fmt.Errorf("%w: class is cancelled", ErrBookingNotPayable)
+	}
+	if !class.Price.IsPositive() {
+		return nil, ErrNothingDue
+	}
+	return class, nil
 }
 
 func (s *service) CreatePayment(userID uint, req CreatePaymentRequest) (*Payment, error) {
-	// 1. Проверяем, существует ли платёж для бронирования
+	// 1. Проверяем бронирование и считаем сумму на сервере
+	class, err := s.classDue(userID, req.BookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Проверяем, существует ли платёж для бронирования
+	// (неудачный платёж можно повторить)
 	existing, err := s.repo.FindByBookingID(req.BookingID)
-	if err == nil && existing != nil {
-		return nil, errors.New("payment already exists for this booking")
+	if err == nil && existing != nil && existing.Status != StatusFailed {
+		return nil, ErrPaymentExists
 	}
 
-	// 2. Создаём платёж со статусом pending
+	// 3. Создаём платёж со статусом pending
 	payment := &Payment{
 		UserID:    userID,
 		BookingID: req.BookingID,
-		Amount:    req.Amount,
+		Amount:    class.Price,
 		Method:    req.Method,
-		Status:    "pending",
+		Kind:      KindBooking,
+		Status:    StatusPending,
+		Provider:  s.provider.Name(),
+	}
+
+	// 3a. Промокод: резервируем использование и уменьшаем сумму
+	if req.PromoCode != "" {
+		if err := s.applyPromo(payment, req.PromoCode, class); err != nil {
+			return nil, err
+		}
+	}
+	// 3b. Налог с цены после скидки
+	s.price(payment, payment.Amount)
+
+	// 4. Сохраняем (бронирование тоже возвращается в pending)
+	err = s.repo.Transaction(func(repo Repository) error {
+		if err := claimBooking(repo, payment.BookingID); err != nil {
+			return err
+		}
+		if err := repo.Create(payment); err != nil {
+			return err
+		}
+		return repo.UpdateBookingPaymentStatus(payment.BookingID, payment.Status)
+	})
+	if err != nil {
+		if payment.PromoRedemptionID != nil {
+			if rerr := s.promos.Release(*payment.PromoRedemptionID); rerr != nil {
+				log.Printf("release promo redemption %d: %v", *payment.PromoRedemptionID, rerr)
+			}
+		}
+		return nil, err
+	}
+
+	// Код покрыл всю цену: списывать нечего
+	if payment.Amount.IsZero() {
+		if err := markPaid(payment, time.Now()); err != nil {
+			return nil, err
+		}
+		if err := s.save(payment); err != nil {
+			return nil, err
+		}
+		s.issueInvoice(payment)
+		s.recordPayment(payment)
+		return payment, nil
+	}
+
+	// 5-7. Авторизация и списание, см. settle
+	if err := s.settle(payment, req.PaymentToken); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// claimBooking locks the booking and checks again, under the lock, that it
+// has no payment other than a failed one: two requests paying the same
+// booking both pass the check made before the transaction. Must be called
+// inside a transaction.
+func claimBooking(repo Repository, bookingID uint) error {
+	if err := repo.LockBooking(bookingID); err != nil {
+		return err
+	}
+	existing, err := repo.FindByBookingID(bookingID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.Status != StatusFailed {
+		return ErrPaymentExists
+	}
+	return nil
+}
+
+// settle runs a stored pending payment through the provider: authorization,
+// then capture. An asynchronous provider leaves it pending until the webhook.
+func (s *service) settle(payment *Payment, token string) error {
+	// 5. Проводим через провайдера: авторизация, затем списание.
+	// Асинхронный провайдер оставляет pending до вебхука.
+	intent, err := s.provider.CreateIntent(IntentRequest{
+		Amount:    payment.Amount,
+		Token:     token,
+		Reference: strconv.FormatUint(uint64(payment.ID), 10),
+	})
+	if err == nil {
+		payment.ProviderRef = intent.ID
+		if intent.Status == IntentRequiresCapture {
+			// 6. Фиксируем авторизацию до списания
+			if err := payment.transition(StatusAuthorized); err != nil {
+				return err
+			}
+			if err := s.save(payment); err != nil {
+				return err
+			}
+			intent, err = s.provider.Capture(intent.ID, payment.Amount)
+		}
+	}
+
+	// 7. Итоговый статус
+	var moved error
+	switch {
+	case err != nil:
+		moved = markFailed(payment, err.Error())
+	case intent.Status == IntentSucceeded:
+		moved = markPaid(payment, time.Now())
+	case intent.Status == IntentFailed:
+		moved = markFailed(payment, intent.FailureReason)
+	}
+	if moved != nil {
+		return moved
+	}
+	if err := s.save(payment); err != nil {
+		return err
+	}
+	s.issueInvoice(payment)
+	s.recordPayment(payment)
+	s.releasePromo(payment)
+	return nil
+}
+
+// applyPromo redeems code for the class and takes the discount off payment.
+func (s *service) applyPromo(payment *Payment, code string, class *booking.GymClass) error {
+	if s.promos == nil {
+		return promo.ErrCodeNotFound
 	}
+	red, err := s.promos.Redeem(promo.Claim{Code: code, UserID: payment.UserID, Class: class})
+	if err != nil {
+		return err
+	}
+	amount, err := payment.Amount.Sub(red.Discount)
+	if err != nil {
+		if rerr := s.promos.Release(red.ID); rerr != nil {
+			log.Printf("release promo redemption %d: %v", red.ID, rerr)
+		}
+		return err
+	}
+	payment.Amount = amount
+	payment.Discount = red.Discount
+	payment.PromoCode = strings.ToUpper(strings.TrimSpace(code))
+	payment.PromoRedemptionID = &red.ID
+	return nil
+}
+
+// releasePromo gives back the promo code use of a payment that didn't go
+// through, so the member can retry with the same code.
+func (s *service) releasePromo(p *Payment) {
+	if s.promos == nil || p.PromoRedemptionID == nil || p.Status != StatusFailed {
+		return
+	}
+	if err := s.promos.Release(*p.PromoRedemptionID); err != nil {
+		log.Printf("release promo redemption of payment %d: %v", p.ID, err)
+	}
+}
 
-	// 3. Сохраняем
-	if err := s.repo.Create(payment); err != nil {
+// save stores p and mirrors its status onto the booking of a class payment.
+func (s *service) save(p *Payment) error {
+	return s.repo.Transaction(func(repo Repository) error {
+		return saveIn(repo, p)
+	})
+}
+
+func saveIn(repo Repository, p *Payment) error {
+	if err := repo.Update(p); err != nil {
+		return err
+	}
+	if p.Kind != KindBooking {
+		return nil
+	}
+	return repo.UpdateBookingPaymentStatus(p.BookingID, p.Status)
+}
+
+func markPaid(p *Payment, at time.Time) error {
+	if err := p.transition(StatusPaid); err != nil {
+		return err
+	}
+	p.PaidAt = &at
+	p.FailureReason = ""
+	return nil
+}
+
+func markFailed(p *Payment, reason string) error {
+	if err := p.transition(StatusFailed); err != nil {
+		return err
+	}
+	p.FailureReason = reason
+	return nil
+}
+
+// HandleWebhook applies a signed provider event to its payment, or to the
+// payment's refund for refund events. Events that would be an illegal
+// transition (e.g. the payment already settled) are acknowledged and
+// ignored, so provider retries are harmless.
+func (s *service) HandleWebhook(payload []byte, signature string) (*Payment, error) {
+	ev, err := s.provider.ParseWebhook(payload, signature)
+	if err != nil {
+		return nil, err
+	}
+	payment, err := s.repo.FindByProviderRef(ev.IntentID)
+	if err != nil {
 		return nil, err
 	}
 
+	switch ev.Type {
+	case EventRefundSucceeded, EventRefundFailed:
+		return s.settleRefund(payment, ev)
+	case EventPaymentSucceeded:
+		err = markPaid(payment, time.Now())
+	case EventPaymentFailed:
+		err = markFailed(payment, ev.FailureReason)
+	default:
+		return payment, nil
+	}
+	if errors.Is(err, ErrIllegalTransition) {
+		return payment, nil
+	}
+	if err := s.save(payment); err != nil {
+		return nil, err
+	}
+	s.issueInvoice(payment)
+	s.recordPayment(payment)
+	s.releasePromo(payment)
 	return payment, nil
 }
 
+// issueInvoice invoices a payment that was just collected. An invoicing
+// failure doesn't undo the payment; the invoice backfill job retries it.
+func (s *service) issueInvoice(p *Payment) {
+	if s.invoices == nil || p.Status != StatusPaid {
+		return
+	}
+	if err := s.invoices.IssueForPayment(p); err != nil {
+		log.Printf("invoice for payment %d: %v", p.ID, err)
+	}
+}
+
+// recordPayment books a payment that was just collected. Like invoicing, a
+// failure is caught up by the ledger backfill job.
+func (s *service) recordPayment(p *Payment) {
+	if s.ledger == nil || p.Status != StatusPaid {
+		return
+	}
+	if err := s.ledger.RecordPayment(p); err != nil {
+		log.Printf("ledger for payment %d: %v", p.ID, err)
+	}
+}
+
 
 func (s *service) ListPayments(userID uint) ([]Payment, error) {
 	return s.repo.ListByUser(userID)
 }
+
+// MarkForRefund flags the payments of bookings dropped by a class cancellation.
+func (s *service) MarkForRefund(bookingIDs []uint) error {
+	return s.repo.MarkRefundRequested(bookingIDs, time.Now())
+}
+
+func (s *service) ChargeMembership(userID, subscriptionID uint, amount money.Money, token string) (*Payment, error) {
+	return s.chargeCard(&Payment{
+		UserID:         userID,
+		Amount:         amount,
+		Kind:           KindMembership,
+		SubscriptionID: &subscriptionID,
+	}, token)
+}
+
+func (s *service) ChargeClassPack(userID, purchaseID uint, amount money.Money, token string) (*Payment, error) {
+	return s.chargeCard(&Payment{
+		UserID:         userID,
+		Amount:         amount,
+		Kind:           KindClassPack,
+		PackPurchaseID: &purchaseID,
+	}, token)
+}
+
+// chargeCard stores payment as pending and settles it with the card token,
+// or without one with the member's stored payment method. A member without
+// a usable stored method gets a failed payment, so a renewal goes past due
+// like any other declined charge.
+func (s *service) chargeCard(payment *Payment, token string) (*Payment, error) {
+	if !payment.Amount.IsPositive() {
+		return nil, ErrNothingDue
+	}
+	missing := false
+	if token == "" {
+		m, err := s.storedMethod(payment.UserID)
+		switch {
+		case errors.Is(err, ErrNoPaymentMethod):
+			missing = true
+		case err != nil:
+			return nil, err
+		default:
+			token = m.Token
+			payment.PaymentMethodID = &m.ID
+		}
+	}
+	s.price(payment, payment.Amount)
+	payment.Method = MethodCard
+	payment.Status = StatusPending
+	payment.Provider = s.provider.Name()
+	err := s.repo.Transaction(func(repo Repository) error {
+		if payment.Kind != KindMembership {
+			return repo.Create(payment)
+		}
+		if err := claimSubscription(repo, *payment.SubscriptionID, false); err != nil {
+			return err
+		}
+		if err := repo.Create(payment); err != nil {
+			return err
+		}
+		return pendOn(repo, payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		if err := markFailed(payment, failureNoMethod); err != nil {
+			return nil, err
+		}
+		if err := s.save(payment); err != nil {
+			return nil, err
+		}
+		return payment, nil
+	}
+	if err := s.settle(payment, token); err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func (s *service) GetPayment(id uint) (*Payment, error) {
+	return s.repo.FindByID(id)
+}
+
+// ChargePenalty charges a late-cancel or no-show fee off-session to the
+// member's stored payment method, on the booking it was charged for.
+// Members who never saved one are charged on their subscription's token.
+func (s *service) ChargePenalty(pen *booking.Penalty) (string, error) {
+	payment := &Payment{
+		UserID:    pen.UserID,
+		BookingID: pen.BookingID,
+		Amount:    pen.Amount,
+		Kind:      KindLateCancelFee,
+		PenaltyID: &pen.ID,
+	}
+	if pen.Reason == booking.PenaltyReasonNoShow {
+		payment.Kind = KindNoShowFee
+	}
+	var token string
+	m, err := s.storedMethod(pen.UserID)
+	switch {
+	case err == nil:
+		token = m.Token
+		payment.PaymentMethodID = &m.ID
+	case errors.Is(err, ErrNoPaymentMethod):
+		if token, err = s.repo.FindStoredPaymentToken(pen.UserID); err != nil {
+			return "", err
+		}
+		if token == "" {
+			return "", ErrNoPaymentMethod
+		}
+	default:
+		return "", err
+	}
+	p, err := s.chargeCard(payment, token)
+	if err != nil {
+		return "", err
+	}
+	pen.PaymentID = &p.ID
+	return p.Status, nil
+}
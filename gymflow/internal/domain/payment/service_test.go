@@ -1,13 +1,72 @@
 package payment
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/promo"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"gorm.io/gorm"
 )
 
+const testWebhookSecret = "whsec_test"
+
+var testApprovalThreshold = money.New(3000, "USD")
+
+// testTaxes charges no tax; TestCreatePayment_Tax sets its own rates.
+var testTaxes = tax.Rates{}
+
+func usd(minor int64) money.Money {
+	return money.New(minor, "USD")
+}
+
+// fakeBookings serves booking 1 (member 1, 50.00 class), booking 2 (member 2),
+// cancelled booking 3, waitlisted booking 4 and booking 5 of a free class.
+type fakeBookings struct {
+	bookings map[uint]*booking.Booking
+	classes  map[uint]*booking.GymClass
+}
+
+// The router passes booking.Repository as Bookings.
+var _ Bookings = booking.Repository(nil)
+
+func testBookings() *fakeBookings {
+	return &fakeBookings{
+		bookings: map[uint]*booking.Booking{
+			1: {ID: 1, UserID: 1, ClassID: 10, Status: booking.BookingStatusBooked},
+			2: {ID: 2, UserID: 2, ClassID: 10, Status: booking.BookingStatusBooked},
+			3: {ID: 3, UserID: 1, ClassID: 10, Status: booking.BookingStatusCancelled},
+			4: {ID: 4, UserID: 1, ClassID: 10, Status: booking.BookingStatusWaitlist},
+			5: {ID: 5, UserID: 1, ClassID: 11, Status: booking.BookingStatusBooked},
+		},
+		classes: map[uint]*booking.GymClass{
+			10: {ID: 10, Price: usd(5000)},
+			11: {ID: 11, Price: usd(0)},
+		},
+	}
+}
+
+func (f *fakeBookings) FindBookingByID(id uint) (*booking.Booking, error) {
+	if b, ok := f.bookings[id]; ok {
+		return b, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeBookings) FindClassByID(id uint) (*booking.GymClass, error) {
+	if c, ok := f.classes[id]; ok {
+		return c, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
 // Mock Repository
 type MockPaymentRepository struct {
 	mock.Mock
@@ -36,55 +95,233 @@ func (m *MockPaymentRepository) FindByBookingID(bookingID uint) (*Payment, error
 	return args.Get(0).(*Payment), args.Error(1)
 }
 
+func (m *MockPaymentRepository) FindByProviderRef(ref string) (*Payment, error) {
+	args := m.Called(ref)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Payment), args.Error(1)
+}
+
 func (m *MockPaymentRepository) ListByUser(userID uint) ([]Payment, error) {
 	args := m.Called(userID)
 	return args.Get(0).([]Payment), args.Error(1)
 }
 
+func (m *MockPaymentRepository) MarkRefundRequested(bookingIDs []uint, at time.Time) error {
+	args := m.Called(bookingIDs, at)
+	return args.Error(0)
+}
+
 func (m *MockPaymentRepository) Update(payment *Payment) error {
 	args := m.Called(payment)
 	return args.Error(0)
 }
 
+func (m *MockPaymentRepository) ListRefundableByBookings(bookingIDs []uint) ([]Payment, error) {
+	args := m.Called(bookingIDs)
+	return args.Get(0).([]Payment), args.Error(1)
+}
+
+// Transaction runs fn on the mock itself.
+func (m *MockPaymentRepository) Transaction(fn func(repo Repository) error) error {
+	return fn(m)
+}
+
+func (m *MockPaymentRepository) LockPayment(id uint) (*Payment, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Payment), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CreateRefund(r *Refund) error {
+	args := m.Called(r)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) UpdateRefund(r *Refund) error {
+	args := m.Called(r)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) FindRefundByID(id uint) (*Refund, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Refund), args.Error(1)
+}
+
+func (m *MockPaymentRepository) FindRefundByProviderRef(ref string) (*Refund, error) {
+	args := m.Called(ref)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Refund), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ListRefunds(status string) ([]Refund, error) {
+	args := m.Called(status)
+	return args.Get(0).([]Refund), args.Error(1)
+}
+
+func (m *MockPaymentRepository) SumActiveRefunds(paymentID uint) (int64, error) {
+	args := m.Called(paymentID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockPaymentRepository) UpdateBookingPaymentStatus(bookingID uint, status string) error {
+	args := m.Called(bookingID, status)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) LockBooking(bookingID uint) error {
+	args := m.Called(bookingID)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) FindStoredPaymentToken(userID uint) (string, error) {
+	args := m.Called(userID)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockPaymentRepository) FindSubscriptionDue(subscriptionID uint) (*SubscriptionDue, error) {
+	args := m.Called(subscriptionID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*SubscriptionDue), args.Error(1)
+}
+
+func (m *MockPaymentRepository) SetSubscriptionPendingPayment(subscriptionID, paymentID uint) error {
+	args := m.Called(subscriptionID, paymentID)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) LockSubscription(subscriptionID uint) error {
+	args := m.Called(subscriptionID)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) CreateTill(t *TillSession) error {
+	args := m.Called(t)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) UpdateTill(t *TillSession) error {
+	args := m.Called(t)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) FindTillByID(id uint) (*TillSession, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*TillSession), args.Error(1)
+}
+
+func (m *MockPaymentRepository) LockTill(id uint) (*TillSession, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*TillSession), args.Error(1)
+}
+
+func (m *MockPaymentRepository) FindOpenTill(staffID uint) (*TillSession, error) {
+	args := m.Called(staffID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*TillSession), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ListTills(from, to time.Time) ([]TillSession, error) {
+	args := m.Called(from, to)
+	return args.Get(0).([]TillSession), args.Error(1)
+}
+
+func (m *MockPaymentRepository) SumTill(tillID uint) (*TillTotals, error) {
+	args := m.Called(tillID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*TillTotals), args.Error(1)
+}
+
+func (m *MockPaymentRepository) CreateMethod(pm *PaymentMethod) error {
+	args := m.Called(pm)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) UpdateMethod(pm *PaymentMethod) error {
+	args := m.Called(pm)
+	return args.Error(0)
+}
+
+func (m *MockPaymentRepository) FindMethodByID(id uint) (*PaymentMethod, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*PaymentMethod), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ListMethods(userID uint) ([]PaymentMethod, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]PaymentMethod), args.Error(1)
+}
+
+func (m *MockPaymentRepository) ClearDefaultMethod(userID uint) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
 // Tests
 func TestCreatePayment_Success(t *testing.T) {
 	mockRepo := new(MockPaymentRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
 
 	req := CreatePaymentRequest{
 		BookingID: 1,
-		Amount:    50.0,
 		Method:    "card", // Изменено: было PaymentMethod, теперь Method
 	}
 
 	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
 	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
-	
-
-	
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	// The booking follows the payment through every state.
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusPending).Return(nil).Once()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusAuthorized).Return(nil).Once()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusPaid).Return(nil).Once()
 
 	payment, err := service.CreatePayment(1, req)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, payment)
-	assert.Equal(t, req.Amount, payment.Amount)
-	assert.Equal(t, "pending", payment.Status)
+	assert.Equal(t, usd(5000), payment.Amount) // class price, not a client value
+	assert.Equal(t, StatusPaid, payment.Status)
+	assert.NotEmpty(t, payment.ProviderRef)
+	assert.NotNil(t, payment.PaidAt)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestCreatePayment_AlreadyExists(t *testing.T) {
 	mockRepo := new(MockPaymentRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
 
 	existingPayment := &Payment{
 		ID:        1,
 		BookingID: 1,
-		Status:    "completed",
+		Status:    StatusPaid,
 	}
 
 	req := CreatePaymentRequest{
 		BookingID: 1,
-		Amount:    50.0,
 		Method:    "card",
 	}
 
@@ -99,11 +336,11 @@ func TestCreatePayment_AlreadyExists(t *testing.T) {
 
 func TestListPayments_Success(t *testing.T) {
 	mockRepo := new(MockPaymentRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
 
 	expectedPayments := []Payment{
-		{ID: 1, UserID: 1, Amount: 50.0, Status: "completed"},
-		{ID: 2, UserID: 1, Amount: 30.0, Status: "completed"},
+		{ID: 1, UserID: 1, Amount: usd(5000), Status: StatusPaid},
+		{ID: 2, UserID: 1, Amount: usd(3000), Status: StatusRefunded},
 	}
 
 	mockRepo.On("ListByUser", uint(1)).Return(expectedPayments, nil)
@@ -112,13 +349,13 @@ func TestListPayments_Success(t *testing.T) {
 
 	assert.NoError(t, err)
 	assert.Len(t, payments, 2)
-	assert.Equal(t, 50.0, payments[0].Amount)
+	assert.Equal(t, usd(5000), payments[0].Amount)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestListPayments_Empty(t *testing.T) {
 	mockRepo := new(MockPaymentRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
 
 	emptyPayments := []Payment{}
 
@@ -129,4 +366,342 @@ func TestListPayments_Empty(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, payments, 0)
 	mockRepo.AssertExpectations(t)
-}
\ No newline at end of file
+}
+
+func TestMarkForRefund(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("MarkRefundRequested", []uint{3, 4}, mock.AnythingOfType("time.Time")).Return(nil)
+
+	err := service.MarkForRefund([]uint{3, 4})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestChargePenalty(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	// Member 2 saved a card; member 3 only gave one to their subscription.
+	mockRepo.On("ListMethods", uint(2)).Return([]PaymentMethod{{ID: 6, UserID: 2, Token: FakeTokenSuccess, ExpMonth: 12, ExpYear: time.Now().Year() + 1, IsDefault: true}}, nil)
+	mockRepo.On("ListMethods", mock.Anything).Return([]PaymentMethod{}, nil)
+	mockRepo.On("FindStoredPaymentToken", uint(3)).Return(FakeTokenSuccess, nil)
+	mockRepo.On("FindStoredPaymentToken", uint(4)).Return("", nil)
+	mockRepo.On("Create", mock.MatchedBy(func(p *Payment) bool {
+		return p.BookingID == 7 && p.Amount == usd(500) && p.Kind == KindNoShowFee && *p.PenaltyID == 4 && *p.PaymentMethodID == 6
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*Payment).ID = 11
+	}).Return(nil)
+	mockRepo.On("Create", mock.MatchedBy(func(p *Payment) bool {
+		return p.BookingID == 8 && p.Kind == KindLateCancelFee && p.PaymentMethodID == nil
+	})).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+
+	pen := &booking.Penalty{ID: 4, UserID: 2, BookingID: 7, Reason: booking.PenaltyReasonNoShow, Amount: usd(500)}
+	status, err := service.ChargePenalty(pen)
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaid, status)
+	assert.Equal(t, uint(11), *pen.PaymentID)
+	// Fees don't touch the payment status of the booking.
+	mockRepo.AssertNotCalled(t, "UpdateBookingPaymentStatus", mock.Anything, mock.Anything)
+
+	status, err = service.ChargePenalty(&booking.Penalty{ID: 5, UserID: 3, BookingID: 8, Amount: usd(500)})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaid, status)
+
+	_, err = service.ChargePenalty(&booking.Penalty{ID: 6, UserID: 4, BookingID: 9, Amount: usd(500)})
+	assert.ErrorIs(t, err, booking.ErrNoPaymentMethod)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreatePayment_Declined(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.AnythingOfType("string")).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PaymentToken: FakeTokenDecline})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusFailed, payment.Status)
+	mockRepo.AssertCalled(t, "UpdateBookingPaymentStatus", uint(1), StatusFailed)
+	assert.Equal(t, "card_declined", payment.FailureReason)
+	assert.Nil(t, payment.PaidAt)
+}
+
+func TestCreatePayment_RetryAfterFailure(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(&Payment{ID: 1, BookingID: 1, Status: StatusFailed}, nil)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.AnythingOfType("string")).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPaid, payment.Status)
+}
+
+// fakeInvoices records which payments and refunds were invoiced.
+type fakeInvoices struct {
+	payments []uint
+	refunds  []uint
+}
+
+func (f *fakeInvoices) IssueForPayment(p *Payment) error {
+	f.payments = append(f.payments, p.ID)
+	return nil
+}
+
+func (f *fakeInvoices) IssueForRefund(ref *Refund) error {
+	f.refunds = append(f.refunds, ref.ID)
+	return errors.New("invoicing is down") // must not fail the refund
+}
+
+// fakeLedger records which payments and refunds were booked.
+type fakeLedger struct {
+	payments []uint
+	refunds  []uint
+}
+
+func (f *fakeLedger) RecordPayment(p *Payment) error {
+	f.payments = append(f.payments, p.ID)
+	return nil
+}
+
+func (f *fakeLedger) RecordRefund(ref *Refund) error {
+	f.refunds = append(f.refunds, ref.ID)
+	return nil
+}
+
+func TestCreatePayment_IssuesInvoiceOnceCollected(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	invoices := &fakeInvoices{}
+	ledger := &fakeLedger{}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), invoices, ledger, nil, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", mock.AnythingOfType("uint")).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", mock.AnythingOfType("uint")).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Run(func(args mock.Arguments) {
+		args.Get(0).(*Payment).ID = 7
+	}).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", mock.AnythingOfType("uint"), mock.AnythingOfType("string")).Return(nil)
+
+	_, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PaymentToken: FakeTokenDecline})
+	require.NoError(t, err)
+	assert.Empty(t, invoices.payments, "declined payments are not invoiced")
+	assert.Empty(t, ledger.payments, "declined payments are not booked")
+
+	p, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{p.ID}, invoices.payments)
+	assert.Equal(t, []uint{p.ID}, ledger.payments)
+}
+
+func TestCreatePayment_DelayedSettlesByWebhook(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	provider := NewFakeProvider(testWebhookSecret, 50*time.Millisecond, "")
+	service := NewService(mockRepo, provider, testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	settled := make(chan *Payment, 1)
+	provider.OnWebhook(func(payload []byte, signature string) {
+		p, err := service.HandleWebhook(payload, signature)
+		assert.NoError(t, err)
+		settled <- p
+	})
+
+	var stored *Payment
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.AnythingOfType("string")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*Payment)
+	}).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PaymentToken: FakeTokenDelay})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, payment.Status)
+
+	mockRepo.On("FindByProviderRef", payment.ProviderRef).Return(stored, nil)
+
+	select {
+	case p := <-settled:
+		assert.Equal(t, StatusPaid, p.Status)
+	case <-time.After(time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
+
+func TestHandleWebhook_RejectsBadSignature(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	payload := []byte(`{"id":"evt_1","type":"payment.succeeded","intent_id":"pi_fake_1"}`)
+
+	_, err := service.HandleWebhook(payload, SignWebhook([]byte("other"), payload, time.Now()))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	_, err = service.HandleWebhook(payload, SignWebhook([]byte(testWebhookSecret), payload, time.Now().Add(-time.Hour)))
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+
+	mockRepo.AssertNotCalled(t, "FindByProviderRef", mock.Anything)
+}
+
+func TestCreatePayment_ValidatesBooking(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	for _, tc := range []struct {
+		bookingID uint
+		want      error
+	}{
+		{99, ErrBookingNotFound},
+		{2, ErrForeignBooking},
+		{3, ErrBookingNotPayable},
+		{4, ErrBookingNotPayable},
+		{5, ErrNothingDue},
+	} {
+		payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: tc.bookingID, Method: "card"})
+		assert.ErrorIs(t, err, tc.want)
+		assert.Nil(t, payment)
+	}
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+// fakePromotions takes discount off every claim and records released uses.
+type fakePromotions struct {
+	discount money.Money
+	err      error
+	released []uint
+}
+
+func (f *fakePromotions) Redeem(claim promo.Claim) (*promo.Redemption, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &promo.Redemption{ID: 7, UserID: claim.UserID, ClassID: claim.Class.ID,
+		Price: claim.Class.Price, Discount: f.discount}, nil
+}
+
+func (f *fakePromotions) Release(id uint) error {
+	f.released = append(f.released, id)
+	return nil
+}
+
+func TestCreatePayment_PromoCodeDiscount(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	promos := &fakePromotions{discount: usd(1000)}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, promos, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.Anything).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PromoCode: " jan20 "})
+	require.NoError(t, err)
+	assert.Equal(t, usd(4000), payment.Amount)
+	assert.Equal(t, usd(1000), payment.Discount)
+	assert.Equal(t, "JAN20", payment.PromoCode)
+	assert.Equal(t, StatusPaid, payment.Status)
+	assert.Empty(t, promos.released)
+}
+
+func TestCreatePayment_PromoCodeCoversPrice(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	promos := &fakePromotions{discount: usd(5000)}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, promos, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusPending).Return(nil).Once()
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), StatusPaid).Return(nil).Once()
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PromoCode: "FIRSTFREE"})
+	require.NoError(t, err)
+	assert.True(t, payment.Amount.IsZero())
+	assert.Equal(t, StatusPaid, payment.Status)
+	assert.Empty(t, payment.ProviderRef, "nothing is charged at the provider")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreatePayment_PromoCodeReleasedOnDecline(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	promos := &fakePromotions{discount: usd(1000)}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, promos, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+	mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+	mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.Anything).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card",
+		PaymentToken: "tok_decline", PromoCode: "JAN20"})
+	require.NoError(t, err)
+	assert.Equal(t, StatusFailed, payment.Status)
+	assert.Equal(t, []uint{7}, promos.released)
+}
+
+func TestCreatePayment_PromoCodeRejected(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	promos := &fakePromotions{err: promo.ErrCodeUsedUp}
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, promos, testApprovalThreshold, testTaxes)
+
+	mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+	mockRepo.On("LockBooking", uint(1)).Return(nil)
+
+	payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card", PromoCode: "JAN20"})
+	assert.ErrorIs(t, err, promo.ErrCodeUsedUp)
+	assert.Nil(t, payment)
+	mockRepo.AssertNotCalled(t, "Create", mock.Anything)
+}
+
+func TestCreatePayment_Tax(t *testing.T) {
+	for _, tc := range []struct {
+		mode            string
+		net, tax, gross int64
+	}{
+		{tax.ModeInclusive, 4000, 1000, 5000},
+		{tax.ModeExclusive, 5000, 1250, 6250},
+	} {
+		mockRepo := new(MockPaymentRepository)
+		rates := tax.Rates{Mode: tc.mode, Class: 2500, Membership: 500}
+		service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, rates)
+
+		mockRepo.On("FindByBookingID", uint(1)).Return(nil, gorm.ErrRecordNotFound)
+		mockRepo.On("LockBooking", uint(1)).Return(nil)
+		mockRepo.On("Create", mock.AnythingOfType("*payment.Payment")).Return(nil)
+		mockRepo.On("Update", mock.AnythingOfType("*payment.Payment")).Return(nil)
+		mockRepo.On("UpdateBookingPaymentStatus", uint(1), mock.Anything).Return(nil)
+
+		payment, err := service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: "card"})
+		require.NoError(t, err, tc.mode)
+		assert.Equal(t, usd(tc.gross), payment.Amount, tc.mode)
+		assert.Equal(t, usd(tc.net), payment.Net, tc.mode)
+		assert.Equal(t, usd(tc.tax), payment.Tax, tc.mode)
+		assert.Equal(t, 2500, payment.TaxRate, tc.mode)
+
+		// Memberships are taxed at their own rate.
+		mockRepo.On("LockSubscription", uint(3)).Return(nil)
+		mockRepo.On("FindSubscriptionDue", uint(3)).Return(&SubscriptionDue{UserID: 1, Status: "active", Price: usd(1000)}, nil)
+		mockRepo.On("SetSubscriptionPendingPayment", uint(3), mock.Anything).Return(nil)
+		membership, err := service.ChargeMembership(1, 3, usd(1000), "tok_success")
+		require.NoError(t, err, tc.mode)
+		assert.Equal(t, 500, membership.TaxRate, tc.mode)
+	}
+}
@@ -0,0 +1,40 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrIllegalTransition = errors.New("illegal payment status transition")
+
+// transitions lists the states each payment state may move to. failed and
+// refunded are final; a failed booking payment is retried as a new payment.
+var transitions = map[string][]string{
+	StatusPending:           {StatusAuthorized, StatusPaid, StatusFailed},
+	StatusAuthorized:        {StatusPaid, StatusFailed},
+	StatusPaid:              {StatusPartiallyRefunded, StatusRefunded},
+	StatusPartiallyRefunded: {StatusPartiallyRefunded, StatusRefunded},
+}
+
+func canTransition(from, to string) bool {
+	for _, s := range transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transition moves p to status to, or fails with ErrIllegalTransition.
+func (p *Payment) transition(to string) error {
+	if !canTransition(p.Status, to) {
+		return fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, p.Status, to)
+	}
+	p.Status = to
+	return nil
+}
+
+// refundable reports whether money taken by p can still be returned.
+func (p *Payment) refundable() bool {
+	return p.Status == StatusPaid || p.Status == StatusPartiallyRefunded
+}
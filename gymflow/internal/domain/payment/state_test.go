@@ -0,0 +1,55 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPaymentTransitions(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		ok       bool
+	}{
+		{StatusPending, StatusAuthorized, true},
+		{StatusPending, StatusPaid, true},
+		{StatusAuthorized, StatusPaid, true},
+		{StatusAuthorized, StatusFailed, true},
+		{StatusPaid, StatusPartiallyRefunded, true},
+		{StatusPartiallyRefunded, StatusRefunded, true},
+		{StatusPaid, StatusPending, false},
+		{StatusPaid, StatusFailed, false},
+		{StatusFailed, StatusPaid, false},
+		{StatusPending, StatusRefunded, false},
+		{StatusRefunded, StatusPartiallyRefunded, false},
+		{"completed", StatusPaid, false},
+	} {
+		p := &Payment{Status: tc.from}
+		err := p.transition(tc.to)
+		if tc.ok {
+			assert.NoError(t, err, "%s -> %s", tc.from, tc.to)
+			assert.Equal(t, tc.to, p.Status)
+		} else {
+			assert.ErrorIs(t, err, ErrIllegalTransition, "%s -> %s", tc.from, tc.to)
+			assert.Equal(t, tc.from, p.Status)
+		}
+	}
+}
+
+func TestHandleWebhook_IgnoresEventsForSettledPayments(t *testing.T) {
+	mockRepo := new(MockPaymentRepository)
+	service := NewService(mockRepo, NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+
+	paid := &Payment{ID: 1, BookingID: 1, Kind: KindBooking, Status: StatusPaid, ProviderRef: "pi_fake_1"}
+	mockRepo.On("FindByProviderRef", "pi_fake_1").Return(paid, nil)
+
+	payload := []byte(`{"id":"evt_2","type":"payment.failed","intent_id":"pi_fake_1","failure_reason":"late"}`)
+	p, err := service.HandleWebhook(payload, SignWebhook([]byte(testWebhookSecret), payload, time.Now()))
+
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPaid, p.Status)
+	mockRepo.AssertNotCalled(t, "Update", mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateBookingPaymentStatus", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,417 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"gymflow/internal/money"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidTarget          = errors.New("pass exactly one of booking_id and subscription_id")
+	ErrSubscriptionNotFound   = errors.New("subscription not found")
+	ErrSubscriptionNotPayable = errors.New("subscription cannot be paid")
+	ErrNoOpenTill             = errors.New("no open till session")
+	ErrTillOpen               = errors.New("staff member already has an open till session")
+	ErrTillClosed             = errors.New("till session is closed")
+	ErrForeignTill            = errors.New("till session belongs to another staff member")
+	ErrTillCurrency           = errors.New("amount is not in the till's currency")
+	ErrInvalidFloat           = errors.New("float must be a non-negative amount in a known currency")
+)
+
+// payableSubscriptions mirrors membership.StatusActive and StatusPastDue:
+// an in-person payment renews them or settles what is past due.
+var payableSubscriptions = map[string]bool{"active": true, "past_due": true}
+
+// RecordInPersonPayment records cash or a card terminal payment a staff
+// member took at their open till. The money is already in hand, so the
+// payment is stored paid. A membership payment is handed to the billing
+// job, which renews the subscription on its next run as it does for charges
+// settled by webhook.
+func (s *service) RecordInPersonPayment(staffID uint, req InPersonPaymentRequest) (*Payment, error) {
+	if (req.BookingID == 0) == (req.SubscriptionID == 0) {
+		return nil, ErrInvalidTarget
+	}
+	till, err := findOpenTill(s.repo, staffID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &Payment{
+		Method:     req.Method,
+		Status:     StatusPending,
+		RecordedBy: &staffID,
+	}
+	if req.Method == MethodTerminal {
+		payment.TerminalRef = req.TerminalRef
+	}
+	if req.BookingID != 0 {
+		err = s.inPersonBooking(payment, req.BookingID)
+	} else {
+		err = s.inPersonMembership(payment, req.SubscriptionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tillCurrency(till, payment.Amount); err != nil {
+		return nil, err
+	}
+	s.price(payment, payment.Amount)
+	if err := markPaid(payment, time.Now()); err != nil {
+		return nil, err
+	}
+
+	err = s.repo.Transaction(func(repo Repository) error {
+		// The till may have been closed since: re-read it under its lock.
+		till, err := lockOpenTill(repo, staffID)
+		if err != nil {
+			return err
+		}
+		if err := tillCurrency(till, payment.Amount); err != nil {
+			return err
+		}
+		payment.TillSessionID = &till.ID
+		switch payment.Kind {
+		case KindBooking:
+			err = claimBooking(repo, payment.BookingID)
+		case KindMembership:
+			err = claimSubscription(repo, *payment.SubscriptionID, true)
+		}
+		if err != nil {
+			return err
+		}
+		if err := repo.Create(payment); err != nil {
+			return err
+		}
+		if payment.Kind == KindBooking {
+			return repo.UpdateBookingPaymentStatus(payment.BookingID, payment.Status)
+		}
+		return pendOn(repo, payment)
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.issueInvoice(payment)
+	s.recordPayment(payment)
+	return payment, nil
+}
+
+// inPersonBooking prices payment as the class payment of the booking, under
+// the same rules as a member paying online.
+func (s *service) inPersonBooking(payment *Payment, bookingID uint) error {
+	b, err := s.bookings.FindBookingByID(bookingID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrBookingNotFound
+	}
+	if err != nil {
+		return err
+	}
+	class, err := s.classDue(b.UserID, bookingID)
+	if err != nil {
+		return err
+	}
+	existing, err := s.repo.FindByBookingID(bookingID)
+	if err == nil && existing != nil && existing.Status != StatusFailed {
+		return ErrPaymentExists
+	}
+	payment.UserID = b.UserID
+	payment.BookingID = bookingID
+	payment.Kind = KindBooking
+	payment.Amount = class.Price
+	return nil
+}
+
+// inPersonMembership prices payment as the next period of the subscription.
+func (s *service) inPersonMembership(payment *Payment, subscriptionID uint) error {
+	sub, err := s.repo.FindSubscriptionDue(subscriptionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSubscriptionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !payableSubscriptions[sub.Status] {
+		return fmt.Errorf("%w: subscription is %s", ErrSubscriptionNotPayable, sub.Status)
+	}
+	if sub.PendingPaymentID != nil {
+		return fmt.Errorf("%w: a payment for it is still settling", ErrSubscriptionNotPayable)
+	}
+	payment.UserID = sub.UserID
+	payment.SubscriptionID = &subscriptionID
+	payment.Kind = KindMembership
+	payment.Amount = sub.Price
+	return nil
+}
+
+// claimSubscription locks the subscription and re-checks that no payment for
+// it is settling, so a desk payment and a renewal charge taken at the same
+// time can't both pay the same period. At the desk the subscription must
+// also still be payable.
+func claimSubscription(repo Repository, subscriptionID uint, atDesk bool) error {
+	if err := repo.LockSubscription(subscriptionID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSubscriptionNotFound
+		}
+		return err
+	}
+	sub, err := repo.FindSubscriptionDue(subscriptionID)
+	if err != nil {
+		return err
+	}
+	if atDesk && !payableSubscriptions[sub.Status] {
+		return fmt.Errorf("%w: subscription is %s", ErrSubscriptionNotPayable, sub.Status)
+	}
+	if sub.PendingPaymentID != nil {
+		return fmt.Errorf("%w: a payment for it is still settling", ErrSubscriptionNotPayable)
+	}
+	return nil
+}
+
+// pendOn hands a membership payment to the billing job.
+func pendOn(repo Repository, payment *Payment) error {
+	err := repo.SetSubscriptionPendingPayment(*payment.SubscriptionID, payment.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("%w: a payment for it is still settling", ErrSubscriptionNotPayable)
+	}
+	return err
+}
+
+// payOutOf assigns a cash refund that is about to be paid to the open till
+// of the staff member handing the cash back. Refunds issued automatically
+// have no one at the desk, so they wait for an admin to approve them.
+func payOutOf(repo Repository, ref *Refund, staffID *uint) error {
+	if ref.Status != RefundProcessing {
+		return nil
+	}
+	if staffID == nil {
+		ref.Status = RefundPendingApproval
+		return nil
+	}
+	till, err := lockOpenTill(repo, *staffID)
+	if errors.Is(err, ErrNoOpenTill) {
+		return fmt.Errorf("%w: cash refunds are paid out of the staff member's till", ErrNoOpenTill)
+	}
+	if err != nil {
+		return err
+	}
+	if err := tillCurrency(till, ref.Amount); err != nil {
+		return err
+	}
+	ref.TillSessionID = &till.ID
+	return nil
+}
+
+func findOpenTill(repo Repository, staffID uint) (*TillSession, error) {
+	till, err := repo.FindOpenTill(staffID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNoOpenTill
+	}
+	return till, err
+}
+
+// lockOpenTill locks the staff member's open till and checks under the lock
+// that it is still open, so no money lands on a till being cashed up. Must
+// be called inside a transaction.
+func lockOpenTill(repo Repository, staffID uint) (*TillSession, error) {
+	till, err := findOpenTill(repo, staffID)
+	if err != nil {
+		return nil, err
+	}
+	if till, err = repo.LockTill(till.ID); err != nil {
+		return nil, err
+	}
+	if till.Status != TillOpen {
+		return nil, ErrNoOpenTill
+	}
+	return till, nil
+}
+
+func tillCurrency(till *TillSession, m money.Money) error {
+	if m.Currency != till.OpeningFloat.Currency {
+		return fmt.Errorf("%w: %s", ErrTillCurrency, till.OpeningFloat.Currency)
+	}
+	return nil
+}
+
+func validFloat(m money.Money) error {
+	if m.Validate() != nil || m.IsNegative() {
+		return ErrInvalidFloat
+	}
+	return nil
+}
+
+func (s *service) OpenTill(staffID uint, req OpenTillRequest) (*TillSession, error) {
+	if err := validFloat(req.OpeningFloat); err != nil {
+		return nil, err
+	}
+	till := &TillSession{
+		StaffID:      staffID,
+		Status:       TillOpen,
+		OpeningFloat: req.OpeningFloat,
+		OpenedAt:     time.Now(),
+	}
+	err := s.repo.Transaction(func(repo Repository) error {
+		if _, err := repo.FindOpenTill(staffID); err == nil {
+			return ErrTillOpen
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return repo.CreateTill(till)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return till, nil
+}
+
+func (s *service) CurrentTill(staffID uint) (*TillSummary, error) {
+	till, err := findOpenTill(s.repo, staffID)
+	if err != nil {
+		return nil, err
+	}
+	return s.summarize(till)
+}
+
+// CloseTill cashes up a till session with the cash counted in the drawer.
+// Staff close their own tills; admins may close anyone's.
+func (s *service) CloseTill(id, staffID uint, staffRole string, req CloseTillRequest) (*TillSummary, error) {
+	if err := validFloat(req.Counted); err != nil {
+		return nil, err
+	}
+	var sum TillSummary
+	err := s.repo.Transaction(func(repo Repository) error {
+		// Locked, so no payment or refund lands on the till between summing
+		// it and closing it.
+		till, err := repo.LockTill(id)
+		if err != nil {
+			return err
+		}
+		if till.StaffID != staffID && staffRole != adminRole {
+			return ErrForeignTill
+		}
+		if till.Status != TillOpen {
+			return ErrTillClosed
+		}
+		if err := tillCurrency(till, req.Counted); err != nil {
+			return err
+		}
+
+		totals, err := repo.SumTill(till.ID)
+		if err != nil {
+			return err
+		}
+		expected := expectedCash(till, totals)
+		now := time.Now()
+		till.Status = TillClosed
+		till.ClosingFloat = req.Counted
+		till.Expected = expected
+		till.Variance = money.New(req.Counted.Amount-expected.Amount, expected.Currency)
+		till.ClosedBy = &staffID
+		till.ClosedAt = &now
+		till.Note = req.Note
+		if err := repo.UpdateTill(till); err != nil {
+			return err
+		}
+		sum = summary(till, totals)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &sum, nil
+}
+
+func expectedCash(till *TillSession, totals *TillTotals) money.Money {
+	return money.New(till.OpeningFloat.Amount+totals.CashTaken-totals.CashRefunded, till.OpeningFloat.Currency)
+}
+
+func (s *service) summarize(till *TillSession) (*TillSummary, error) {
+	totals, err := s.repo.SumTill(till.ID)
+	if err != nil {
+		return nil, err
+	}
+	sum := summary(till, totals)
+	return &sum, nil
+}
+
+// summary reports an open till as it stands and a closed one as it was
+// cashed up.
+func summary(till *TillSession, totals *TillTotals) TillSummary {
+	currency := till.OpeningFloat.Currency
+	sum := TillSummary{
+		ID:           till.ID,
+		StaffID:      till.StaffID,
+		Status:       till.Status,
+		OpenedAt:     till.OpenedAt,
+		ClosedAt:     till.ClosedAt,
+		ClosedBy:     till.ClosedBy,
+		OpeningFloat: till.OpeningFloat,
+		CashTaken:    money.New(totals.CashTaken, currency),
+		CashRefunded: money.New(totals.CashRefunded, currency),
+		Expected:     expectedCash(till, totals),
+		Terminal:     money.New(totals.Terminal, currency),
+		Payments:     totals.Payments,
+		Note:         till.Note,
+	}
+	if till.Status == TillClosed {
+		counted, variance := till.ClosingFloat, till.Variance
+		sum.Expected = till.Expected
+		sum.Counted = &counted
+		sum.Variance = &variance
+	}
+	return sum
+}
+
+// CashUp reports the till sessions opened on the UTC day of date, with
+// totals per currency.
+func (s *service) CashUp(date time.Time) (*CashUpReport, error) {
+	date = date.UTC()
+	from := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	tills, err := s.repo.ListTills(from, from.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CashUpReport{Date: from.Format("2006-01-02"), Tills: make([]TillSummary, 0, len(tills))}
+	totals := map[string]*CashUpTotal{}
+	for i := range tills {
+		sum, err := s.summarize(&tills[i])
+		if err != nil {
+			return nil, err
+		}
+		report.Tills = append(report.Tills, *sum)
+
+		currency := sum.OpeningFloat.Currency
+		t, ok := totals[currency]
+		if !ok {
+			zero := money.Zero(currency)
+			t = &CashUpTotal{
+				Currency: currency, OpeningFloat: zero, CashTaken: zero, CashRefunded: zero,
+				Expected: zero, Counted: zero, Variance: zero, Terminal: zero,
+			}
+			totals[currency] = t
+		}
+		t.OpeningFloat.Amount += sum.OpeningFloat.Amount
+		t.CashTaken.Amount += sum.CashTaken.Amount
+		t.CashRefunded.Amount += sum.CashRefunded.Amount
+		t.Expected.Amount += sum.Expected.Amount
+		t.Terminal.Amount += sum.Terminal.Amount
+		if sum.Counted == nil {
+			t.OpenTills++
+			continue
+		}
+		t.Counted.Amount += sum.Counted.Amount
+		t.Variance.Amount += sum.Variance.Amount
+	}
+
+	report.Totals = make([]CashUpTotal, 0, len(totals))
+	for _, t := range totals {
+		report.Totals = append(report.Totals, *t)
+	}
+	sort.Slice(report.Totals, func(i, j int) bool { return report.Totals[i].Currency < report.Totals[j].Currency })
+	return report, nil
+}
@@ -0,0 +1,231 @@
+package payment
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// tillSetup runs the service on SQLite with bookings 1 and 2 of
+// testBookings and subscription 7 (member 3, past_due, 30.00 USD plan).
+func tillSetup(t *testing.T) (Service, *gorm.DB) {
+	db := tillDB(t)
+	service := NewService(NewRepository(db), NewFakeProvider(testWebhookSecret, 0, ""), testBookings(), nil, nil, nil, testApprovalThreshold, testTaxes)
+	return service, db
+}
+
+// tillDB is the database of tillSetup.
+func tillDB(t *testing.T) *gorm.DB {
+	dsn := filepath.Join(t.TempDir(), "till.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Payment{}, &Refund{}, &TillSession{}, &booking.Booking{}))
+	require.NoError(t, db.Create(&[]booking.Booking{
+		{ID: 1, UserID: 1, ClassID: 10, Status: booking.BookingStatusBooked},
+		{ID: 2, UserID: 2, ClassID: 10, Status: booking.BookingStatusBooked},
+	}).Error)
+	// The membership package owns these tables; only the columns read here.
+	require.NoError(t, db.Exec("CREATE TABLE plans (id integer primary key, price_minor integer, price_currency text)").Error)
+	require.NoError(t, db.Exec("CREATE TABLE subscriptions (id integer primary key, user_id integer, plan_id integer, status text, pending_payment_id integer)").Error)
+	require.NoError(t, db.Exec("INSERT INTO plans VALUES (1, 3000, 'USD')").Error)
+	require.NoError(t, db.Exec("INSERT INTO subscriptions VALUES (7, 3, 1, 'past_due', NULL)").Error)
+	return db
+}
+
+func TestInPersonPayments(t *testing.T) {
+	service, db := tillSetup(t)
+	const staff = uint(9)
+
+	_, err := service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 1, Method: MethodCash})
+	assert.ErrorIs(t, err, ErrNoOpenTill)
+
+	till, err := service.OpenTill(staff, OpenTillRequest{OpeningFloat: usd(10000)})
+	require.NoError(t, err)
+	_, err = service.OpenTill(staff, OpenTillRequest{OpeningFloat: usd(10000)})
+	assert.ErrorIs(t, err, ErrTillOpen)
+
+	cash, err := service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 1, Method: MethodCash})
+	require.NoError(t, err)
+	assert.Equal(t, StatusPaid, cash.Status)
+	assert.Equal(t, usd(5000), cash.Amount)
+	assert.Equal(t, uint(1), cash.UserID)
+	assert.Equal(t, staff, *cash.RecordedBy)
+	assert.Equal(t, till.ID, *cash.TillSessionID)
+	var b booking.Booking
+	require.NoError(t, db.First(&b, 1).Error)
+	assert.Equal(t, StatusPaid, b.PaymentStatus)
+
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 1, Method: MethodCash})
+	assert.ErrorIs(t, err, ErrPaymentExists)
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 2, SubscriptionID: 7, Method: MethodCash})
+	assert.ErrorIs(t, err, ErrInvalidTarget)
+
+	card, err := service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 2, Method: MethodTerminal, TerminalRef: "T-1001"})
+	require.NoError(t, err)
+	assert.Equal(t, "T-1001", card.TerminalRef)
+	assert.Empty(t, card.ProviderRef)
+
+	// The membership billing job renews the subscription with the payment.
+	renewal, err := service.RecordInPersonPayment(staff, InPersonPaymentRequest{SubscriptionID: 7, Method: MethodCash})
+	require.NoError(t, err)
+	assert.Equal(t, KindMembership, renewal.Kind)
+	assert.Equal(t, usd(3000), renewal.Amount)
+	var pending uint
+	require.NoError(t, db.Raw("SELECT pending_payment_id FROM subscriptions WHERE id = 7").Scan(&pending).Error)
+	assert.Equal(t, renewal.ID, pending)
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{SubscriptionID: 7, Method: MethodCash})
+	assert.ErrorIs(t, err, ErrSubscriptionNotPayable)
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{SubscriptionID: 8, Method: MethodCash})
+	assert.ErrorIs(t, err, ErrSubscriptionNotFound)
+}
+
+func TestCashRefundsAndCashUp(t *testing.T) {
+	service, _ := tillSetup(t)
+	const staff, admin = uint(9), uint(1)
+
+	till, err := service.OpenTill(staff, OpenTillRequest{OpeningFloat: usd(10000)})
+	require.NoError(t, err)
+	cash, err := service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 1, Method: MethodCash})
+	require.NoError(t, err)
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{SubscriptionID: 7, Method: MethodCash})
+	require.NoError(t, err)
+	_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 2, Method: MethodTerminal})
+	require.NoError(t, err)
+
+	// Cash is handed back out of the open till, without the provider.
+	ref, err := service.CreateRefund(cash.ID, staff, "trainer", CreateRefundRequest{Amount: ptr(usd(2000)), Reason: RefundReasonServiceIssue})
+	require.NoError(t, err)
+	assert.Equal(t, RefundSucceeded, ref.Status)
+	assert.Equal(t, till.ID, *ref.TillSessionID)
+	assert.Empty(t, ref.ProviderRef)
+
+	// An automatic refund of cash waits for someone to pay it out.
+	require.NoError(t, service.RefundBookings([]uint{1}, RefundReasonMemberCancel))
+	pending, err := service.ListRefunds(RefundPendingApproval)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, usd(3000), pending[0].Amount)
+	_, err = service.ApproveRefund(pending[0].ID, admin)
+	assert.ErrorIs(t, err, ErrNoOpenTill)
+
+	sum, err := service.CurrentTill(staff)
+	require.NoError(t, err)
+	assert.Equal(t, usd(8000), sum.CashTaken)
+	assert.Equal(t, usd(2000), sum.CashRefunded)
+	assert.Equal(t, usd(16000), sum.Expected)
+	assert.Equal(t, usd(5000), sum.Terminal)
+	assert.Equal(t, int64(3), sum.Payments)
+	assert.Nil(t, sum.Variance)
+
+	_, err = service.CloseTill(till.ID, 8, "trainer", CloseTillRequest{Counted: usd(15900)})
+	assert.ErrorIs(t, err, ErrForeignTill)
+	_, err = service.CloseTill(till.ID, staff, "trainer", CloseTillRequest{Counted: money.New(15900, "EUR")})
+	assert.ErrorIs(t, err, ErrTillCurrency)
+	closed, err := service.CloseTill(till.ID, staff, "trainer", CloseTillRequest{Counted: usd(15900), Note: "1.00 short"})
+	require.NoError(t, err)
+	assert.Equal(t, TillClosed, closed.Status)
+	assert.Equal(t, usd(-100), *closed.Variance)
+	_, err = service.CloseTill(till.ID, staff, "trainer", CloseTillRequest{Counted: usd(15900)})
+	assert.ErrorIs(t, err, ErrTillClosed)
+	_, err = service.CurrentTill(staff)
+	assert.ErrorIs(t, err, ErrNoOpenTill)
+
+	report, err := service.CashUp(time.Now())
+	require.NoError(t, err)
+	require.Len(t, report.Tills, 1)
+	require.Len(t, report.Totals, 1)
+	assert.Equal(t, usd(16000), report.Totals[0].Expected)
+	assert.Equal(t, usd(15900), report.Totals[0].Counted)
+	assert.Equal(t, usd(-100), report.Totals[0].Variance)
+	assert.Equal(t, 0, report.Totals[0].OpenTills)
+
+	report, err = service.CashUp(time.Now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Empty(t, report.Tills)
+}
+
+func TestBookingPaidOnceUnderConcurrency(t *testing.T) {
+	service, db := tillSetup(t)
+	const staff = uint(9)
+	_, err := service.OpenTill(staff, OpenTillRequest{OpeningFloat: usd(10000)})
+	require.NoError(t, err)
+
+	// Online and at the desk at once: exactly one payment is taken.
+	var wg sync.WaitGroup
+	errs := make(chan error, 32)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(online bool) {
+			defer wg.Done()
+			var err error
+			if online {
+				_, err = service.CreatePayment(1, CreatePaymentRequest{BookingID: 1, Method: MethodCard, PaymentToken: FakeTokenSuccess})
+			} else {
+				_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{BookingID: 1, Method: MethodCash})
+			}
+			errs <- err
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrPaymentExists)
+	}
+	assert.Equal(t, 1, succeeded)
+	var count int64
+	require.NoError(t, db.Model(&Payment{}).Where("booking_id = ?", 1).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestSubscriptionPaidOnceUnderConcurrency(t *testing.T) {
+	service, db := tillSetup(t)
+	const staff = uint(9)
+	_, err := service.OpenTill(staff, OpenTillRequest{OpeningFloat: usd(10000)})
+	require.NoError(t, err)
+
+	// Two desks and the renewal job at once: one payment is handed over.
+	var wg sync.WaitGroup
+	errs := make(chan error, 24)
+	for i := 0; i < 24; i++ {
+		wg.Add(1)
+		go func(desk bool) {
+			defer wg.Done()
+			var err error
+			if desk {
+				_, err = service.RecordInPersonPayment(staff, InPersonPaymentRequest{SubscriptionID: 7, Method: MethodCash})
+			} else {
+				_, err = service.ChargeMembership(3, 7, usd(3000), FakeTokenSuccess)
+			}
+			errs <- err
+		}(i%3 != 0)
+	}
+	wg.Wait()
+	close(errs)
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		assert.ErrorIs(t, err, ErrSubscriptionNotPayable)
+	}
+	assert.Equal(t, 1, succeeded)
+	var count int64
+	require.NoError(t, db.Model(&Payment{}).Where("subscription_id = ?", 7).Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,91 @@
+package promo
+
+import (
+	"time"
+
+	"gymflow/internal/money"
+)
+
+type CreateCodeRequest struct {
+	Code        string      `json:"code" binding:"required"`
+	Description string      `json:"description"`
+	Type        string      `json:"type" binding:"required"` // percent, fixed
+	PercentOff  int         `json:"percent_off"`
+	AmountOff   money.Money `json:"amount_off"`
+	ValidFrom   *time.Time  `json:"valid_from"`
+	ValidUntil  *time.Time  `json:"valid_until"`
+
+	MaxRedemptions int `json:"max_redemptions" binding:"min=0"`
+	MaxPerUser     int `json:"max_per_user" binding:"min=0"`
+
+	ClassIDs   []uint   `json:"class_ids"`
+	ClassTypes []string `json:"class_types"`
+	Tiers      []string `json:"tiers"` // basic, premium, vip
+}
+
+// UpdateCodeRequest changes a code. The code text and its discount are
+// fixed once created; deactivate it and create another instead.
+type UpdateCodeRequest struct {
+	Description    *string    `json:"description"`
+	ValidFrom      *time.Time `json:"valid_from"`
+	ValidUntil     *time.Time `json:"valid_until"`
+	MaxRedemptions *int       `json:"max_redemptions" binding:"omitempty,min=0"`
+	MaxPerUser     *int       `json:"max_per_user" binding:"omitempty,min=0"`
+	ClassIDs       *[]uint    `json:"class_ids"`
+	ClassTypes     *[]string  `json:"class_types"`
+	Tiers          *[]string  `json:"tiers"`
+	Active         *bool      `json:"active"`
+}
+
+type CodeResponse struct {
+	ID             uint         `json:"id"`
+	Code           string       `json:"code"`
+	Description    string       `json:"description"`
+	Type           string       `json:"type"`
+	PercentOff     int          `json:"percent_off,omitempty"`
+	AmountOff      *money.Money `json:"amount_off,omitempty"`
+	ValidFrom      *time.Time   `json:"valid_from,omitempty"`
+	ValidUntil     *time.Time   `json:"valid_until,omitempty"`
+	MaxRedemptions int          `json:"max_redemptions"`
+	MaxPerUser     int          `json:"max_per_user"`
+	ClassIDs       []uint       `json:"class_ids"`
+	ClassTypes     []string     `json:"class_types"`
+	Tiers          []string     `json:"tiers"`
+	Active         bool         `json:"active"`
+	CreatedAt      time.Time    `json:"created_at"`
+}
+
+func ToCodeResponse(c *Code) *CodeResponse {
+	resp := &CodeResponse{
+		ID:             c.ID,
+		Code:           c.Code,
+		Description:    c.Description,
+		Type:           c.Type,
+		PercentOff:     c.PercentOff,
+		ValidFrom:      c.ValidFrom,
+		ValidUntil:     c.ValidUntil,
+		MaxRedemptions: c.MaxRedemptions,
+		MaxPerUser:     c.MaxPerUser,
+		ClassIDs:       c.classIDs(),
+		ClassTypes:     splitList(c.ClassTypes),
+		Tiers:          splitList(c.Tiers),
+		Active:         c.Active,
+		CreatedAt:      c.CreatedAt,
+	}
+	if resp.ClassIDs == nil {
+		resp.ClassIDs = []uint{}
+	}
+	if c.Type == TypeFixed {
+		off := c.AmountOff
+		resp.AmountOff = &off
+	}
+	return resp
+}
+
+func ToCodeResponses(codes []Code) []*CodeResponse {
+	out := make([]*CodeResponse, len(codes))
+	for i := range codes {
+		out[i] = ToCodeResponse(&codes[i])
+	}
+	return out
+}
@@ -0,0 +1,129 @@
+package promo
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"gymflow/internal/money"
+)
+
+const (
+	TypePercent = "percent"
+	TypeFixed   = "fixed"
+)
+
+// Code is a promo code members enter when paying for a class, e.g. JAN20 for
+// 20% off in January. Restrictions left empty apply to everything.
+type Code struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Code        string    `gorm:"size:40;not null;uniqueIndex" json:"code"` // stored upper-case
+	Description string    `json:"description"`
+	Type        string    `gorm:"size:16;not null" json:"type"`
+	// PercentOff is the discount of percent codes, 1-100.
+	PercentOff int `json:"percent_off,omitempty"`
+	// AmountOff is the discount of fixed codes; it never exceeds the price.
+	AmountOff money.Money `gorm:"embedded;embeddedPrefix:amount_off_" json:"amount_off"`
+
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	// MaxRedemptions caps uses across all members, MaxPerUser the uses of
+	// one member; 0 is unlimited.
+	MaxRedemptions int `gorm:"not null;default:0" json:"max_redemptions"`
+	MaxPerUser     int `gorm:"not null;default:0" json:"max_per_user"`
+
+	// ClassIDs, ClassTypes and Tiers are comma-separated, like Room.Equipment.
+	ClassIDs   string `json:"class_ids"`
+	ClassTypes string `json:"class_types"` // lowercase, e.g. "spin,yoga"
+	Tiers      string `json:"tiers"`       // membership tiers, e.g. "premium,vip"
+	Active     bool   `gorm:"not null;default:true" json:"active"`
+}
+
+func (Code) TableName() string { return "promo_codes" }
+
+// Redemption is one use of a code on a payment. A redemption whose payment
+// failed is released and stops counting towards the limits.
+type Redemption struct {
+	ID         uint        `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time   `json:"created_at"`
+	CodeID     uint        `gorm:"index" json:"code_id"`
+	UserID     uint        `gorm:"index" json:"user_id"`
+	ClassID    uint        `json:"class_id"`
+	Price      money.Money `gorm:"embedded;embeddedPrefix:price_" json:"price"`
+	Discount   money.Money `gorm:"embedded;embeddedPrefix:discount_" json:"discount"`
+	ReleasedAt *time.Time  `json:"released_at,omitempty"`
+}
+
+func (Redemption) TableName() string { return "promo_redemptions" }
+
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func contains(list, v string) bool {
+	for _, s := range splitList(list) {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeList lowercases, trims and de-duplicates values.
+func normalizeList(values []string) string {
+	seen := map[string]bool{}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" || seen[v] {
+			continue
+		}
+		seen[v] = true
+		out = append(out, v)
+	}
+	return strings.Join(out, ",")
+}
+
+func joinIDs(ids []uint) string {
+	seen := map[uint]bool{}
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id == 0 || seen[id] {
+			continue
+		}
+		seen[id] = true
+		out = append(out, strconv.FormatUint(uint64(id), 10))
+	}
+	return strings.Join(out, ",")
+}
+
+func (c *Code) classIDs() []uint {
+	var ids []uint
+	for _, s := range splitList(c.ClassIDs) {
+		if id, err := strconv.ParseUint(s, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}
+
+// discountOn returns what the code takes off price. Percentages round half
+// up to the minor unit.
+func (c *Code) discountOn(price money.Money) money.Money {
+	off := money.Zero(price.Currency)
+	switch c.Type {
+	case TypePercent:
+		off.Amount = (price.Amount*int64(c.PercentOff) + 50) / 100
+	case TypeFixed:
+		off.Amount = c.AmountOff.Amount
+	}
+	if off.Amount > price.Amount {
+		off.Amount = price.Amount
+	}
+	return off
+}
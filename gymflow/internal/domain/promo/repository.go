@@ -0,0 +1,114 @@
+package promo
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Repository interface {
+	CreateCode(c *Code) error
+	UpdateCode(c *Code) error
+	FindCodeByID(id uint) (*Code, error)
+	ListCodes() ([]Code, error)
+
+	// Transaction runs fn against a repository bound to one transaction.
+	Transaction(fn func(repo Repository) error) error
+	// LockCode finds a code by its (upper-case) text and holds its row until
+	// the transaction ends, so concurrent redemptions respect the limits.
+	LockCode(code string) (*Code, error)
+
+	CreateRedemption(r *Redemption) error
+	// CountRedemptions counts the unreleased redemptions of a code, of one
+	// member only when userID is not 0.
+	CountRedemptions(codeID, userID uint) (int64, error)
+	ListRedemptions(codeID uint) ([]Redemption, error)
+	ReleaseRedemption(id uint, at time.Time) error
+
+	// FindUserTier reads the users table directly, like membership's
+	// SetUserTier writes it.
+	FindUserTier(userID uint) (string, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+func (r *repository) CreateCode(c *Code) error {
+	return r.db.Create(c).Error
+}
+
+func (r *repository) UpdateCode(c *Code) error {
+	return r.db.Save(c).Error
+}
+
+func (r *repository) FindCodeByID(id uint) (*Code, error) {
+	var c Code
+	if err := r.db.First(&c, id).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *repository) ListCodes() ([]Code, error) {
+	var codes []Code
+	err := r.db.Order("id DESC").Find(&codes).Error
+	return codes, err
+}
+
+func (r *repository) Transaction(fn func(repo Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&repository{db: tx})
+	})
+}
+
+// LockCode uses a no-op UPDATE, like booking's LockClass, so it also locks under SQLite.
+func (r *repository) LockCode(code string) (*Code, error) {
+	res := r.db.Model(&Code{}).Where("code = ?", code).UpdateColumn("max_redemptions", gorm.Expr("max_redemptions"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	var c Code
+	if err := r.db.Where("code = ?", code).First(&c).Error; err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *repository) CreateRedemption(red *Redemption) error {
+	return r.db.Create(red).Error
+}
+
+func (r *repository) CountRedemptions(codeID, userID uint) (int64, error) {
+	var n int64
+	q := r.db.Model(&Redemption{}).Where("code_id = ? AND released_at IS NULL", codeID)
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	err := q.Count(&n).Error
+	return n, err
+}
+
+func (r *repository) ListRedemptions(codeID uint) ([]Redemption, error) {
+	var reds []Redemption
+	err := r.db.Where("code_id = ?", codeID).Order("id DESC").Find(&reds).Error
+	return reds, err
+}
+
+func (r *repository) ReleaseRedemption(id uint, at time.Time) error {
+	return r.db.Model(&Redemption{}).Where("id = ? AND released_at IS NULL", id).
+		Update("released_at", at).Error
+}
+
+func (r *repository) FindUserTier(userID uint) (string, error) {
+	var tier string
+	err := r.db.Table("users").Select("membership_tier").Where("id = ?", userID).Scan(&tier).Error
+	return tier, err
+}
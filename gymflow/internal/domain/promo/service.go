@@ -0,0 +1,267 @@
+package promo
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gymflow/internal/domain/booking"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCodeNotFound    = errors.New("promo code not found")
+	ErrInvalidCode     = errors.New("invalid promo code")
+	ErrCodeNotValid    = errors.New("promo code is not valid at this time")
+	ErrCodeNotEligible = errors.New("promo code does not apply to this booking")
+	ErrCodeUsedUp      = errors.New("promo code has reached its usage limit")
+	ErrCodeAlreadyUsed = errors.New("promo code was already used by this member")
+)
+
+// tiers mirrors user.MembershipBasic, user.MembershipPremium and user.MembershipVIP.
+var tiers = map[string]bool{"basic": true, "premium": true, "vip": true}
+
+// Claim is a member applying a code to the price of a class.
+type Claim struct {
+	Code   string
+	UserID uint
+	Class  *booking.GymClass
+}
+
+type Service interface {
+	ListCodes() ([]Code, error)
+	CreateCode(req CreateCodeRequest) (*Code, error)
+	UpdateCode(id uint, req UpdateCodeRequest) (*Code, error)
+	ListRedemptions(codeID uint) ([]Redemption, error)
+
+	// Redeem checks the claim against the code's window, restrictions and
+	// limits and records one use of it. The payment service calls it before
+	// charging the discounted price.
+	Redeem(claim Claim) (*Redemption, error)
+	// Release gives back the use of a redemption whose payment failed.
+	Release(redemptionID uint) error
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return &service{repo: repo}
+}
+
+// normalizeCode is how codes are stored and looked up: members may type them
+// in any case.
+func normalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (s *service) ListCodes() ([]Code, error) {
+	return s.repo.ListCodes()
+}
+
+func validateCode(c *Code) error {
+	if c.Code == "" || strings.ContainsAny(c.Code, " \t") {
+		return fmt.Errorf("%w: code must be a single word", ErrInvalidCode)
+	}
+	switch c.Type {
+	case TypePercent:
+		if c.PercentOff < 1 || c.PercentOff > 100 {
+			return fmt.Errorf("%w: percent_off must be between 1 and 100", ErrInvalidCode)
+		}
+	case TypeFixed:
+		if !c.AmountOff.IsPositive() {
+			return fmt.Errorf("%w: amount_off must be positive", ErrInvalidCode)
+		}
+		if err := c.AmountOff.Validate(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidCode, err)
+		}
+	default:
+		return fmt.Errorf("%w: type must be percent or fixed", ErrInvalidCode)
+	}
+	if c.ValidFrom != nil && c.ValidUntil != nil && !c.ValidUntil.After(*c.ValidFrom) {
+		return fmt.Errorf("%w: valid_until must be after valid_from", ErrInvalidCode)
+	}
+	for _, t := range splitList(c.Tiers) {
+		if !tiers[t] {
+			return fmt.Errorf("%w: unknown tier %q", ErrInvalidCode, t)
+		}
+	}
+	return nil
+}
+
+func (s *service) CreateCode(req CreateCodeRequest) (*Code, error) {
+	c := &Code{
+		Code:           normalizeCode(req.Code),
+		Description:    req.Description,
+		Type:           req.Type,
+		ValidFrom:      req.ValidFrom,
+		ValidUntil:     req.ValidUntil,
+		MaxRedemptions: req.MaxRedemptions,
+		MaxPerUser:     req.MaxPerUser,
+		ClassIDs:       joinIDs(req.ClassIDs),
+		ClassTypes:     normalizeList(req.ClassTypes),
+		Tiers:          normalizeList(req.Tiers),
+		Active:         true,
+	}
+	if c.Type == TypePercent {
+		c.PercentOff = req.PercentOff
+	} else {
+		c.AmountOff = req.AmountOff
+	}
+	if err := validateCode(c); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateCode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *service) UpdateCode(id uint, req UpdateCodeRequest) (*Code, error) {
+	c, err := s.repo.FindCodeByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCodeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.Description != nil {
+		c.Description = *req.Description
+	}
+	if req.ValidFrom != nil {
+		c.ValidFrom = req.ValidFrom
+	}
+	if req.ValidUntil != nil {
+		c.ValidUntil = req.ValidUntil
+	}
+	if req.MaxRedemptions != nil {
+		c.MaxRedemptions = *req.MaxRedemptions
+	}
+	if req.MaxPerUser != nil {
+		c.MaxPerUser = *req.MaxPerUser
+	}
+	if req.ClassIDs != nil {
+		c.ClassIDs = joinIDs(*req.ClassIDs)
+	}
+	if req.ClassTypes != nil {
+		c.ClassTypes = normalizeList(*req.ClassTypes)
+	}
+	if req.Tiers != nil {
+		c.Tiers = normalizeList(*req.Tiers)
+	}
+	if req.Active != nil {
+		c.Active = *req.Active
+	}
+	if err := validateCode(c); err != nil {
+		return nil, err
+	}
+	if err := s.repo.UpdateCode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *service) ListRedemptions(codeID uint) ([]Redemption, error) {
+	if _, err := s.repo.FindCodeByID(codeID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCodeNotFound
+		}
+		return nil, err
+	}
+	return s.repo.ListRedemptions(codeID)
+}
+
+func (s *service) Redeem(claim Claim) (*Redemption, error) {
+	var red *Redemption
+	err := s.repo.Transaction(func(repo Repository) error {
+		c, err := repo.LockCode(normalizeCode(claim.Code))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCodeNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.eligible(repo, c, claim, time.Now()); err != nil {
+			return err
+		}
+
+		// The code row is locked, so the counts can't change under us.
+		if c.MaxRedemptions > 0 {
+			n, err := repo.CountRedemptions(c.ID, 0)
+			if err != nil {
+				return err
+			}
+			if n >= int64(c.MaxRedemptions) {
+				return ErrCodeUsedUp
+			}
+		}
+		if c.MaxPerUser > 0 {
+			n, err := repo.CountRedemptions(c.ID, claim.UserID)
+			if err != nil {
+				return err
+			}
+			if n >= int64(c.MaxPerUser) {
+				return ErrCodeAlreadyUsed
+			}
+		}
+
+		red = &Redemption{
+			CodeID:   c.ID,
+			UserID:   claim.UserID,
+			ClassID:  claim.Class.ID,
+			Price:    claim.Class.Price,
+			Discount: c.discountOn(claim.Class.Price),
+		}
+		return repo.CreateRedemption(red)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return red, nil
+}
+
+// eligible checks the code's state, validity window and restrictions.
+// Inactive codes look like unknown ones to members.
+func (s *service) eligible(repo Repository, c *Code, claim Claim, now time.Time) error {
+	if !c.Active {
+		return ErrCodeNotFound
+	}
+	if (c.ValidFrom != nil && now.Before(*c.ValidFrom)) || (c.ValidUntil != nil && !now.Before(*c.ValidUntil)) {
+		return ErrCodeNotValid
+	}
+	if c.Type == TypeFixed && c.AmountOff.Currency != claim.Class.Price.Currency {
+		return fmt.Errorf("%w: code is in %s", ErrCodeNotEligible, c.AmountOff.Currency)
+	}
+	if ids := c.classIDs(); len(ids) > 0 && !containsID(ids, claim.Class.ID) {
+		return ErrCodeNotEligible
+	}
+	if c.ClassTypes != "" && !contains(c.ClassTypes, strings.ToLower(claim.Class.ClassType)) {
+		return ErrCodeNotEligible
+	}
+	if c.Tiers != "" {
+		tier, err := repo.FindUserTier(claim.UserID)
+		if err != nil {
+			return err
+		}
+		if !contains(c.Tiers, tier) {
+			return fmt.Errorf("%w: requires a %s membership", ErrCodeNotEligible, strings.ReplaceAll(c.Tiers, ",", " or "))
+		}
+	}
+	return nil
+}
+
+func containsID(ids []uint, id uint) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *service) Release(redemptionID uint) error {
+	return s.repo.ReleaseRedemption(redemptionID, time.Now())
+}
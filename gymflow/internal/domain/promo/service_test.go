@@ -0,0 +1,128 @@
+package promo
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gymflow/internal/domain/booking"
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setup(t *testing.T) Service {
+	dsn := filepath.Join(t.TempDir(), "promo.db") + "?_busy_timeout=30000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&Code{}, &Redemption{}))
+	// Minimal stand-in for the user package's table.
+	require.NoError(t, db.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, membership_tier TEXT)").Error)
+	require.NoError(t, db.Exec("INSERT INTO users (id, membership_tier) VALUES (2, 'basic'), (3, 'vip')").Error)
+	return NewService(NewRepository(db))
+}
+
+var yoga = &booking.GymClass{ID: 10, ClassType: "yoga", Price: money.New(2500, "USD")}
+
+func TestRedeem_PercentAndFixed(t *testing.T) {
+	service := setup(t)
+	_, err := service.CreateCode(CreateCodeRequest{Code: "jan20", Type: TypePercent, PercentOff: 20})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "TENOFF", Type: TypeFixed, AmountOff: money.New(1000, "USD")})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "BIG", Type: TypeFixed, AmountOff: money.New(9900, "USD")})
+	require.NoError(t, err)
+
+	red, err := service.Redeem(Claim{Code: "Jan20", UserID: 2, Class: yoga})
+	require.NoError(t, err)
+	assert.Equal(t, money.New(500, "USD"), red.Discount)
+
+	red, err = service.Redeem(Claim{Code: "TENOFF", UserID: 2, Class: yoga})
+	require.NoError(t, err)
+	assert.Equal(t, money.New(1000, "USD"), red.Discount)
+
+	// A fixed discount never exceeds the price.
+	red, err = service.Redeem(Claim{Code: "BIG", UserID: 2, Class: yoga})
+	require.NoError(t, err)
+	assert.Equal(t, money.New(2500, "USD"), red.Discount)
+
+	_, err = service.Redeem(Claim{Code: "NOPE", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotFound)
+}
+
+func TestRedeem_Limits(t *testing.T) {
+	service := setup(t)
+	_, err := service.CreateCode(CreateCodeRequest{Code: "FIRSTFREE", Type: TypePercent, PercentOff: 100,
+		MaxRedemptions: 2, MaxPerUser: 1})
+	require.NoError(t, err)
+
+	first, err := service.Redeem(Claim{Code: "FIRSTFREE", UserID: 2, Class: yoga})
+	require.NoError(t, err)
+	_, err = service.Redeem(Claim{Code: "FIRSTFREE", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeAlreadyUsed)
+
+	// A released use (its payment failed) can be redeemed again.
+	require.NoError(t, service.Release(first.ID))
+	_, err = service.Redeem(Claim{Code: "FIRSTFREE", UserID: 2, Class: yoga})
+	require.NoError(t, err)
+
+	_, err = service.Redeem(Claim{Code: "FIRSTFREE", UserID: 3, Class: yoga})
+	require.NoError(t, err)
+	_, err = service.Redeem(Claim{Code: "FIRSTFREE", UserID: 4, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeUsedUp)
+}
+
+func TestRedeem_WindowAndRestrictions(t *testing.T) {
+	service := setup(t)
+	from := time.Now().Add(24 * time.Hour)
+	_, err := service.CreateCode(CreateCodeRequest{Code: "LATER", Type: TypePercent, PercentOff: 10, ValidFrom: &from})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "SPIN", Type: TypePercent, PercentOff: 10, ClassTypes: []string{"Spin"}})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "CLASS10", Type: TypePercent, PercentOff: 10, ClassIDs: []uint{10}})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "VIP", Type: TypePercent, PercentOff: 10, Tiers: []string{"vip"}})
+	require.NoError(t, err)
+	_, err = service.CreateCode(CreateCodeRequest{Code: "EURO", Type: TypeFixed, AmountOff: money.New(500, "EUR")})
+	require.NoError(t, err)
+
+	_, err = service.Redeem(Claim{Code: "LATER", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotValid)
+	_, err = service.Redeem(Claim{Code: "SPIN", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotEligible)
+	_, err = service.Redeem(Claim{Code: "CLASS10", UserID: 2, Class: yoga})
+	assert.NoError(t, err)
+	_, err = service.Redeem(Claim{Code: "VIP", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotEligible)
+	_, err = service.Redeem(Claim{Code: "VIP", UserID: 3, Class: yoga})
+	assert.NoError(t, err)
+	_, err = service.Redeem(Claim{Code: "EURO", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotEligible)
+}
+
+func TestCreateCode_Validation(t *testing.T) {
+	service := setup(t)
+	for _, req := range []CreateCodeRequest{
+		{Code: "ZERO", Type: TypePercent},
+		{Code: "MUCH", Type: TypePercent, PercentOff: 120},
+		{Code: "NOAMOUNT", Type: TypeFixed},
+		{Code: "TWO WORDS", Type: TypePercent, PercentOff: 10},
+		{Code: "BOGO", Type: "bogo"},
+		{Code: "GOLD", Type: TypePercent, PercentOff: 10, Tiers: []string{"gold"}},
+	} {
+		_, err := service.CreateCode(req)
+		assert.ErrorIs(t, err, ErrInvalidCode, req.Code)
+	}
+
+	code, err := service.CreateCode(CreateCodeRequest{Code: "JAN20", Type: TypePercent, PercentOff: 20})
+	require.NoError(t, err)
+	inactive := false
+	_, err = service.UpdateCode(code.ID, UpdateCodeRequest{Active: &inactive})
+	require.NoError(t, err)
+	_, err = service.Redeem(Claim{Code: "JAN20", UserID: 2, Class: yoga})
+	assert.ErrorIs(t, err, ErrCodeNotFound)
+}
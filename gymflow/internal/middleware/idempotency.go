@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	idempotencyStatusPending = 0
+)
+
+// IdempotencyRecord is the first response to a request made with an
+// Idempotency-Key. StatusCode stays 0 while that request is still running.
+type IdempotencyRecord struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_idempotency_user_key"`
+	Key         string `gorm:"column:idempotency_key;size:255;not null;uniqueIndex:idx_idempotency_user_key"`
+	Fingerprint string `gorm:"size:64;not null"`
+	StatusCode  int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// IdempotencyStore keeps idempotency records. The database is the source of
+// truth; a unique index on (user, key) decides which request runs.
+type IdempotencyStore interface {
+	// Claim inserts rec unless the user already used the key, in which case
+	// it returns the earlier record instead. Records created before
+	// expiredBefore no longer count and are replaced.
+	Claim(rec *IdempotencyRecord, expiredBefore time.Time) (*IdempotencyRecord, error)
+	Complete(rec *IdempotencyRecord) error
+	Release(rec *IdempotencyRecord) error
+	PurgeExpired(before time.Time) (int64, error)
+}
+
+type idempotencyStore struct {
+	db *gorm.DB
+}
+
+func NewIdempotencyStore(db *gorm.DB) IdempotencyStore {
+	return &idempotencyStore{db: db}
+}
+
+func (s *idempotencyStore) Claim(rec *IdempotencyRecord, expiredBefore time.Time) (*IdempotencyRecord, error) {
+	err := s.db.Where("user_id = ? AND idempotency_key = ? AND created_at < ?", rec.UserID, rec.Key, expiredBefore).
+		Delete(&IdempotencyRecord{}).Error
+	if err != nil {
+		return nil, err
+	}
+	res := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(rec)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 1 {
+		return nil, nil
+	}
+	var existing IdempotencyRecord
+	if err := s.db.Where("user_id = ? AND idempotency_key = ?", rec.UserID, rec.Key).First(&existing).Error; err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+func (s *idempotencyStore) Complete(rec *IdempotencyRecord) error {
+	return s.db.Model(rec).Select("StatusCode", "ContentType", "Body").Updates(rec).Error
+}
+
+func (s *idempotencyStore) Release(rec *IdempotencyRecord) error {
+	return s.db.Delete(rec).Error
+}
+
+func (s *idempotencyStore) PurgeExpired(before time.Time) (int64, error) {
+	res := s.db.Where("created_at < ?", before).Delete(&IdempotencyRecord{})
+	return res.RowsAffected, res.Error
+}
+
+// bodyRecorder copies the response body while it is written to the client.
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes retries of a mutating request safe. The first response to
+// a user's Idempotency-Key is stored and replayed for retries with the same
+// method, path and body; reusing the key for a different request is a 422.
+// A retry that arrives while the first request is still running gets a 409.
+// Server errors are not stored, so the client may retry them with the same
+// key. Safe methods and requests without the header pass through. It must run
+// after AuthMiddleware, since keys are scoped to the user.
+func Idempotency(store IdempotencyStore, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		safe := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead || c.Request.Method == http.MethodOptions
+		if key == "" || safe {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			return
+		}
+		userID := c.GetUint(ContextUserIDKey)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "cannot read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		rec := &IdempotencyRecord{
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: fingerprint(c.Request.Method, c.Request.URL.Path, body),
+		}
+		existing, err := store.Claim(rec, time.Now().Add(-ttl))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if existing != nil {
+			switch {
+			case existing.Fingerprint != rec.Fingerprint:
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case existing.StatusCode == idempotencyStatusPending:
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "a request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header(IdempotentReplayedHeader, "true")
+				c.Data(existing.StatusCode, existing.ContentType, existing.Body)
+				c.Abort()
+			}
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			// A panic or a server error must not pin the key.
+			if !completed {
+				_ = store.Release(rec)
+			}
+		}()
+
+		c.Next()
+
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		rec.StatusCode = c.Writer.Status()
+		rec.ContentType = c.Writer.Header().Get("Content-Type")
+		rec.Body = recorder.body.Bytes()
+		if err := store.Complete(rec); err != nil {
+			return
+		}
+		completed = true
+	}
+}
+
+// PurgeIdempotencyKeys returns a scheduler job that deletes expired records.
+func PurgeIdempotencyKeys(store IdempotencyStore, ttl time.Duration) func() error {
+	return func() error {
+		_, err := store.PurgeExpired(time.Now().Add(-ttl))
+		return err
+	}
+}
+
+func fingerprint(method, path string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupIdempotencyRouter(t *testing.T, status *int) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "idem.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&IdempotencyRecord{}))
+
+	calls := 0
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		if id := c.GetHeader("X-User"); id == "2" {
+			c.Set(ContextUserIDKey, uint(2))
+		} else {
+			c.Set(ContextUserIDKey, uint(1))
+		}
+	}, Idempotency(NewIdempotencyStore(db), time.Hour))
+	r.POST("/bookings", func(c *gin.Context) {
+		calls++
+		c.JSON(*status, gin.H{"call": calls})
+	})
+	return r, &calls
+}
+
+func postWithKey(r *gin.Engine, key, body, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/bookings", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency_ReplaysFirstResponse(t *testing.T) {
+	status := http.StatusCreated
+	r, calls := setupIdempotencyRouter(t, &status)
+
+	first := postWithKey(r, "k1", `{"class_id":1}`, "")
+	assert.Equal(t, http.StatusCreated, first.Code)
+
+	retry := postWithKey(r, "k1", `{"class_id":1}`, "")
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 1, *calls)
+
+	// Keys are per user, and requests without a key are not deduplicated.
+	assert.Equal(t, http.StatusCreated, postWithKey(r, "k1", `{"class_id":1}`, "2").Code)
+	assert.Equal(t, http.StatusCreated, postWithKey(r, "", `{"class_id":1}`, "").Code)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestIdempotency_RejectsKeyReuseWithDifferentBody(t *testing.T) {
+	status := http.StatusCreated
+	r, calls := setupIdempotencyRouter(t, &status)
+
+	postWithKey(r, "k1", `{"class_id":1}`, "")
+	w := postWithKey(r, "k1", `{"class_id":2}`, "")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestIdempotency_ServerErrorsAreNotStored(t *testing.T) {
+	status := http.StatusInternalServerError
+	r, calls := setupIdempotencyRouter(t, &status)
+
+	assert.Equal(t, http.StatusInternalServerError, postWithKey(r, "k1", `{}`, "").Code)
+
+	status = http.StatusCreated
+	w := postWithKey(r, "k1", `{}`, "")
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get(IdempotentReplayedHeader))
+	assert.Equal(t, 2, *calls)
+}
@@ -0,0 +1,35 @@
+package money
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// MigrateFloatColumn moves a legacy float major-unit column into the
+// <prefix>minor and <prefix>currency columns of an embedded Money, then drops
+// it. The Money columns must already exist (AutoMigrate adds them). Tables
+// without the legacy column are left alone, so it is safe to run on every start.
+func MigrateFloatColumn(db *gorm.DB, table, column, prefix, currency string) error {
+	if !Known(currency) {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	m := db.Migrator()
+	if !m.HasTable(table) || !m.HasColumn(table, column) {
+		return nil
+	}
+	scale := 1
+	for i := 0; i < Digits(currency); i++ {
+		scale *= 10
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(fmt.Sprintf(
+			"UPDATE %s SET %sminor = CAST(ROUND(COALESCE(%s, 0) * %d) AS BIGINT), %scurrency = ?",
+			table, prefix, column, scale, prefix,
+		), currency).Error
+		if err != nil {
+			return fmt.Errorf("migrate %s.%s: %w", table, column, err)
+		}
+		return tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, column)).Error
+	})
+}
@@ -0,0 +1,162 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// minorDigits is the ISO 4217 minor unit exponent of the supported currencies.
+var minorDigits = map[string]int{
+	"AUD": 2, "CAD": 2, "CHF": 2, "CZK": 2, "DKK": 2, "EUR": 2, "GBP": 2,
+	"KZT": 2, "NOK": 2, "PLN": 2, "RUB": 2, "SEK": 2, "UAH": 2, "USD": 2,
+	"JPY": 0, "KRW": 0,
+	"BHD": 3, "KWD": 3, "OMR": 3,
+}
+
+// Money is an exact amount in the minor units (e.g. cents) of an ISO 4217
+// currency. Embedded in a model with a prefix it maps to <prefix>minor and
+// <prefix>currency columns.
+type Money struct {
+	Amount   int64  `gorm:"column:minor;not null;default:0" json:"amount"`
+	Currency string `gorm:"column:currency;size:3" json:"currency"`
+}
+
+// New returns amount minor units of currency.
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: strings.ToUpper(currency)}
+}
+
+// Zero is nothing in currency.
+func Zero(currency string) Money {
+	return New(0, currency)
+}
+
+// Known reports whether currency is a supported ISO 4217 code.
+func Known(currency string) bool {
+	_, ok := minorDigits[currency]
+	return ok
+}
+
+// Digits is the number of minor unit digits of currency.
+func Digits(currency string) int {
+	return minorDigits[currency]
+}
+
+// Parse reads a decimal major-unit amount such as "12.50" exactly.
+func Parse(s, currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	if !Known(currency) {
+		return Money{}, fmt.Errorf("%w: %q", ErrUnknownCurrency, currency)
+	}
+	digits := Digits(currency)
+
+	s = strings.TrimSpace(s)
+	neg := strings.HasPrefix(s, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(s, "-"), ".")
+	if whole == "" || len(frac) > digits {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	frac += strings.Repeat("0", digits-len(frac))
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, s)
+	}
+	if neg {
+		n = -n
+	}
+	return Money{Amount: n, Currency: currency}, nil
+}
+
+// FromMajor converts a floating-point major-unit amount, rounding half away
+// from zero. It exists for migrating legacy float columns only.
+func FromMajor(v float64, currency string) Money {
+	currency = strings.ToUpper(currency)
+	scale := math.Pow10(Digits(currency))
+	return Money{Amount: int64(math.Round(v * scale)), Currency: currency}
+}
+
+// Validate checks the currency code.
+func (m Money) Validate() error {
+	if !Known(m.Currency) {
+		return fmt.Errorf("%w: %q", ErrUnknownCurrency, m.Currency)
+	}
+	return nil
+}
+
+func (m Money) IsZero() bool     { return m.Amount == 0 }
+func (m Money) IsPositive() bool { return m.Amount > 0 }
+func (m Money) IsNegative() bool { return m.Amount < 0 }
+
+// Add returns m+o. Both must be in the same currency; a zero value without a
+// currency adopts the other operand's.
+func (m Money) Add(o Money) (Money, error) {
+	cur, err := common(m, o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: cur}, nil
+}
+
+// Sub returns m-o under the same currency rules as Add.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(o.Neg())
+}
+
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+// Cmp compares two amounts of the same currency: -1, 0 or +1.
+func (m Money) Cmp(o Money) (int, error) {
+	if _, err := common(m, o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Amount < o.Amount:
+		return -1, nil
+	case m.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func common(a, b Money) (string, error) {
+	switch {
+	case a.Currency == b.Currency:
+		return a.Currency, nil
+	case a.Currency == "" && a.Amount == 0:
+		return b.Currency, nil
+	case b.Currency == "" && b.Amount == 0:
+		return a.Currency, nil
+	}
+	return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, a.Currency, b.Currency)
+}
+
+// Major formats the amount in major units, e.g. "12.50".
+func (m Money) Major() string {
+	digits := Digits(m.Currency)
+	abs := m.Amount
+	sign := ""
+	if abs < 0 {
+		abs, sign = -abs, "-"
+	}
+	if digits == 0 {
+		return sign + strconv.FormatInt(abs, 10)
+	}
+	scale := int64(math.Pow10(digits))
+	return fmt.Sprintf("%s%d.%0*d", sign, abs/scale, digits, abs%scale)
+}
+
+// String formats m as "12.50 EUR".
+func (m Money) String() string {
+	return m.Major() + " " + m.Currency
+}
@@ -0,0 +1,100 @@
+package money
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		in, currency string
+		want         Money
+	}{
+		{"12.50", "eur", New(1250, "EUR")},
+		{"12.5", "USD", New(1250, "USD")},
+		{"0.1", "USD", New(10, "USD")},
+		{"100", "USD", New(10000, "USD")},
+		{"-3.07", "GBP", New(-307, "GBP")},
+		{"1500", "JPY", New(1500, "JPY")},
+		{"1.234", "KWD", New(1234, "KWD")},
+	} {
+		got, err := Parse(tc.in, tc.currency)
+		require.NoError(t, err, tc.in)
+		assert.Equal(t, tc.want, got, tc.in)
+	}
+
+	for _, bad := range []string{"", "1.234", "abc", ".5", "1.2.3"} {
+		_, err := Parse(bad, "USD")
+		assert.ErrorIs(t, err, ErrInvalidAmount, bad)
+	}
+	_, err := Parse("1", "XXX")
+	assert.ErrorIs(t, err, ErrUnknownCurrency)
+}
+
+func TestArithmeticIsExact(t *testing.T) {
+	// 0.1 + 0.2 is not 0.3 in float64.
+	a, _ := Parse("0.10", "USD")
+	b, _ := Parse("0.20", "USD")
+	sum, err := a.Add(b)
+	require.NoError(t, err)
+	assert.Equal(t, "0.30 USD", sum.String())
+
+	diff, err := sum.Sub(New(50, "USD"))
+	require.NoError(t, err)
+	assert.Equal(t, "-0.20", diff.Major())
+
+	_, err = a.Add(New(1, "EUR"))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+
+	// A currency-less zero is the identity.
+	total, err := Money{}.Add(a)
+	require.NoError(t, err)
+	assert.Equal(t, a, total)
+
+	c, err := a.Cmp(b)
+	require.NoError(t, err)
+	assert.Equal(t, -1, c)
+}
+
+func TestFormatting(t *testing.T) {
+	assert.Equal(t, "1500 JPY", New(1500, "JPY").String())
+	assert.Equal(t, "1.005 KWD", New(1005, "KWD").String())
+	assert.Equal(t, "0.05 EUR", New(5, "EUR").String())
+	assert.Equal(t, FromMajor(19.99, "USD"), New(1999, "USD"))
+}
+
+func TestMigrateFloatColumn(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "money.db")), &gorm.Config{Logger: logger.Discard})
+	require.NoError(t, err)
+
+	type item struct {
+		ID    uint
+		Price float64
+	}
+	require.NoError(t, db.AutoMigrate(&item{}))
+	require.NoError(t, db.Create(&[]item{{Price: 19.99}, {Price: 0.1}, {Price: 5}}).Error)
+
+	type migrated struct {
+		ID    uint
+		Price Money `gorm:"embedded;embeddedPrefix:price_"`
+	}
+	require.NoError(t, db.Table("items").AutoMigrate(&migrated{}))
+	require.NoError(t, MigrateFloatColumn(db, "items", "price", "price_", "EUR"))
+	assert.False(t, db.Migrator().HasColumn("items", "price"))
+
+	var rows []migrated
+	require.NoError(t, db.Table("items").Order("id").Find(&rows).Error)
+	require.Len(t, rows, 3)
+	assert.Equal(t, New(1999, "EUR"), rows[0].Price)
+	assert.Equal(t, New(10, "EUR"), rows[1].Price)
+	assert.Equal(t, New(500, "EUR"), rows[2].Price)
+
+	// Running again is a no-op.
+	require.NoError(t, MigrateFloatColumn(db, "items", "price", "price_", "EUR"))
+}
@@ -1,11 +1,18 @@
 package router
 
 import (
+	"time"
+
 	"gymflow/internal/config"
 	"gymflow/internal/database"
 	"gymflow/internal/domain/admin"
 	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/classpack"
+	"gymflow/internal/domain/invoice"
+	"gymflow/internal/domain/ledger"
+	"gymflow/internal/domain/membership"
 	"gymflow/internal/domain/payment"
+	"gymflow/internal/domain/promo"
 	"gymflow/internal/domain/user"
 	"gymflow/internal/middleware"
 
@@ -13,7 +20,9 @@ import (
 	"gorm.io/gorm"
 )
 
-func SetupRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
+// SetupRouter wires the HTTP handlers. The ledger and payment services are
+// shared with the background jobs.
+func SetupRouter(cfg *config.Config, db *gorm.DB, ledgerService ledger.Service, paymentService payment.Service) *gin.Engine {
 	r := gin.New()
 	r.Use(middleware.Logger(), gin.Recovery())
 
@@ -23,16 +32,31 @@ func SetupRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
 	userHandler := user.NewHandler(cfg, userService)
 
 	bookingRepo := booking.NewRepository(db)
-	bookingService := booking.NewService(bookingRepo)
-	bookingHandler := booking.NewHandler(bookingService)
 
-	paymentRepo := payment.NewRepository(db)
-	paymentService := payment.NewService(paymentRepo)
+	invoiceService := invoice.NewService(invoice.NewRepository(db), bookingRepo, InvoiceSettings(cfg))
+	invoiceHandler := invoice.NewHandler(invoiceService)
+
+	promoService := promo.NewService(promo.NewRepository(db))
+	promoHandler := promo.NewHandler(promoService)
+
+	ledgerHandler := ledger.NewHandler(ledgerService)
+
 	paymentHandler := payment.NewHandler(paymentService)
 
+	membershipService := membership.NewService(membership.NewRepository(db), paymentService, MembershipSettings(cfg))
+	membershipHandler := membership.NewHandler(membershipService)
+
+	bookingService := booking.NewService(bookingRepo, paymentService)
+	bookingHandler := booking.NewHandler(bookingService)
+
+	classPackService := classpack.NewService(classpack.NewRepository(db), paymentService, bookingService)
+	classPackHandler := classpack.NewHandler(classPackService)
+
 	adminService := admin.NewService(db)
 	adminHandler := admin.NewHandler(adminService)
 
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(db), cfg.IdempotencyKeyTTL)
+
 	api := r.Group("/api/v1")
 
 	
@@ -43,27 +67,93 @@ func SetupRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
 
 	// Public classes
 	api.GET("/classes", bookingHandler.ListClasses)
+	api.GET("/class-series/:id", bookingHandler.GetSeries)
+	api.GET("/rooms", bookingHandler.ListRooms)
+	api.GET("/membership-plans", membershipHandler.ListPlans)
+	api.GET("/class-packs", classPackHandler.ListPacks)
+
+	// Payment provider callbacks (authenticated by signature, not JWT)
+	api.POST("/payments/webhook", paymentHandler.Webhook)
 
 	// Authenticated routes
 	authMember := api.Group("/")
-	authMember.Use(middleware.AuthMiddleware(cfg, user.RoleMember, user.RoleTrainer, user.RoleAdmin))
+	authMember.Use(middleware.AuthMiddleware(cfg, user.RoleMember, user.RoleTrainer, user.RoleAdmin), idempotency)
 
 	authMember.POST("/bookings", bookingHandler.CreateBooking)
 	authMember.GET("/bookings", bookingHandler.ListBookings)
 	authMember.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+	authMember.GET("/bookings/:id/waitlist", bookingHandler.GetWaitlistPosition)
+	authMember.GET("/attendance", bookingHandler.GetMyAttendance)
+	authMember.GET("/penalties", bookingHandler.ListPenalties)
 
 	authMember.POST("/payments", paymentHandler.CreatePayment)
 	authMember.GET("/payments", paymentHandler.ListPayments)
+	authMember.GET("/payment-methods", paymentHandler.ListPaymentMethods)
+	authMember.POST("/payment-methods", paymentHandler.AddPaymentMethod)
+	authMember.POST("/payment-methods/:id/default", paymentHandler.SetDefaultPaymentMethod)
+	authMember.DELETE("/payment-methods/:id", paymentHandler.RemovePaymentMethod)
+	authMember.GET("/invoices", invoiceHandler.ListInvoices)
+	authMember.GET("/invoices/:id", invoiceHandler.GetInvoice)
+	authMember.GET("/invoices/:id/download", invoiceHandler.Download)
+	authMember.POST("/memberships", membershipHandler.Subscribe)
+	authMember.GET("/memberships/me", membershipHandler.GetSubscription)
+	authMember.POST("/memberships/me/cancel", membershipHandler.CancelSubscription)
+	authMember.POST("/class-packs/:id/purchase", classPackHandler.Purchase)
+	authMember.GET("/class-pack-purchases", classPackHandler.ListPurchases)
+	authMember.GET("/credits", bookingHandler.GetCredits)
+	authMember.GET("/credits/ledger", bookingHandler.ListCreditLedger)
 
 	// Trainer/Admin
 	authTrainer := api.Group("/")
-	authTrainer.Use(middleware.AuthMiddleware(cfg, user.RoleTrainer, user.RoleAdmin))
+	authTrainer.Use(middleware.AuthMiddleware(cfg, user.RoleTrainer, user.RoleAdmin), idempotency)
 	authTrainer.POST("/classes", bookingHandler.CreateClass)
+	authTrainer.PATCH("/classes/:id", bookingHandler.UpdateClass)
+	authTrainer.DELETE("/classes/:id", bookingHandler.CancelClass)
+	authTrainer.POST("/class-series", bookingHandler.CreateSeries)
+	authTrainer.PATCH("/class-series/:id/occurrences/:class_id", bookingHandler.UpdateOccurrence)
+	authTrainer.POST("/classes/:id/check-in", bookingHandler.CheckIn)
+	authTrainer.GET("/classes/:id/attendance", bookingHandler.GetClassAttendance)
+	authTrainer.GET("/users/:id/attendance", bookingHandler.GetMemberAttendance)
+	authTrainer.POST("/payments/:id/refunds", paymentHandler.CreateRefund)
+	authTrainer.POST("/payments/in-person", paymentHandler.RecordInPersonPayment)
+	authTrainer.POST("/tills", paymentHandler.OpenTill)
+	authTrainer.GET("/tills/current", paymentHandler.CurrentTill)
+	authTrainer.POST("/tills/:id/close", paymentHandler.CloseTill)
 
 	// Admin only
 	authAdmin := api.Group("/admin")
-	authAdmin.Use(middleware.AuthMiddleware(cfg, user.RoleAdmin))
+	authAdmin.Use(middleware.AuthMiddleware(cfg, user.RoleAdmin), idempotency)
 	authAdmin.GET("/dashboard", adminHandler.Dashboard)
+	authAdmin.POST("/rooms", bookingHandler.CreateRoom)
+	authAdmin.PATCH("/rooms/:id", bookingHandler.UpdateRoom)
+	authAdmin.GET("/cancellation-policies", bookingHandler.ListPolicies)
+	authAdmin.POST("/cancellation-policies", bookingHandler.CreatePolicy)
+	authAdmin.PUT("/cancellation-policies/:id", bookingHandler.UpdatePolicy)
+	authAdmin.DELETE("/cancellation-policies/:id", bookingHandler.DeletePolicy)
+	authAdmin.GET("/refunds", paymentHandler.ListRefunds)
+	authAdmin.POST("/refunds/:id/approve", paymentHandler.ApproveRefund)
+	authAdmin.POST("/refunds/:id/reject", paymentHandler.RejectRefund)
+	authAdmin.GET("/invoices/:id/credit-notes", invoiceHandler.ListCreditNotes)
+	authAdmin.POST("/invoices/:id/credit-notes", invoiceHandler.CreateCreditNote)
+	authAdmin.GET("/membership-plans", membershipHandler.AdminListPlans)
+	authAdmin.POST("/membership-plans", membershipHandler.CreatePlan)
+	authAdmin.PATCH("/membership-plans/:id", membershipHandler.UpdatePlan)
+	authAdmin.GET("/subscriptions", membershipHandler.ListSubscriptions)
+	authAdmin.GET("/class-packs", classPackHandler.AdminListPacks)
+	authAdmin.POST("/class-packs", classPackHandler.CreatePack)
+	authAdmin.PATCH("/class-packs/:id", classPackHandler.UpdatePack)
+	authAdmin.GET("/promo-codes", promoHandler.ListCodes)
+	authAdmin.POST("/promo-codes", promoHandler.CreateCode)
+	authAdmin.PATCH("/promo-codes/:id", promoHandler.UpdateCode)
+	authAdmin.GET("/promo-codes/:id/redemptions", promoHandler.ListRedemptions)
+	authAdmin.GET("/ledger/transactions", ledgerHandler.ListTransactions)
+	authAdmin.GET("/ledger/balances", ledgerHandler.Balances)
+	authAdmin.GET("/reconciliation", ledgerHandler.Reconcile)
+	authAdmin.GET("/cash-up", paymentHandler.CashUp)
+	authAdmin.POST("/users/:id/credits", bookingHandler.GrantCredits)
+	authAdmin.GET("/users/:id/credits/ledger", bookingHandler.GetMemberCreditLedger)
+	authAdmin.GET("/users/:id/penalties", bookingHandler.GetMemberPenalties)
+	authAdmin.POST("/penalties/:id/waive", bookingHandler.WaivePenalty)
 
 	// Healthcheck
 	r.GET("/health", func(c *gin.Context) {
@@ -76,3 +166,28 @@ func SetupRouter(cfg *config.Config, db *gorm.DB) *gin.Engine {
 
 	return r
 }
+
+// InvoiceSettings builds the invoice settings from the config.
+func InvoiceSettings(cfg *config.Config) invoice.Settings {
+	return invoice.Settings{
+		Club: invoice.Club{
+			Code:    cfg.ClubCode,
+			Name:    cfg.ClubName,
+			Address: cfg.ClubAddress,
+			TaxID:   cfg.ClubTaxID,
+			Email:   cfg.ClubEmail,
+		},
+		FiscalYearStart: time.Month(cfg.FiscalYearStartMonth),
+		TaxRate:         cfg.TaxRateBasisPoints,
+	}
+}
+
+// MembershipSettings builds the subscription billing settings from the config.
+func MembershipSettings(cfg *config.Config) membership.Settings {
+	return membership.Settings{
+		GracePeriod:   cfg.MembershipGracePeriod,
+		RetryInterval: cfg.MembershipRetryInterval,
+		LapseAction:   cfg.MembershipLapseAction,
+		LapseTier:     cfg.MembershipLapseTier,
+	}
+}
@@ -0,0 +1,29 @@
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs job once immediately and then on every interval until ctx is done.
+// Errors are logged and do not stop the loop; the next tick simply retries.
+func Every(ctx context.Context, name string, interval time.Duration, job func() error) {
+	run := func() {
+		if err := job(); err != nil {
+			log.Printf("job %s failed: %v", name, err)
+		}
+	}
+
+	run()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package tax
+
+import (
+	"fmt"
+
+	"gymflow/internal/money"
+)
+
+// Product types, each with its own rate.
+const (
+	ProductClass      = "class"
+	ProductMembership = "membership"
+	ProductProduct    = "product"
+)
+
+// Pricing modes. Inclusive prices already contain the tax; exclusive prices
+// are net and the tax is added on top.
+const (
+	ModeInclusive = "inclusive"
+	ModeExclusive = "exclusive"
+)
+
+// Rates are the tax rates in basis points (2000 is 20%) per product type.
+// The zero value charges no tax on inclusive prices.
+type Rates struct {
+	Mode       string
+	Class      int
+	Membership int
+	Product    int
+}
+
+// Breakdown is a price split into net and tax. Gross is what is charged.
+type Breakdown struct {
+	Rate  int // basis points
+	Net   money.Money
+	Tax   money.Money
+	Gross money.Money
+}
+
+// Validate checks the mode and that every rate is below 100%.
+func (r Rates) Validate() error {
+	if r.Mode != "" && r.Mode != ModeInclusive && r.Mode != ModeExclusive {
+		return fmt.Errorf("unknown tax mode %q", r.Mode)
+	}
+	for _, rate := range []int{r.Class, r.Membership, r.Product} {
+		if rate < 0 || rate >= 10000 {
+			return fmt.Errorf("tax rate %d is out of range", rate)
+		}
+	}
+	return nil
+}
+
+// Rate is the rate of product; unknown types are taxed as products.
+func (r Rates) Rate(product string) int {
+	switch product {
+	case ProductClass:
+		return r.Class
+	case ProductMembership:
+		return r.Membership
+	}
+	return r.Product
+}
+
+// Apply prices product at price in the configured mode.
+func (r Rates) Apply(price money.Money, product string) Breakdown {
+	if r.Mode == ModeExclusive {
+		return Exclusive(price, r.Rate(product))
+	}
+	return Inclusive(price, r.Rate(product))
+}
+
+const base = 10000
+
+// Inclusive splits a tax-inclusive amount into net and tax, rounding the net
+// half up to the minor unit.
+func Inclusive(gross money.Money, rate int) Breakdown {
+	n := (gross.Amount*base*2 + int64(base+rate)) / (2 * int64(base+rate))
+	return Breakdown{
+		Rate:  rate,
+		Net:   money.New(n, gross.Currency),
+		Tax:   money.New(gross.Amount-n, gross.Currency),
+		Gross: gross,
+	}
+}
+
+// Exclusive adds tax to a net amount, rounding the tax half up to the minor
+// unit.
+func Exclusive(net money.Money, rate int) Breakdown {
+	t := (net.Amount*int64(rate)*2 + base) / (2 * base)
+	return Breakdown{
+		Rate:  rate,
+		Net:   net,
+		Tax:   money.New(t, net.Currency),
+		Gross: money.New(net.Amount+t, net.Currency),
+	}
+}
@@ -0,0 +1,42 @@
+package tax
+
+import (
+	"testing"
+
+	"gymflow/internal/money"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	rates := Rates{Class: 2000, Membership: 500, Product: 2000}
+	usd := func(minor int64) money.Money { return money.New(minor, "USD") }
+
+	// Inclusive: the price is the gross.
+	b := rates.Apply(usd(1200), ProductClass)
+	assert.Equal(t, Breakdown{Rate: 2000, Net: usd(1000), Tax: usd(200), Gross: usd(1200)}, b)
+	b = rates.Apply(usd(1000), ProductMembership)
+	assert.Equal(t, usd(952), b.Net) // 952.38 rounds down
+	assert.Equal(t, usd(48), b.Tax)
+
+	// Exclusive: the price is the net and tax is added on top.
+	rates.Mode = ModeExclusive
+	b = rates.Apply(usd(1000), ProductClass)
+	assert.Equal(t, Breakdown{Rate: 2000, Net: usd(1000), Tax: usd(200), Gross: usd(1200)}, b)
+	b = rates.Apply(usd(999), ProductMembership)
+	assert.Equal(t, usd(50), b.Tax) // 49.95 rounds half up
+	assert.Equal(t, usd(1049), b.Gross)
+
+	// Without rates nothing changes.
+	b = Rates{}.Apply(usd(1000), ProductProduct)
+	assert.Equal(t, usd(1000), b.Net)
+	assert.True(t, b.Tax.IsZero())
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Rates{}.Validate())
+	assert.NoError(t, Rates{Mode: ModeExclusive, Class: 2000}.Validate())
+	assert.Error(t, Rates{Mode: "gross"}.Validate())
+	assert.Error(t, Rates{Product: 10000}.Validate())
+	assert.Error(t, Rates{Membership: -1}.Validate())
+}
@@ -175,6 +175,8 @@ func TestAdminStatistics_InitialState(t *testing.T) {
 	// In initial state
 	assert.GreaterOrEqual(t, int(dashboardResp["total_users"].(float64)), 1)
 	assert.Equal(t, float64(0), dashboardResp["total_bookings"].(float64))
-	assert.Equal(t, float64(0), dashboardResp["total_revenue"].(float64))
+	// One entry per currency, so no revenue is an empty list rather than 0.
+	assert.Contains(t, dashboardResp, "total_revenue")
+	assert.Equal(t, []interface{}{}, dashboardResp["total_revenue"])
 	assert.Equal(t, float64(0), dashboardResp["upcoming_classes"].(float64))
 }
\ No newline at end of file
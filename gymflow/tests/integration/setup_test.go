@@ -6,14 +6,22 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"gymflow/internal/config"
 	"gymflow/internal/domain/admin"
 	"gymflow/internal/domain/auth"
 	"gymflow/internal/domain/booking"
+	"gymflow/internal/domain/classpack"
+	"gymflow/internal/domain/invoice"
+	"gymflow/internal/domain/ledger"
+	"gymflow/internal/domain/membership"
 	"gymflow/internal/domain/payment"
+	"gymflow/internal/domain/promo"
 	"gymflow/internal/domain/user"
 	"gymflow/internal/middleware"
+	"gymflow/internal/money"
+	"gymflow/internal/tax"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -31,8 +39,29 @@ func setupTestDB() *gorm.DB {
 	db.AutoMigrate(
 		&user.User{},
 		&booking.GymClass{},
+		&booking.ClassSeries{},
+		&booking.Room{},
 		&booking.Booking{},
+		&booking.CancellationPolicy{},
+		&booking.Penalty{},
+		&booking.CreditLot{},
+		&booking.CreditEntry{},
 		&payment.Payment{},
+		&payment.Refund{},
+		&payment.TillSession{},
+		&payment.PaymentMethod{},
+		&middleware.IdempotencyRecord{},
+		&invoice.Invoice{},
+		&invoice.Line{},
+		&invoice.Sequence{},
+		&membership.Plan{},
+		&membership.Subscription{},
+		&classpack.Pack{},
+		&classpack.Purchase{},
+		&promo.Code{},
+		&promo.Redemption{},
+		&ledger.Transaction{},
+		&ledger.Entry{},
 	)
 
 	return db
@@ -45,8 +74,9 @@ func setupTestRouter() *gin.Engine {
 
 	// Config
 	cfg := &config.Config{
-		JWTSecret:   "test-secret-key",
-		JWTTTLHours: 72,
+		JWTSecret:         "test-secret-key",
+		JWTTTLHours:       72,
+		IdempotencyKeyTTL: 24 * time.Hour,
 	}
 
 	// Repositories
@@ -56,8 +86,14 @@ func setupTestRouter() *gin.Engine {
 
 	// Services
 	userService := user.NewService(userRepo)
-	bookingService := booking.NewService(bookingRepo)
-	paymentService := payment.NewService(paymentRepo)
+	invoiceService := invoice.NewService(invoice.NewRepository(db), bookingRepo, invoice.Settings{Club: invoice.Club{Code: "GF", Name: "GymFlow"}})
+	promoService := promo.NewService(promo.NewRepository(db))
+	paymentProvider := payment.NewFakeProvider("test-webhook-secret", 0, "")
+	ledgerService := ledger.NewService(ledger.NewRepository(db), paymentProvider, 0)
+	paymentService := payment.NewService(paymentRepo, paymentProvider, bookingRepo, invoiceService, ledgerService, promoService, money.New(10000, "USD"), tax.Rates{})
+	bookingService := booking.NewService(bookingRepo, paymentService)
+	classPackService := classpack.NewService(classpack.NewRepository(db), paymentService, bookingService)
+	membershipService := membership.NewService(membership.NewRepository(db), paymentService, membership.Settings{GracePeriod: 7 * 24 * time.Hour})
 	adminService := admin.NewService(db)
 
 	// Handlers
@@ -65,6 +101,11 @@ func setupTestRouter() *gin.Engine {
 	authHandler := auth.NewHandler(userHandler)
 	bookingHandler := booking.NewHandler(bookingService)
 	paymentHandler := payment.NewHandler(paymentService)
+	invoiceHandler := invoice.NewHandler(invoiceService)
+	membershipHandler := membership.NewHandler(membershipService)
+	classPackHandler := classpack.NewHandler(classPackService)
+	promoHandler := promo.NewHandler(promoService)
+	ledgerHandler := ledger.NewHandler(ledgerService)
 	adminHandler := admin.NewHandler(adminService)
 
 	// Router
@@ -82,11 +123,17 @@ func setupTestRouter() *gin.Engine {
 
 		// Public: list classes
 		api.GET("/classes", bookingHandler.ListClasses)
+		api.GET("/class-series/:id", bookingHandler.GetSeries)
+		api.GET("/rooms", bookingHandler.ListRooms)
+		api.GET("/membership-plans", membershipHandler.ListPlans)
+		api.GET("/class-packs", classPackHandler.ListPacks)
+		api.POST("/payments/webhook", paymentHandler.Webhook)
 	}
 
 	// Protected routes (any authenticated user)
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware(cfg))
+	idempotency := middleware.Idempotency(middleware.NewIdempotencyStore(db), cfg.IdempotencyKeyTTL)
+	protected.Use(middleware.AuthMiddleware(cfg), idempotency)
 	{
 		// User routes
 		protected.GET("/users", userHandler.ListUsers)
@@ -95,24 +142,89 @@ func setupTestRouter() *gin.Engine {
 		protected.POST("/bookings", bookingHandler.CreateBooking)
 		protected.GET("/bookings", bookingHandler.ListBookings)
 		protected.POST("/bookings/:id/cancel", bookingHandler.CancelBooking)
+		protected.GET("/bookings/:id/waitlist", bookingHandler.GetWaitlistPosition)
+		protected.GET("/attendance", bookingHandler.GetMyAttendance)
+		protected.GET("/penalties", bookingHandler.ListPenalties)
 
 		// Payment routes
 		protected.POST("/payments", paymentHandler.CreatePayment)
 		protected.GET("/payments", paymentHandler.ListPayments)
+		protected.GET("/payment-methods", paymentHandler.ListPaymentMethods)
+		protected.POST("/payment-methods", paymentHandler.AddPaymentMethod)
+		protected.POST("/payment-methods/:id/default", paymentHandler.SetDefaultPaymentMethod)
+		protected.DELETE("/payment-methods/:id", paymentHandler.RemovePaymentMethod)
+
+		// Invoice routes
+		protected.GET("/invoices", invoiceHandler.ListInvoices)
+		protected.GET("/invoices/:id", invoiceHandler.GetInvoice)
+		protected.GET("/invoices/:id/download", invoiceHandler.Download)
+
+		// Membership routes
+		protected.POST("/memberships", membershipHandler.Subscribe)
+		protected.GET("/memberships/me", membershipHandler.GetSubscription)
+		protected.POST("/memberships/me/cancel", membershipHandler.CancelSubscription)
+
+		// Class pack and credit routes
+		protected.POST("/class-packs/:id/purchase", classPackHandler.Purchase)
+		protected.GET("/class-pack-purchases", classPackHandler.ListPurchases)
+		protected.GET("/credits", bookingHandler.GetCredits)
+		protected.GET("/credits/ledger", bookingHandler.ListCreditLedger)
 	}
 
 	// Trainer/Admin routes
 	trainerRoutes := api.Group("")
-	trainerRoutes.Use(middleware.AuthMiddleware(cfg, "trainer", "admin"))
+	trainerRoutes.Use(middleware.AuthMiddleware(cfg, "trainer", "admin"), idempotency)
 	{
 		trainerRoutes.POST("/classes", bookingHandler.CreateClass)
+		trainerRoutes.PATCH("/classes/:id", bookingHandler.UpdateClass)
+		trainerRoutes.DELETE("/classes/:id", bookingHandler.CancelClass)
+		trainerRoutes.POST("/class-series", bookingHandler.CreateSeries)
+		trainerRoutes.PATCH("/class-series/:id/occurrences/:class_id", bookingHandler.UpdateOccurrence)
+		trainerRoutes.POST("/classes/:id/check-in", bookingHandler.CheckIn)
+		trainerRoutes.GET("/classes/:id/attendance", bookingHandler.GetClassAttendance)
+		trainerRoutes.GET("/users/:id/attendance", bookingHandler.GetMemberAttendance)
+		trainerRoutes.POST("/payments/:id/refunds", paymentHandler.CreateRefund)
+		trainerRoutes.POST("/payments/in-person", paymentHandler.RecordInPersonPayment)
+		trainerRoutes.POST("/tills", paymentHandler.OpenTill)
+		trainerRoutes.GET("/tills/current", paymentHandler.CurrentTill)
+		trainerRoutes.POST("/tills/:id/close", paymentHandler.CloseTill)
 	}
 
 	// Admin only routes
 	adminRoutes := api.Group("/admin")
-	adminRoutes.Use(middleware.AuthMiddleware(cfg, "admin"))
+	adminRoutes.Use(middleware.AuthMiddleware(cfg, "admin"), idempotency)
 	{
 		adminRoutes.GET("/dashboard", adminHandler.Dashboard)
+		adminRoutes.POST("/rooms", bookingHandler.CreateRoom)
+		adminRoutes.PATCH("/rooms/:id", bookingHandler.UpdateRoom)
+		adminRoutes.GET("/cancellation-policies", bookingHandler.ListPolicies)
+		adminRoutes.POST("/cancellation-policies", bookingHandler.CreatePolicy)
+		adminRoutes.PUT("/cancellation-policies/:id", bookingHandler.UpdatePolicy)
+		adminRoutes.DELETE("/cancellation-policies/:id", bookingHandler.DeletePolicy)
+		adminRoutes.GET("/refunds", paymentHandler.ListRefunds)
+		adminRoutes.POST("/refunds/:id/approve", paymentHandler.ApproveRefund)
+		adminRoutes.POST("/refunds/:id/reject", paymentHandler.RejectRefund)
+		adminRoutes.GET("/invoices/:id/credit-notes", invoiceHandler.ListCreditNotes)
+		adminRoutes.POST("/invoices/:id/credit-notes", invoiceHandler.CreateCreditNote)
+		adminRoutes.GET("/membership-plans", membershipHandler.AdminListPlans)
+		adminRoutes.POST("/membership-plans", membershipHandler.CreatePlan)
+		adminRoutes.PATCH("/membership-plans/:id", membershipHandler.UpdatePlan)
+		adminRoutes.GET("/subscriptions", membershipHandler.ListSubscriptions)
+		adminRoutes.GET("/class-packs", classPackHandler.AdminListPacks)
+		adminRoutes.POST("/class-packs", classPackHandler.CreatePack)
+		adminRoutes.PATCH("/class-packs/:id", classPackHandler.UpdatePack)
+		adminRoutes.GET("/promo-codes", promoHandler.ListCodes)
+		adminRoutes.POST("/promo-codes", promoHandler.CreateCode)
+		adminRoutes.PATCH("/promo-codes/:id", promoHandler.UpdateCode)
+		adminRoutes.GET("/promo-codes/:id/redemptions", promoHandler.ListRedemptions)
+		adminRoutes.GET("/ledger/transactions", ledgerHandler.ListTransactions)
+		adminRoutes.GET("/ledger/balances", ledgerHandler.Balances)
+		adminRoutes.GET("/reconciliation", ledgerHandler.Reconcile)
+		adminRoutes.GET("/cash-up", paymentHandler.CashUp)
+		adminRoutes.POST("/users/:id/credits", bookingHandler.GrantCredits)
+		adminRoutes.GET("/users/:id/credits/ledger", bookingHandler.GetMemberCreditLedger)
+		adminRoutes.GET("/users/:id/penalties", bookingHandler.GetMemberPenalties)
+		adminRoutes.POST("/penalties/:id/waive", bookingHandler.WaivePenalty)
 	}
 
 	return r
@@ -175,7 +287,7 @@ func seedTestData(db *gorm.DB) {
 		Description: "Beginner yoga class",
 		TrainerID:   1,
 		Capacity:    10,
-		Price:       50.0,
+		Price:       money.New(5000, "USD"),
 	}
 	db.Create(gymClass)
 
@@ -192,8 +304,8 @@ func seedTestData(db *gorm.DB) {
 	paymentRecord := &payment.Payment{
 		UserID:    memberUser.ID,
 		BookingID: bookingRecord.ID,
-		Amount:    50.0,
-		Status:    "paid",
+		Amount:    money.New(5000, "USD"),
+		Status:    payment.StatusPaid,
 		Method:    "card",
 	}
 	db.Create(paymentRecord)